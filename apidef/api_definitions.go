@@ -25,6 +25,7 @@ type TykEventHandlerName string // A type for handler codes in API definitions
 
 type EndpointMethodAction string
 type TemplateMode string
+type TemplateEngine string
 
 type MiddlewareDriver string
 type IdExtractorSource string
@@ -39,6 +40,9 @@ const (
 	UseBlob TemplateMode = "blob"
 	UseFile TemplateMode = "file"
 
+	GoTemplateEngine TemplateEngine = "go_template"
+	JQTemplateEngine TemplateEngine = "jq"
+
 	RequestXML  RequestInputType = "xml"
 	RequestJSON RequestInputType = "json"
 
@@ -58,13 +62,17 @@ const (
 	RegexExtractor IdExtractorType = "regex"
 
 	// For multi-type auth
-	AuthToken     AuthTypeEnum = "auth_token"
-	HMACKey       AuthTypeEnum = "hmac_key"
-	BasicAuthUser AuthTypeEnum = "basic_auth_user"
-	JWTClaim      AuthTypeEnum = "jwt_claim"
-	OIDCUser      AuthTypeEnum = "oidc_user"
-	OAuthKey      AuthTypeEnum = "oauth_key"
-	UnsetAuth     AuthTypeEnum = ""
+	AuthToken         AuthTypeEnum = "auth_token"
+	HMACKey           AuthTypeEnum = "hmac_key"
+	BasicAuthUser     AuthTypeEnum = "basic_auth_user"
+	JWTClaim          AuthTypeEnum = "jwt_claim"
+	OIDCUser          AuthTypeEnum = "oidc_user"
+	OIDCLoginUser     AuthTypeEnum = "oidc_login_user"
+	OAuthKey          AuthTypeEnum = "oauth_key"
+	ExternalOAuthUser AuthTypeEnum = "external_oauth_user"
+	MutualTLS         AuthTypeEnum = "mutual_tls"
+	CustomAuth        AuthTypeEnum = "custom_auth"
+	UnsetAuth         AuthTypeEnum = ""
 
 	// For routing triggers
 	All    RoutingTriggerOnType = "all"
@@ -77,6 +85,29 @@ type EndpointMethodMeta struct {
 	Code    int                  `bson:"code" json:"code"`
 	Data    string               `bson:"data" json:"data"`
 	Headers map[string]string    `bson:"headers" json:"headers"`
+	// Template makes Data and the header values of a reply Go templates
+	// that are rendered with the request.
+	Template bool `bson:"template" json:"template"`
+	// Variants are replies picked by matching the request, the first
+	// match is used. The reply above is used if none match.
+	Variants []MockVariant `bson:"variants" json:"variants,omitempty"`
+	// Delay holds the reply for this many milliseconds, plus a random
+	// amount up to DelayJitter.
+	Delay       int `bson:"delay" json:"delay"`
+	DelayJitter int `bson:"delay_jitter" json:"delay_jitter"`
+	// FailureRate is the share of requests, from 0 to 1, that fail with
+	// FailureCode, or 500, instead of getting the reply.
+	FailureRate float64 `bson:"failure_rate" json:"failure_rate"`
+	FailureCode int     `bson:"failure_code" json:"failure_code"`
+}
+
+// MockVariant is a mock reply that is used when its triggers match.
+type MockVariant struct {
+	On      RoutingTriggerOnType  `bson:"on" json:"on"`
+	Options RoutingTriggerOptions `bson:"options" json:"options"`
+	Code    int                   `bson:"code" json:"code"`
+	Data    string                `bson:"data" json:"data"`
+	Headers map[string]string     `bson:"headers" json:"headers"`
 }
 
 type EndPointMeta struct {
@@ -97,6 +128,12 @@ type TemplateData struct {
 	Mode           TemplateMode     `bson:"template_mode" json:"template_mode"`
 	EnableSession  bool             `bson:"enable_session" json:"enable_session"`
 	TemplateSource string           `bson:"template_source" json:"template_source"`
+	// Engine is what TemplateSource is written in, a Go template by
+	// default. With JQTemplateEngine it is a jq filter whose output is
+	// the new body, encoded as JSON.
+	Engine TemplateEngine `bson:"engine" json:"engine,omitempty"`
+	// Timeout limits how long a jq filter can run, in milliseconds.
+	Timeout int `bson:"timeout" json:"timeout,omitempty"`
 }
 
 type TemplateMeta struct {
@@ -109,6 +146,8 @@ type TransformJQMeta struct {
 	Filter string `bson:"filter" json:"filter"`
 	Path   string `bson:"path" json:"path"`
 	Method string `bson:"method" json:"method"`
+	// Timeout limits how long the filter can run, in milliseconds.
+	Timeout int `bson:"timeout" json:"timeout,omitempty"`
 }
 
 type HeaderInjectionMeta struct {
@@ -125,6 +164,14 @@ type HardTimeoutMeta struct {
 	TimeOut int    `bson:"timeout" json:"timeout"`
 }
 
+// UpstreamCertificateMeta selects the client certificate presented to the
+// upstream for requests to a path.
+type UpstreamCertificateMeta struct {
+	Path          string `bson:"path" json:"path"`
+	Method        string `bson:"method" json:"method"`
+	CertificateID string `bson:"certificate_id" json:"certificate_id"`
+}
+
 type TrackEndpointMeta struct {
 	Path   string `bson:"path" json:"path"`
 	Method string `bson:"method" json:"method"`
@@ -147,6 +194,40 @@ type CircuitBreakerMeta struct {
 	ThresholdPercent     float64 `bson:"threshold_percent" json:"threshold_percent"`
 	Samples              int64   `bson:"samples" json:"samples"`
 	ReturnToServiceAfter int     `bson:"return_to_service_after" json:"return_to_service_after"`
+
+	// ConsecutiveFailures trips the breaker after this many failed
+	// requests in a row, regardless of ThresholdPercent.
+	ConsecutiveFailures int64 `bson:"consecutive_failures" json:"consecutive_failures"`
+	// LatencyThreshold counts responses slower than this many
+	// milliseconds as failures.
+	LatencyThreshold int64 `bson:"latency_threshold" json:"latency_threshold"`
+	// HalfOpenRequests is the number of trial requests let through once
+	// ReturnToServiceAfter has passed. The breaker closes when all of
+	// them succeed and opens again if any fails. Defaults to 1.
+	HalfOpenRequests int `bson:"half_open_requests" json:"half_open_requests"`
+	// PerHost keeps a separate breaker for each upstream host instead
+	// of one for the whole path.
+	PerHost bool `bson:"per_host" json:"per_host"`
+	// Shared stores open breakers in Redis so that every gateway in the
+	// same group stops sending requests to the tripped upstream. Gateways
+	// read them every second.
+	Shared   bool                   `bson:"shared" json:"shared"`
+	Fallback CircuitBreakerFallback `bson:"fallback" json:"fallback"`
+}
+
+// CircuitBreakerFallback is the response served while a breaker is open.
+// With UseCachedResponse, the last 2xx response for the same URL is
+// served when there is one, and the static response otherwise. Cached
+// responses are served to every consumer calling the URL, so they should
+// only be used for public responses unless CachePerKey is set, which keeps
+// them apart for each key.
+type CircuitBreakerFallback struct {
+	Enabled           bool              `bson:"enabled" json:"enabled"`
+	Code              int               `bson:"code" json:"code"`
+	Body              string            `bson:"body" json:"body"`
+	Headers           map[string]string `bson:"headers" json:"headers"`
+	UseCachedResponse bool              `bson:"use_cached_response" json:"use_cached_response"`
+	CachePerKey       bool              `bson:"cache_per_key" json:"cache_per_key"`
 }
 
 type StringRegexMap struct {
@@ -205,28 +286,80 @@ type ValidatePathMeta struct {
 	ErrorResponseCode int `bson:"error_response_code" json:"error_response_code"`
 }
 
+// BodyFormat is a body encoding that ConvertBodyMeta converts between.
+type BodyFormat string
+
+const (
+	BodyJSON     BodyFormat = "json"
+	BodyXML      BodyFormat = "xml"
+	BodyForm     BodyFormat = "form"
+	BodyProtobuf BodyFormat = "protobuf"
+)
+
+// ConvertBodyMeta converts a request or response body from one format to
+// another, setting the Content-Type to match.
+type ConvertBodyMeta struct {
+	Path     string                    `bson:"path" json:"path"`
+	Method   string                    `bson:"method" json:"method"`
+	From     BodyFormat                `bson:"from" json:"from"`
+	To       BodyFormat                `bson:"to" json:"to"`
+	XML      XMLConversionOptions      `bson:"xml" json:"xml"`
+	Protobuf ProtobufConversionOptions `bson:"protobuf" json:"protobuf"`
+}
+
+type XMLConversionOptions struct {
+	// RootElement wraps the JSON in an element of this name. By default
+	// an object with a single key is used as the root element.
+	RootElement string `bson:"root_element" json:"root_element"`
+	// Namespaces are declared on the root element, by prefix. The empty
+	// prefix declares the default namespace.
+	Namespaces map[string]string `bson:"namespaces" json:"namespaces"`
+	// NamespacePrefix is added to element names that have no prefix.
+	NamespacePrefix string `bson:"namespace_prefix" json:"namespace_prefix"`
+	// AttributePrefix marks the JSON keys that are XML attributes.
+	// Defaults to "-".
+	AttributePrefix string `bson:"attribute_prefix" json:"attribute_prefix"`
+	// ArrayPaths are dot separated element paths, starting with the root
+	// element, that are always JSON arrays, even with a single element.
+	ArrayPaths []string `bson:"array_paths" json:"array_paths"`
+	// CastValues turns numeric and boolean XML values into JSON numbers
+	// and booleans, instead of strings.
+	CastValues bool `bson:"cast_values" json:"cast_values"`
+}
+
+type ProtobufConversionOptions struct {
+	// DescriptorSet is a base64 encoded FileDescriptorSet including the
+	// imports, as written by protoc --descriptor_set_out --include_imports.
+	DescriptorSet string `bson:"descriptor_set" json:"descriptor_set"`
+	// Message is the full name of the message type, e.g. "acme.v1.Order".
+	Message string `bson:"message" json:"message"`
+}
+
 type ExtendedPathsSet struct {
-	Ignored                 []EndPointMeta        `bson:"ignored" json:"ignored,omitempty"`
-	WhiteList               []EndPointMeta        `bson:"white_list" json:"white_list,omitempty"`
-	BlackList               []EndPointMeta        `bson:"black_list" json:"black_list,omitempty"`
-	Cached                  []string              `bson:"cache" json:"cache,omitempty"`
-	AdvanceCacheConfig      []CacheMeta           `bson:"advance_cache_config" json:"advance_cache_config,omitempty"`
-	Transform               []TemplateMeta        `bson:"transform" json:"transform,omitempty"`
-	TransformResponse       []TemplateMeta        `bson:"transform_response" json:"transform_response,omitempty"`
-	TransformJQ             []TransformJQMeta     `bson:"transform_jq" json:"transform_jq,omitempty"`
-	TransformJQResponse     []TransformJQMeta     `bson:"transform_jq_response" json:"transform_jq_response,omitempty"`
-	TransformHeader         []HeaderInjectionMeta `bson:"transform_headers" json:"transform_headers,omitempty"`
-	TransformResponseHeader []HeaderInjectionMeta `bson:"transform_response_headers" json:"transform_response_headers,omitempty"`
-	HardTimeouts            []HardTimeoutMeta     `bson:"hard_timeouts" json:"hard_timeouts,omitempty"`
-	CircuitBreaker          []CircuitBreakerMeta  `bson:"circuit_breakers" json:"circuit_breakers,omitempty"`
-	URLRewrite              []URLRewriteMeta      `bson:"url_rewrites" json:"url_rewrites,omitempty"`
-	Virtual                 []VirtualMeta         `bson:"virtual" json:"virtual,omitempty"`
-	SizeLimit               []RequestSizeMeta     `bson:"size_limits" json:"size_limits,omitempty"`
-	MethodTransforms        []MethodTransformMeta `bson:"method_transforms" json:"method_transforms,omitempty"`
-	TrackEndpoints          []TrackEndpointMeta   `bson:"track_endpoints" json:"track_endpoints,omitempty"`
-	DoNotTrackEndpoints     []TrackEndpointMeta   `bson:"do_not_track_endpoints" json:"do_not_track_endpoints,omitempty"`
-	ValidateJSON            []ValidatePathMeta    `bson:"validate_json" json:"validate_json,omitempty"`
-	Internal                []InternalMeta        `bson:"internal" json:"internal"`
+	Ignored                 []EndPointMeta            `bson:"ignored" json:"ignored,omitempty"`
+	WhiteList               []EndPointMeta            `bson:"white_list" json:"white_list,omitempty"`
+	BlackList               []EndPointMeta            `bson:"black_list" json:"black_list,omitempty"`
+	Cached                  []string                  `bson:"cache" json:"cache,omitempty"`
+	AdvanceCacheConfig      []CacheMeta               `bson:"advance_cache_config" json:"advance_cache_config,omitempty"`
+	Transform               []TemplateMeta            `bson:"transform" json:"transform,omitempty"`
+	TransformResponse       []TemplateMeta            `bson:"transform_response" json:"transform_response,omitempty"`
+	TransformJQ             []TransformJQMeta         `bson:"transform_jq" json:"transform_jq,omitempty"`
+	TransformJQResponse     []TransformJQMeta         `bson:"transform_jq_response" json:"transform_jq_response,omitempty"`
+	TransformHeader         []HeaderInjectionMeta     `bson:"transform_headers" json:"transform_headers,omitempty"`
+	TransformResponseHeader []HeaderInjectionMeta     `bson:"transform_response_headers" json:"transform_response_headers,omitempty"`
+	HardTimeouts            []HardTimeoutMeta         `bson:"hard_timeouts" json:"hard_timeouts,omitempty"`
+	CircuitBreaker          []CircuitBreakerMeta      `bson:"circuit_breakers" json:"circuit_breakers,omitempty"`
+	URLRewrite              []URLRewriteMeta          `bson:"url_rewrites" json:"url_rewrites,omitempty"`
+	Virtual                 []VirtualMeta             `bson:"virtual" json:"virtual,omitempty"`
+	SizeLimit               []RequestSizeMeta         `bson:"size_limits" json:"size_limits,omitempty"`
+	MethodTransforms        []MethodTransformMeta     `bson:"method_transforms" json:"method_transforms,omitempty"`
+	TrackEndpoints          []TrackEndpointMeta       `bson:"track_endpoints" json:"track_endpoints,omitempty"`
+	DoNotTrackEndpoints     []TrackEndpointMeta       `bson:"do_not_track_endpoints" json:"do_not_track_endpoints,omitempty"`
+	ValidateJSON            []ValidatePathMeta        `bson:"validate_json" json:"validate_json,omitempty"`
+	Internal                []InternalMeta            `bson:"internal" json:"internal"`
+	UpstreamCertificates    []UpstreamCertificateMeta `bson:"upstream_certificates" json:"upstream_certificates,omitempty"`
+	ConvertBody             []ConvertBodyMeta         `bson:"convert_body" json:"convert_body,omitempty"`
+	ConvertBodyResponse     []ConvertBodyMeta         `bson:"convert_body_response" json:"convert_body_response,omitempty"`
 }
 
 type VersionInfo struct {
@@ -244,6 +377,22 @@ type VersionInfo struct {
 	GlobalHeadersRemove []string          `bson:"global_headers_remove" json:"global_headers_remove"`
 	GlobalSizeLimit     int64             `bson:"global_size_limit" json:"global_size_limit"`
 	OverrideTarget      string            `bson:"override_target" json:"override_target"`
+	OpenAPI             OpenAPIValidation `bson:"openapi" json:"openapi"`
+}
+
+// OpenAPIValidation validates requests, and optionally upstream responses,
+// against an OpenAPI 3 document. Paths in the document are relative to the
+// listen path.
+type OpenAPIValidation struct {
+	Enabled     bool                   `bson:"enabled" json:"enabled"`
+	Document    map[string]interface{} `bson:"document" json:"document"`
+	DocumentB64 string                 `bson:"document_b64" json:"document_b64,omitempty"`
+	// Allows override of default 422 Unprocessible Entity response code for validation errors.
+	ErrorResponseCode int  `bson:"error_response_code" json:"error_response_code"`
+	ValidateResponses bool `bson:"validate_responses" json:"validate_responses"`
+	// BlockInvalidResponses replaces invalid upstream responses with a
+	// 502 Bad Gateway. Otherwise they are only logged.
+	BlockInvalidResponses bool `bson:"block_invalid_responses" json:"block_invalid_responses"`
 }
 
 type AuthProviderMeta struct {
@@ -312,6 +461,25 @@ type HostCheckObject struct {
 	Body     string            `bson:"body" json:"body"`
 }
 
+// TargetCertificate pairs a load balanced target, as listed in target_list,
+// with the ID of the client certificate to present to it.
+type TargetCertificate struct {
+	Target        string `bson:"target" json:"target"`
+	CertificateID string `bson:"certificate_id" json:"certificate_id"`
+}
+
+// OutlierDetectionConfig configures passive health checking of load
+// balanced targets. A host that fails ConsecutiveErrors proxied requests in
+// a row, with a connection error or a 5xx response, is ejected for
+// BaseEjectionTime seconds. Each further ejection doubles the time, up to
+// MaxEjectionTime.
+type OutlierDetectionConfig struct {
+	Enabled           bool  `bson:"enabled" json:"enabled"`
+	ConsecutiveErrors int   `bson:"consecutive_errors" json:"consecutive_errors"`
+	BaseEjectionTime  int64 `bson:"base_ejection_time" json:"base_ejection_time"`
+	MaxEjectionTime   int64 `bson:"max_ejection_time" json:"max_ejection_time"`
+}
+
 type ServiceDiscoveryConfiguration struct {
 	UseDiscoveryService bool   `bson:"use_discovery_service" json:"use_discovery_service"`
 	QueryEndpoint       string `bson:"query_endpoint" json:"query_endpoint"`
@@ -323,6 +491,20 @@ type ServiceDiscoveryConfiguration struct {
 	UseTargetList       bool   `bson:"use_target_list" json:"use_target_list"`
 	CacheTimeout        int64  `bson:"cache_timeout" json:"cache_timeout"`
 	EndpointReturnsList bool   `bson:"endpoint_returns_list" json:"endpoint_returns_list"`
+
+	// Provider selects a native discovery provider: "dns_srv", "consul" or
+	// "kubernetes". When empty, QueryEndpoint is read as JSON using the
+	// data paths above.
+	Provider          string `bson:"provider" json:"provider"`
+	ServiceName       string `bson:"service_name" json:"service_name"`
+	Namespace         string `bson:"namespace" json:"namespace"`
+	PortName          string `bson:"port_name" json:"port_name"`
+	Tag               string `bson:"tag" json:"tag"`
+	Token             string `bson:"token" json:"token"`
+	DNSServer         string `bson:"dns_server" json:"dns_server"`
+	Scheme            string `bson:"scheme" json:"scheme"`
+	UseEndpointSlices bool   `bson:"use_endpoint_slices" json:"use_endpoint_slices"`
+	Watch             bool   `bson:"watch" json:"watch"`
 }
 
 type OIDProviderConfig struct {
@@ -335,6 +517,46 @@ type OpenIDOptions struct {
 	SegregateByClient bool                `bson:"segregate_by_client" json:"segregate_by_client"`
 }
 
+// OIDCLoginOptions configure the gateway as an OpenID Connect relying
+// party. Requests without a login are redirected to the provider, and the
+// callback on the oidc/callback path of the API sets an encrypted session
+// cookie. Tokens are refreshed before they expire.
+type OIDCLoginOptions struct {
+	// Issuer picks the provider of OpenIDOptions.Providers. Defaults to the
+	// first one.
+	Issuer string `bson:"issuer" json:"issuer"`
+	// ClientID picks the client of the provider, whose policy is applied to
+	// the sessions of users. Defaults to the only client of the provider.
+	ClientID string `bson:"client_id" json:"client_id"`
+	// ClientSecret and CookieSecret can be given as
+	// "env://TYK_SECRET_NAME", "secrets://name" or "file://name".
+	ClientSecret string   `bson:"client_secret" json:"client_secret"`
+	Scopes       []string `bson:"scopes" json:"scopes"`
+	// RedirectURL is the callback URL registered with the provider. It is
+	// required unless the API is served on a custom domain, in which case
+	// it defaults to the oidc/callback path of the API on that domain.
+	RedirectURL string `bson:"redirect_url" json:"redirect_url"`
+	// CookieName defaults to "tyk_oidc_session".
+	CookieName   string `bson:"cookie_name" json:"cookie_name"`
+	CookieSecret string `bson:"cookie_secret" json:"cookie_secret"`
+	// InsecureCookie lets browsers send the cookies over plain HTTP, for
+	// testing. Cookies are Secure otherwise.
+	InsecureCookie bool `bson:"insecure_cookie" json:"insecure_cookie"`
+	// IdentityHeaders forwards ID token claims upstream, by claim name to
+	// header name. Headers sent by clients are always replaced.
+	IdentityHeaders map[string]string `bson:"identity_headers" json:"identity_headers"`
+	IdentityJWT     OIDCIdentityJWT   `bson:"identity_jwt" json:"identity_jwt"`
+}
+
+// OIDCIdentityJWT forwards the ID token claims upstream as a short lived
+// JWT, signed with RS256 by the key of a certificate.
+type OIDCIdentityJWT struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// Header defaults to "X-Tyk-Identity".
+	Header        string `bson:"header" json:"header"`
+	SigningCertID string `bson:"signing_cert_id" json:"signing_cert_id"`
+}
+
 // APIDefinition represents the configuration for a single proxied API and it's versions.
 //
 // swagger:model
@@ -348,6 +570,10 @@ type APIDefinition struct {
 	UseOauth2        bool          `bson:"use_oauth2" json:"use_oauth2"`
 	UseOpenID        bool          `bson:"use_openid" json:"use_openid"`
 	OpenIDOptions    OpenIDOptions `bson:"openid_options" json:"openid_options"`
+	// UseOIDCLogin logs browsers in with the OpenID Connect authorization
+	// code flow, with a provider of OpenIDOptions.
+	UseOIDCLogin     bool             `bson:"use_oidc_login" json:"use_oidc_login"`
+	OIDCLoginOptions OIDCLoginOptions `bson:"oidc_login" json:"oidc_login"`
 	Oauth2Meta       struct {
 		AllowedAccessTypes     []osin.AccessRequestType    `bson:"allowed_access_types" json:"allowed_access_types"`
 		AllowedAuthorizeTypes  []osin.AuthorizeRequestType `bson:"allowed_authorize_types" json:"allowed_authorize_types"`
@@ -370,6 +596,8 @@ type APIDefinition struct {
 	UseStandardAuth            bool                 `bson:"use_standard_auth" json:"use_standard_auth"`
 	UseGoPluginAuth            bool                 `bson:"use_go_plugin_auth" json:"use_go_plugin_auth"`
 	EnableCoProcessAuth        bool                 `bson:"enable_coprocess_auth" json:"enable_coprocess_auth"`
+	UseExternalOAuth           bool                 `bson:"use_external_oauth" json:"use_external_oauth"`
+	ExternalOAuthOptions       ExternalOAuthOptions `bson:"external_oauth" json:"external_oauth"`
 	JWTSigningMethod           string               `bson:"jwt_signing_method" json:"jwt_signing_method"`
 	JWTSource                  string               `bson:"jwt_source" json:"jwt_source"`
 	JWTIdentityBaseField       string               `bson:"jwt_identit_base_field" json:"jwt_identity_base_field"`
@@ -387,6 +615,7 @@ type APIDefinition struct {
 	HmacAllowedClockSkew       float64              `bson:"hmac_allowed_clock_skew" json:"hmac_allowed_clock_skew"`
 	HmacAllowedAlgorithms      []string             `bson:"hmac_allowed_algorithms" json:"hmac_allowed_algorithms"`
 	BaseIdentityProvidedBy     AuthTypeEnum         `bson:"base_identity_provided_by" json:"base_identity_provided_by"`
+	AuthComposition            AuthComposition      `bson:"auth_composition" json:"auth_composition"`
 	VersionDefinition          struct {
 		Location  string `bson:"location" json:"location"`
 		Key       string `bson:"key" json:"key"`
@@ -416,11 +645,19 @@ type APIDefinition struct {
 		StructuredTargetList        *HostList                     `bson:"-" json:"-"`
 		CheckHostAgainstUptimeTests bool                          `bson:"check_host_against_uptime_tests" json:"check_host_against_uptime_tests"`
 		ServiceDiscovery            ServiceDiscoveryConfiguration `bson:"service_discovery" json:"service_discovery"`
-		Transport                   struct {
+		OutlierDetection            OutlierDetectionConfig        `bson:"outlier_detection" json:"outlier_detection"`
+		// TargetCertificates selects the client certificate presented
+		// to each load balanced target.
+		TargetCertificates []TargetCertificate `bson:"target_certificates" json:"target_certificates"`
+		Transport          struct {
 			SSLInsecureSkipVerify bool     `bson:"ssl_insecure_skip_verify" json:"ssl_insecure_skip_verify"`
 			SSLCipherSuites       []string `bson:"ssl_ciphers" json:"ssl_ciphers"`
 			SSLMinVersion         uint16   `bson:"ssl_min_version" json:"ssl_min_version"`
 			ProxyURL              string   `bson:"proxy_url" json:"proxy_url"`
+			// SSLCACertificates are IDs of CA certificates, or bundles,
+			// trusted when verifying upstream servers instead of the
+			// system roots.
+			SSLCACertificates []string `bson:"ssl_ca_certificates" json:"ssl_ca_certificates"`
 		} `bson:"transport" json:"transport"`
 	} `bson:"proxy" json:"proxy"`
 	DisableRateLimit          bool                   `bson:"disable_rate_limit" json:"disable_rate_limit"`
@@ -462,6 +699,236 @@ type APIDefinition struct {
 	TagHeaders        []string               `bson:"tag_headers" json:"tag_headers"`
 	GlobalRateLimit   GlobalRateLimit        `bson:"global_rate_limit" json:"global_rate_limit"`
 	StripAuthData     bool                   `bson:"strip_auth_data" json:"strip_auth_data"`
+	ErrorOverrides    ErrorOverrides         `bson:"error_overrides" json:"error_overrides"`
+
+	RequestDecompression RequestDecompression `bson:"request_decompression" json:"request_decompression"`
+	// ResponseSizeLimit is the largest upstream response body, in bytes,
+	// that is passed on to the client. 0 means no limit.
+	ResponseSizeLimit   int64               `bson:"response_size_limit" json:"response_size_limit"`
+	ResponseCompression ResponseCompression `bson:"response_compression" json:"response_compression"`
+	WebSocket           WebSocketOptions    `bson:"websocket" json:"websocket"`
+	UpstreamAuth        UpstreamAuth        `bson:"upstream_auth" json:"upstream_auth"`
+
+	SessionIntrospection SessionIntrospection `bson:"session_introspection" json:"session_introspection"`
+	OAuthServer          OAuthServerOptions   `bson:"oauth_server" json:"oauth_server"`
+}
+
+// ErrorOverrides replaces the error responses of an API. An override for
+// the gateway error reason, such as "rate_limited", is used before one for
+// the status code. Status codes are keyed as strings, e.g. "429".
+type ErrorOverrides struct {
+	// ProblemDetails renders errors as RFC 7807 application/problem+json.
+	ProblemDetails bool `bson:"problem_details" json:"problem_details"`
+	// ProblemTypeBaseURL is joined with the error reason to build the
+	// problem type URI. Without it, or without a reason, the type is
+	// "about:blank".
+	ProblemTypeBaseURL string                   `bson:"problem_type_base_url" json:"problem_type_base_url"`
+	ByReason           map[string]ErrorOverride `bson:"by_reason" json:"by_reason"`
+	ByCode             map[string]ErrorOverride `bson:"by_code" json:"by_code"`
+}
+
+// RequestDecompression decodes gzip, deflate and brotli request bodies
+// before the middleware chain reads them, so validation and transforms see
+// the plain body. The upstream gets the decoded body too.
+type RequestDecompression struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// MaxSize caps the decoded size of a body, in bytes, to guard against
+	// zip bombs. Larger bodies are rejected with 413. Defaults to 10MB.
+	MaxSize int64 `bson:"max_size" json:"max_size"`
+}
+
+// ResponseCompression compresses upstream responses for clients that
+// accept it, once the response processors have run. Responses that the
+// upstream already encoded are passed on as they are.
+type ResponseCompression struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// Encodings are the ones offered, in order of preference. Supported
+	// are "br", "gzip" and "deflate", which is also the default.
+	Encodings []string `bson:"encodings" json:"encodings"`
+	// MinSize is the smallest body, in bytes, that is compressed.
+	MinSize int64 `bson:"min_size" json:"min_size"`
+	// ContentTypes are the media types that are compressed, which can
+	// be wildcards like "text/*". Defaults to text, JSON, XML and
+	// JavaScript types.
+	ContentTypes []string `bson:"content_types" json:"content_types"`
+}
+
+// WebSocketOptions control proxied WebSocket connections once they are
+// upgraded. Connections that break a limit are closed with a close frame
+// to both the client and the upstream.
+type WebSocketOptions struct {
+	// RateLimitMessages counts every message the client sends towards the
+	// key's rate limit and quota, not only the upgrade request.
+	RateLimitMessages bool `bson:"rate_limit_messages" json:"rate_limit_messages"`
+	// MaxMessageSize is the largest message, in bytes, passed on in
+	// either direction. 0 means no limit.
+	MaxMessageSize int64 `bson:"max_message_size" json:"max_message_size"`
+	// IdleTimeout closes connections with no messages in either direction
+	// for this many seconds. 0 means no timeout.
+	IdleTimeout int64 `bson:"idle_timeout" json:"idle_timeout"`
+	// SessionCheckInterval is how often, in seconds, the key is checked
+	// again, closing the connection once it is deleted, inactive or
+	// expired. Defaults to 60, -1 turns the check off.
+	SessionCheckInterval int64 `bson:"session_check_interval" json:"session_check_interval"`
+}
+
+// SessionIntrospection serves API consumers a view of their own key on a
+// path of the API, authenticated like any other request. It shows their
+// quota, rate limit, access rights, expiry and policies, but no secrets or
+// metadata.
+type SessionIntrospection struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// Path is relative to the listen path. Defaults to "tyk/session".
+	Path string `bson:"path" json:"path"`
+}
+
+// OAuthServerOptions extend the built-in OAuth server of APIs with
+// use_oauth2.
+type OAuthServerOptions struct {
+	// RequirePKCE rejects authorization code requests without a PKCE code
+	// challenge. Challenges are checked whenever clients send them.
+	RequirePKCE bool `bson:"require_pkce" json:"require_pkce"`
+	// ScopeToPolicyMapping maps the scopes requested for a token to
+	// policies, applied on top of the client's policy. As with keys with
+	// several policies, the policies must be partitioned. Clients can only
+	// request mapped scopes listed in their allowed_scopes, and tokens for
+	// other scopes are refused with invalid_scope.
+	ScopeToPolicyMapping map[string]string    `bson:"scope_to_policy_mapping" json:"scope_to_policy_mapping"`
+	JWTAccessTokens      OAuthJWTAccessTokens `bson:"jwt_access_tokens" json:"jwt_access_tokens"`
+}
+
+// OAuthJWTAccessTokens issues access tokens as signed JWTs, which other
+// services can verify with the keys published on the oauth/jwks path of
+// the API.
+type OAuthJWTAccessTokens struct {
+	Enabled bool `bson:"enabled" json:"enabled"`
+	// SigningCertID is the certificate, with its RSA private key, that
+	// signs tokens with RS256.
+	SigningCertID string `bson:"signing_cert_id" json:"signing_cert_id"`
+	// Issuer is the "iss" claim of the tokens.
+	Issuer string `bson:"issuer" json:"issuer"`
+}
+
+// ExternalOAuthOptions validate opaque access tokens issued by an external
+// identity provider against its RFC 7662 introspection endpoint. Each
+// subject gets a session of its own, built from policies, so rate limits
+// and quotas apply per subject.
+type ExternalOAuthOptions struct {
+	IntrospectionURL string `bson:"introspection_url" json:"introspection_url"`
+	// ClientID and ClientSecret authenticate the gateway to the
+	// introspection endpoint. Like upstream auth secrets, they can be given
+	// as "env://TYK_SECRET_NAME", "secrets://name" or "file://name".
+	ClientID     string `bson:"client_id" json:"client_id"`
+	ClientSecret string `bson:"client_secret" json:"client_secret"`
+	// Audiences are the "aud" values the API accepts, one of which tokens
+	// must be issued for. Defaults to the API ID.
+	Audiences []string `bson:"audiences" json:"audiences"`
+	// IdentityBaseField is the claim identifying the subject, which tokens
+	// must carry. When unset, "sub" is used, falling back to "client_id"
+	// for tokens without one.
+	IdentityBaseField string `bson:"identity_base_field" json:"identity_base_field"`
+	// DefaultPolicies are applied to every subject.
+	DefaultPolicies []string `bson:"default_policies" json:"default_policies"`
+	// ScopeToPolicyMapping adds policies for the scopes of the token, read
+	// from ScopeClaimName, "scope" by default. As with keys with several
+	// policies, the policies must be partitioned.
+	ScopeToPolicyMapping map[string]string `bson:"scope_to_policy_mapping" json:"scope_to_policy_mapping"`
+	ScopeClaimName       string            `bson:"scope_claim_name" json:"scope_claim_name"`
+	// CacheTTL is how long, in seconds, an active token is trusted without
+	// asking the identity provider again, never past the token's expiry.
+	// Defaults to 60, -1 turns caching off. Inactive tokens are cached for
+	// at most 5 seconds.
+	CacheTTL int64 `bson:"cache_ttl" json:"cache_ttl"`
+}
+
+// AuthComposition states which of the enabled auth methods a request must
+// pass, instead of all of them. Groups are tried in order and a request
+// passing every method of a group is authenticated, so that groups are
+// ORed and the methods of a group ANDed. Every enabled method must be
+// listed by a group, or the API isn't loaded.
+type AuthComposition struct {
+	Enabled bool        `bson:"enabled" json:"enabled"`
+	Groups  []AuthGroup `bson:"groups" json:"groups"`
+}
+
+// AuthGroup lists auth methods that must all pass, checked in order. The
+// session comes from BaseIdentityProvidedBy, or else the last method of the
+// group providing one, so a group needs a method that provides a session.
+// "mutual_tls" only checks the client certificate, for APIs without a
+// domain of their own, and "custom_auth" is coprocess or JS plugin auth.
+// Go plugin auth can't be composed and is always checked.
+type AuthGroup struct {
+	Methods []AuthTypeEnum `bson:"methods" json:"methods"`
+}
+
+// UpstreamAuth authenticates the requests the gateway sends upstream. Only
+// one method can be enabled. Secrets can be given as
+// "env://TYK_SECRET_NAME" for an environment variable with the TYK_SECRET_
+// prefix, "secrets://name" for an entry in the gateway's secrets config or
+// "file://name" for the contents of a file in the gateway's secrets_dir,
+// instead of in plain text.
+type UpstreamAuth struct {
+	SigV4 UpstreamSigV4 `bson:"sigv4" json:"sigv4"`
+	HMAC  UpstreamHMAC  `bson:"hmac" json:"hmac"`
+	OAuth UpstreamOAuth `bson:"oauth" json:"oauth"`
+}
+
+// UpstreamSigV4 signs requests with AWS Signature Version 4, for upstreams
+// such as API Gateway and Lambda function URLs.
+type UpstreamSigV4 struct {
+	Enabled bool   `bson:"enabled" json:"enabled"`
+	Region  string `bson:"region" json:"region"`
+	// Service is the signing name of the service, such as "execute-api"
+	// or "lambda".
+	Service string `bson:"service" json:"service"`
+	// AccessKeyID and SecretAccessKey are required, and like SessionToken
+	// can be env:// or secrets:// references. The AWS credentials of the
+	// gateway process itself are never used.
+	AccessKeyID     string `bson:"access_key_id" json:"access_key_id"`
+	SecretAccessKey string `bson:"secret_access_key" json:"secret_access_key"`
+	SessionToken    string `bson:"session_token" json:"session_token"`
+}
+
+// UpstreamHMAC signs requests with an HMAC signature in the Authorization
+// header, in the draft-cavage-http-signatures format that APIs with
+// signature checking expect.
+type UpstreamHMAC struct {
+	Enabled bool   `bson:"enabled" json:"enabled"`
+	KeyID   string `bson:"key_id" json:"key_id"`
+	Secret  string `bson:"secret" json:"secret"`
+	// Algorithm is one of "hmac-sha1", "hmac-sha256", "hmac-sha384" and
+	// "hmac-sha512". Defaults to "hmac-sha256".
+	Algorithm string `bson:"algorithm" json:"algorithm"`
+	// Headers are the ones signed, and can include "(request-target)".
+	// Defaults to "(request-target)" and "date".
+	Headers []string `bson:"headers" json:"headers"`
+}
+
+// UpstreamOAuth fetches access tokens with the OAuth 2.0 client
+// credentials grant and sends them as bearer tokens. Tokens are reused
+// until shortly before they expire, or until the upstream rejects one.
+type UpstreamOAuth struct {
+	Enabled      bool     `bson:"enabled" json:"enabled"`
+	TokenURL     string   `bson:"token_url" json:"token_url"`
+	ClientID     string   `bson:"client_id" json:"client_id"`
+	ClientSecret string   `bson:"client_secret" json:"client_secret"`
+	Scopes       []string `bson:"scopes" json:"scopes"`
+	// EndpointParams are extra values sent to the token endpoint, such
+	// as "audience".
+	EndpointParams map[string]string `bson:"endpoint_params" json:"endpoint_params"`
+}
+
+// ErrorOverride is a replacement error response. Body is a text/template
+// executed with .Message, .StatusCode, .Reason, .RequestID and .Errors;
+// without a Body, the default or problem details body is used. Type and
+// Title set the problem type URI and title.
+type ErrorOverride struct {
+	Code        int               `bson:"code" json:"code"`
+	Body        string            `bson:"body" json:"body"`
+	ContentType string            `bson:"content_type" json:"content_type"`
+	Headers     map[string]string `bson:"headers" json:"headers"`
+	Type        string            `bson:"type" json:"type"`
+	Title       string            `bson:"title" json:"title"`
 }
 
 type Auth struct {
@@ -494,6 +961,7 @@ type BundleManifest struct {
 	CustomMiddleware MiddlewareSection `bson:"custom_middleware" json:"custom_middleware"`
 	Checksum         string            `bson:"checksum" json:"checksum"`
 	Signature        string            `bson:"signature" json:"signature"`
+	KeyID            string            `bson:"key_id" json:"key_id,omitempty"`
 }
 
 // Clean will URL encode map[string]struct variables for saving
@@ -529,6 +997,13 @@ func (a *APIDefinition) EncodeForDB() {
 
 			a.VersionData.Versions[i].ExtendedPaths.ValidateJSON[j] = oldSchema
 		}
+
+		if version.OpenAPI.Document != nil {
+			jsBytes, _ := json.Marshal(version.OpenAPI.Document)
+			version.OpenAPI.DocumentB64 = base64.StdEncoding.EncodeToString(jsBytes)
+			version.OpenAPI.Document = nil
+			a.VersionData.Versions[i] = version
+		}
 	}
 }
 
@@ -579,6 +1054,13 @@ func (a *APIDefinition) DecodeFromDB() {
 
 			a.VersionData.Versions[i].ExtendedPaths.ValidateJSON[j] = oldSchema
 		}
+
+		if version.OpenAPI.DocumentB64 != "" {
+			jsBytes, _ := base64.StdEncoding.DecodeString(version.OpenAPI.DocumentB64)
+			json.Unmarshal(jsBytes, &version.OpenAPI.Document)
+			version.OpenAPI.DocumentB64 = ""
+			a.VersionData.Versions[i] = version
+		}
 	}
 }
 
@@ -63,6 +63,29 @@ const Schema = `{
         "base_identity_provided_by": {
             "type": "string"
         },
+        "auth_composition": {
+            "type": ["object", "null"],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "groups": {
+                    "type": ["array", "null"],
+                    "items": {
+                        "type": "object",
+                        "properties": {
+                            "methods": {
+                                "type": ["array", "null"],
+                                "items": {
+                                    "type": "string",
+                                    "enum": ["auth_token", "hmac_key", "basic_auth_user", "jwt_claim", "oidc_user", "oidc_login_user", "oauth_key", "external_oauth_user", "mutual_tls", "custom_auth"]
+                                }
+                            }
+                        }
+                    }
+                }
+            }
+        },
         "disable_rate_limit": {
             "type": "boolean"
         },
@@ -394,6 +417,255 @@ const Schema = `{
                     "type": "number"
                 }
             }
+        },
+        "error_overrides": {
+            "type": ["object", "null"],
+            "properties": {
+                "problem_details": {
+                    "type": "boolean"
+                },
+                "problem_type_base_url": {
+                    "type": "string"
+                },
+                "by_reason": {
+                    "type": ["object", "null"]
+                },
+                "by_code": {
+                    "type": ["object", "null"]
+                }
+            }
+        },
+        "request_decompression": {
+            "type": ["object", "null"],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "max_size": {
+                    "type": "number"
+                }
+            }
+        },
+        "response_size_limit": {
+            "type": "number"
+        },
+        "response_compression": {
+            "type": ["object", "null"],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "encodings": {
+                    "type": ["array", "null"],
+                    "items": {
+                        "type": "string",
+                        "enum": ["br", "gzip", "deflate"]
+                    }
+                },
+                "min_size": {
+                    "type": "number"
+                },
+                "content_types": {
+                    "type": ["array", "null"],
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "websocket": {
+            "type": ["object", "null"],
+            "properties": {
+                "rate_limit_messages": {
+                    "type": "boolean"
+                },
+                "max_message_size": {
+                    "type": "number"
+                },
+                "idle_timeout": {
+                    "type": "number"
+                },
+                "session_check_interval": {
+                    "type": "number"
+                }
+            }
+        },
+        "upstream_auth": {
+            "type": ["object", "null"],
+            "properties": {
+                "sigv4": {
+                    "type": ["object", "null"],
+                    "properties": {
+                        "enabled": {
+                            "type": "boolean"
+                        },
+                        "region": {
+                            "type": "string"
+                        },
+                        "service": {
+                            "type": "string"
+                        }
+                    }
+                },
+                "hmac": {
+                    "type": ["object", "null"],
+                    "properties": {
+                        "enabled": {
+                            "type": "boolean"
+                        },
+                        "algorithm": {
+                            "type": "string",
+                            "enum": ["", "hmac-sha1", "hmac-sha256", "hmac-sha384", "hmac-sha512"]
+                        },
+                        "headers": {
+                            "type": ["array", "null"],
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                },
+                "oauth": {
+                    "type": ["object", "null"],
+                    "properties": {
+                        "enabled": {
+                            "type": "boolean"
+                        },
+                        "token_url": {
+                            "type": "string"
+                        },
+                        "scopes": {
+                            "type": ["array", "null"],
+                            "items": {
+                                "type": "string"
+                            }
+                        },
+                        "endpoint_params": {
+                            "type": ["object", "null"]
+                        }
+                    }
+                }
+            }
+        },
+        "session_introspection": {
+            "type": ["object", "null"],
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "path": {
+                    "type": "string"
+                }
+            }
+        },
+        "use_oidc_login": {
+            "type": "boolean"
+        },
+        "oidc_login": {
+            "type": ["object", "null"],
+            "properties": {
+                "issuer": {
+                    "type": "string"
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "client_secret": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": ["array", "null"]
+                },
+                "redirect_url": {
+                    "type": "string"
+                },
+                "cookie_name": {
+                    "type": "string"
+                },
+                "cookie_secret": {
+                    "type": "string"
+                },
+                "insecure_cookie": {
+                    "type": "boolean"
+                },
+                "identity_headers": {
+                    "type": ["object", "null"]
+                },
+                "identity_jwt": {
+                    "type": ["object", "null"],
+                    "properties": {
+                        "enabled": {
+                            "type": "boolean"
+                        },
+                        "header": {
+                            "type": "string"
+                        },
+                        "signing_cert_id": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
+        "use_external_oauth": {
+            "type": "boolean"
+        },
+        "external_oauth": {
+            "type": ["object", "null"],
+            "properties": {
+                "introspection_url": {
+                    "type": "string"
+                },
+                "client_id": {
+                    "type": "string"
+                },
+                "client_secret": {
+                    "type": "string"
+                },
+                "audiences": {
+                    "type": ["array", "null"]
+                },
+                "identity_base_field": {
+                    "type": "string"
+                },
+                "default_policies": {
+                    "type": ["array", "null"]
+                },
+                "scope_to_policy_mapping": {
+                    "type": ["object", "null"]
+                },
+                "scope_claim_name": {
+                    "type": "string"
+                },
+                "cache_ttl": {
+                    "type": "integer"
+                }
+            }
+        },
+        "oauth_server": {
+            "type": ["object", "null"],
+            "properties": {
+                "require_pkce": {
+                    "type": "boolean"
+                },
+                "scope_to_policy_mapping": {
+                    "type": ["object", "null"]
+                },
+                "jwt_access_tokens": {
+                    "type": ["object", "null"],
+                    "properties": {
+                        "enabled": {
+                            "type": "boolean"
+                        },
+                        "signing_cert_id": {
+                            "type": "string"
+                        },
+                        "issuer": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
         }
     },
     "required": [
@@ -0,0 +1,381 @@
+// Package bundlesig signs and verifies plugin bundles. The same code is
+// used by the gateway when loading a bundle and by the "tyk bundle"
+// subcommands, so a bundle that verifies offline also verifies on a node.
+package bundlesig
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/certs"
+)
+
+var (
+	ErrUnsigned         = errors.New("Bundle isn't signed")
+	ErrInvalidChecksum  = errors.New("Invalid checksum")
+	ErrNoKeyFound       = errors.New("ssh: no key found")
+	ErrUntrustedKey     = errors.New("Bundle is signed with an untrusted key")
+	ErrKeyNotValidYet   = errors.New("Bundle signing key is not valid yet")
+	ErrKeyExpired       = errors.New("Bundle signing key has expired")
+	ErrInvalidSignature = errors.New("Invalid bundle signature")
+)
+
+// Signer creates bundle signatures.
+type Signer interface {
+	// Sign returns the raw signature for data, hashing it as required
+	// by the key type.
+	Sign(data []byte) ([]byte, error)
+	// Public returns the public half of the signing key.
+	Public() crypto.PublicKey
+}
+
+// Verifier validates bundle signatures.
+type Verifier interface {
+	Verify(data []byte, sig []byte) error
+}
+
+type rsaSigner struct{ *rsa.PrivateKey }
+
+// Sign signs data with rsa-sha256, matching the signatures produced by
+// older versions of the bundler.
+func (s rsaSigner) Sign(data []byte) ([]byte, error) {
+	d := sha256.Sum256(data)
+	return rsa.SignPKCS1v15(rand.Reader, s.PrivateKey, crypto.SHA256, d[:])
+}
+
+type ecdsaSigner struct{ *ecdsa.PrivateKey }
+
+// Sign signs data with ecdsa-sha256 and returns an ASN.1 encoded signature.
+func (s ecdsaSigner) Sign(data []byte) ([]byte, error) {
+	d := sha256.Sum256(data)
+	return ecdsa.SignASN1(rand.Reader, s.PrivateKey, d[:])
+}
+
+type ed25519Signer struct{ ed25519.PrivateKey }
+
+func (s ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.PrivateKey, data), nil
+}
+
+type rsaVerifier struct{ *rsa.PublicKey }
+
+func (v rsaVerifier) Verify(data []byte, sig []byte) error {
+	d := sha256.Sum256(data)
+	return rsa.VerifyPKCS1v15(v.PublicKey, crypto.SHA256, d[:], sig)
+}
+
+type ecdsaVerifier struct{ *ecdsa.PublicKey }
+
+func (v ecdsaVerifier) Verify(data []byte, sig []byte) error {
+	d := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(v.PublicKey, d[:], sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+type ed25519Verifier struct{ ed25519.PublicKey }
+
+func (v ed25519Verifier) Verify(data []byte, sig []byte) error {
+	if !ed25519.Verify(v.PublicKey, data, sig) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// NewSigner wraps an RSA, ECDSA or Ed25519 private key.
+func NewSigner(key crypto.PrivateKey) (Signer, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return rsaSigner{k}, nil
+	case *ecdsa.PrivateKey:
+		return ecdsaSigner{k}, nil
+	case ed25519.PrivateKey:
+		return ed25519Signer{k}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", key)
+}
+
+// NewVerifier wraps an RSA, ECDSA or Ed25519 public key.
+func NewVerifier(key crypto.PublicKey) (Verifier, error) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return rsaVerifier{k}, nil
+	case *ecdsa.PublicKey:
+		return ecdsaVerifier{k}, nil
+	case ed25519.PublicKey:
+		return ed25519Verifier{k}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key type %T", key)
+}
+
+// ParsePrivateKey parses a PEM encoded PKCS#1, PKCS#8 or SEC1 private key.
+func ParsePrivateKey(data []byte) (Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoKeyFound
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return NewSigner(key)
+	}
+	if key, err := x509.ParsePKCS8PrivateKey(block.Bytes); err == nil {
+		return NewSigner(key)
+	}
+	if key, err := x509.ParseECPrivateKey(block.Bytes); err == nil {
+		return NewSigner(key)
+	}
+	return nil, errors.New("failed to parse private key")
+}
+
+// LoadPrivateKeyFromFile loads a PEM encoded private key file.
+func LoadPrivateKeyFromFile(path string) (Signer, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePrivateKey(data)
+}
+
+// ParsePublicKey parses the first PEM encoded public key or certificate
+// found in data.
+func ParsePublicKey(data []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, ErrNoKeyFound
+	}
+	switch block.Type {
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", block.Type)
+}
+
+// LoadPublicKeyFromFile loads a PEM encoded public key or certificate file.
+func LoadPublicKeyFromFile(path string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePublicKey(data)
+}
+
+// PublicKeyFromCertificate returns the public key of an entry returned by
+// certs.CertificateManager, which may hold either a certificate or a bare
+// public key.
+func PublicKeyFromCertificate(cert *tls.Certificate) (crypto.PublicKey, error) {
+	if cert == nil || len(cert.Certificate) == 0 {
+		return nil, ErrNoKeyFound
+	}
+	if cert.Leaf != nil && cert.Leaf.PublicKey != nil {
+		return cert.Leaf.PublicKey, nil
+	}
+	// Public keys stored in the certificate manager keep the raw PKIX
+	// bytes in place of the certificate.
+	if key, err := x509.ParsePKIXPublicKey(cert.Certificate[0]); err == nil {
+		return key, nil
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+	return leaf.PublicKey, nil
+}
+
+// KeyID returns the default identifier for a public key: the hex SHA256
+// of its PKIX encoding, the same fingerprint the certificate manager uses.
+func KeyID(key crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		return "", err
+	}
+	return certs.HexSHA256(der), nil
+}
+
+// Checksum returns the manifest checksum for the concatenated bundle files.
+func Checksum(data []byte) string {
+	return fmt.Sprintf("%x", md5.Sum(data))
+}
+
+// Sign fills in the checksum, key ID and signature of manifest.
+func Sign(manifest *apidef.BundleManifest, data []byte, signer Signer, keyID string) error {
+	manifest.Checksum = Checksum(data)
+	signed, err := signer.Sign(data)
+	if err != nil {
+		return err
+	}
+	manifest.KeyID = keyID
+	manifest.Signature = base64.StdEncoding.EncodeToString(signed)
+	return nil
+}
+
+// ReadBundle extracts the manifest from a bundle ZIP file, along with the
+// concatenated contents of the files it lists, in manifest order.
+func ReadBundle(bundle []byte) (*apidef.BundleManifest, []byte, error) {
+	reader, err := zip.NewReader(bytes.NewReader(bundle), int64(len(bundle)))
+	if err != nil {
+		return nil, nil, err
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+
+	mf, ok := files["manifest.json"]
+	if !ok {
+		return nil, nil, errors.New("Bundle has no manifest.json")
+	}
+	rc, err := mf.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	var manifest apidef.BundleManifest
+	err = json.NewDecoder(rc).Decode(&manifest)
+	rc.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var data bytes.Buffer
+	for _, name := range manifest.FileList {
+		f, ok := files[name]
+		if !ok {
+			return nil, nil, errors.New("Bundle is missing file: " + name)
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, nil, err
+		}
+		_, err = io.Copy(&data, rc)
+		rc.Close()
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	return &manifest, data.Bytes(), nil
+}
+
+// TrustedKey is a public key the gateway accepts bundle signatures from.
+// A zero NotBefore or NotAfter leaves that end of the window open.
+type TrustedKey struct {
+	ID        string
+	Verifier  Verifier
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+func (k *TrustedKey) validAt(now time.Time) error {
+	if !k.NotBefore.IsZero() && now.Before(k.NotBefore) {
+		return ErrKeyNotValidYet
+	}
+	if !k.NotAfter.IsZero() && now.After(k.NotAfter) {
+		return ErrKeyExpired
+	}
+	return nil
+}
+
+// NewTrustedKey wraps a public key for use in a KeyRing. If id is empty the
+// key fingerprint is used, which is also the bundler's default key ID.
+func NewTrustedKey(id string, key crypto.PublicKey) (TrustedKey, error) {
+	verifier, err := NewVerifier(key)
+	if err != nil {
+		return TrustedKey{}, err
+	}
+	if id == "" {
+		if id, err = KeyID(key); err != nil {
+			return TrustedKey{}, err
+		}
+	}
+	return TrustedKey{ID: id, Verifier: verifier}, nil
+}
+
+// KeyRing is a set of trusted keys. Several keys can be trusted at once so
+// that a signing key can be rotated without every gateway switching at the
+// same moment.
+type KeyRing struct {
+	keys []TrustedKey
+}
+
+// Add adds a key to the ring.
+func (r *KeyRing) Add(key TrustedKey) {
+	r.keys = append(r.keys, key)
+}
+
+// Len returns the number of keys in the ring.
+func (r *KeyRing) Len() int {
+	if r == nil {
+		return 0
+	}
+	return len(r.keys)
+}
+
+// Verify checks the manifest checksum against data and, if the ring holds
+// any keys, the manifest signature. Bundles carrying a key ID must be signed
+// by the trusted key with that ID. Bundles without one, as produced by older
+// bundlers, are accepted if any key valid at now verifies them.
+func (r *KeyRing) Verify(manifest *apidef.BundleManifest, data []byte, now time.Time) error {
+	if Checksum(data) != manifest.Checksum {
+		return ErrInvalidChecksum
+	}
+	if r.Len() == 0 {
+		return nil
+	}
+	if manifest.Signature == "" {
+		return ErrUnsigned
+	}
+	sig, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil {
+		return err
+	}
+
+	if manifest.KeyID != "" {
+		for i := range r.keys {
+			key := &r.keys[i]
+			if key.ID != manifest.KeyID {
+				continue
+			}
+			if err := key.validAt(now); err != nil {
+				return err
+			}
+			if err := key.Verifier.Verify(data, sig); err != nil {
+				return ErrInvalidSignature
+			}
+			return nil
+		}
+		return ErrUntrustedKey
+	}
+
+	for i := range r.keys {
+		key := &r.keys[i]
+		if key.validAt(now) != nil {
+			continue
+		}
+		if key.Verifier.Verify(data, sig) == nil {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
@@ -0,0 +1,194 @@
+package bundlesig
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/apidef"
+)
+
+var bundleData = []byte("def MyPreHook(request, session, spec):\n    return request, session\n")
+
+func genKeys(t testing.TB) map[string]crypto.Signer {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]crypto.Signer{
+		"RSA":     rsaKey,
+		"ECDSA":   ecKey,
+		"Ed25519": edKey,
+	}
+}
+
+func trustedKey(t testing.TB, id string, key crypto.Signer) TrustedKey {
+	trusted, err := NewTrustedKey(id, key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return trusted
+}
+
+func TestSignAndVerify(t *testing.T) {
+	for name, key := range genKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			signer, err := NewSigner(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keyID, _ := KeyID(key.Public())
+
+			var manifest apidef.BundleManifest
+			if err := Sign(&manifest, bundleData, signer, keyID); err != nil {
+				t.Fatal(err)
+			}
+
+			ring := &KeyRing{}
+			ring.Add(trustedKey(t, "", key))
+			if err := ring.Verify(&manifest, bundleData, time.Now()); err != nil {
+				t.Fatalf("expected valid bundle, got %v", err)
+			}
+
+			tampered := append([]byte{}, bundleData...)
+			tampered[0] = 'x'
+			manifest.Checksum = Checksum(tampered)
+			if err := ring.Verify(&manifest, tampered, time.Now()); err != ErrInvalidSignature {
+				t.Fatalf("expected %v, got %v", ErrInvalidSignature, err)
+			}
+		})
+	}
+}
+
+func TestKeyRingVerify(t *testing.T) {
+	keys := genKeys(t)
+	oldKey, newKey := keys["RSA"], keys["Ed25519"]
+	now := time.Now()
+
+	sign := func(key crypto.Signer, keyID string) *apidef.BundleManifest {
+		signer, _ := NewSigner(key)
+		manifest := &apidef.BundleManifest{}
+		if err := Sign(manifest, bundleData, signer, keyID); err != nil {
+			t.Fatal(err)
+		}
+		return manifest
+	}
+
+	ring := &KeyRing{}
+	old := trustedKey(t, "old", oldKey)
+	old.NotAfter = now.Add(time.Hour)
+	ring.Add(old)
+	next := trustedKey(t, "new", newKey)
+	next.NotBefore = now.Add(-time.Minute)
+	ring.Add(next)
+
+	tests := []struct {
+		name     string
+		manifest *apidef.BundleManifest
+		at       time.Time
+		want     error
+	}{
+		{"OldKey", sign(oldKey, "old"), now, nil},
+		{"NewKey", sign(newKey, "new"), now, nil},
+		{"OldKeyExpired", sign(oldKey, "old"), now.Add(2 * time.Hour), ErrKeyExpired},
+		{"NewKeyNotValidYet", sign(newKey, "new"), now.Add(-time.Hour), ErrKeyNotValidYet},
+		{"UnknownKeyID", sign(newKey, "other"), now, ErrUntrustedKey},
+		{"WrongKeyForID", sign(newKey, "old"), now, ErrInvalidSignature},
+		{"LegacyNoKeyID", sign(oldKey, ""), now, nil},
+		{"LegacyNoKeyIDExpired", sign(oldKey, ""), now.Add(2 * time.Hour), ErrInvalidSignature},
+		{"Unsigned", &apidef.BundleManifest{Checksum: Checksum(bundleData)}, now, ErrUnsigned},
+		{"BadChecksum", &apidef.BundleManifest{Checksum: "x"}, now, ErrInvalidChecksum},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := ring.Verify(tc.manifest, bundleData, tc.at); err != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, err)
+			}
+		})
+	}
+
+	t.Run("EmptyRing", func(t *testing.T) {
+		manifest := &apidef.BundleManifest{Checksum: Checksum(bundleData)}
+		if err := (&KeyRing{}).Verify(manifest, bundleData, now); err != nil {
+			t.Fatalf("unsigned bundle should pass with no trusted keys, got %v", err)
+		}
+	})
+}
+
+func TestParseKeys(t *testing.T) {
+	for name, key := range genKeys(t) {
+		t.Run(name, func(t *testing.T) {
+			der, err := x509.MarshalPKCS8PrivateKey(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer, err := ParsePrivateKey(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			der, err = x509.MarshalPKIXPublicKey(key.Public())
+			if err != nil {
+				t.Fatal(err)
+			}
+			pub, err := ParsePublicKey(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want, _ := KeyID(key.Public())
+			got, _ := KeyID(signer.Public())
+			if got != want {
+				t.Fatalf("private key fingerprint mismatch")
+			}
+			if got, _ = KeyID(pub); got != want {
+				t.Fatalf("public key fingerprint mismatch")
+			}
+		})
+	}
+}
+
+func TestReadBundle(t *testing.T) {
+	manifest := apidef.BundleManifest{
+		FileList: []string{"b.py", "a.py"},
+		Checksum: Checksum([]byte("BA")),
+	}
+	manifestData, _ := json.Marshal(&manifest)
+
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, data := range map[string]string{"a.py": "A", "b.py": "B", "manifest.json": string(manifestData)} {
+		f, _ := w.Create(name)
+		f.Write([]byte(data))
+	}
+	w.Close()
+
+	got, data, err := ReadBundle(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "BA" {
+		t.Fatalf("files should be read in manifest order, got %q", data)
+	}
+	if got.Checksum != manifest.Checksum {
+		t.Fatalf("manifest mismatch")
+	}
+}
@@ -4,17 +4,16 @@ import (
 	"archive/zip"
 	"bufio"
 	"bytes"
-	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"time"
 
-	"github.com/TykTechnologies/goverify"
 	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/bundlesig"
 	logger "github.com/ins-tykgw/tyk/log"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
@@ -37,6 +36,7 @@ var (
 	errManifestLoad = errors.New("Couldn't load manifest file")
 	errBundleData   = errors.New("Couldn't read/write bundle data")
 	errBundleSign   = errors.New("Couldn't sign bundle")
+	errNoVerifyKeys = errors.New("No public keys specified")
 
 	log = logger.Get().WithField("prefix", "tyk")
 )
@@ -44,9 +44,13 @@ var (
 // Bundler wraps the bundler data structure.
 type Bundler struct {
 	keyPath      *string
+	keyID        *string
 	bundlePath   *string
 	skipSigning  *bool
 	manifestPath *string
+
+	verifyBundlePath *string
+	verifyKeys       *[]string
 }
 
 func init() {
@@ -89,7 +93,7 @@ func (b *Bundler) Build(ctx *kingpin.ParseContext) error {
 	}
 
 	// Compute the checksum and append it to the manifest data structure:
-	manifest.Checksum = fmt.Sprintf("%x", md5.Sum(bundleBuf.Bytes()))
+	manifest.Checksum = bundlesig.Checksum(bundleBuf.Bytes())
 
 	if key == "" {
 		if skipSigning {
@@ -105,7 +109,7 @@ func (b *Bundler) Build(ctx *kingpin.ParseContext) error {
 			}
 		}
 	} else {
-		err = b.sign(key, manifest, bundleBuf)
+		err = b.sign(key, *b.keyID, manifest, bundleBuf)
 		if err != nil {
 			return err
 		}
@@ -150,20 +154,72 @@ func (b *Bundler) Build(ctx *kingpin.ParseContext) error {
 	return nil
 }
 
-func (b *Bundler) sign(key string, manifest *apidef.BundleManifest, bundle *bytes.Buffer) (err error) {
-	signer, err := goverify.LoadPrivateKeyFromFile(key)
+func (b *Bundler) sign(key, keyID string, manifest *apidef.BundleManifest, bundle *bytes.Buffer) (err error) {
+	signer, err := bundlesig.LoadPrivateKeyFromFile(key)
+	if err != nil {
+		return err
+	}
+	if keyID == "" {
+		keyID, err = bundlesig.KeyID(signer.Public())
+		if err != nil {
+			return err
+		}
+	}
+	if err := bundlesig.Sign(manifest, bundle.Bytes(), signer, keyID); err != nil {
+		return err
+	}
+	log.Infof("Signing bundle with key '%s' (key ID '%s')", key, keyID)
+	return nil
+}
+
+// Verify checks a bundle's checksum and signature against a set of public
+// keys, using the same code as the gateway.
+func (b *Bundler) Verify(ctx *kingpin.ParseContext) error {
+	bundlePath := *b.verifyBundlePath
+	if len(*b.verifyKeys) == 0 {
+		return errNoVerifyKeys
+	}
+
+	keyRing := &bundlesig.KeyRing{}
+	for _, spec := range *b.verifyKeys {
+		key, err := loadVerifyKey(spec)
+		if err != nil {
+			return err
+		}
+		keyRing.Add(key)
+	}
+
+	data, err := ioutil.ReadFile(bundlePath)
 	if err != nil {
 		return err
 	}
-	signed, err := signer.Sign(bundle.Bytes())
+	manifest, bundleData, err := bundlesig.ReadBundle(data)
 	if err != nil {
 		return err
 	}
-	manifest.Signature = base64.StdEncoding.EncodeToString(signed)
-	log.Infof("Signing bundle with key '%s'", key)
+	if err := keyRing.Verify(manifest, bundleData, time.Now()); err != nil {
+		return err
+	}
+	log.Infof("Bundle '%s' is valid (key ID '%s')", bundlePath, manifest.KeyID)
 	return nil
 }
 
+// loadVerifyKey loads a public key given as "path" or "id=path". Keys
+// without an explicit ID use their fingerprint, which is what the bundler
+// writes when no key ID is given.
+func loadVerifyKey(spec string) (bundlesig.TrustedKey, error) {
+	var id string
+	path := spec
+	if i := strings.Index(spec, "="); i > 0 {
+		id, path = spec[:i], spec[i+1:]
+	}
+	pub, err := bundlesig.LoadPublicKeyFromFile(path)
+	if err != nil {
+		return bundlesig.TrustedKey{}, err
+	}
+	return bundlesig.NewTrustedKey(id, pub)
+}
+
 func (b *Bundler) validateManifest(manifest *apidef.BundleManifest) (err error) {
 	for _, f := range manifest.FileList {
 		if _, err := os.Stat(f); err != nil {
@@ -217,5 +273,11 @@ func AddTo(app *kingpin.Application) {
 	bundler.bundlePath = buildCmd.Flag("output", "Output file").Short('o').Default(defaultBundlePath).String()
 	bundler.skipSigning = buildCmd.Flag("skip-signing", "Skip bundle signing").Short('y').Bool()
 	bundler.manifestPath = buildCmd.Flag("manifest", "Path to manifest file").Default(defaultManifestPath).Short('m').String()
+	bundler.keyID = buildCmd.Flag("key-id", "Key ID stored in the manifest, defaults to the public key fingerprint").String()
 	buildCmd.Action(bundler.Build)
+
+	verifyCmd := cmd.Command("verify", "Verify the checksum and signature of a plugin bundle")
+	bundler.verifyKeys = verifyCmd.Flag("key", "Trusted public key, as a path or id=path (repeatable)").Short('k').Strings()
+	bundler.verifyBundlePath = verifyCmd.Arg("bundle", "Bundle file").Default(defaultBundlePath).String()
+	verifyCmd.Action(bundler.Verify)
 }
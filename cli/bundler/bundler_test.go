@@ -3,13 +3,18 @@ package bundler
 import (
 	"archive/zip"
 	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
 	"encoding/json"
+	"encoding/pem"
 	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/bundlesig"
 
 	kingpin "gopkg.in/alecthomas/kingpin.v2"
 )
@@ -210,3 +215,53 @@ func TestBuild(t *testing.T) {
 		}
 	})
 }
+
+func TestBuildAndVerifySigned(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privDER, _ := x509.MarshalPKCS8PrivateKey(priv)
+	pubDER, _ := x509.MarshalPKIXPublicKey(priv.Public())
+	ioutil.WriteFile("signing.pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER}), 0600)
+	ioutil.WriteFile("signing.pub", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}), 0600)
+	ioutil.WriteFile("middleware.py", []byte("# hook"), 0600)
+	defer func() {
+		for _, f := range []string{"signing.pem", "signing.pub", "middleware.py", defaultManifestPath, defaultBundlePath} {
+			os.Remove(f)
+		}
+	}()
+
+	writeManifestFile(t, &apidef.BundleManifest{
+		FileList:         []string{"middleware.py"},
+		CustomMiddleware: standardManifest.CustomMiddleware,
+	}, defaultManifestPath)
+	if _, err := testApp.Parse([]string{"bundle", "build", "-k", "signing.pem", "--key-id", "2018-q4"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bundler.Build(&kingpin.ParseContext{}); err != nil {
+		t.Fatalf("Couldn't build signed bundle: %s", err.Error())
+	}
+
+	verifyPath := defaultBundlePath
+	bundler.verifyBundlePath = &verifyPath
+
+	tests := []struct {
+		name string
+		keys []string
+		want error
+	}{
+		{"TrustedKey", []string{"2018-q4=signing.pub"}, nil},
+		{"FingerprintID", []string{"signing.pub"}, bundlesig.ErrUntrustedKey},
+		{"NoKeys", nil, errNoVerifyKeys},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			keys := tc.keys
+			bundler.verifyKeys = &keys
+			if err := bundler.Verify(&kingpin.ParseContext{}); err != tc.want {
+				t.Fatalf("want %v, got %v", tc.want, err)
+			}
+		})
+	}
+}
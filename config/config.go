@@ -10,6 +10,7 @@ import (
 	"runtime"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/kelseyhightower/envconfig"
 
@@ -90,6 +91,13 @@ type StorageOptionsConf struct {
 	EnableCluster         bool              `json:"enable_cluster"`
 	UseSSL                bool              `json:"use_ssl"`
 	SSLInsecureSkipVerify bool              `json:"ssl_insecure_skip_verify"`
+	CAFile                string            `json:"ca_file"`
+	CertFile              string            `json:"cert_file"`
+	KeyFile               string            `json:"key_file"`
+	SentinelMasterName    string            `json:"sentinel_master_name"`
+	SentinelAddrs         []string          `json:"sentinel_addrs"`
+	SentinelPassword      string            `json:"sentinel_password"`
+	EmbeddedPath          string            `json:"embedded_path"`
 }
 
 type NormalisedURLConfig struct {
@@ -186,6 +194,31 @@ type HttpServerOptionsConfig struct {
 	SkipURLCleaning        bool       `json:"skip_url_cleaning"`
 	SkipTargetPathEscaping bool       `json:"skip_target_path_escaping"`
 	Ciphers                []string   `json:"ssl_ciphers"`
+
+	// TrustedProxies lists the proxy networks, in CIDR notation, that
+	// are trusted to report the client address. TrustedProxyHops is the
+	// number of proxies in front of the gateway. TrustedProxyHeader is
+	// the header they set, one of X-Forwarded-For (the default),
+	// Forwarded or X-Real-IP; the others are ignored. If neither
+	// networks nor hops are set, the headers are trusted from any
+	// client.
+	TrustedProxies     []string            `json:"trusted_proxies"`
+	TrustedProxyHops   int                 `json:"trusted_proxy_hops"`
+	TrustedProxyHeader string              `json:"trusted_proxy_header"`
+	ProxyProtocol      ProxyProtocolConfig `json:"proxy_protocol"`
+}
+
+// ProxyProtocolConfig enables the HAProxy PROXY protocol, versions 1 and
+// 2, so that the client address is kept behind layer 4 load balancers.
+// Connections without a valid PROXY header are closed.
+type ProxyProtocolConfig struct {
+	Enabled bool `json:"enabled"`
+	// EnableControlAPI also expects the header on the control API
+	// listener, when control_api_port is set.
+	EnableControlAPI bool `json:"enable_control_api"`
+	// HeaderTimeout is how long to wait for the header, in seconds.
+	// Defaults to 5.
+	HeaderTimeout int `json:"header_timeout"`
 }
 
 type AuthOverrideConf struct {
@@ -217,6 +250,19 @@ type CoProcessConfig struct {
 	PythonPathPrefix    string `json:"python_path_prefix"`
 }
 
+// BundleTrustedKey is a public key accepted for plugin bundle signatures.
+// The key is read from Path, or from the certificate store when CertID is
+// set. If ID is empty, the key fingerprint is used as its ID. NotBefore and
+// NotAfter bound the window in which the key is trusted; zero values leave
+// the window open, which allows overlapping keys during rotation.
+type BundleTrustedKey struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	CertID    string    `json:"cert_id"`
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after"`
+}
+
 type CertificatesConfig struct {
 	API        []string          `json:"apis"`
 	Upstream   map[string]string `json:"upstream"`
@@ -230,6 +276,19 @@ type SecurityConfig struct {
 	ControlAPIUseMutualTLS           bool               `json:"control_api_use_mutual_tls"`
 	PinnedPublicKeys                 map[string]string  `json:"pinned_public_keys"`
 	Certificates                     CertificatesConfig `json:"certificates"`
+	// CertificateExpiryWarningDays is how many days before expiry a
+	// CertificateExpiringSoon event is fired for an upstream
+	// certificate. Defaults to 30.
+	CertificateExpiryWarningDays int `json:"certificate_expiry_warning_days"`
+}
+
+// RequestIDConfig enables the request ID middleware. The ID is taken from
+// Header on the incoming request, or generated by Generator, "uuid" or
+// "ulid", and is sent upstream and returned to the client in Header.
+type RequestIDConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Header    string `json:"header"`
+	Generator string `json:"generator"`
 }
 
 type NewRelicConfig struct {
@@ -272,7 +331,10 @@ type Config struct {
 	PublicKeyPath             string                  `json:"public_key_path"`
 	AllowRemoteConfig         bool                    `bson:"allow_remote_config" json:"allow_remote_config"`
 	Security                  SecurityConfig          `json:"security"`
+	Secrets                   map[string]string       `json:"secrets"`
+	SecretsDir                string                  `json:"secrets_dir"`
 	HttpServerOptions         HttpServerOptionsConfig `json:"http_server_options"`
+	RequestID                 RequestIDConfig         `json:"request_id"`
 	ReloadWaitTime            int                     `bson:"reload_wait_time" json:"reload_wait_time"`
 	VersionHeader             string                  `json:"version_header"`
 	UseAsyncSessionWrite      bool                    `json:"optimisations_use_async_session_write"`
@@ -283,6 +345,7 @@ type Config struct {
 	HashKeyFunction         string         `json:"hash_key_function"`
 	EnableHashedKeysListing bool           `json:"enable_hashed_keys_listing"`
 	MinTokenLength          int            `json:"min_token_length"`
+	KeyRotationGracePeriod  int64          `json:"key_rotation_grace_period"`
 	EnableAPISegregation    bool           `json:"enable_api_segregation"`
 	TemplatePath            string         `json:"template_path"`
 	Policies                PoliciesConfig `json:"policies"`
@@ -356,14 +419,15 @@ type Config struct {
 	CacheStorage             StorageOptionsConf    `json:"cache_storage"`
 
 	// Middleware/Plugin Configuration
-	EnableBundleDownloader  bool            `bson:"enable_bundle_downloader" json:"enable_bundle_downloader"`
-	BundleBaseURL           string          `bson:"bundle_base_url" json:"bundle_base_url"`
-	EnableJSVM              bool            `json:"enable_jsvm"`
-	JSVMTimeout             int             `json:"jsvm_timeout"`
-	DisableVirtualPathBlobs bool            `json:"disable_virtual_path_blobs"`
-	TykJSPath               string          `json:"tyk_js_path"`
-	MiddlewarePath          string          `json:"middleware_path"`
-	CoProcessOptions        CoProcessConfig `json:"coprocess_options"`
+	EnableBundleDownloader  bool               `bson:"enable_bundle_downloader" json:"enable_bundle_downloader"`
+	BundleBaseURL           string             `bson:"bundle_base_url" json:"bundle_base_url"`
+	BundleTrustedKeys       []BundleTrustedKey `json:"bundle_trusted_keys"`
+	EnableJSVM              bool               `json:"enable_jsvm"`
+	JSVMTimeout             int                `json:"jsvm_timeout"`
+	DisableVirtualPathBlobs bool               `json:"disable_virtual_path_blobs"`
+	TykJSPath               string             `json:"tyk_js_path"`
+	MiddlewarePath          string             `json:"middleware_path"`
+	CoProcessOptions        CoProcessConfig    `json:"coprocess_options"`
 
 	// Monitoring, Logging & Profiling
 	LogLevel                string         `json:"log_level"`
@@ -29,6 +29,8 @@ const (
 	ThrottleLevelLimit
 	Trace
 	CheckLoopLimits
+	RequestID
+	WebSocketConn
 )
 
 func setContext(r *http.Request, ctx context.Context) {
@@ -44,7 +44,17 @@ type AnalyticsRecord struct {
 	Tags          []string
 	Alias         string
 	TrackPath     bool
-	ExpireAt      time.Time `bson:"expireAt" json:"expireAt"`
+	RequestID     string
+	WebSocket     *WebSocketRecord // Set for WebSocket connections, once closed
+	ExpireAt      time.Time        `bson:"expireAt" json:"expireAt"`
+}
+
+// WebSocketRecord describes a proxied WebSocket connection.
+type WebSocketRecord struct {
+	MessagesIn  int64 // Messages sent by the client
+	MessagesOut int64 // Messages sent by the upstream
+	Duration    int64 // Milliseconds the connection was open
+	CloseReason string
 }
 
 type GeoData struct {
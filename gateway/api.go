@@ -864,10 +864,15 @@ func keyHandler(w http.ResponseWriter, r *http.Request) {
 					)
 					return
 				}
+			}
 
+			switch {
+			case isKeysPageRequest(r.URL.Query()):
+				obj, code = handleGetKeysPage(r.URL.Query(), apiID)
+			case config.Global().HashKeys:
 				// we don't use filter for hashed keys
 				obj, code = handleGetAllKeys("", apiID)
-			} else {
+			default:
 				filter := r.URL.Query().Get("filter")
 				obj, code = handleGetAllKeys(filter, apiID)
 			}
@@ -1326,6 +1331,7 @@ type NewClientRequest struct {
 	ClientSecret      string      `json:"secret"`
 	MetaData          interface{} `json:"meta_data"`
 	Description       string      `json:"description"`
+	AllowedScopes     []string    `json:"allowed_scopes,omitempty"`
 }
 
 func oauthClientStorageID(clientID string) string {
@@ -1366,6 +1372,7 @@ func createOauthClient(w http.ResponseWriter, r *http.Request) {
 		PolicyID:          newOauthClient.PolicyID,
 		MetaData:          newOauthClient.MetaData,
 		Description:       newOauthClient.Description,
+		AllowedScopes:     newOauthClient.AllowedScopes,
 	}
 
 	storageID := oauthClientStorageID(newClient.GetId())
@@ -1531,6 +1538,7 @@ func updateOauthClient(keyName, apiID string, r *http.Request) (interface{}, int
 		PolicyID:          updateClientData.PolicyID,          // update
 		MetaData:          client.GetUserData(),               // DO NOT update
 		Description:       updateClientData.Description,       // update
+		AllowedScopes:     updateClientData.AllowedScopes,     // update
 	}
 
 	err = apiSpec.OAuthManager.OsinServer.Storage.SetClient(storageID, &updatedClient, true)
@@ -1925,6 +1933,35 @@ func invalidateCacheHandler(w http.ResponseWriter, r *http.Request) {
 	doJSONWrite(w, http.StatusOK, apiOk("cache invalidated"))
 }
 
+func circuitBreakerHandler(w http.ResponseWriter, r *http.Request) {
+	apiID := mux.Vars(r)["apiID"]
+
+	spec := getApiSpec(apiID)
+	if spec == nil {
+		doJSONWrite(w, http.StatusNotFound, apiError("API not found"))
+		return
+	}
+
+	breakers := spec.circuitBreakers()
+	if r.Method == http.MethodDelete {
+		for _, cb := range breakers {
+			cb.Reset()
+		}
+		log.WithFields(logrus.Fields{
+			"prefix": "api",
+			"api_id": apiID,
+		}).Info("Circuit breakers reset")
+		doJSONWrite(w, http.StatusOK, apiOk("circuit breakers reset"))
+		return
+	}
+
+	status := []BreakerStatus{}
+	for _, cb := range breakers {
+		status = append(status, cb.Status()...)
+	}
+	doJSONWrite(w, http.StatusOK, status)
+}
+
 // TODO: Don't modify http.Request values in-place. We must right now
 // because our middleware design doesn't pass around http.Request
 // pointers, so we have no way to modify the pointer in a middleware.
@@ -2162,3 +2199,25 @@ func ctxTraceEnabled(r *http.Request) bool {
 func ctxSetTrace(r *http.Request) {
 	setCtxValue(r, ctx.Trace, true)
 }
+
+func ctxGetRequestID(r *http.Request) string {
+	if v := r.Context().Value(ctx.RequestID); v != nil {
+		return v.(string)
+	}
+	return ""
+}
+
+func ctxSetRequestID(r *http.Request, id string) {
+	setCtxValue(r, ctx.RequestID, id)
+}
+
+func ctxGetWebSocketConn(r *http.Request) *wsConn {
+	if v := r.Context().Value(ctx.WebSocketConn); v != nil {
+		return v.(*wsConn)
+	}
+	return nil
+}
+
+func ctxSetWebSocketConn(r *http.Request, c *wsConn) {
+	setCtxValue(r, ctx.WebSocketConn, c)
+}
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
@@ -23,7 +24,6 @@ import (
 	"github.com/ins-tykgw/tyk/rpc"
 
 	"github.com/Sirupsen/logrus"
-	circuit "github.com/rubyist/circuitbreaker"
 
 	"github.com/TykTechnologies/gojsonschema"
 	"github.com/ins-tykgw/tyk/apidef"
@@ -75,6 +75,9 @@ const (
 	RequestNotTracked
 	ValidateJSONRequest
 	Internal
+	UpstreamCertificate
+	BodyConverted
+	BodyConvertedResponse
 )
 
 // RequestStatus is a custom type to avoid collisions
@@ -107,6 +110,9 @@ const (
 	StatusRequestNotTracked        RequestStatus = "Request Not Tracked"
 	StatusValidateJSON             RequestStatus = "Validate JSON"
 	StatusInternal                 RequestStatus = "Internal path"
+	StatusUpstreamCertificate      RequestStatus = "Upstream certificate selected"
+	StatusBodyConverted            RequestStatus = "Body converted"
+	StatusBodyConvertedResponse    RequestStatus = "Body converted on response"
 )
 
 // URLSpec represents a flattened specification for URLs, used to check if a proxy URL
@@ -133,6 +139,11 @@ type URLSpec struct {
 	DoNotTrackEndpoint        apidef.TrackEndpointMeta
 	ValidatePathMeta          apidef.ValidatePathMeta
 	Internal                  apidef.InternalMeta
+	UpstreamCertificate       apidef.UpstreamCertificateMeta
+
+	// mockReplies are the compiled reply method actions, by method
+	mockReplies map[string]*mockReply
+	convertBody *bodyConverter
 }
 
 type EndPointCacheMeta struct {
@@ -143,11 +154,13 @@ type EndPointCacheMeta struct {
 type TransformSpec struct {
 	apidef.TemplateMeta
 	Template *template.Template
+	// Filter is set instead of Template for the jq engine.
+	Filter jqFilter
 }
 
 type ExtendedCircuitBreakerMeta struct {
 	apidef.CircuitBreakerMeta
-	CB *circuit.Breaker `json:"-"`
+	CB *Breaker `json:"-"`
 }
 
 // APISpec represents a path specification for an API, to avoid enumerating multiple nested lists, a single
@@ -171,6 +184,7 @@ type APISpec struct {
 	URLRewriteEnabled        bool
 	CircuitBreakerEnabled    bool
 	EnforcedTimeoutEnabled   bool
+	UpstreamCertsByPath      bool
 	LastGoodHostList         *apidef.HostList
 	HasRun                   bool
 	ServiceRefreshInProgress bool
@@ -183,7 +197,17 @@ type APISpec struct {
 
 	middlewareChain http.Handler
 
-	shouldRelease bool
+	shouldRelease          bool
+	serviceDiscoveryCancel context.CancelFunc
+	outliers               *outlierDetector
+	upstreamAuth           upstreamAuthenticator
+	// certTransports are the upstream transports presenting a client
+	// certificate, by certificate ID
+	certTransports map[string]http.RoundTripper
+	errorOverrides map[string]*errorOverride
+	// openAPIDocs are the compiled OpenAPI documents, by version name.
+	// They are nil for the versions whose document couldn't be compiled.
+	openAPIDocs map[string]*openAPIDocument
 }
 
 // Release re;leases all resources associated with API spec
@@ -191,17 +215,9 @@ func (s *APISpec) Release() {
 	// mark spec as to be released
 	s.shouldRelease = true
 
-	// release circuit breaker resources
-	for _, path := range s.RxPaths {
-		for _, urlSpec := range path {
-			if urlSpec.CircuitBreaker.CB != nil {
-				// this will force CB-event reading routing to exit
-				urlSpec.CircuitBreaker.CB.Reset()
-
-				// TODO: close all circuit breaker's event receivers
-				// which should let event reading Go-routines to exit (need to change circuitbreaker package)
-			}
-		}
+	// stop service discovery watches
+	if s.serviceDiscoveryCancel != nil {
+		s.serviceDiscoveryCancel()
 	}
 
 	// release all other resources associated with spec
@@ -278,6 +294,8 @@ func (a APIDefinitionLoader) MakeSpec(def *apidef.APIDefinition, logger *logrus.
 		}
 	}
 
+	spec.errorOverrides = compileErrorOverrides(def.ErrorOverrides, logger)
+
 	spec.RxPaths = make(map[string][]URLSpec, len(def.VersionData.Versions))
 	spec.WhiteListEnabled = make(map[string]bool, len(def.VersionData.Versions))
 	for _, v := range def.VersionData.Versions {
@@ -293,6 +311,17 @@ func (a APIDefinitionLoader) MakeSpec(def *apidef.APIDefinition, logger *logrus.
 		}
 		spec.RxPaths[v.Name] = pathSpecs
 		spec.WhiteListEnabled[v.Name] = whiteListSpecs
+
+		if v.OpenAPI.Enabled {
+			doc, err := compileOpenAPI(v.OpenAPI)
+			if err != nil {
+				logger.WithError(err).WithField("version", v.Name).Error("Could not load OpenAPI document, requests will be rejected")
+			}
+			if spec.openAPIDocs == nil {
+				spec.openAPIDocs = make(map[string]*openAPIDocument)
+			}
+			spec.openAPIDocs[v.Name] = doc
+		}
 	}
 
 	return spec
@@ -520,6 +549,15 @@ func (a APIDefinitionLoader) compileExtendedPathSpec(paths []apidef.EndPointMeta
 
 		// Extend with method actions
 		newSpec.MethodActions = stringSpec.MethodActions
+		for method, action := range stringSpec.MethodActions {
+			if action.Action != apidef.Reply {
+				continue
+			}
+			if newSpec.mockReplies == nil {
+				newSpec.mockReplies = make(map[string]*mockReply)
+			}
+			newSpec.mockReplies[method] = a.compileMockReply(stringSpec.Path, newSpec.Spec, action)
+		}
 		urlSpec = append(urlSpec, newSpec)
 	}
 
@@ -574,6 +612,71 @@ func (a APIDefinitionLoader) loadBlobTemplate(blob string) (*template.Template,
 	return apidef.Template.New("").Funcs(a.filterSprigFuncs()).Parse(string(uDec))
 }
 
+// loadJQTemplate compiles a jq filter, read from a file or a base64
+// encoded blob like Go templates are.
+func (a APIDefinitionLoader) loadJQTemplate(data apidef.TemplateData) (jqFilter, error) {
+	var source []byte
+	var err error
+	switch data.Mode {
+	case apidef.UseFile:
+		source, err = ioutil.ReadFile(data.TemplateSource)
+	case apidef.UseBlob:
+		source, err = base64.StdEncoding.DecodeString(data.TemplateSource)
+	default:
+		err = errors.New("No valid template mode defined, must be either 'file' or 'blob'")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return newJQFilter(string(source))
+}
+
+// compileMockReply parses the templates and triggers of a reply method
+// action on path. Templates that fail to parse are logged and sent as
+// they are.
+func (a APIDefinitionLoader) compileMockReply(path string, pathRx *regexp.Regexp, meta apidef.EndpointMethodMeta) *mockReply {
+	mock := &mockReply{EndpointMethodMeta: meta, pathRx: pathRx}
+	for _, m := range mockPathParam.FindAllStringSubmatch(path, -1) {
+		mock.pathParams = append(mock.pathParams, m[1])
+	}
+
+	compile := func(code int, data string, headers map[string]string) mockResponse {
+		res := mockResponse{code: code, data: data, headers: headers}
+		if !meta.Template {
+			return res
+		}
+		var err error
+		if res.body, err = apidef.Template.New("").Funcs(a.filterSprigFuncs()).Parse(data); err != nil {
+			log.WithError(err).WithField("path", path).Error("Could not parse mock reply template")
+		}
+		res.headerTmpls = make(map[string]*template.Template, len(headers))
+		for name, value := range headers {
+			tmpl, err := apidef.Template.New("").Funcs(a.filterSprigFuncs()).Parse(value)
+			if err != nil {
+				log.WithError(err).WithField("header", name).Error("Could not parse mock reply header template")
+				continue
+			}
+			res.headerTmpls[name] = tmpl
+		}
+		return res
+	}
+
+	mock.reply = compile(meta.Code, meta.Data, meta.Headers)
+	for _, v := range meta.Variants {
+		initTriggerOptions(&v.Options)
+		code := v.Code
+		if code == 0 {
+			code = meta.Code
+		}
+		mock.variants = append(mock.variants, mockVariant{
+			any:      v.On == apidef.Any,
+			options:  v.Options,
+			response: compile(code, v.Data, v.Headers),
+		})
+	}
+	return mock
+}
+
 func (a APIDefinitionLoader) compileTransformPathSpec(paths []apidef.TemplateMeta, stat URLStatus) []URLSpec {
 	// transform an extended configuration URL into an array of URLSpecs
 	// This way we can iterate the whole array once, on match we break with status
@@ -591,16 +694,24 @@ func (a APIDefinitionLoader) compileTransformPathSpec(paths []apidef.TemplateMet
 		// Load the templates
 		var err error
 
-		switch stringSpec.TemplateData.Mode {
-		case apidef.UseFile:
-			log.Debug("-- Using File mode")
-			newTransformSpec.Template, err = a.loadFileTemplate(stringSpec.TemplateData.TemplateSource)
-		case apidef.UseBlob:
-			log.Debug("-- Blob mode")
-			newTransformSpec.Template, err = a.loadBlobTemplate(stringSpec.TemplateData.TemplateSource)
+		switch stringSpec.TemplateData.Engine {
+		case apidef.JQTemplateEngine:
+			log.Debug("-- Using jq engine")
+			newTransformSpec.Filter, err = a.loadJQTemplate(stringSpec.TemplateData)
+		case "", apidef.GoTemplateEngine:
+			switch stringSpec.TemplateData.Mode {
+			case apidef.UseFile:
+				log.Debug("-- Using File mode")
+				newTransformSpec.Template, err = a.loadFileTemplate(stringSpec.TemplateData.TemplateSource)
+			case apidef.UseBlob:
+				log.Debug("-- Blob mode")
+				newTransformSpec.Template, err = a.loadBlobTemplate(stringSpec.TemplateData.TemplateSource)
+			default:
+				log.Warning("[Transform Templates] No template mode defined! Found: ", stringSpec.TemplateData.Mode)
+				err = errors.New("No valid template mode defined, must be either 'file' or 'blob'")
+			}
 		default:
-			log.Warning("[Transform Templates] No template mode defined! Found: ", stringSpec.TemplateData.Mode)
-			err = errors.New("No valid template mode defined, must be either 'file' or 'blob'")
+			err = fmt.Errorf("unknown template engine %q, must be either 'go_template' or 'jq'", stringSpec.TemplateData.Engine)
 		}
 
 		if stat == Transformed {
@@ -703,59 +814,7 @@ func (a APIDefinitionLoader) compileCircuitBreakerPathSpec(paths []apidef.Circui
 		// Extend with method actions
 		newSpec.CircuitBreaker = ExtendedCircuitBreakerMeta{CircuitBreakerMeta: stringSpec}
 		log.Debug("Initialising circuit breaker for: ", stringSpec.Path)
-		newSpec.CircuitBreaker.CB = circuit.NewRateBreaker(stringSpec.ThresholdPercent, stringSpec.Samples)
-		events := newSpec.CircuitBreaker.CB.Subscribe()
-		go func(path string, spec *APISpec, breakerPtr *circuit.Breaker) {
-			timerActive := false
-			for e := range events {
-				switch e {
-				case circuit.BreakerTripped:
-					log.Warning("[PROXY] [CIRCUIT BREAKER] Breaker tripped for path: ", path)
-					log.Debug("Breaker tripped: ", e)
-					// Start a timer function
-
-					if !timerActive {
-						go func(timeout int, breaker *circuit.Breaker) {
-							log.Debug("-- Sleeping for (s): ", timeout)
-							time.Sleep(time.Duration(timeout) * time.Second)
-							log.Debug("-- Resetting breaker")
-							breaker.Reset()
-							timerActive = false
-						}(newSpec.CircuitBreaker.ReturnToServiceAfter, breakerPtr)
-						timerActive = true
-					}
-
-					if spec.Proxy.ServiceDiscovery.UseDiscoveryService {
-						if ServiceCache != nil {
-							log.Warning("[PROXY] [CIRCUIT BREAKER] Refreshing host list")
-							ServiceCache.Delete(spec.APIID)
-						}
-					}
-
-					spec.FireEvent(EventBreakerTriggered, EventCurcuitBreakerMeta{
-						EventMetaDefault: EventMetaDefault{Message: "Breaker Tripped"},
-						CircuitEvent:     e,
-						Path:             path,
-						APIID:            spec.APIID,
-					})
-
-				case circuit.BreakerReset:
-					// check if this spec is set to release resources
-					if spec.shouldRelease {
-						// time to stop this Go-routine
-						return
-					}
-
-					spec.FireEvent(EventBreakerTriggered, EventCurcuitBreakerMeta{
-						EventMetaDefault: EventMetaDefault{Message: "Breaker Reset"},
-						CircuitEvent:     e,
-						Path:             path,
-						APIID:            spec.APIID,
-					})
-
-				}
-			}
-		}(stringSpec.Path, apiSpec, newSpec.CircuitBreaker.CB)
+		newSpec.CircuitBreaker.CB = newBreaker(stringSpec, apiSpec)
 
 		urlSpec = append(urlSpec, newSpec)
 	}
@@ -868,6 +927,37 @@ func (a APIDefinitionLoader) compileInternalPathspathSpec(paths []apidef.Interna
 	return urlSpec
 }
 
+func (a APIDefinitionLoader) compileUpstreamCertificatePathSpec(paths []apidef.UpstreamCertificateMeta, stat URLStatus) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat)
+		newSpec.UpstreamCertificate = stringSpec
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
+func (a APIDefinitionLoader) compileConvertBodyPathSpec(paths []apidef.ConvertBodyMeta, stat URLStatus) []URLSpec {
+	urlSpec := []URLSpec{}
+
+	for _, stringSpec := range paths {
+		converter, err := compileBodyConverter(stringSpec)
+		if err != nil {
+			log.WithError(err).WithField("path", stringSpec.Path).Error("Could not load body conversion, skipping")
+			continue
+		}
+		newSpec := URLSpec{}
+		a.generateRegex(stringSpec.Path, &newSpec, stat)
+		newSpec.convertBody = converter
+		urlSpec = append(urlSpec, newSpec)
+	}
+
+	return urlSpec
+}
+
 func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionInfo, apiSpec *APISpec) ([]URLSpec, bool) {
 	// TODO: New compiler here, needs to put data into a different structure
 
@@ -891,6 +981,9 @@ func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionIn
 	unTrackedPaths := a.compileUnTrackedEndpointPathspathSpec(apiVersionDef.ExtendedPaths.DoNotTrackEndpoints, RequestNotTracked)
 	validateJSON := a.compileValidateJSONPathspathSpec(apiVersionDef.ExtendedPaths.ValidateJSON, ValidateJSONRequest)
 	internalPaths := a.compileInternalPathspathSpec(apiVersionDef.ExtendedPaths.Internal, Internal)
+	upstreamCertificates := a.compileUpstreamCertificatePathSpec(apiVersionDef.ExtendedPaths.UpstreamCertificates, UpstreamCertificate)
+	convertBody := a.compileConvertBodyPathSpec(apiVersionDef.ExtendedPaths.ConvertBody, BodyConverted)
+	convertBodyResponse := a.compileConvertBodyPathSpec(apiVersionDef.ExtendedPaths.ConvertBodyResponse, BodyConvertedResponse)
 
 	combinedPath := []URLSpec{}
 	combinedPath = append(combinedPath, ignoredPaths...)
@@ -913,6 +1006,9 @@ func (a APIDefinitionLoader) getExtendedPathSpecs(apiVersionDef apidef.VersionIn
 	combinedPath = append(combinedPath, unTrackedPaths...)
 	combinedPath = append(combinedPath, validateJSON...)
 	combinedPath = append(combinedPath, internalPaths...)
+	combinedPath = append(combinedPath, upstreamCertificates...)
+	combinedPath = append(combinedPath, convertBody...)
+	combinedPath = append(combinedPath, convertBodyResponse...)
 
 	return combinedPath, len(whiteListPaths) > 0
 }
@@ -971,6 +1067,12 @@ func (a *APISpec) getURLStatus(stat URLStatus) RequestStatus {
 		return StatusValidateJSON
 	case Internal:
 		return StatusInternal
+	case UpstreamCertificate:
+		return StatusUpstreamCertificate
+	case BodyConverted:
+		return StatusBodyConverted
+	case BodyConvertedResponse:
+		return StatusBodyConvertedResponse
 
 	default:
 		log.Error("URL Status was not one of Ignored, Blacklist or WhiteList! Blocking.")
@@ -1000,7 +1102,7 @@ func (a *APISpec) URLAllowedAndIgnored(r *http.Request, rxPaths []URLSpec, white
 				// NoAction status means we're not treating this request in any special or exceptional way
 				return a.getURLStatus(v.Status), nil
 			case apidef.Reply:
-				return StatusRedirectFlowByReply, &methodMeta
+				return StatusRedirectFlowByReply, v.mockReplies[r.Method]
 			default:
 				log.Error("URL Method Action was not set to NoAction, blocking.")
 				return EndPointNotAllowed, nil
@@ -1024,7 +1126,7 @@ func (a *APISpec) URLAllowedAndIgnored(r *http.Request, rxPaths []URLSpec, white
 			}
 		}
 
-		if v.TransformAction.Template != nil {
+		if v.TransformAction.Template != nil || v.TransformAction.Filter != nil {
 			return a.getURLStatus(v.Status), &v.TransformAction
 		}
 
@@ -1057,7 +1159,7 @@ func (a *APISpec) CheckSpecMatchesStatus(r *http.Request, rxPaths []URLSpec, mod
 
 	//If url-rewrite middleware was used, call response middleware of original path and not of rewritten path
 	// context variable UrlRewritePath is set by rewrite middleware
-	if mode == TransformedJQResponse || mode == HeaderInjectedResponse || mode == TransformedResponse {
+	if mode == TransformedJQResponse || mode == HeaderInjectedResponse || mode == TransformedResponse || mode == BodyConvertedResponse {
 		matchPath = ctxGetUrlRewritePath(r)
 		method = ctxGetRequestMethod(r)
 		if matchPath == "" {
@@ -1156,6 +1258,14 @@ func (a *APISpec) CheckSpecMatchesStatus(r *http.Request, rxPaths []URLSpec, mod
 			if method == v.Internal.Method {
 				return true, &v.Internal
 			}
+		case UpstreamCertificate:
+			if method == v.UpstreamCertificate.Method {
+				return true, &v.UpstreamCertificate
+			}
+		case BodyConverted, BodyConvertedResponse:
+			if method == v.convertBody.Method {
+				return true, v.convertBody
+			}
 		}
 	}
 	return false, nil
@@ -1224,7 +1334,7 @@ func (a *APISpec) RequestValid(r *http.Request) (bool, RequestStatus, interface{
 
 	// Is the API version expired?
 	// TODO: Don't abuse the interface{} return value for both
-	// *apidef.EndpointMethodMeta and *time.Time. Probably need to
+	// *mockReply and *time.Time. Probably need to
 	// redesign or entirely remove RequestValid. See discussion on
 	// https://github.com/ins-tykgw/tyk/pull/776
 	expired, expTime := a.VersionExpired(versionMetaData)
@@ -32,6 +32,10 @@ type ChainObject struct {
 	Index          int
 	Skip           bool
 	Subrouter      *mux.Router
+
+	// IntrospectionChain serves consumers their own key, when enabled.
+	IntrospectionChain http.Handler
+	IntrospectionPath  string
 }
 
 func prepareStorage() (storage.RedisCluster, storage.RedisCluster, storage.RedisCluster, RPCStorageHandler, RPCStorageHandler) {
@@ -248,6 +252,14 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 	} else {
 		proxy = TykNewSingleHostReverseProxy(spec.target, spec)
 	}
+	startServiceDiscoveryWatches(spec)
+	if spec.Proxy.OutlierDetection.Enabled {
+		spec.outliers = newOutlierDetector(spec, GlobalHostChecker.store)
+	}
+	spec.upstreamAuth = newUpstreamAuth(spec.UpstreamAuth)
+	if f, ok := spec.upstreamAuth.(failedUpstreamAuth); ok {
+		logger.WithError(f.err).Error("Upstream authentication is misconfigured, requests will fail")
+	}
 
 	// Create the response processors
 	createResponseMiddlewareChain(spec)
@@ -261,6 +273,9 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 		if len(v.ExtendedPaths.HardTimeouts) > 0 {
 			baseMid.Spec.EnforcedTimeoutEnabled = true
 		}
+		if len(v.ExtendedPaths.UpstreamCertificates) > 0 {
+			baseMid.Spec.UpstreamCertsByPath = true
+		}
 	}
 
 	keyPrefix := "cache-" + spec.APIID
@@ -270,12 +285,14 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 	var chain http.Handler
 	var chainArray []alice.Constructor
 	var authArray []alice.Constructor
+	auth := newAuthChain(spec)
 
 	if spec.UseKeylessAccess {
 		chainDef.Open = true
 		logger.Info("Checking security policy: Open")
 	}
 
+	mwAppendEnabled(&chainArray, &RequestIDMiddleware{BaseMiddleware: baseMid})
 	handleCORS(&chainArray, spec)
 
 	for _, obj := range mwPreFuncs {
@@ -299,35 +316,49 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 	mwAppendEnabled(&chainArray, &RateCheckMW{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &IPWhiteListMiddleware{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &IPBlackListMiddleware{BaseMiddleware: baseMid})
-	mwAppendEnabled(&chainArray, &CertificateCheckMW{BaseMiddleware: baseMid})
+	// with an auth composition, the client certificate check is one of the
+	// auth methods
+	certCheck := &CertificateCheckMW{BaseMiddleware: baseMid}
+	if spec.UseKeylessAccess || !auth.composed() {
+		mwAppendEnabled(&chainArray, certCheck)
+	}
 	mwAppendEnabled(&chainArray, &OrganizationMonitor{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &VersionCheck{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &RequestSizeLimitMiddleware{baseMid})
+	mwAppendEnabled(&chainArray, &RequestDecompressionMiddleware{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &MiddlewareContextVars{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &TrackEndpointMiddleware{baseMid})
 
 	if !spec.UseKeylessAccess {
 		// Select the keying method to use for setting session states
-		if mwAppendEnabled(&authArray, &Oauth2KeyExists{baseMid}) {
+		if auth.appendEnabled(apidef.OAuthKey, &Oauth2KeyExists{baseMid}) {
 			logger.Info("Checking security policy: OAuth")
 		}
 
-		if mwAppendEnabled(&authArray, &BasicAuthKeyIsValid{baseMid, nil, nil}) {
+		if auth.appendEnabled(apidef.BasicAuthUser, &BasicAuthKeyIsValid{baseMid, nil, nil}) {
 			logger.Info("Checking security policy: Basic")
 		}
 
-		if mwAppendEnabled(&authArray, &HMACMiddleware{BaseMiddleware: baseMid}) {
+		if auth.appendEnabled(apidef.HMACKey, &HMACMiddleware{BaseMiddleware: baseMid}) {
 			logger.Info("Checking security policy: HMAC")
 		}
 
-		if mwAppendEnabled(&authArray, &JWTMiddleware{baseMid}) {
+		if auth.appendEnabled(apidef.JWTClaim, &JWTMiddleware{baseMid}) {
 			logger.Info("Checking security policy: JWT")
 		}
 
-		if mwAppendEnabled(&authArray, &OpenIDMW{BaseMiddleware: baseMid}) {
+		if auth.appendEnabled(apidef.OIDCUser, &OpenIDMW{BaseMiddleware: baseMid}) {
 			logger.Info("Checking security policy: OpenID")
 		}
 
+		if auth.appendEnabled(apidef.ExternalOAuthUser, &ExternalOAuthMiddleware{BaseMiddleware: baseMid}) {
+			logger.Info("Checking security policy: External OAuth")
+		}
+
+		if auth.appendEnabled(apidef.OIDCLoginUser, &OIDCLoginMiddleware{BaseMiddleware: baseMid}) {
+			logger.Info("Checking security policy: OIDC login")
+		}
+
 		coprocessAuth := EnableCoProcess && mwDriver != apidef.OttoDriver && spec.EnableCoProcessAuth
 		ottoAuth := !coprocessAuth && mwDriver == apidef.OttoDriver && spec.EnableCoProcessAuth
 		gopluginAuth := !coprocessAuth && !ottoAuth && mwDriver == apidef.GoPluginDriver && spec.UseGoPluginAuth
@@ -337,18 +368,23 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 			coprocessLog.Debug("Registering coprocess middleware, hook name: ", mwAuthCheckFunc.Name, "hook type: CustomKeyCheck", ", driver: ", mwDriver)
 
 			newExtractor(spec, baseMid)
-			mwAppendEnabled(&authArray, &CoProcessMiddleware{baseMid, coprocess.HookType_CustomKeyCheck, mwAuthCheckFunc.Name, mwDriver, mwAuthCheckFunc.RawBodyOnly, nil})
+			auth.appendEnabled(apidef.CustomAuth, &CoProcessMiddleware{baseMid, coprocess.HookType_CustomKeyCheck, mwAuthCheckFunc.Name, mwDriver, mwAuthCheckFunc.RawBodyOnly, nil})
 		}
 
 		if ottoAuth {
 			logger.Info("----> Checking security policy: JS Plugin")
 
-			authArray = append(authArray, createDynamicMiddleware(mwAuthCheckFunc.Name, true, false, baseMid))
+			auth.append(apidef.CustomAuth, &DynamicMiddleware{
+				BaseMiddleware:      baseMid,
+				MiddlewareClassName: mwAuthCheckFunc.Name,
+				Pre:                 true,
+			})
 		}
 
 		if gopluginAuth {
+			// Go plugins write their own errors, so can't be composed
 			mwAppendEnabled(
-				&authArray,
+				&auth.array,
 				&GoPluginMiddleware{
 					BaseMiddleware: baseMid,
 					Path:           mwAuthCheckFunc.Path,
@@ -357,11 +393,25 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 			)
 		}
 
-		if spec.UseStandardAuth || len(authArray) == 0 {
+		if auth.composed() {
+			auth.appendEnabled(apidef.MutualTLS, certCheck)
+		}
+
+		if spec.UseStandardAuth || auth.empty() {
 			logger.Info("Checking security policy: Token")
-			authArray = append(authArray, createMiddleware(&AuthKey{baseMid}))
+			auth.append(apidef.AuthToken, &AuthKey{baseMid})
+		}
+
+		if auth.composed() {
+			if unlisted := auth.unlisted(spec); len(unlisted) > 0 {
+				logger.Error("Auth methods enabled but not listed by any auth group: ", unlisted)
+				logger.Warning("Spec not valid, skipped!")
+				chainDef.Skip = true
+				return &chainDef
+			}
 		}
 
+		authArray = auth.constructors(baseMid)
 		chainArray = append(chainArray, authArray...)
 
 		for _, obj := range mwPostAuthCheckFuncs {
@@ -389,6 +439,8 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 	mwAppendEnabled(&chainArray, &RateLimitForAPI{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &ValidateJSON{BaseMiddleware: baseMid})
+	mwAppendEnabled(&chainArray, &OpenAPIValidation{BaseMiddleware: baseMid})
+	mwAppendEnabled(&chainArray, &ConvertBodyMiddleware{BaseMiddleware: baseMid})
 	mwAppendEnabled(&chainArray, &TransformMiddleware{baseMid})
 	mwAppendEnabled(&chainArray, &TransformJQMiddleware{baseMid})
 	mwAppendEnabled(&chainArray, &TransformHeaders{BaseMiddleware: baseMid})
@@ -419,6 +471,7 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 
 	if !spec.UseKeylessAccess {
 		var simpleArray []alice.Constructor
+		mwAppendEnabled(&simpleArray, &RequestIDMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&simpleArray, &IPWhiteListMiddleware{baseMid})
 		mwAppendEnabled(&simpleArray, &IPBlackListMiddleware{BaseMiddleware: baseMid})
 		mwAppendEnabled(&simpleArray, &OrganizationMonitor{BaseMiddleware: baseMid})
@@ -434,6 +487,16 @@ func processSpec(spec *APISpec, apisByListen map[string]int,
 		chainDef.RateLimitPath = rateLimitPath
 		chainDef.RateLimitChain = alice.New(simpleArray...).
 			Then(http.HandlerFunc(userRatesCheck))
+
+		if spec.SessionIntrospection.Enabled {
+			introspectionPath := spec.SessionIntrospection.Path
+			if introspectionPath == "" {
+				introspectionPath = defaultSessionIntrospectionPath
+			}
+			chainDef.IntrospectionPath = spec.Proxy.ListenPath + strings.TrimPrefix(introspectionPath, "/")
+			chainDef.IntrospectionChain = alice.New(simpleArray...).
+				Then(sessionIntrospectionHandler(spec))
+		}
 	}
 
 	logger.Debug("Setting Listen Path: ", spec.Proxy.ListenPath)
@@ -656,6 +719,9 @@ func loadApps(specs []*APISpec, muxer *mux.Router) {
 		}
 		if !chainObj.Open {
 			chainObj.Subrouter.Handle(chainObj.RateLimitPath, chainObj.RateLimitChain)
+			if chainObj.IntrospectionChain != nil {
+				chainObj.Subrouter.Handle(chainObj.IntrospectionPath, chainObj.IntrospectionChain)
+			}
 		}
 
 		mainLog.Infof("Processed and listening on: %s%s", chainObj.Domain, chainObj.ListenOn)
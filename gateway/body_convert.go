@@ -0,0 +1,356 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/clbanning/mxj"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+// mxj's own attribute prefix, other prefixes are mapped to and from it
+const mxjAttrPrefix = "-"
+
+var bodyContentTypes = map[apidef.BodyFormat]string{
+	apidef.BodyJSON:     headers.ApplicationJSON,
+	apidef.BodyXML:      headers.ApplicationXML,
+	apidef.BodyForm:     "application/x-www-form-urlencoded",
+	apidef.BodyProtobuf: "application/x-protobuf",
+}
+
+// bodyConverter is a compiled apidef.ConvertBodyMeta. Bodies are decoded
+// into their JSON form, which is then encoded in the target format.
+type bodyConverter struct {
+	apidef.ConvertBodyMeta
+	attrPrefix string
+	arrayPaths [][]string
+	proto      *protoMessage
+}
+
+func compileBodyConverter(meta apidef.ConvertBodyMeta) (*bodyConverter, error) {
+	c := &bodyConverter{ConvertBodyMeta: meta, attrPrefix: meta.XML.AttributePrefix}
+	for _, format := range []apidef.BodyFormat{meta.From, meta.To} {
+		if _, ok := bodyContentTypes[format]; !ok {
+			return nil, fmt.Errorf("unsupported body format %q", format)
+		}
+	}
+	if c.attrPrefix == "" {
+		c.attrPrefix = mxjAttrPrefix
+	}
+	for _, p := range meta.XML.ArrayPaths {
+		c.arrayPaths = append(c.arrayPaths, strings.Split(p, "."))
+	}
+
+	if meta.From == apidef.BodyProtobuf || meta.To == apidef.BodyProtobuf {
+		set, err := base64.StdEncoding.DecodeString(meta.Protobuf.DescriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("descriptor set is not valid base64: %v", err)
+		}
+		if c.proto, err = loadProtoMessage(set, meta.Protobuf.Message); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// convert returns the converted body and its content type. Empty bodies
+// are left alone.
+func (c *bodyConverter) convert(body []byte) ([]byte, string, error) {
+	if len(body) == 0 {
+		return body, "", nil
+	}
+	data, err := c.decode(body)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not decode %s body: %v", c.From, err)
+	}
+	out, err := c.encode(data)
+	if err != nil {
+		return nil, "", fmt.Errorf("could not encode %s body: %v", c.To, err)
+	}
+	return out, bodyContentTypes[c.To], nil
+}
+
+func (c *bodyConverter) decode(body []byte) (interface{}, error) {
+	switch c.From {
+	case apidef.BodyXML:
+		mxj.XmlCharsetReader = WrappedCharsetReader
+		m, err := mxj.NewMapXml(body, c.XML.CastValues)
+		if err != nil {
+			return nil, err
+		}
+		data := xmlToJSON(map[string]interface{}(m), c.attrPrefix)
+		for _, path := range c.arrayPaths {
+			forceJSONArray(data, path)
+		}
+		return data, nil
+	case apidef.BodyForm:
+		values, err := url.ParseQuery(string(body))
+		if err != nil {
+			return nil, err
+		}
+		return formToJSON(values), nil
+	case apidef.BodyProtobuf:
+		return c.proto.decode(body)
+	}
+
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	err := dec.Decode(&data)
+	return data, err
+}
+
+func (c *bodyConverter) encode(data interface{}) ([]byte, error) {
+	switch c.To {
+	case apidef.BodyXML:
+		return c.encodeXML(data)
+	case apidef.BodyForm:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("only objects can be form encoded")
+		}
+		values := make(url.Values)
+		jsonToForm("", obj, values)
+		return []byte(values.Encode()), nil
+	case apidef.BodyProtobuf:
+		obj, ok := data.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("only objects can be protobuf encoded")
+		}
+		return c.proto.encode(obj)
+	}
+	return json.Marshal(data)
+}
+
+func (c *bodyConverter) encodeXML(data interface{}) ([]byte, error) {
+	var root string
+	var m map[string]interface{}
+	switch x := data.(type) {
+	case map[string]interface{}:
+		m = x
+	case []interface{}:
+		// a top level array becomes repeated item elements
+		m = map[string]interface{}{"item": x}
+	default:
+		return nil, errors.New("only objects and arrays can be XML encoded")
+	}
+
+	m = jsonToXML(m, c.attrPrefix, c.XML.NamespacePrefix).(map[string]interface{})
+
+	if c.XML.RootElement != "" || len(m) != 1 {
+		root = c.XML.RootElement
+		if root == "" {
+			root = mxj.DefaultRootTag
+		}
+		root = prefixXMLName(root, c.XML.NamespacePrefix)
+		addXMLNamespaces(m, c.XML.Namespaces)
+	} else {
+		for key, value := range m {
+			elem, ok := value.(map[string]interface{})
+			if !ok {
+				elem = map[string]interface{}{"#text": value}
+				m[key] = elem
+			}
+			addXMLNamespaces(elem, c.XML.Namespaces)
+		}
+	}
+
+	var out []byte
+	var err error
+	if root != "" {
+		out, err = mxj.Map(m).Xml(root)
+	} else {
+		out, err = mxj.Map(m).Xml()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), out...), nil
+}
+
+func addXMLNamespaces(elem map[string]interface{}, namespaces map[string]string) {
+	for prefix, uri := range namespaces {
+		name := mxjAttrPrefix + "xmlns"
+		if prefix != "" {
+			name += ":" + prefix
+		}
+		elem[name] = xmlEscape(uri)
+	}
+}
+
+// xmlToJSON copies a body decoded by mxj, changing the prefix of
+// attribute keys to attrPrefix.
+func xmlToJSON(v interface{}, attrPrefix string) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, child := range x {
+			if strings.HasPrefix(k, mxjAttrPrefix) {
+				k = attrPrefix + strings.TrimPrefix(k, mxjAttrPrefix)
+			}
+			out[k] = xmlToJSON(child, attrPrefix)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, child := range x {
+			out[i] = xmlToJSON(child, attrPrefix)
+		}
+		return out
+	}
+	return v
+}
+
+// jsonToXML copies a JSON body for mxj to encode: attribute keys get
+// mxj's prefix, element names get nsPrefix and strings are escaped, as
+// mxj doesn't escape them by default.
+func jsonToXML(v interface{}, attrPrefix, nsPrefix string) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, child := range x {
+			switch {
+			case strings.HasPrefix(k, attrPrefix):
+				k = mxjAttrPrefix + strings.TrimPrefix(k, attrPrefix)
+			case k != "#text":
+				k = prefixXMLName(k, nsPrefix)
+			}
+			out[k] = jsonToXML(child, attrPrefix, nsPrefix)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, child := range x {
+			out[i] = jsonToXML(child, attrPrefix, nsPrefix)
+		}
+		return out
+	case string:
+		return xmlEscape(x)
+	}
+	return v
+}
+
+func prefixXMLName(name, prefix string) string {
+	if prefix == "" || strings.Contains(name, ":") {
+		return name
+	}
+	return prefix + ":" + name
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// forceJSONArray wraps the elements at path in an array if they aren't
+// one already, so a single repeated element has the same shape as many.
+func forceJSONArray(v interface{}, path []string) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		child, ok := x[path[0]]
+		if !ok {
+			return
+		}
+		if len(path) == 1 {
+			if _, isArray := child.([]interface{}); !isArray {
+				x[path[0]] = []interface{}{child}
+			}
+			return
+		}
+		forceJSONArray(child, path[1:])
+	case []interface{}:
+		for _, item := range x {
+			forceJSONArray(item, path)
+		}
+	}
+}
+
+// formToJSON turns form values into an object. Keys like "a[b]" are
+// nested objects and "a[]" or repeated keys are arrays.
+func formToJSON(values url.Values) map[string]interface{} {
+	out := make(map[string]interface{})
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		vals := values[key]
+		path := formKeyPath(key)
+
+		var value interface{}
+		if path[len(path)-1] == "" || len(vals) > 1 {
+			list := make([]interface{}, len(vals))
+			for i, v := range vals {
+				list[i] = v
+			}
+			value = list
+			if path[len(path)-1] == "" {
+				path = path[:len(path)-1]
+			}
+		} else {
+			value = vals[0]
+		}
+
+		node := out
+		for _, part := range path[:len(path)-1] {
+			child, ok := node[part].(map[string]interface{})
+			if !ok {
+				child = make(map[string]interface{})
+				node[part] = child
+			}
+			node = child
+		}
+		node[path[len(path)-1]] = value
+	}
+	return out
+}
+
+func formKeyPath(key string) []string {
+	i := strings.IndexByte(key, '[')
+	if i <= 0 || !strings.HasSuffix(key, "]") {
+		return []string{key}
+	}
+	path := []string{key[:i]}
+	return append(path, strings.Split(key[i+1:len(key)-1], "][")...)
+}
+
+// jsonToForm is the reverse of formToJSON.
+func jsonToForm(prefix string, v interface{}, values url.Values) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		for k, child := range x {
+			if prefix != "" {
+				k = prefix + "[" + k + "]"
+			}
+			jsonToForm(k, child, values)
+		}
+	case []interface{}:
+		for i, item := range x {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				jsonToForm(prefix+"["+strconv.Itoa(i)+"]", item, values)
+			default:
+				jsonToForm(prefix, item, values)
+			}
+		}
+	case nil:
+		values.Add(prefix, "")
+	case string:
+		values.Add(prefix, x)
+	default:
+		values.Add(prefix, fmt.Sprint(x))
+	}
+}
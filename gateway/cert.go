@@ -7,8 +7,12 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/ins-tykgw/tyk/apidef"
 	"github.com/ins-tykgw/tyk/certs"
 	"github.com/ins-tykgw/tyk/config"
 
@@ -52,9 +56,20 @@ var cipherSuites = map[string]uint16{
 
 var certLog = log.WithField("prefix", "certs")
 
-func getUpstreamCertificate(host string, spec *APISpec) (cert *tls.Certificate) {
-	var certID string
+const (
+	defaultCertificateExpiryWarningDays = 30
+	certificateExpiryReportInterval     = 24 * time.Hour
+	certificateExpiryCheckInterval      = time.Hour
+)
+
+var (
+	certExpiryMu       sync.Mutex
+	certExpiryReported = make(map[string]time.Time)
+)
 
+// getUpstreamCertificateID returns the ID of the client certificate for
+// host from the global and API upstream_certificates host patterns.
+func getUpstreamCertificateID(host string, spec *APISpec) (certID string) {
 	certMaps := []map[string]string{config.Global().Security.Certificates.Upstream}
 
 	if spec != nil && spec.UpstreamCertificates != nil {
@@ -84,19 +99,200 @@ func getUpstreamCertificate(host string, spec *APISpec) (cert *tls.Certificate)
 		}
 	}
 
+	return certID
+}
+
+// upstreamCertificateID picks the client certificate for an outbound
+// request. A certificate set for the request path takes precedence over
+// one set for the load balanced target, which takes precedence over the
+// upstream_certificates host patterns.
+func upstreamCertificateID(req, outreq *http.Request, spec *APISpec) string {
+	if spec.UpstreamCertsByPath {
+		_, versionPaths, _, _ := spec.Version(req)
+		if found, meta := spec.CheckSpecMatchesStatus(req, versionPaths, UpstreamCertificate); found {
+			return meta.(*apidef.UpstreamCertificateMeta).CertificateID
+		}
+	}
+
+	for _, tc := range spec.Proxy.TargetCertificates {
+		u, err := url.Parse(EnsureTransport(tc.Target))
+		if err == nil && u.Host == outreq.URL.Host {
+			return tc.CertificateID
+		}
+	}
+
+	return getUpstreamCertificateID(outreq.Host, spec)
+}
+
+func getUpstreamCertificate(certID string, spec *APISpec) *tls.Certificate {
 	if certID == "" {
 		return nil
 	}
 
 	certs := CertificateManager.List([]string{certID}, certs.CertificatePrivate)
-
-	if len(certs) == 0 {
+	if len(certs) == 0 || certs[0] == nil {
+		certLog.Warning("Upstream client certificate not found: ", certID)
 		return nil
 	}
 
+	checkCertificateExpiry(spec, certID, certs[0])
 	return certs[0]
 }
 
+// upstreamCAPool returns the CA certificates the API trusts for upstream
+// servers, or nil to use the system roots.
+func upstreamCAPool(spec *APISpec) *x509.CertPool {
+	if len(spec.Proxy.Transport.SSLCACertificates) == 0 {
+		return nil
+	}
+
+	pool := x509.NewCertPool()
+	for _, certID := range spec.Proxy.Transport.SSLCACertificates {
+		list := CertificateManager.List([]string{certID}, certs.CertificateAny)
+		if len(list) == 0 || list[0] == nil {
+			certLog.Warning("Upstream CA certificate not found: ", certID)
+			continue
+		}
+
+		checkCertificateExpiry(spec, certID, list[0])
+		// a bundle keeps every certificate in the chain
+		for _, der := range list[0].Certificate {
+			if ca, err := x509.ParseCertificate(der); err == nil {
+				pool.AddCert(ca)
+			}
+		}
+	}
+
+	return pool
+}
+
+// checkCertificateExpiry fires CertificateExpiringSoon if cert expires
+// within the configured number of days. Each certificate is reported at
+// most once a day per API.
+func checkCertificateExpiry(spec *APISpec, certID string, cert *tls.Certificate) {
+	if spec == nil || cert.Leaf == nil || cert.Leaf.NotAfter.IsZero() {
+		return
+	}
+
+	days := config.Global().Security.CertificateExpiryWarningDays
+	if days <= 0 {
+		days = defaultCertificateExpiryWarningDays
+	}
+	remaining := time.Until(cert.Leaf.NotAfter)
+	if remaining > time.Duration(days)*24*time.Hour {
+		return
+	}
+
+	key := spec.APIID + "-" + certID
+	certExpiryMu.Lock()
+	if last, ok := certExpiryReported[key]; ok && time.Since(last) < certificateExpiryReportInterval {
+		certExpiryMu.Unlock()
+		return
+	}
+	certExpiryReported[key] = time.Now()
+	certExpiryMu.Unlock()
+
+	certLog.Warningf("Certificate %s used by API %s expires on %s", certID, spec.APIID, cert.Leaf.NotAfter)
+	spec.FireEvent(EventCertificateExpiringSoon, EventCertificateExpiringSoonMeta{
+		EventMetaDefault: EventMetaDefault{Message: "Certificate is expiring soon"},
+		APIID:            spec.APIID,
+		CertID:           certID,
+		ExpiresAt:        cert.Leaf.NotAfter,
+		DaysRemaining:    int(remaining.Hours() / 24),
+	})
+}
+
+// certificateExpiryLoop checks the upstream certificates of every loaded
+// API on each tick, so that certificates on long lived transports are
+// reported too and not only when a transport is created.
+func certificateExpiryLoop(tick <-chan time.Time) {
+	for range tick {
+		checkUpstreamCertificatesExpiry()
+	}
+}
+
+func checkUpstreamCertificatesExpiry() {
+	apisMu.RLock()
+	specs := make([]*APISpec, 0, len(apisByID))
+	for _, spec := range apisByID {
+		specs = append(specs, spec)
+	}
+	apisMu.RUnlock()
+
+	for _, spec := range specs {
+		for _, certID := range upstreamCertificateIDs(spec) {
+			list := CertificateManager.List([]string{certID}, certs.CertificateAny)
+			if len(list) == 0 || list[0] == nil {
+				continue
+			}
+			checkCertificateExpiry(spec, certID, list[0])
+		}
+	}
+}
+
+// upstreamCertificateIDs returns the IDs of all the client and CA
+// certificates an API may use for its upstream.
+func upstreamCertificateIDs(spec *APISpec) []string {
+	seen := make(map[string]bool)
+	var ids []string
+	add := func(certID string) {
+		if certID != "" && !seen[certID] {
+			seen[certID] = true
+			ids = append(ids, certID)
+		}
+	}
+
+	for _, certID := range config.Global().Security.Certificates.Upstream {
+		add(certID)
+	}
+	for _, certID := range spec.UpstreamCertificates {
+		add(certID)
+	}
+	for _, tc := range spec.Proxy.TargetCertificates {
+		add(tc.CertificateID)
+	}
+	for _, version := range spec.VersionData.Versions {
+		for _, meta := range version.ExtendedPaths.UpstreamCertificates {
+			add(meta.CertificateID)
+		}
+	}
+	for _, certID := range spec.Proxy.Transport.SSLCACertificates {
+		add(certID)
+	}
+
+	return ids
+}
+
+// closeIdleTransports closes the idle connections of the upstream
+// transports of spec before they are replaced, so that they aren't left
+// open until the upstream drops them. The caller must hold the spec lock.
+func closeIdleTransports(spec *APISpec) {
+	if t, ok := spec.HTTPTransport.(*http.Transport); ok {
+		t.CloseIdleConnections()
+	}
+	for _, rt := range spec.certTransports {
+		if t, ok := rt.(*http.Transport); ok {
+			t.CloseIdleConnections()
+		}
+	}
+}
+
+// flushUpstreamTransports drops the upstream transports of every loaded API
+// so that new connections pick up certificate changes.
+func flushUpstreamTransports() {
+	apisMu.RLock()
+	defer apisMu.RUnlock()
+
+	for _, spec := range apisByID {
+		spec.Lock()
+		closeIdleTransports(spec)
+		spec.HTTPTransport = nil
+		spec.WSTransport = nil
+		spec.certTransports = nil
+		spec.Unlock()
+	}
+}
+
 func verifyPeerCertificatePinnedCheck(spec *APISpec, tlsConfig *tls.Config) func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
 	if (spec == nil || len(spec.PinnedPublicKeys) == 0) && len(config.Global().Security.PinnedPublicKeys) == 0 {
 		return nil
@@ -319,6 +515,7 @@ func certHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
+		flushUpstreamTransports()
 		doJSONWrite(w, http.StatusOK, &APICertificateStatusMessage{certID, "ok", "Certificate added"})
 	case "GET":
 		if certID == "" {
@@ -355,6 +552,7 @@ func certHandler(w http.ResponseWriter, r *http.Request) {
 		}
 	case "DELETE":
 		CertificateManager.Delete(certID)
+		flushUpstreamTransports()
 		doJSONWrite(w, http.StatusOK, &apiStatusMessage{"ok", "removed"})
 	}
 }
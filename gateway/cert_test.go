@@ -3,10 +3,13 @@ package gateway
 import (
 	"bytes"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/pem"
 	"fmt"
 	"io/ioutil"
@@ -480,6 +483,192 @@ func TestUpstreamMutualTLS(t *testing.T) {
 	})
 }
 
+// genSignedCertificate creates an ECDSA certificate signed by parent, or a
+// self-signed one if parent is nil.
+func genSignedCertificate(template, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) ([]byte, []byte, *x509.Certificate, *ecdsa.PrivateKey) {
+	priv, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	serialNumberLimit := new(big.Int).Lsh(big.NewInt(1), 128)
+	template.SerialNumber, _ = rand.Int(rand.Reader, serialNumberLimit)
+	template.BasicConstraintsValid = true
+	template.NotBefore = time.Now().Add(-time.Minute)
+	if template.NotAfter.IsZero() {
+		template.NotAfter = time.Now().Add(365 * 24 * time.Hour)
+	}
+	if parent == nil {
+		parent, parentKey = template, priv
+	}
+
+	derBytes, _ := x509.CreateCertificate(rand.Reader, template, parent, &priv.PublicKey, parentKey)
+	cert, _ := x509.ParseCertificate(derBytes)
+
+	keyDER, _ := x509.MarshalECPrivateKey(priv)
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: derBytes})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPem, bytes.Join([][]byte{certPem, keyPem}, []byte("\n")), cert, priv
+}
+
+func TestUpstreamCertificateSelection(t *testing.T) {
+	caPEM, _, caCert, caKey := genSignedCertificate(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "upstream-ca"},
+		IsCA:     true,
+		KeyUsage: x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, serverPEM, _, _ := genSignedCertificate(&x509.Certificate{
+		Subject:     pkix.Name{CommonName: "upstream"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, caCert, caKey)
+	genClient := func(name string) []byte {
+		_, combined, _, _ := genSignedCertificate(&x509.Certificate{
+			Subject:     pkix.Name{CommonName: name},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}, caCert, caKey)
+		return combined
+	}
+
+	serverCert, err := tls.X509KeyPair(serverPEM, serverPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	upstream.TLS = &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    pool,
+	}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	ts := StartTest()
+	defer ts.Close()
+
+	caID, _ := CertificateManager.Add(caPEM, "")
+	defer CertificateManager.Delete(caID)
+	targetCertID, _ := CertificateManager.Add(genClient("target-client"), "")
+	defer CertificateManager.Delete(targetCertID)
+	pathCertID, _ := CertificateManager.Add(genClient("path-client"), "")
+	defer CertificateManager.Delete(pathCertID)
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.TargetURL = upstream.URL
+		spec.Proxy.Transport.SSLCACertificates = []string{caID}
+		spec.Proxy.TargetCertificates = []apidef.TargetCertificate{
+			{Target: upstream.URL, CertificateID: targetCertID},
+		}
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.ExtendedPaths.UpstreamCertificates = []apidef.UpstreamCertificateMeta{
+				{Path: "/path", Method: http.MethodGet, CertificateID: pathCertID},
+			}
+		})
+	})
+
+	ts.Run(t, []test.TestCase{
+		{Path: "/", Code: http.StatusOK, BodyMatch: "target-client"},
+		{Path: "/path", Code: http.StatusOK, BodyMatch: "path-client"},
+		{Path: "/", Code: http.StatusOK, BodyMatch: "target-client"},
+	}...)
+
+	t.Run("Certificate removed without reload", func(t *testing.T) {
+		ts.Run(t, []test.TestCase{
+			{Method: http.MethodDelete, Path: "/tyk/certs/" + targetCertID, AdminAuth: true, Code: http.StatusOK},
+			{Path: "/", Code: http.StatusInternalServerError},
+			{Path: "/path", Code: http.StatusOK, BodyMatch: "path-client"},
+		}...)
+	})
+
+	t.Run("Untrusted upstream CA", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.Proxy.TargetURL = upstream.URL
+			spec.Proxy.Transport.SSLCACertificates = []string{pathCertID}
+			spec.UpstreamCertificates = map[string]string{"*": pathCertID}
+		})
+		ts.Run(t, test.TestCase{Path: "/", Code: http.StatusInternalServerError})
+	})
+}
+
+func TestCertificateExpiringSoon(t *testing.T) {
+	_, combinedPEM, _, _ := genSignedCertificate(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "expiring"},
+		NotAfter: time.Now().Add(10 * 24 * time.Hour),
+	}, nil, nil)
+	cert, err := certs.ParsePEMCertificate(combinedPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan config.EventMessage, 2)
+	spec := BuildAPI(func(spec *APISpec) {
+		spec.APIID = "expiring-cert"
+	})[0]
+	spec.EventPaths = map[apidef.TykEvent][]config.TykEventHandler{
+		EventCertificateExpiringSoon: {&testEventHandler{func(em config.EventMessage) {
+			events <- em
+		}}},
+	}
+
+	checkCertificateExpiry(spec, "cert-id", cert)
+	checkCertificateExpiry(spec, "cert-id", cert)
+
+	em := <-events
+	meta := em.Meta.(EventCertificateExpiringSoonMeta)
+	if meta.CertID != "cert-id" || meta.DaysRemaining != 9 {
+		t.Fatalf("unexpected event meta: %+v", meta)
+	}
+	select {
+	case <-events:
+		t.Fatal("expiring certificate should only be reported once a day")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestCertificateExpiryLoop(t *testing.T) {
+	_, combinedPEM, _, _ := genSignedCertificate(&x509.Certificate{
+		Subject:  pkix.Name{CommonName: "expiring upstream"},
+		NotAfter: time.Now().Add(5 * 24 * time.Hour),
+	}, nil, nil)
+	certID, _ := CertificateManager.Add(combinedPEM, "")
+	defer CertificateManager.Delete(certID)
+
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.APIID = "expiring-upstream-cert"
+		spec.UpstreamCertificates = map[string]string{"*": certID}
+	})
+
+	events := make(chan config.EventMessage, 1)
+	spec := getApiSpec("expiring-upstream-cert")
+	spec.EventPaths = map[apidef.TykEvent][]config.TykEventHandler{
+		EventCertificateExpiringSoon: {&testEventHandler{func(em config.EventMessage) {
+			events <- em
+		}}},
+	}
+
+	tick := make(chan time.Time)
+	go certificateExpiryLoop(tick)
+	tick <- time.Now()
+
+	select {
+	case em := <-events:
+		if meta := em.Meta.(EventCertificateExpiringSoonMeta); meta.CertID != certID {
+			t.Fatalf("unexpected event meta: %+v", meta)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expiring upstream certificate was not reported")
+	}
+	close(tick)
+}
+
 func TestKeyWithCertificateTLS(t *testing.T) {
 	_, _, combinedPEM, _ := genServerCertificate()
 	serverCertID, _ := CertificateManager.Add(combinedPEM, "")
@@ -0,0 +1,463 @@
+package gateway
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	circuit "github.com/rubyist/circuitbreaker"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/storage"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+const (
+	breakerSharedKeyPrefix = "circuit-breaker-"
+	// maximum number of responses a breaker keeps for fallbacks
+	breakerMaxCachedResponses = 1000
+	// largest response body, in bytes, kept for fallbacks when the API
+	// sets no response_size_limit
+	breakerMaxCachedBodySize = 1 << 20
+	// how often shared breakers are read from the store
+	breakerSharedRefreshInterval = time.Second
+)
+
+// Breaker guards a circuit breaker path. Requests are let through
+// while it is closed. It opens after too many failures, rejecting requests
+// for ReturnToServiceAfter seconds, and then goes half-open, letting a
+// limited number of trial requests through to decide whether to close or
+// open again.
+type Breaker struct {
+	conf apidef.CircuitBreakerMeta
+	spec *APISpec
+	// store holds open breakers when the breaker is shared
+	store storage.Handler
+
+	mu        sync.Mutex
+	breakers  map[string]*hostBreaker
+	responses map[string]*cachedBreakerResponse
+}
+
+type hostBreaker struct {
+	state    BreakerState
+	openedAt time.Time
+
+	consecutive int64
+	// outcomes is a ring of the last Samples results, true for failures
+	outcomes []bool
+	next     int
+	filled   int
+	failures int
+
+	halfOpenAdmitted  int
+	halfOpenSucceeded int
+
+	// the shared breaker, as last read from the store
+	sharedOpenUntil  time.Time
+	sharedCheckedAt  time.Time
+	sharedRefreshing bool
+}
+
+type cachedBreakerResponse struct {
+	code   int
+	header http.Header
+	body   []byte
+}
+
+// BreakerStatus is the state of a single breaker, as returned by the
+// control API.
+type BreakerStatus struct {
+	Path                string       `json:"path"`
+	Method              string       `json:"method"`
+	Host                string       `json:"host,omitempty"`
+	State               BreakerState `json:"state"`
+	ConsecutiveFailures int64        `json:"consecutive_failures"`
+	FailureRate         float64      `json:"failure_rate"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+}
+
+func newBreaker(conf apidef.CircuitBreakerMeta, spec *APISpec) *Breaker {
+	if conf.HalfOpenRequests <= 0 {
+		conf.HalfOpenRequests = 1
+	}
+	cb := &Breaker{
+		conf:      conf,
+		spec:      spec,
+		breakers:  make(map[string]*hostBreaker),
+		responses: make(map[string]*cachedBreakerResponse),
+	}
+	if conf.Shared {
+		cb.store = &storage.RedisCluster{KeyPrefix: breakerSharedKeyPrefix}
+	}
+	return cb
+}
+
+func (cb *Breaker) hostKey(host string) string {
+	if !cb.conf.PerHost {
+		return ""
+	}
+	return host
+}
+
+func (cb *Breaker) sharedKey(host string) string {
+	return config.Global().SlaveOptions.GroupID + "-" + cb.spec.APIID + "-" +
+		cb.conf.Method + "-" + cb.conf.Path + "-" + host
+}
+
+func (cb *Breaker) breaker(host string) *hostBreaker {
+	b := cb.breakers[host]
+	if b == nil {
+		b = &hostBreaker{state: BreakerClosed}
+		if cb.conf.Samples > 0 {
+			b.outcomes = make([]bool, cb.conf.Samples)
+		}
+		cb.breakers[host] = b
+	}
+	return b
+}
+
+func (b *hostBreaker) reset(state BreakerState) {
+	b.state = state
+	if state == BreakerClosed {
+		// closing deletes the shared breaker too
+		b.sharedOpenUntil = time.Time{}
+	}
+	b.consecutive = 0
+	b.next, b.filled, b.failures = 0, 0, 0
+	b.halfOpenAdmitted, b.halfOpenSucceeded = 0, 0
+}
+
+func (b *hostBreaker) record(failed bool) {
+	if failed {
+		b.consecutive++
+	} else {
+		b.consecutive = 0
+	}
+	if len(b.outcomes) == 0 {
+		return
+	}
+	if b.filled == len(b.outcomes) {
+		if b.outcomes[b.next] {
+			b.failures--
+		}
+	} else {
+		b.filled++
+	}
+	b.outcomes[b.next] = failed
+	if failed {
+		b.failures++
+	}
+	b.next = (b.next + 1) % len(b.outcomes)
+}
+
+func (b *hostBreaker) failureRate() float64 {
+	if b.filled == 0 {
+		return 0
+	}
+	return float64(b.failures) / float64(b.filled)
+}
+
+// Ready reports whether a request to host may go ahead.
+func (cb *Breaker) Ready(host string) bool {
+	host = cb.hostKey(host)
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	b := cb.breaker(host)
+	now := time.Now()
+
+	// A breaker tripped by another gateway in the group is opened
+	// locally until the shared entry expires.
+	if cb.store != nil {
+		cb.refreshShared(host, b, now)
+		if now.Before(b.sharedOpenUntil) && b.state == BreakerClosed {
+			b.reset(BreakerOpen)
+			b.openedAt = b.sharedOpenUntil.Add(-time.Duration(cb.conf.ReturnToServiceAfter) * time.Second)
+		}
+	}
+
+	switch b.state {
+	case BreakerOpen:
+		if now.Sub(b.openedAt) < time.Duration(cb.conf.ReturnToServiceAfter)*time.Second {
+			return false
+		}
+		b.reset(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.halfOpenAdmitted >= cb.conf.HalfOpenRequests {
+			return false
+		}
+		b.halfOpenAdmitted++
+	}
+	return true
+}
+
+// refreshShared reads the shared breaker of host from the store in the
+// background, at most every breakerSharedRefreshInterval, so that requests
+// don't wait for the store. cb.mu must be held.
+func (cb *Breaker) refreshShared(host string, b *hostBreaker, now time.Time) {
+	if b.sharedRefreshing || now.Sub(b.sharedCheckedAt) < breakerSharedRefreshInterval {
+		return
+	}
+	b.sharedRefreshing = true
+	go func() {
+		// TTL is negative if the key does not exist
+		ttl, _ := cb.store.GetExp(cb.sharedKey(host))
+
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
+		b.sharedRefreshing = false
+		b.sharedCheckedAt = time.Now()
+		b.sharedOpenUntil = time.Time{}
+		if ttl > 0 {
+			b.sharedOpenUntil = b.sharedCheckedAt.Add(time.Duration(ttl) * time.Second)
+		}
+	}()
+}
+
+// Record records the outcome of a request to host. Requests slower than
+// LatencyThreshold count as failures.
+func (cb *Breaker) Record(host string, failed bool, latency time.Duration) {
+	host = cb.hostKey(host)
+	if cb.conf.LatencyThreshold > 0 && latency > time.Duration(cb.conf.LatencyThreshold)*time.Millisecond {
+		failed = true
+	}
+
+	cb.mu.Lock()
+	b := cb.breaker(host)
+	var event circuit.BreakerEvent = -1
+	switch b.state {
+	case BreakerClosed:
+		b.record(failed)
+		if cb.shouldTrip(b) {
+			b.reset(BreakerOpen)
+			b.openedAt = time.Now()
+			event = circuit.BreakerTripped
+		}
+	case BreakerHalfOpen:
+		if failed {
+			b.reset(BreakerOpen)
+			b.openedAt = time.Now()
+			event = circuit.BreakerTripped
+		} else if b.halfOpenSucceeded++; b.halfOpenSucceeded >= cb.conf.HalfOpenRequests {
+			b.reset(BreakerClosed)
+			event = circuit.BreakerReset
+		}
+	}
+	cb.mu.Unlock()
+
+	switch event {
+	case circuit.BreakerTripped:
+		cb.onTripped(host)
+	case circuit.BreakerReset:
+		cb.onReset(host)
+	}
+}
+
+func (cb *Breaker) shouldTrip(b *hostBreaker) bool {
+	if cb.conf.ConsecutiveFailures > 0 && b.consecutive >= cb.conf.ConsecutiveFailures {
+		return true
+	}
+	return cb.conf.ThresholdPercent > 0 && len(b.outcomes) > 0 &&
+		b.filled == len(b.outcomes) && b.failureRate() >= cb.conf.ThresholdPercent
+}
+
+func (cb *Breaker) onTripped(host string) {
+	log.WithFields(logrus.Fields{
+		"prefix": "proxy",
+		"api_id": cb.spec.APIID,
+		"host":   host,
+	}).Warning("[PROXY] [CIRCUIT BREAKER] Breaker tripped for path: ", cb.conf.Path)
+
+	if cb.store != nil {
+		cb.store.SetKey(cb.sharedKey(host), "1", int64(cb.conf.ReturnToServiceAfter))
+	}
+
+	if cb.spec.Proxy.ServiceDiscovery.UseDiscoveryService {
+		if ServiceCache != nil {
+			log.Warning("[PROXY] [CIRCUIT BREAKER] Refreshing host list")
+			ServiceCache.Delete(cb.spec.APIID)
+		}
+	}
+
+	cb.spec.FireEvent(EventBreakerTriggered, EventCurcuitBreakerMeta{
+		EventMetaDefault: EventMetaDefault{Message: "Breaker Tripped"},
+		CircuitEvent:     circuit.BreakerTripped,
+		Path:             cb.conf.Path,
+		APIID:            cb.spec.APIID,
+		Host:             host,
+	})
+}
+
+func (cb *Breaker) onReset(host string) {
+	if cb.store != nil {
+		cb.store.DeleteKey(cb.sharedKey(host))
+	}
+
+	cb.spec.FireEvent(EventBreakerTriggered, EventCurcuitBreakerMeta{
+		EventMetaDefault: EventMetaDefault{Message: "Breaker Reset"},
+		CircuitEvent:     circuit.BreakerReset,
+		Path:             cb.conf.Path,
+		APIID:            cb.spec.APIID,
+		Host:             host,
+	})
+}
+
+// Reset closes every breaker, including shared ones.
+func (cb *Breaker) Reset() {
+	cb.mu.Lock()
+	var hosts []string
+	for host, b := range cb.breakers {
+		if b.state != BreakerClosed {
+			hosts = append(hosts, host)
+		}
+		b.reset(BreakerClosed)
+	}
+	cb.mu.Unlock()
+
+	for _, host := range hosts {
+		cb.onReset(host)
+	}
+}
+
+// Status returns the state of each breaker.
+func (cb *Breaker) Status() []BreakerStatus {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	status := make([]BreakerStatus, 0, len(cb.breakers))
+	for host, b := range cb.breakers {
+		s := BreakerStatus{
+			Path:                cb.conf.Path,
+			Method:              cb.conf.Method,
+			Host:                host,
+			State:               b.state,
+			ConsecutiveFailures: b.consecutive,
+			FailureRate:         b.failureRate(),
+		}
+		if b.state != BreakerClosed {
+			openedAt := b.openedAt
+			s.OpenedAt = &openedAt
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// CacheResponse keeps a copy of a 2xx response to serve as a fallback.
+// The start of the response body is buffered and replaced, and bodies
+// larger than the API's response size limit, or 1 MiB without one, are
+// not kept.
+func (cb *Breaker) CacheResponse(req *http.Request, res *http.Response) {
+	if !cb.conf.Fallback.Enabled || !cb.conf.Fallback.UseCachedResponse || req.Method != http.MethodGet {
+		return
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return
+	}
+	limit := int64(breakerMaxCachedBodySize)
+	if cb.spec != nil && cb.spec.ResponseSizeLimit > 0 {
+		limit = cb.spec.ResponseSizeLimit
+	}
+	if res.ContentLength > limit {
+		return
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, limit+1))
+	// the rest of a larger body is still streamed to the client
+	res.Body = readCloser{io.MultiReader(bytes.NewReader(body), res.Body), res.Body}
+	if err != nil || int64(len(body)) > limit {
+		return
+	}
+
+	key := cb.responseKey(req)
+	cb.mu.Lock()
+	if _, ok := cb.responses[key]; ok || len(cb.responses) < breakerMaxCachedResponses {
+		cb.responses[key] = &cachedBreakerResponse{
+			code:   res.StatusCode,
+			header: cloneHeader(res.Header),
+			body:   body,
+		}
+	}
+	cb.mu.Unlock()
+}
+
+// responseKey is the key of the cached fallback for req. Responses are
+// shared by everyone calling the same URL unless CachePerKey is set.
+func (cb *Breaker) responseKey(req *http.Request) string {
+	if cb.conf.Fallback.CachePerKey {
+		return storage.HashStr(ctxGetAuthToken(req)) + " " + req.URL.String()
+	}
+	return req.URL.String()
+}
+
+// Fallback returns the response to serve while the breaker is open, or nil
+// if none is configured.
+func (cb *Breaker) Fallback(req *http.Request) *http.Response {
+	fb := cb.conf.Fallback
+	if !fb.Enabled {
+		return nil
+	}
+
+	res := &http.Response{
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Request:    req,
+	}
+
+	var cached *cachedBreakerResponse
+	if fb.UseCachedResponse {
+		cb.mu.Lock()
+		cached = cb.responses[cb.responseKey(req)]
+		cb.mu.Unlock()
+	}
+	if cached != nil {
+		res.StatusCode = cached.code
+		copyHeader(res.Header, cached.header)
+		res.Header.Set("x-tyk-cached-response", "1")
+		res.Body = ioutil.NopCloser(bytes.NewReader(cached.body))
+		res.ContentLength = int64(len(cached.body))
+		return res
+	}
+
+	res.StatusCode = fb.Code
+	if res.StatusCode == 0 {
+		res.StatusCode = http.StatusServiceUnavailable
+	}
+	for k, v := range fb.Headers {
+		res.Header.Set(k, v)
+	}
+	res.Header.Set("Content-Length", strconv.Itoa(len(fb.Body)))
+	res.Body = ioutil.NopCloser(strings.NewReader(fb.Body))
+	res.ContentLength = int64(len(fb.Body))
+	return res
+}
+
+// circuitBreakers returns the breakers of every version of the API.
+func (s *APISpec) circuitBreakers() []*Breaker {
+	var breakers []*Breaker
+	for _, paths := range s.RxPaths {
+		for i := range paths {
+			if cb := paths[i].CircuitBreaker.CB; cb != nil {
+				breakers = append(breakers, cb)
+			}
+		}
+	}
+	return breakers
+}
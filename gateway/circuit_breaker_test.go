@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func testBreaker(conf apidef.CircuitBreakerMeta) *Breaker {
+	spec := BuildAPI(func(spec *APISpec) {
+		spec.APIID = "breaker-test"
+	})[0]
+	return newBreaker(conf, spec)
+}
+
+func TestBreakerStates(t *testing.T) {
+	cb := testBreaker(apidef.CircuitBreakerMeta{
+		Path:                 "/",
+		ConsecutiveFailures:  2,
+		ReturnToServiceAfter: 0,
+		HalfOpenRequests:     2,
+	})
+	state := func() BreakerState {
+		return cb.Status()[0].State
+	}
+
+	cb.Ready("")
+	cb.Record("", true, 0)
+	if state() != BreakerClosed {
+		t.Fatalf("wanted closed after one failure, got %s", state())
+	}
+	cb.Record("", true, 0)
+	if state() != BreakerOpen {
+		t.Fatalf("wanted open after two failures, got %s", state())
+	}
+
+	// ReturnToServiceAfter is 0, so the breaker goes straight to
+	// half-open and lets two trial requests through.
+	if !cb.Ready("") || !cb.Ready("") {
+		t.Fatal("wanted trial requests to be let through")
+	}
+	if cb.Ready("") {
+		t.Fatal("wanted only two trial requests")
+	}
+	if state() != BreakerHalfOpen {
+		t.Fatalf("wanted half-open, got %s", state())
+	}
+	cb.Record("", false, 0)
+	cb.Record("", false, 0)
+	if state() != BreakerClosed {
+		t.Fatalf("wanted closed after successful trials, got %s", state())
+	}
+
+	cb.Record("", true, 0)
+	cb.Record("", true, 0)
+	cb.Ready("")
+	cb.Record("", true, 0)
+	if state() != BreakerOpen {
+		t.Fatalf("wanted failed trial to reopen breaker, got %s", state())
+	}
+}
+
+func TestBreakerTripConditions(t *testing.T) {
+	t.Run("Ratio", func(t *testing.T) {
+		cb := testBreaker(apidef.CircuitBreakerMeta{
+			ThresholdPercent:     0.5,
+			Samples:              4,
+			ReturnToServiceAfter: 60,
+		})
+		for _, failed := range []bool{true, false, true} {
+			cb.Record("", failed, 0)
+		}
+		if !cb.Ready("") {
+			t.Fatal("breaker should wait for enough samples")
+		}
+		cb.Record("", false, 0)
+		if cb.Ready("") {
+			t.Fatal("breaker should trip at 50% failures")
+		}
+	})
+
+	t.Run("Latency", func(t *testing.T) {
+		cb := testBreaker(apidef.CircuitBreakerMeta{
+			ConsecutiveFailures:  1,
+			LatencyThreshold:     100,
+			ReturnToServiceAfter: 60,
+		})
+		cb.Record("", false, 50*time.Millisecond)
+		if !cb.Ready("") {
+			t.Fatal("fast response should not trip the breaker")
+		}
+		cb.Record("", false, 200*time.Millisecond)
+		if cb.Ready("") {
+			t.Fatal("slow response should trip the breaker")
+		}
+	})
+
+	t.Run("PerHost", func(t *testing.T) {
+		cb := testBreaker(apidef.CircuitBreakerMeta{
+			ConsecutiveFailures:  1,
+			ReturnToServiceAfter: 60,
+			PerHost:              true,
+		})
+		cb.Record("a:80", true, 0)
+		if cb.Ready("a:80") {
+			t.Fatal("failing host should be cut off")
+		}
+		if !cb.Ready("b:80") {
+			t.Fatal("other hosts should not be affected")
+		}
+	})
+}
+
+func TestBreakerShared(t *testing.T) {
+	conf := apidef.CircuitBreakerMeta{
+		Path:                 "/shared",
+		ConsecutiveFailures:  1,
+		ReturnToServiceAfter: 60,
+		Shared:               true,
+	}
+	node1, node2 := testBreaker(conf), testBreaker(conf)
+	defer node1.Reset()
+
+	node1.Record("", true, 0)
+	// the shared breaker is read in the background
+	deadline := time.Now().Add(time.Second)
+	for node2.Ready("") {
+		if time.Now().After(deadline) {
+			t.Fatal("breaker tripped on another gateway should be open")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if status := node2.Status()[0]; status.State != BreakerOpen {
+		t.Fatalf("wanted open, got %s", status.State)
+	}
+}
+
+func TestBreakerFallback(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	var fail int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte("live " + r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.APIID = "breaker-fallback"
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.TargetURL = upstream.URL
+		UpdateAPIVersion(spec, "v1", func(version *apidef.VersionInfo) {
+			version.ExtendedPaths.CircuitBreaker = []apidef.CircuitBreakerMeta{{
+				Path:                 "/",
+				Method:               http.MethodGet,
+				ConsecutiveFailures:  1,
+				ReturnToServiceAfter: 60,
+				Fallback: apidef.CircuitBreakerFallback{
+					Enabled:           true,
+					Code:              http.StatusOK,
+					Body:              "fallback",
+					Headers:           map[string]string{"X-Fallback": "1"},
+					UseCachedResponse: true,
+				},
+			}}
+		})
+	})
+
+	ts.Run(t, test.TestCase{Path: "/cached", Code: http.StatusOK, BodyMatch: "live /cached"})
+
+	atomic.StoreInt32(&fail, 1)
+	ts.Run(t, []test.TestCase{
+		{Path: "/cached", Code: http.StatusInternalServerError},
+		{Path: "/cached", Code: http.StatusOK, BodyMatch: "live /cached"},
+		{Path: "/other", Code: http.StatusOK, BodyMatch: "fallback", HeadersMatch: map[string]string{"X-Fallback": "1"}},
+		{Path: "/tyk/breakers/breaker-fallback", AdminAuth: true, Code: http.StatusOK, BodyMatch: `"state":"open"`},
+		{Method: http.MethodDelete, Path: "/tyk/breakers/breaker-fallback", AdminAuth: true, Code: http.StatusOK},
+		{Path: "/tyk/breakers/breaker-fallback", AdminAuth: true, Code: http.StatusOK, BodyMatch: `"state":"closed"`},
+		{Path: "/tyk/breakers/unknown", AdminAuth: true, Code: http.StatusNotFound},
+	}...)
+}
+
+func TestBreakerFallbackCache(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	var fail int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&fail) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		switch r.URL.Path {
+		case "/large":
+			w.Write(bytes.Repeat([]byte("x"), breakerMaxCachedBodySize+1))
+		case "/missing":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte("missing"))
+		default:
+			w.Write([]byte("live " + r.Header.Get("Authorization")))
+		}
+	}))
+	defer upstream.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.APIID = "breaker-fallback-cache"
+		spec.UseKeylessAccess = false
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.TargetURL = upstream.URL
+		UpdateAPIVersion(spec, "v1", func(version *apidef.VersionInfo) {
+			version.ExtendedPaths.CircuitBreaker = []apidef.CircuitBreakerMeta{{
+				Path:                 "/",
+				Method:               http.MethodGet,
+				ConsecutiveFailures:  1,
+				ReturnToServiceAfter: 60,
+				Fallback: apidef.CircuitBreakerFallback{
+					Enabled:           true,
+					Code:              http.StatusOK,
+					Body:              "fallback",
+					UseCachedResponse: true,
+					CachePerKey:       true,
+				},
+			}}
+		})
+	})
+
+	key1, key2 := CreateSession(), CreateSession()
+	auth1 := map[string]string{"Authorization": key1}
+	auth2 := map[string]string{"Authorization": key2}
+
+	ts.Run(t, []test.TestCase{
+		{Path: "/shared", Headers: auth1, Code: http.StatusOK, BodyMatch: "live " + key1},
+		{Path: "/large", Headers: auth1, Code: http.StatusOK, BodyMatch: "xxx"},
+		{Path: "/missing", Headers: auth1, Code: http.StatusNotFound},
+	}...)
+
+	// a 503 counts as a failure and opens the breaker
+	atomic.StoreInt32(&fail, 1)
+	ts.Run(t, []test.TestCase{
+		{Path: "/shared", Headers: auth1, Code: http.StatusServiceUnavailable},
+		{Path: "/shared", Headers: auth1, Code: http.StatusOK, BodyMatch: "live " + key1},
+		{Path: "/shared", Headers: auth2, Code: http.StatusOK, BodyMatch: "fallback", BodyNotMatch: "live"},
+		{Path: "/large", Headers: auth1, Code: http.StatusOK, BodyMatch: "fallback", BodyNotMatch: "x"},
+		{Path: "/missing", Headers: auth1, Code: http.StatusOK, BodyMatch: "fallback", BodyNotMatch: "x"},
+	}...)
+}
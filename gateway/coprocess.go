@@ -150,6 +150,9 @@ func (c *CoProcessor) ObjectFromRequest(r *http.Request) (*coprocess.Object, err
 			"config_data": string(configDataAsJson),
 		}
 	}
+	if id := ctxGetRequestID(r); id != "" {
+		object.Spec["request_id"] = id
+	}
 
 	// Encode the session object (if not a pre-process & not a custom key check):
 	if c.HookType != coprocess.HookType_Pre && c.HookType != coprocess.HookType_CustomKeyCheck {
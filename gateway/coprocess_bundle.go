@@ -3,14 +3,15 @@ package gateway
 import (
 	"github.com/Sirupsen/logrus"
 
-	"github.com/TykTechnologies/goverify"
 	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/bundlesig"
+	"github.com/ins-tykgw/tyk/certs"
 	"github.com/ins-tykgw/tyk/config"
 
 	"archive/zip"
 	"bytes"
+	"crypto"
 	"crypto/md5"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -20,6 +21,7 @@ import (
 	"net/url"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Bundle is the basic bundle data structure, it holds the bundle name and the data.
@@ -31,30 +33,15 @@ type Bundle struct {
 	Manifest apidef.BundleManifest
 }
 
-// Verify performs a signature verification on the bundle file.
+// Verify performs a checksum and signature verification on the bundle file.
 func (b *Bundle) Verify() error {
 	log.WithFields(logrus.Fields{
 		"prefix": "main",
 	}).Info("----> Verifying bundle: ", b.Spec.CustomMiddlewareBundle)
 
-	var useSignature bool
-	var bundleVerifier goverify.Verifier
-
-	// Perform signature verification if a public key path is set:
-	if config.Global().PublicKeyPath != "" {
-		if b.Manifest.Signature == "" {
-			// Error: A public key is set, but the bundle isn't signed.
-			return errors.New("Bundle isn't signed")
-		}
-		if notificationVerifier == nil {
-			var err error
-			bundleVerifier, err = goverify.LoadPublicKeyFromFile(config.Global().PublicKeyPath)
-			if err != nil {
-				return err
-			}
-		}
-
-		useSignature = true
+	keyRing, err := bundleKeyRing()
+	if err != nil {
+		return err
 	}
 
 	var bundleData bytes.Buffer
@@ -73,24 +60,53 @@ func (b *Bundle) Verify() error {
 		}
 	}
 
-	checksum := fmt.Sprintf("%x", md5.Sum(bundleData.Bytes()))
+	return keyRing.Verify(&b.Manifest, bundleData.Bytes(), time.Now())
+}
 
-	if checksum != b.Manifest.Checksum {
-		return errors.New("Invalid checksum")
-	}
+// bundleKeyRing builds the set of keys trusted for bundle signatures from
+// public_key_path and bundle_trusted_keys. An empty ring disables
+// signature verification.
+func bundleKeyRing() (*bundlesig.KeyRing, error) {
+	keyRing := &bundlesig.KeyRing{}
 
-	if useSignature {
-		signed, err := base64.StdEncoding.DecodeString(b.Manifest.Signature)
+	if path := config.Global().PublicKeyPath; path != "" {
+		pub, err := bundlesig.LoadPublicKeyFromFile(path)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if err := bundleVerifier.Verify(bundleData.Bytes(), signed); err != nil {
-			return err
+		key, err := bundlesig.NewTrustedKey("", pub)
+		if err != nil {
+			return nil, err
 		}
+		keyRing.Add(key)
+	}
 
+	for _, conf := range config.Global().BundleTrustedKeys {
+		var pub crypto.PublicKey
+		var err error
+		if conf.CertID != "" {
+			cert := CertificateManager.List([]string{conf.CertID}, certs.CertificateAny)
+			if len(cert) == 0 {
+				return nil, errors.New("Couldn't load bundle trusted key: " + conf.CertID)
+			}
+			pub, err = bundlesig.PublicKeyFromCertificate(cert[0])
+		} else {
+			pub, err = bundlesig.LoadPublicKeyFromFile(conf.Path)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		key, err := bundlesig.NewTrustedKey(conf.ID, pub)
+		if err != nil {
+			return nil, err
+		}
+		key.NotBefore = conf.NotBefore
+		key.NotAfter = conf.NotAfter
+		keyRing.Add(key)
 	}
 
-	return nil
+	return keyRing, nil
 }
 
 // AddToSpec attaches the custom middleware settings to an API definition.
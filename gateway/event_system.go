@@ -19,20 +19,25 @@ const EH_LogHandler apidef.TykEventHandlerName = "eh_log_handler"
 
 // Register new event types here, the string is the code used to hook at the Api Deifnititon JSON/BSON level
 const (
-	EventQuotaExceeded        apidef.TykEvent = "QuotaExceeded"
-	EventRateLimitExceeded    apidef.TykEvent = "RatelimitExceeded"
-	EventAuthFailure          apidef.TykEvent = "AuthFailure"
-	EventKeyExpired           apidef.TykEvent = "KeyExpired"
-	EventVersionFailure       apidef.TykEvent = "VersionFailure"
-	EventOrgQuotaExceeded     apidef.TykEvent = "OrgQuotaExceeded"
-	EventOrgRateLimitExceeded apidef.TykEvent = "OrgRateLimitExceeded"
-	EventTriggerExceeded      apidef.TykEvent = "TriggerExceeded"
-	EventBreakerTriggered     apidef.TykEvent = "BreakerTriggered"
-	EventHOSTDOWN             apidef.TykEvent = "HostDown"
-	EventHOSTUP               apidef.TykEvent = "HostUp"
-	EventTokenCreated         apidef.TykEvent = "TokenCreated"
-	EventTokenUpdated         apidef.TykEvent = "TokenUpdated"
-	EventTokenDeleted         apidef.TykEvent = "TokenDeleted"
+	EventQuotaExceeded           apidef.TykEvent = "QuotaExceeded"
+	EventRateLimitExceeded       apidef.TykEvent = "RatelimitExceeded"
+	EventAuthFailure             apidef.TykEvent = "AuthFailure"
+	EventKeyExpired              apidef.TykEvent = "KeyExpired"
+	EventVersionFailure          apidef.TykEvent = "VersionFailure"
+	EventOrgQuotaExceeded        apidef.TykEvent = "OrgQuotaExceeded"
+	EventOrgRateLimitExceeded    apidef.TykEvent = "OrgRateLimitExceeded"
+	EventTriggerExceeded         apidef.TykEvent = "TriggerExceeded"
+	EventBreakerTriggered        apidef.TykEvent = "BreakerTriggered"
+	EventHOSTDOWN                apidef.TykEvent = "HostDown"
+	EventHOSTUP                  apidef.TykEvent = "HostUp"
+	EventHostEjected             apidef.TykEvent = "HostEjected"
+	EventHostRecovered           apidef.TykEvent = "HostRecovered"
+	EventCertificateExpiringSoon apidef.TykEvent = "CertificateExpiringSoon"
+	EventTokenCreated            apidef.TykEvent = "TokenCreated"
+	EventTokenUpdated            apidef.TykEvent = "TokenUpdated"
+	EventTokenDeleted            apidef.TykEvent = "TokenDeleted"
+	EventKeyRotated              apidef.TykEvent = "KeyRotated"
+	EventOAuthTokenRevoked       apidef.TykEvent = "OAuthTokenRevoked"
 )
 
 // EventMetaDefault is a standard embedded struct to be used with custom event metadata types, gives an interface for
@@ -47,6 +52,26 @@ type EventHostStatusMeta struct {
 	HostInfo HostHealthReport
 }
 
+// EventHostEjectedMeta is the metadata structure for an upstream host being
+// ejected from, or returned to, load balancing by outlier detection.
+type EventHostEjectedMeta struct {
+	EventMetaDefault
+	APIID             string
+	Host              string
+	ConsecutiveErrors int
+	EjectionTime      int64
+}
+
+// EventCertificateExpiringSoonMeta is the metadata structure for a
+// certificate used by an API that is close to expiry.
+type EventCertificateExpiringSoonMeta struct {
+	EventMetaDefault
+	APIID         string
+	CertID        string
+	ExpiresAt     time.Time
+	DaysRemaining int
+}
+
 // EventKeyFailureMeta is the metadata structure for any failure related
 // to a key, such as quota or auth failures.
 type EventKeyFailureMeta struct {
@@ -61,6 +86,7 @@ type EventCurcuitBreakerMeta struct {
 	EventMetaDefault
 	Path         string
 	APIID        string
+	Host         string
 	CircuitEvent circuit.BreakerEvent
 }
 
@@ -87,6 +113,28 @@ type EventTokenMeta struct {
 	Key string
 }
 
+// EventKeyRotatedMeta is the metadata structure for the end of the grace
+// period of a rotated key or secret. For secret rotations, Secret names the
+// rotated secret and NewKey is the same as Key.
+type EventKeyRotatedMeta struct {
+	EventMetaDefault
+	Org    string
+	Key    string
+	NewKey string
+	Secret string
+}
+
+// EventOAuthTokenRevokedMeta is the metadata structure for an OAuth client
+// revoking one of its tokens. TokenType is "access_token" or
+// "refresh_token".
+type EventOAuthTokenRevokedMeta struct {
+	EventMetaDefault
+	APIID     string
+	ClientID  string
+	Token     string
+	TokenType string
+}
+
 // EncodeRequestToEvent will write the request out in wire protocol and
 // encode it to base64 and store it in an Event object
 func EncodeRequestToEvent(r *http.Request) string {
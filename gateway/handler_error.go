@@ -3,12 +3,18 @@ package gateway
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"runtime/pprof"
 	"strconv"
 	"strings"
+	"text/template"
 	"time"
 
+	"github.com/Sirupsen/logrus"
+
+	"github.com/ins-tykgw/tyk/apidef"
 	"github.com/ins-tykgw/tyk/headers"
 	"github.com/ins-tykgw/tyk/request"
 )
@@ -30,43 +36,113 @@ type ErrorHandler struct {
 	BaseMiddleware
 }
 
-// HandleError is the actual error handler and will store the error details in analytics if analytics processing is enabled.
-func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMsg string, errCode int, writeResponse bool) {
-	defer e.Base().UpdateRequestSession(r)
+// Gateway error reasons, used to override error responses per API.
+const (
+	ErrorReasonAuthFailed       = "auth_failed"
+	ErrorReasonRateLimited      = "rate_limited"
+	ErrorReasonQuotaExceeded    = "quota_exceeded"
+	ErrorReasonValidationFailed = "validation_failed"
+	ErrorReasonUpstreamTimeout  = "upstream_timeout"
+	ErrorReasonBodyTooLarge     = "body_too_large"
+	ErrorReasonUpstreamAuth     = "upstream_auth_failed"
+)
 
-	if writeResponse {
-		var templateExtension string
-		var contentType string
+const problemContentType = "application/problem+json"
 
-		switch r.Header.Get(headers.ContentType) {
-		case headers.ApplicationXML:
-			templateExtension = "xml"
-			contentType = headers.ApplicationXML
-		default:
-			templateExtension = "json"
-			contentType = headers.ApplicationJSON
-		}
+// reasonError is an error tagged with the reason the gateway raised it.
+type reasonError struct {
+	error
+	reason string
+	// details are extra messages or objects, such as schema validation
+	// errors
+	details []interface{}
+}
+
+func errorWithReason(reason string, err error, details ...interface{}) error {
+	return &reasonError{error: err, reason: reason, details: details}
+}
 
-		w.Header().Set(headers.ContentType, contentType)
+// errorData is passed to error override templates.
+type errorData struct {
+	Message    string
+	StatusCode int
+	Reason     string
+	RequestID  string
+	Errors     []interface{}
+}
 
-		templateName := "error_" + strconv.Itoa(errCode) + "." + templateExtension
+// problemDetails is an RFC 7807 problem document.
+type problemDetails struct {
+	Type      string        `json:"type"`
+	Title     string        `json:"title"`
+	Status    int           `json:"status"`
+	Detail    string        `json:"detail,omitempty"`
+	Instance  string        `json:"instance,omitempty"`
+	RequestID string        `json:"request_id,omitempty"`
+	Errors    []interface{} `json:"errors,omitempty"`
+}
 
-		// Try to use an error template that matches the HTTP error code and the content type: 500.json, 400.xml, etc.
-		tmpl := templates.Lookup(templateName)
+// errorOverride is a compiled apidef.ErrorOverride.
+type errorOverride struct {
+	apidef.ErrorOverride
+	tmpl *template.Template
+}
 
-		// Fallback to a generic error template, but match the content type: error.json, error.xml, etc.
-		if tmpl == nil {
-			templateName = defaultTemplateName + "." + templateExtension
-			tmpl = templates.Lookup(templateName)
+// compileErrorOverrides parses the error override templates of an API.
+// Overrides are keyed by reason, or by "code:" and the status code.
+func compileErrorOverrides(def apidef.ErrorOverrides, logger *logrus.Entry) map[string]*errorOverride {
+	overrides := make(map[string]*errorOverride, len(def.ByReason)+len(def.ByCode))
+	add := func(key string, o apidef.ErrorOverride) {
+		override := &errorOverride{ErrorOverride: o}
+		if o.Body != "" {
+			tmpl, err := template.New(key).Parse(o.Body)
+			if err != nil {
+				logger.WithError(err).WithField("override", key).Error("Could not parse error override template")
+				return
+			}
+			override.tmpl = tmpl
 		}
+		overrides[key] = override
+	}
+	for reason, o := range def.ByReason {
+		add(reason, o)
+	}
+	for code, o := range def.ByCode {
+		add("code:"+code, o)
+	}
+	return overrides
+}
 
-		// If no template is available for this content type, fallback to "error.json".
-		if tmpl == nil {
-			templateName = defaultTemplateName + "." + defaultTemplateFormat
-			tmpl = templates.Lookup(templateName)
-			w.Header().Set(headers.ContentType, defaultContentType)
+// errorOverride returns the override for an error, or nil if there is none.
+func (s *APISpec) errorOverride(reason string, code int) *errorOverride {
+	if reason != "" {
+		if o := s.errorOverrides[reason]; o != nil {
+			return o
 		}
+	}
+	return s.errorOverrides["code:"+strconv.Itoa(code)]
+}
+
+// HandleError is the actual error handler and will store the error details in analytics if analytics processing is enabled.
+func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMsg string, errCode int, writeResponse bool) {
+	e.handleError(w, r, errors.New(errMsg), errCode, writeResponse)
+}
 
+// handleError is HandleError for errors that may carry a reason.
+func (e *ErrorHandler) handleError(w http.ResponseWriter, r *http.Request, err error, errCode int, writeResponse bool) {
+	defer e.Base().UpdateRequestSession(r)
+
+	var reason string
+	var details []interface{}
+	if rerr, ok := err.(*reasonError); ok {
+		reason, details = rerr.reason, rerr.details
+	}
+	override := e.Spec.errorOverride(reason, errCode)
+	if override != nil && override.Code != 0 {
+		errCode = override.Code
+	}
+
+	if writeResponse {
 		//If the config option is not set or is false, add the header
 		if !e.Spec.GlobalConfig.HideGeneratorHeader {
 			w.Header().Add(headers.XGenerator, "tyk.io")
@@ -77,10 +153,26 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 			w.Header().Add(headers.Connection, "close")
 		}
 
-		// Need to return the correct error code!
-		w.WriteHeader(errCode)
-		apiError := APIError{errMsg}
-		tmpl.Execute(w, &apiError)
+		data := errorData{
+			Message:    err.Error(),
+			StatusCode: errCode,
+			Reason:     reason,
+			RequestID:  errorRequestID(r),
+			Errors:     details,
+		}
+		switch {
+		case override != nil && override.tmpl != nil:
+			e.writeOverride(w, override, &data)
+		case e.Spec.ErrorOverrides.ProblemDetails:
+			e.writeProblem(w, r, override, &data)
+		default:
+			if override != nil {
+				for k, v := range override.Headers {
+					w.Header().Set(k, v)
+				}
+			}
+			e.writeTemplate(w, r, &data)
+		}
 	}
 
 	if memProfFile != nil {
@@ -177,6 +269,8 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 			tags,
 			alias,
 			trackEP,
+			ctxGetRequestID(r),
+			nil,
 			t,
 		}
 
@@ -209,3 +303,111 @@ func (e *ErrorHandler) HandleError(w http.ResponseWriter, r *http.Request, errMs
 		pprof.WriteHeapProfile(memProfFile)
 	}
 }
+
+// writeTemplate writes the error with the global error templates.
+func (e *ErrorHandler) writeTemplate(w http.ResponseWriter, r *http.Request, data *errorData) {
+	var templateExtension string
+	var contentType string
+
+	switch r.Header.Get(headers.ContentType) {
+	case headers.ApplicationXML:
+		templateExtension = "xml"
+		contentType = headers.ApplicationXML
+	default:
+		templateExtension = "json"
+		contentType = headers.ApplicationJSON
+	}
+
+	w.Header().Set(headers.ContentType, contentType)
+
+	templateName := "error_" + strconv.Itoa(data.StatusCode) + "." + templateExtension
+
+	// Try to use an error template that matches the HTTP error code and the content type: 500.json, 400.xml, etc.
+	tmpl := templates.Lookup(templateName)
+
+	// Fallback to a generic error template, but match the content type: error.json, error.xml, etc.
+	if tmpl == nil {
+		templateName = defaultTemplateName + "." + templateExtension
+		tmpl = templates.Lookup(templateName)
+	}
+
+	// If no template is available for this content type, fallback to "error.json".
+	if tmpl == nil {
+		templateName = defaultTemplateName + "." + defaultTemplateFormat
+		tmpl = templates.Lookup(templateName)
+		w.Header().Set(headers.ContentType, defaultContentType)
+	}
+
+	// Need to return the correct error code!
+	w.WriteHeader(data.StatusCode)
+	apiError := APIError{data.Message}
+	tmpl.Execute(w, &apiError)
+}
+
+// writeOverride writes the error with an API's override template.
+func (e *ErrorHandler) writeOverride(w http.ResponseWriter, o *errorOverride, data *errorData) {
+	contentType := o.ContentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	w.Header().Set(headers.ContentType, contentType)
+	for k, v := range o.Headers {
+		w.Header().Set(k, v)
+	}
+
+	var buf bytes.Buffer
+	if err := o.tmpl.Execute(&buf, data); err != nil {
+		e.Logger().WithError(err).Error("Could not execute error override template")
+	}
+	w.WriteHeader(data.StatusCode)
+	w.Write(buf.Bytes())
+}
+
+// writeProblem writes the error as an RFC 7807 problem document.
+func (e *ErrorHandler) writeProblem(w http.ResponseWriter, r *http.Request, o *errorOverride, data *errorData) {
+	problem := problemDetails{
+		Type:      "about:blank",
+		Title:     http.StatusText(data.StatusCode),
+		Status:    data.StatusCode,
+		Detail:    data.Message,
+		Instance:  r.URL.Path,
+		RequestID: data.RequestID,
+		Errors:    data.Errors,
+	}
+	if base := e.Spec.ErrorOverrides.ProblemTypeBaseURL; base != "" && data.Reason != "" {
+		problem.Type = strings.TrimSuffix(base, "/") + "/" + data.Reason
+	}
+
+	contentType := problemContentType
+	if o != nil {
+		if o.Type != "" {
+			problem.Type = o.Type
+		}
+		if o.Title != "" {
+			problem.Title = o.Title
+		}
+		if o.ContentType != "" {
+			contentType = o.ContentType
+		}
+		for k, v := range o.Headers {
+			w.Header().Set(k, v)
+		}
+	}
+	if problem.Title == "" {
+		problem.Title = "Error"
+	}
+
+	w.Header().Set(headers.ContentType, contentType)
+	w.WriteHeader(data.StatusCode)
+	json.NewEncoder(w).Encode(problem)
+}
+
+// errorRequestID returns the request ID, falling back to the one set in
+// the context variables.
+func errorRequestID(r *http.Request) string {
+	if id := ctxGetRequestID(r); id != "" {
+		return id
+	}
+	id, _ := ctxGetData(r)["request_id"].(string)
+	return id
+}
@@ -0,0 +1,81 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func TestErrorOverrides(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.APIID = "problem"
+		spec.Proxy.ListenPath = "/problem/"
+		spec.UseKeylessAccess = false
+		spec.ErrorOverrides = apidef.ErrorOverrides{
+			ProblemDetails:     true,
+			ProblemTypeBaseURL: "https://errors.example.com/",
+			ByCode: map[string]apidef.ErrorOverride{
+				"403": {Title: "Key not allowed", Type: "https://errors.example.com/forbidden"},
+			},
+		}
+	}, func(spec *APISpec) {
+		spec.APIID = "validate"
+		spec.Proxy.ListenPath = "/validate/"
+		spec.ErrorOverrides.ProblemDetails = true
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			json.Unmarshal([]byte(`[{"path": "/v", "method": "POST", "schema": `+testJsonSchema+`}]`),
+				&v.ExtendedPaths.ValidateJSON)
+		})
+	}, func(spec *APISpec) {
+		spec.APIID = "custom"
+		spec.Proxy.ListenPath = "/custom/"
+		spec.UseKeylessAccess = false
+		spec.ErrorOverrides.ByReason = map[string]apidef.ErrorOverride{
+			ErrorReasonAuthFailed: {
+				Code:        http.StatusTeapot,
+				Body:        `<error reason="{{.Reason}}" code="{{.StatusCode}}">{{.Message}}</error>`,
+				ContentType: "application/xml",
+				Headers:     map[string]string{"X-Error": "custom"},
+			},
+		}
+		spec.ErrorOverrides.ByCode = map[string]apidef.ErrorOverride{
+			"401": {Body: "by code"},
+		}
+	}, func(spec *APISpec) {
+		spec.APIID = "default"
+		spec.Proxy.ListenPath = "/default/"
+		spec.UseKeylessAccess = false
+	})
+
+	ts.Run(t, []test.TestCase{
+		{
+			Path: "/problem/", Code: http.StatusUnauthorized,
+			HeadersMatch: map[string]string{"Content-Type": "application/problem+json"},
+			BodyMatch:    `{"type":"https://errors.example.com/auth_failed","title":"Unauthorized","status":401,"detail":"Authorization field missing","instance":"/problem/"}`,
+		},
+		{
+			Path: "/problem/", Headers: map[string]string{"Authorization": "unknown"}, Code: http.StatusForbidden,
+			BodyMatch: `{"type":"https://errors.example.com/forbidden","title":"Key not allowed","status":403`,
+		},
+		{
+			Method: "POST", Path: "/validate/v", Data: `{"age":23}`, Code: http.StatusUnprocessableEntity,
+			BodyMatch: `"type":"about:blank","title":"Unprocessable Entity","status":422,"detail":"firstName: firstName is required; lastName: lastName is required","instance":"/validate/v","errors":["firstName: firstName is required","lastName: lastName is required"]`,
+		},
+		{
+			Path: "/custom/", Code: http.StatusTeapot,
+			HeadersMatch: map[string]string{"Content-Type": "application/xml", "X-Error": "custom"},
+			BodyMatch:    `<error reason="auth_failed" code="418">Authorization field missing</error>`,
+		},
+		{
+			Path: "/default/", Code: http.StatusUnauthorized,
+			HeadersMatch: map[string]string{"Content-Type": "application/json"},
+			BodyMatch:    `"error": "Authorization field missing"`,
+		},
+	}...)
+}
@@ -226,9 +226,15 @@ func (s *SuccessHandler) RecordHit(r *http.Request, timing int64, code int, resp
 			tags,
 			alias,
 			trackEP,
+			ctxGetRequestID(r),
+			nil,
 			t,
 		}
 
+		if c := ctxGetWebSocketConn(r); c != nil {
+			record.WebSocket = c.record()
+		}
+
 		if s.Spec.GlobalConfig.AnalyticsConfig.EnableGeoIP {
 			record.GetGeo(ip)
 		}
@@ -80,7 +80,7 @@ func (ws *WSDialer) RoundTrip(req *http.Request) (*http.Response, error) {
 		return nil, errors.New("Not a hjijacker?")
 	}
 
-	nc, _, err := hj.Hijack()
+	nc, bufrw, err := hj.Hijack()
 	if err != nil {
 		log.WithFields(logrus.Fields{
 			"path":   req.URL.Path,
@@ -98,14 +98,24 @@ func (ws *WSDialer) RoundTrip(req *http.Request) (*http.Response, error) {
 		return nil, err
 	}
 
+	if c := ctxGetWebSocketConn(req); c != nil {
+		return nil, c.serve(req, nc, bufrw.Reader, d)
+	}
+
+	return nil, copyStreams(req, nc, bufrw.Reader, d, d)
+}
+
+// copyStreams passes bytes both ways until both sides are done.
+func copyStreams(req *http.Request, client net.Conn, clientR io.Reader, upstream net.Conn, upstreamR io.Reader) error {
 	errc := make(chan error, 2)
 	cp := func(dst io.Writer, src io.Reader) {
 		_, err := io.Copy(dst, src)
 		errc <- err
 	}
-	go cp(d, nc)
-	go cp(nc, d)
+	go cp(upstream, clientR)
+	go cp(client, upstreamR)
 
+	var err error
 	for i := 0; i < 2; i++ {
 		cerr := <-errc
 		if cerr == nil {
@@ -114,11 +124,10 @@ func (ws *WSDialer) RoundTrip(req *http.Request) (*http.Response, error) {
 		err = cerr
 		log.WithFields(logrus.Fields{
 			"path":   req.URL.Path,
-			"origin": ip,
+			"origin": request.RealIP(req),
 		}).Errorf("Error transmitting request: %v", err)
 	}
-
-	return nil, err
+	return err
 }
 
 func IsWebsocket(req *http.Request) bool {
@@ -0,0 +1,206 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func startWebSocketTest(t *testing.T, options apidef.WebSocketOptions) Test {
+	globalConf := config.Global()
+	globalConf.HttpServerOptions.EnableWebSockets = true
+	config.SetGlobal(globalConf)
+
+	ts := StartTest()
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.DisableRateLimit = false
+		spec.Proxy.ListenPath = "/"
+		spec.WebSocket = options
+	})
+	return ts
+}
+
+func dialWebSocket(t *testing.T, ts Test, key string) *websocket.Conn {
+	t.Helper()
+	baseURL := strings.Replace(ts.URL, "http://", "ws://", -1)
+	conn, _, err := websocket.DefaultDialer.Dial(baseURL+"/ws", http.Header{"Authorization": {key}})
+	if err != nil {
+		t.Fatalf("cannot make websocket connection: %v", err)
+	}
+	return conn
+}
+
+// sendMessage sends a message and reads the echo, returning the error the
+// connection was closed with, if any.
+func sendMessage(t *testing.T, conn *websocket.Conn, msg string) error {
+	t.Helper()
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+		t.Fatalf("cannot write message: %v", err)
+	}
+	_, p, err := conn.ReadMessage()
+	if err != nil {
+		return err
+	}
+	if string(p) != "reply to message: "+msg {
+		t.Error("Unexpected reply:", string(p))
+	}
+	return nil
+}
+
+func assertClosedWith(t *testing.T, err error, code int, text string) {
+	t.Helper()
+	closeErr, ok := err.(*websocket.CloseError)
+	if !ok {
+		t.Fatalf("Expected the connection to be closed, got %v", err)
+	}
+	if closeErr.Code != code || closeErr.Text != text {
+		t.Errorf("Expected close %d %q, got %d %q", code, text, closeErr.Code, closeErr.Text)
+	}
+}
+
+func TestWebSocketMessageLimits(t *testing.T) {
+	ts := startWebSocketTest(t, apidef.WebSocketOptions{
+		RateLimitMessages: true,
+		MaxMessageSize:    100,
+	})
+	defer ts.Close()
+	defer ResetTestConfig()
+
+	t.Run("Quota", func(t *testing.T) {
+		key := CreateSession(func(s *user.SessionState) {
+			s.QuotaMax = 3
+		})
+		conn := dialWebSocket(t, ts, key)
+		defer conn.Close()
+
+		// the upgrade request counts too
+		for _, msg := range []string{"one", "two"} {
+			if err := sendMessage(t, conn, msg); err != nil {
+				t.Fatalf("Message %q should be passed on, got %v", msg, err)
+			}
+		}
+		assertClosedWith(t, sendMessage(t, conn, "three"), wsClosePolicyViolation, "Quota exceeded")
+	})
+
+	t.Run("Rate limit", func(t *testing.T) {
+		DRLManager.CurrentTokenValue = 1
+		DRLManager.RequestTokenValue = 1
+		defer func() {
+			DRLManager.CurrentTokenValue = 0
+			DRLManager.RequestTokenValue = 0
+		}()
+
+		key := CreateSession(func(s *user.SessionState) {
+			s.Rate = 1
+			s.Per = 60
+		})
+		conn := dialWebSocket(t, ts, key)
+		defer conn.Close()
+
+		// the upgrade request used the allowance up
+		assertClosedWith(t, sendMessage(t, conn, "one"), wsCloseTryAgainLater, "Rate limit exceeded")
+	})
+
+	t.Run("Message size", func(t *testing.T) {
+		conn := dialWebSocket(t, ts, CreateSession())
+		defer conn.Close()
+
+		if err := sendMessage(t, conn, strings.Repeat("a", 50)); err != nil {
+			t.Fatalf("Message should be passed on, got %v", err)
+		}
+		assertClosedWith(t, sendMessage(t, conn, strings.Repeat("a", 101)), wsCloseMessageTooBig, "Message is too large")
+
+		// replies are limited too
+		conn = dialWebSocket(t, ts, CreateSession())
+		defer conn.Close()
+		assertClosedWith(t, sendMessage(t, conn, strings.Repeat("a", 90)), wsCloseMessageTooBig, "Message is too large")
+	})
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	defer func(period time.Duration) { wsMonitorPeriod = period }(wsMonitorPeriod)
+	wsMonitorPeriod = 50 * time.Millisecond
+
+	ts := startWebSocketTest(t, apidef.WebSocketOptions{IdleTimeout: 1})
+	defer ts.Close()
+	defer ResetTestConfig()
+
+	conn := dialWebSocket(t, ts, CreateSession())
+	defer conn.Close()
+
+	if err := sendMessage(t, conn, "one"); err != nil {
+		t.Fatalf("Message should be passed on, got %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := conn.ReadMessage()
+	assertClosedWith(t, err, wsCloseGoingAway, "Idle timeout")
+}
+
+func TestWebSocketKeyRevoked(t *testing.T) {
+	defer func(period time.Duration) { wsMonitorPeriod = period }(wsMonitorPeriod)
+	wsMonitorPeriod = 50 * time.Millisecond
+
+	ts := startWebSocketTest(t, apidef.WebSocketOptions{SessionCheckInterval: 1})
+	defer ts.Close()
+	defer ResetTestConfig()
+
+	key := CreateSession()
+	conn := dialWebSocket(t, ts, key)
+	defer conn.Close()
+
+	if err := sendMessage(t, conn, "one"); err != nil {
+		t.Fatalf("Message should be passed on, got %v", err)
+	}
+
+	ts.Run(t, test.TestCase{Method: "DELETE", Path: "/tyk/keys/" + key, AdminAuth: true, Code: http.StatusOK})
+
+	conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, _, err := conn.ReadMessage()
+	assertClosedWith(t, err, wsClosePolicyViolation, "Key not authorised")
+}
+
+func TestWebSocketAnalytics(t *testing.T) {
+	ts := startWebSocketTest(t, apidef.WebSocketOptions{})
+	defer ts.Close()
+	defer ResetTestConfig()
+
+	// let records to to be sent
+	time.Sleep(recordsBufferFlushInterval + 50)
+	analytics.Store.GetAndDeleteSet(analyticsKeyName)
+
+	conn := dialWebSocket(t, ts, CreateSession())
+	for _, msg := range []string{"one", "two"} {
+		if err := sendMessage(t, conn, msg); err != nil {
+			t.Fatalf("Message %q should be passed on, got %v", msg, err)
+		}
+	}
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	conn.Close()
+
+	// let records to to be sent
+	time.Sleep(recordsBufferFlushInterval + 200*time.Millisecond)
+
+	results := analytics.Store.GetAndDeleteSet(analyticsKeyName)
+	if len(results) != 1 {
+		t.Fatal("Should return 1 record: ", len(results))
+	}
+
+	var record AnalyticsRecord
+	msgpack.Unmarshal(results[0].([]byte), &record)
+	if record.ResponseCode != http.StatusSwitchingProtocols || record.WebSocket == nil {
+		t.Fatal("Analytics record do not match: ", record)
+	}
+	if ws := record.WebSocket; ws.MessagesIn != 2 || ws.MessagesOut != 2 || ws.CloseReason != "Closed by client" {
+		t.Error("WebSocket record do not match: ", *ws)
+	}
+}
@@ -397,14 +397,21 @@ func (hc *HostCheckerManager) ListFromService(apiID string) ([]HostData, error)
 		return nil, err
 	}
 
-	// The returned data is a string, so lets unmarshal it:
 	checkTargets := make([]apidef.HostCheckObject, 0)
-	data0, _ := data.GetIndex(0)
-	if err := json.Unmarshal([]byte(data0), &checkTargets); err != nil {
-		log.WithFields(logrus.Fields{
-			"prefix": "host-check-mgr",
-		}).Error("[HOST CHECKER MANAGER] Decoder failed: ", err)
-		return nil, err
+	if sd.provider != nil {
+		// Native providers return the hosts themselves, check each of them:
+		for _, host := range data.All() {
+			checkTargets = append(checkTargets, apidef.HostCheckObject{CheckURL: EnsureTransport(host)})
+		}
+	} else {
+		// The returned data is a string, so lets unmarshal it:
+		data0, _ := data.GetIndex(0)
+		if err := json.Unmarshal([]byte(data0), &checkTargets); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "host-check-mgr",
+			}).Error("[HOST CHECKER MANAGER] Decoder failed: ", err)
+			return nil, err
+		}
 	}
 
 	hostData := make([]HostData, len(checkTargets))
@@ -514,10 +521,10 @@ func SetCheckerHostList() {
 	apisMu.RLock()
 	for _, spec := range apisByID {
 		if spec.UptimeTests.Config.ServiceDiscovery.UseDiscoveryService {
-			hostList, err := GlobalHostChecker.ListFromService(spec.APIID)
+			serviceHosts, err := GlobalHostChecker.ListFromService(spec.APIID)
 			if err == nil {
-				hostList = append(hostList, hostList...)
-				for _, t := range hostList {
+				hostList = append(hostList, serviceHosts...)
+				for _, t := range serviceHosts {
 					log.WithFields(logrus.Fields{
 						"prefix": "host-check-mgr",
 					}).WithFields(logrus.Fields{
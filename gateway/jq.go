@@ -7,9 +7,11 @@ package gateway
 // #include <jv.h>
 import "C"
 import (
+	"context"
 	"errors"
 	"fmt"
 	"reflect"
+	"sync"
 )
 
 // JQ type stores a JQ vm
@@ -51,6 +53,75 @@ func (jq *JQ) Handle(value interface{}) (interface{}, error) {
 	return result, nil
 }
 
+// lockedJQ guards a JQ vm for use from concurrent requests. libjq can't
+// be interrupted, so filters run in a goroutine and the request gives up
+// once its deadline passes. A vm still busy then is left to finish on its
+// own and replaced, so that later requests aren't stuck behind it.
+type lockedJQ struct {
+	program string
+
+	mu sync.Mutex
+	vm *jqVM
+}
+
+type jqVM struct {
+	sync.Mutex
+	jq *JQ
+}
+
+type jqResult struct {
+	value interface{}
+	err   error
+}
+
+func newJQFilter(program string) (jqFilter, error) {
+	jq, err := NewJQ(program)
+	if err != nil {
+		return nil, err
+	}
+	return &lockedJQ{program: program, vm: &jqVM{jq: jq}}, nil
+}
+
+func (l *lockedJQ) Handle(ctx context.Context, value interface{}) (interface{}, error) {
+	l.mu.Lock()
+	vm := l.vm
+	l.mu.Unlock()
+
+	done := make(chan jqResult, 1)
+	go func() {
+		vm.Lock()
+		defer vm.Unlock()
+		if err := ctx.Err(); err != nil {
+			done <- jqResult{err: err}
+			return
+		}
+		out, err := vm.jq.Handle(value)
+		done <- jqResult{out, err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.value, res.err
+	case <-ctx.Done():
+		l.replace(vm)
+		return nil, ctx.Err()
+	}
+}
+
+// replace swaps vm for a new one, unless another request already did.
+func (l *lockedJQ) replace(vm *jqVM) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.vm != vm {
+		return
+	}
+	jq, err := NewJQ(l.program)
+	if err != nil {
+		return
+	}
+	l.vm = &jqVM{jq: jq}
+}
+
 func isValid(jv C.jv) bool {
 	return C.jv_is_valid(jv) != 0
 }
@@ -0,0 +1,27 @@
+// +build !jq
+
+package gateway
+
+import (
+	"context"
+
+	"github.com/ins-tykgw/tyk/jq"
+)
+
+// jqCode runs filters with the pure Go jq engine, so jq transforms work
+// in builds without libjq.
+type jqCode struct {
+	code *jq.Code
+}
+
+func newJQFilter(program string) (jqFilter, error) {
+	code, err := jq.Compile(program)
+	if err != nil {
+		return nil, err
+	}
+	return &jqCode{code: code}, nil
+}
+
+func (j *jqCode) Handle(ctx context.Context, value interface{}) (interface{}, error) {
+	return j.code.First(ctx, value)
+}
@@ -0,0 +1,28 @@
+package gateway
+
+import (
+	"testing"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func TestJQFilterTimeout(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.TransformJQ = []apidef.TransformJQMeta{{
+				Path:    "/jq",
+				Method:  "POST",
+				Filter:  `{"body": last(range(1e12))}`,
+				Timeout: 10,
+			}}
+		})
+	})
+
+	ts.Run(t, test.TestCase{Path: "/jq", Method: "POST", Data: `{}`, Code: 415})
+}
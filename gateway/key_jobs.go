@@ -0,0 +1,594 @@
+package gateway
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/storage"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+const (
+	defaultKeysPageSize = 100
+	maxKeysPageSize     = 10000
+
+	// keyJobBatchSize is how many keys a job reads and writes at once.
+	keyJobBatchSize = 1000
+	// keyJobRetention is how long finished jobs can still be queried.
+	keyJobRetention = 24 * time.Hour
+)
+
+// KeyFilter selects keys by their name and by the fields of their
+// sessions. Empty fields match every key.
+//
+// swagger:model
+type KeyFilter struct {
+	// Filter is a prefix of the key names. It's ignored when keys are
+	// hashed.
+	Filter    string `json:"filter"`
+	Policy    string `json:"policy"`
+	OrgID     string `json:"org_id"`
+	Tag       string `json:"tag"`
+	Alias     string `json:"alias"`
+	MetaKey   string `json:"meta_key"`
+	MetaValue string `json:"meta_value"`
+	// ExpiresAfter and ExpiresBefore bound the expiry of the keys, as Unix
+	// timestamps. Keys that never expire are only after any time.
+	ExpiresAfter  int64 `json:"expires_after"`
+	ExpiresBefore int64 `json:"expires_before"`
+}
+
+var keyFilterParams = []string{"cursor", "count", "policy", "org_id", "tag", "alias", "meta_key", "meta_value", "expires_after", "expires_before"}
+
+// isKeysPageRequest reports whether a key listing asks for a page of keys,
+// rather than all of them.
+func isKeysPageRequest(q url.Values) bool {
+	for _, param := range keyFilterParams {
+		if q.Get(param) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func keyFilterFromQuery(q url.Values) (*KeyFilter, error) {
+	f := &KeyFilter{
+		Filter:    q.Get("filter"),
+		Policy:    q.Get("policy"),
+		OrgID:     q.Get("org_id"),
+		Tag:       q.Get("tag"),
+		Alias:     q.Get("alias"),
+		MetaKey:   q.Get("meta_key"),
+		MetaValue: q.Get("meta_value"),
+	}
+	var err error
+	if v := q.Get("expires_after"); v != "" {
+		if f.ExpiresAfter, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, errors.New("expires_after must be a Unix timestamp")
+		}
+	}
+	if v := q.Get("expires_before"); v != "" {
+		if f.ExpiresBefore, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, errors.New("expires_before must be a Unix timestamp")
+		}
+	}
+	return f, nil
+}
+
+func (f *KeyFilter) empty() bool {
+	return *f == KeyFilter{}
+}
+
+// pattern is the pattern of the keys to scan in a store.
+func (f *KeyFilter) pattern(store storage.Handler) string {
+	if f.Filter == "" || config.Global().HashKeys {
+		return store.GetKeyPrefix() + "*"
+	}
+	return store.GetKeyPrefix() + storage.HashKey(f.Filter) + "*"
+}
+
+func (f *KeyFilter) matches(session *user.SessionState) bool {
+	if f.OrgID != "" && session.OrgID != f.OrgID {
+		return false
+	}
+	if f.Alias != "" && session.Alias != f.Alias {
+		return false
+	}
+	if f.Policy != "" && !containsString(session.PolicyIDs(), f.Policy) {
+		return false
+	}
+	if f.Tag != "" && !containsString(session.Tags, f.Tag) {
+		return false
+	}
+	if f.MetaKey != "" {
+		value, ok := session.MetaData[f.MetaKey]
+		if !ok || (f.MetaValue != "" && fmt.Sprint(value) != f.MetaValue) {
+			return false
+		}
+	}
+	if f.ExpiresBefore > 0 && (session.Expires <= 0 || session.Expires > f.ExpiresBefore) {
+		return false
+	}
+	if f.ExpiresAfter > 0 && session.Expires > 0 && session.Expires < f.ExpiresAfter {
+		return false
+	}
+	return true
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// scanSessions scans the keys of store from cursor, batch by batch, and
+// calls fn with the names and sessions of the keys matching filter, and
+// the number of keys scanned. It stops when fn returns false, and returns
+// the cursor to carry on from.
+func scanSessions(store storage.BatchHandler, prefix, pattern string, filter *KeyFilter, cursor string, count int64,
+	fn func(scanned int, names []string, sessions []*user.SessionState) bool) (string, error) {
+	if cursor == "" {
+		cursor = "0"
+	}
+	for {
+		keys, next, err := store.ScanKeys(cursor, pattern, count)
+		if err != nil {
+			return cursor, err
+		}
+		values, err := store.GetRawKeys(keys)
+		if err != nil {
+			return cursor, err
+		}
+
+		var names []string
+		var sessions []*user.SessionState
+		for i, value := range values {
+			session := &user.SessionState{}
+			if value == "" || json.Unmarshal([]byte(value), session) != nil || !filter.matches(session) {
+				continue
+			}
+			names = append(names, strings.TrimPrefix(keys[i], prefix))
+			sessions = append(sessions, session)
+		}
+
+		cursor = next
+		if !fn(len(keys), names, sessions) || cursor == "0" {
+			return cursor, nil
+		}
+	}
+}
+
+// apiKeysPage represents a page of keys in the memory store
+// swagger:model
+type apiKeysPage struct {
+	APIKeys []string `json:"keys"`
+	// Cursor is where the next page starts, or "0" after the last page.
+	Cursor string `json:"cursor"`
+}
+
+func batchStore(apiID string) (storage.Handler, storage.BatchHandler, error) {
+	sessionManager := FallbackKeySesionManager
+	if spec := getApiSpec(apiID); spec != nil {
+		sessionManager = spec.SessionManager
+	}
+	store := sessionManager.Store()
+	batch, ok := store.(storage.BatchHandler)
+	if !ok {
+		return nil, nil, errors.New("The key store doesn't support paging through keys")
+	}
+	return store, batch, nil
+}
+
+// handleGetKeysPage returns a page of about count keys, with SCAN. As SCAN
+// returns whole batches, a page can hold more keys than asked for.
+func handleGetKeysPage(q url.Values, apiID string) (interface{}, int) {
+	filter, err := keyFilterFromQuery(q)
+	if err != nil {
+		return apiError(err.Error()), http.StatusBadRequest
+	}
+	count := int64(defaultKeysPageSize)
+	if v := q.Get("count"); v != "" {
+		if count, err = strconv.ParseInt(v, 10, 64); err != nil || count <= 0 || count > maxKeysPageSize {
+			return apiError(fmt.Sprintf("count must be between 1 and %d", maxKeysPageSize)), http.StatusBadRequest
+		}
+	}
+	store, batch, err := batchStore(apiID)
+	if err != nil {
+		return apiError(err.Error()), http.StatusBadRequest
+	}
+
+	page := apiKeysPage{APIKeys: []string{}}
+	page.Cursor, err = scanSessions(batch, store.GetKeyPrefix(), filter.pattern(store), filter, q.Get("cursor"), count,
+		func(_ int, names []string, _ []*user.SessionState) bool {
+			page.APIKeys = append(page.APIKeys, names...)
+			return int64(len(page.APIKeys)) < count
+		})
+	if err != nil {
+		log.WithError(err).Error("Could not scan keys")
+		return apiError("Could not scan keys"), http.StatusInternalServerError
+	}
+
+	log.WithFields(logrus.Fields{
+		"prefix": "api",
+		"status": "ok",
+	}).Info("Retrieved key page.")
+
+	return page, http.StatusOK
+}
+
+// Actions of bulk key jobs.
+const (
+	keyJobCreate = "create"
+	keyJobUpdate = "update"
+	keyJobDelete = "delete"
+)
+
+// KeyJobRequest is the body of a bulk key job. Create jobs add Keys, while
+// update and delete jobs change or remove the keys that match Filter.
+//
+// swagger:model
+type KeyJobRequest struct {
+	Action string          `json:"action"`
+	Filter KeyFilter       `json:"filter"`
+	Keys   []KeyJobSession `json:"keys"`
+	Update KeyJobUpdate    `json:"update"`
+}
+
+// KeyJobSession is a key to create. The name of the key is generated
+// unless Key is set, which is the user name for basic auth keys.
+type KeyJobSession struct {
+	Key string `json:"key"`
+	user.SessionState
+}
+
+// KeyJobUpdate lists the changes made to keys by update jobs. Unset fields
+// are left as they are.
+type KeyJobUpdate struct {
+	ApplyPolicies []string `json:"apply_policies"`
+	Expires       *int64   `json:"expires"`
+	IsInactive    *bool    `json:"is_inactive"`
+}
+
+func (u *KeyJobUpdate) empty() bool {
+	return u.ApplyPolicies == nil && u.Expires == nil && u.IsInactive == nil
+}
+
+func (u *KeyJobUpdate) apply(session *user.SessionState) {
+	if u.ApplyPolicies != nil {
+		session.SetPolicies(u.ApplyPolicies...)
+		mw := BaseMiddleware{}
+		mw.ApplyPolicies(session)
+	}
+	if u.Expires != nil {
+		session.Expires = *u.Expires
+	}
+	if u.IsInactive != nil {
+		session.IsInactive = *u.IsInactive
+	}
+	session.LastUpdated = strconv.Itoa(int(time.Now().Unix()))
+}
+
+// keyJob is the progress of a bulk key job. Jobs run in the background on
+// the gateway they were sent to, which is also where they're queried.
+//
+// swagger:model
+type keyJob struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+	// Status is "running", "done" or "failed".
+	Status string `json:"status"`
+	// Scanned counts the keys looked at, Matched those selected by the
+	// filter or given to create, and Failed those that couldn't be changed.
+	Scanned  int        `json:"scanned"`
+	Matched  int        `json:"matched"`
+	Done     int        `json:"done"`
+	Failed   int        `json:"failed"`
+	Error    string     `json:"error,omitempty"`
+	Keys     []string   `json:"keys,omitempty"`
+	Started  time.Time  `json:"started"`
+	Finished *time.Time `json:"finished,omitempty"`
+}
+
+var (
+	keyJobsMu sync.RWMutex
+	keyJobs   = map[string]*keyJob{}
+)
+
+// getKeyJob returns a copy of a job, so that it can be read while the job
+// goes on.
+func getKeyJob(id string) (keyJob, bool) {
+	keyJobsMu.RLock()
+	defer keyJobsMu.RUnlock()
+	job, ok := keyJobs[id]
+	if !ok {
+		return keyJob{}, false
+	}
+	return *job, true
+}
+
+func (j *keyJob) progress(fn func(j *keyJob)) {
+	keyJobsMu.Lock()
+	fn(j)
+	keyJobsMu.Unlock()
+}
+
+func (j *keyJob) finish(err error) {
+	j.progress(func(j *keyJob) {
+		now := time.Now()
+		j.Finished = &now
+		j.Status = "done"
+		if err != nil {
+			j.Status = "failed"
+			j.Error = err.Error()
+		}
+	})
+
+	log.WithFields(logrus.Fields{
+		"prefix": "api",
+		"job":    j.ID,
+		"action": j.Action,
+		"status": j.Status,
+	}).Info("Key job finished.")
+}
+
+func keyJobsHandler(w http.ResponseWriter, r *http.Request) {
+	var obj interface{}
+	code := http.StatusOK
+
+	switch r.Method {
+	case http.MethodPost:
+		var req KeyJobRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			doJSONWrite(w, http.StatusBadRequest, apiError("Request malformed"))
+			return
+		}
+		obj, code = handleStartKeyJob(&req)
+	case http.MethodGet:
+		if id := mux.Vars(r)["jobID"]; id != "" {
+			job, ok := getKeyJob(id)
+			if !ok {
+				doJSONWrite(w, http.StatusNotFound, apiError("Job not found"))
+				return
+			}
+			obj = job
+		} else {
+			keyJobsMu.RLock()
+			jobs := make([]keyJob, 0, len(keyJobs))
+			for _, job := range keyJobs {
+				jobs = append(jobs, *job)
+			}
+			keyJobsMu.RUnlock()
+			obj = jobs
+		}
+	}
+
+	doJSONWrite(w, code, obj)
+}
+
+func handleStartKeyJob(req *KeyJobRequest) (interface{}, int) {
+	switch req.Action {
+	case keyJobCreate:
+		if len(req.Keys) == 0 {
+			return apiError("No keys to create"), http.StatusBadRequest
+		}
+	case keyJobUpdate:
+		if req.Update.empty() {
+			return apiError("No changes to make"), http.StatusBadRequest
+		}
+		fallthrough
+	case keyJobDelete:
+		if req.Filter.empty() {
+			return apiError("A filter is required"), http.StatusBadRequest
+		}
+	default:
+		return apiError("Unknown action " + req.Action), http.StatusBadRequest
+	}
+
+	var store storage.Handler
+	var batch storage.BatchHandler
+	if req.Action != keyJobCreate {
+		var err error
+		if store, batch, err = batchStore(""); err != nil {
+			return apiError(err.Error()), http.StatusBadRequest
+		}
+	}
+
+	job := &keyJob{
+		ID:      uuid.NewV4().String(),
+		Action:  req.Action,
+		Status:  "running",
+		Started: time.Now(),
+	}
+	keyJobsMu.Lock()
+	for id, old := range keyJobs {
+		if old.Finished != nil && time.Since(*old.Finished) > keyJobRetention {
+			delete(keyJobs, id)
+		}
+	}
+	keyJobs[job.ID] = job
+	keyJobsMu.Unlock()
+
+	go func() {
+		switch req.Action {
+		case keyJobCreate:
+			job.finish(job.create(req.Keys))
+		case keyJobUpdate:
+			job.finish(job.update(store, batch, &req.Filter, &req.Update))
+		case keyJobDelete:
+			job.finish(job.delete(store, batch, &req.Filter))
+		}
+	}()
+
+	log.WithFields(logrus.Fields{
+		"prefix": "api",
+		"job":    job.ID,
+		"action": job.Action,
+	}).Info("Started key job.")
+
+	started, _ := getKeyJob(job.ID)
+	return started, http.StatusOK
+}
+
+func (j *keyJob) create(keys []KeyJobSession) error {
+	j.progress(func(j *keyJob) { j.Matched = len(keys) })
+	for _, k := range keys {
+		session := k.SessionState
+		keyName := k.Key
+		switch {
+		case session.BasicAuthData.Password != "":
+			if keyName == "" {
+				j.progress(func(j *keyJob) { j.Failed++ })
+				continue
+			}
+			keyName = generateToken(session.OrgID, keyName)
+			setSessionPassword(&session)
+		case keyName == "":
+			keyName = keyGen.GenerateAuthKey(session.OrgID)
+		}
+		if session.HMACEnabled && session.HmacSecret == "" {
+			session.HmacSecret = keyGen.GenerateHMACSecret()
+		}
+		session.DateCreated = time.Now()
+
+		mw := BaseMiddleware{}
+		mw.ApplyPolicies(&session)
+		if err := doAddOrUpdate(keyName, &session, false, false); err != nil {
+			j.progress(func(j *keyJob) { j.Failed++ })
+			continue
+		}
+
+		FireSystemEvent(EventTokenCreated, EventTokenMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Key generated by a job."},
+			Org:              session.OrgID,
+			Key:              keyName,
+		})
+		j.progress(func(j *keyJob) {
+			j.Scanned++
+			j.Done++
+			j.Keys = append(j.Keys, keyName)
+		})
+	}
+	return nil
+}
+
+func (j *keyJob) update(store storage.Handler, batch storage.BatchHandler, filter *KeyFilter, update *KeyJobUpdate) error {
+	prefix := store.GetKeyPrefix()
+	_, err := scanSessions(batch, prefix, filter.pattern(store), filter, "0", keyJobBatchSize,
+		func(scanned int, names []string, sessions []*user.SessionState) bool {
+			values := make([]storage.KeyValue, 0, len(names))
+			for i, session := range sessions {
+				update.apply(session)
+				v, err := json.Marshal(session)
+				if err != nil {
+					continue
+				}
+				values = append(values, storage.KeyValue{
+					Key:   prefix + names[i],
+					Value: string(v),
+					TTL:   sessionLifetime(session),
+				})
+			}
+
+			err := batch.SetRawKeysPipelined(values)
+			if err == nil {
+				flushKeysCache(names)
+				for i, name := range names {
+					FireSystemEvent(EventTokenUpdated, EventTokenMeta{
+						EventMetaDefault: EventMetaDefault{Message: "Key modified by a job."},
+						Org:              sessions[i].OrgID,
+						Key:              name,
+					})
+				}
+			}
+			j.progress(func(j *keyJob) {
+				j.Scanned += scanned
+				j.Matched += len(names)
+				if err != nil {
+					j.Failed += len(names)
+				} else {
+					j.Done += len(values)
+					j.Failed += len(names) - len(values)
+				}
+			})
+			return true
+		})
+	return err
+}
+
+func (j *keyJob) delete(store storage.Handler, batch storage.BatchHandler, filter *KeyFilter) error {
+	prefix := store.GetKeyPrefix()
+	_, err := scanSessions(batch, prefix, filter.pattern(store), filter, "0", keyJobBatchSize,
+		func(scanned int, names []string, sessions []*user.SessionState) bool {
+			keys := make([]string, 0, len(names)*2)
+			for i, name := range names {
+				keys = append(keys, prefix+name, QuotaKeyPrefix+name)
+				for apiID := range sessions[i].AccessRights {
+					keys = append(keys, QuotaKeyPrefix+apiID+"-"+name)
+				}
+			}
+
+			ok := batch.DeleteRawKeys(keys)
+			if ok {
+				flushKeysCache(names)
+				for i, name := range names {
+					FireSystemEvent(EventTokenDeleted, EventTokenMeta{
+						EventMetaDefault: EventMetaDefault{Message: "Key deleted by a job."},
+						Org:              sessions[i].OrgID,
+						Key:              name,
+					})
+				}
+			}
+			j.progress(func(j *keyJob) {
+				j.Scanned += scanned
+				j.Matched += len(names)
+				if ok {
+					j.Done += len(names)
+				} else {
+					j.Failed += len(names)
+				}
+			})
+			return true
+		})
+	return err
+}
+
+// sessionLifetime is the TTL a session is saved with, from the APIs it has
+// access to.
+func sessionLifetime(session *user.SessionState) int64 {
+	var lifetime int64
+	for apiID := range session.AccessRights {
+		if spec := getApiSpec(apiID); spec != nil && spec.SessionLifetime > lifetime {
+			lifetime = spec.SessionLifetime
+		}
+	}
+	return session.Lifetime(lifetime)
+}
+
+// flushKeysCache drops keys, given as stored, from the session cache of
+// all gateways.
+func flushKeysCache(names []string) {
+	if len(names) == 0 {
+		return
+	}
+	payload := strings.Join(names, ",")
+	handleKeySpaceEventCacheFlush(payload)
+	MainNotifier.Notify(Notification{
+		Command: KeySpaceUpdateNotification,
+		Payload: payload,
+	})
+}
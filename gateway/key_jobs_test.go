@@ -0,0 +1,165 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestKeyJobs(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.Proxy.ListenPath = "/"
+	})
+
+	startJob := func(t *testing.T, req KeyJobRequest) string {
+		body, _ := json.Marshal(req)
+		resp, _ := ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/tyk/keys/jobs", Data: string(body), AdminAuth: true, Code: http.StatusOK})
+		var job keyJob
+		if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+			t.Fatal(err)
+		}
+		return job.ID
+	}
+	waitForJob := func(t *testing.T, id string) keyJob {
+		for i := 0; i < 100; i++ {
+			resp, _ := ts.Run(t, test.TestCase{Path: "/tyk/keys/jobs/" + id, AdminAuth: true, Code: http.StatusOK})
+			var job keyJob
+			if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+				t.Fatal(err)
+			}
+			if job.Status != "running" {
+				return job
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Fatal("Job didn't finish")
+		return keyJob{}
+	}
+	listKeys := func(t *testing.T, query string) []string {
+		var keys []string
+		cursor := "0"
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatal("Paging doesn't end")
+			}
+			resp, _ := ts.Run(t, test.TestCase{Path: "/tyk/keys?count=2&cursor=" + cursor + "&" + query, AdminAuth: true, Code: http.StatusOK})
+			var page apiKeysPage
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, page.APIKeys...)
+			if cursor = page.Cursor; cursor == "0" {
+				break
+			}
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	expires := time.Now().Unix() + 1000
+	var keys []KeyJobSession
+	for i := 0; i < 5; i++ {
+		session := CreateStandardSession()
+		session.OrgID = "key-jobs"
+		session.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+		session.MetaData = map[string]interface{}{"n": strconv.Itoa(i)}
+		session.Tags = []string{"even"}
+		if i%2 == 1 {
+			session.Tags = []string{"odd"}
+			session.Expires = expires
+		}
+		keys = append(keys, KeyJobSession{SessionState: *session})
+	}
+	keys[0].Key = "custom-job-key"
+
+	var created []string
+	t.Run("Create", func(t *testing.T) {
+		job := waitForJob(t, startJob(t, KeyJobRequest{Action: keyJobCreate, Keys: keys}))
+		if job.Status != "done" || job.Done != 5 || len(job.Keys) != 5 || job.Keys[0] != "custom-job-key" {
+			t.Fatalf("Unexpected job: %+v", job)
+		}
+		created = job.Keys
+		sort.Strings(created)
+
+		ts.Run(t, test.TestCase{Path: "/", Headers: map[string]string{"Authorization": created[0]}, Code: http.StatusOK})
+	})
+
+	t.Run("List pages", func(t *testing.T) {
+		if got := listKeys(t, "org_id=key-jobs"); len(got) != 5 || got[0] != created[0] {
+			t.Errorf("Expected all created keys, got %v", got)
+		}
+		if got := listKeys(t, "org_id=key-jobs&tag=odd"); len(got) != 2 {
+			t.Errorf("Expected the odd keys, got %v", got)
+		}
+		if got := listKeys(t, "org_id=key-jobs&meta_key=n&meta_value=0"); len(got) != 1 || got[0] != "custom-job-key" {
+			t.Errorf("Expected the custom key, got %v", got)
+		}
+		if got := listKeys(t, "org_id=key-jobs&expires_before="+strconv.FormatInt(expires+1, 10)); len(got) != 2 {
+			t.Errorf("Expected the expiring keys, got %v", got)
+		}
+		if got := listKeys(t, "org_id=key-jobs&expires_after="+strconv.FormatInt(expires+1, 10)); len(got) != 3 {
+			t.Errorf("Expected the keys that never expire, got %v", got)
+		}
+		if got := listKeys(t, "filter=custom-job&org_id=key-jobs"); len(got) != 1 {
+			t.Errorf("Expected the key with the prefix, got %v", got)
+		}
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/tyk/keys?count=0", AdminAuth: true, Code: http.StatusBadRequest},
+			{Path: "/tyk/keys?expires_after=soon", AdminAuth: true, Code: http.StatusBadRequest},
+		}...)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		inactive := true
+		job := waitForJob(t, startJob(t, KeyJobRequest{
+			Action: keyJobUpdate,
+			Filter: KeyFilter{OrgID: "key-jobs", Tag: "even"},
+			Update: KeyJobUpdate{IsInactive: &inactive},
+		}))
+		if job.Status != "done" || job.Matched != 3 || job.Done != 3 {
+			t.Fatalf("Unexpected job: %+v", job)
+		}
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: map[string]string{"Authorization": "custom-job-key"}, Code: http.StatusForbidden},
+			{Path: "/tyk/keys/custom-job-key", AdminAuth: true, Code: http.StatusOK, BodyMatch: `"is_inactive":true`},
+		}...)
+	})
+
+	t.Run("Invalid jobs", func(t *testing.T) {
+		ts.Run(t, []test.TestCase{
+			{Method: http.MethodPost, Path: "/tyk/keys/jobs", Data: `{"action": "delete"}`, AdminAuth: true, Code: http.StatusBadRequest},
+			{Method: http.MethodPost, Path: "/tyk/keys/jobs", Data: `{"action": "update", "filter": {"org_id": "key-jobs"}}`,
+				AdminAuth: true, Code: http.StatusBadRequest},
+			{Method: http.MethodPost, Path: "/tyk/keys/jobs", Data: `{"action": "create"}`, AdminAuth: true, Code: http.StatusBadRequest},
+			{Method: http.MethodPost, Path: "/tyk/keys/jobs", Data: `{"action": "archive"}`, AdminAuth: true, Code: http.StatusBadRequest},
+			{Path: "/tyk/keys/jobs/unknown", AdminAuth: true, Code: http.StatusNotFound},
+		}...)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		job := waitForJob(t, startJob(t, KeyJobRequest{Action: keyJobDelete, Filter: KeyFilter{OrgID: "key-jobs"}}))
+		if job.Status != "done" || job.Done != 5 {
+			t.Fatalf("Unexpected job: %+v", job)
+		}
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/tyk/keys/" + created[1], AdminAuth: true, Code: http.StatusNotFound},
+			{Path: "/tyk/keys/jobs", AdminAuth: true, Code: http.StatusOK, BodyMatch: `"action":"delete"`},
+		}...)
+		if got := listKeys(t, "org_id=key-jobs"); len(got) != 0 {
+			t.Errorf("Expected no keys, got %v", got)
+		}
+	})
+}
@@ -0,0 +1,321 @@
+package gateway
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/mux"
+
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/storage"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+// Secrets that can be rotated instead of the key.
+const (
+	rotateHMACSecret        = "hmac"
+	rotateBasicAuthPassword = "basic_auth"
+)
+
+// KeyRotationRequest is the optional body of a key rotation.
+//
+// swagger:model
+type KeyRotationRequest struct {
+	// GracePeriod is how long, in seconds, the old key or secret stays
+	// valid. Zero uses key_rotation_grace_period from the config.
+	GracePeriod int64 `json:"grace_period"`
+	// KeepCounters carries the used quota and the Redis rate limiter
+	// counters over to the new key, instead of starting afresh.
+	KeepCounters bool `json:"keep_counters"`
+	// NewKey is a custom name for the new key.
+	NewKey string `json:"new_key"`
+	// Secret rotates a secret of the key instead of the key itself, either
+	// "hmac" or "basic_auth".
+	Secret string `json:"secret"`
+	// Password is the new password when rotating "basic_auth".
+	Password string `json:"password"`
+}
+
+// apiRotateKeySuccess represents the result of a key rotation
+// swagger:model
+type apiRotateKeySuccess struct {
+	apiModifyKeySuccess
+	OldKey          string `json:"old_key"`
+	GracePeriodEnds int64  `json:"grace_period_ends"`
+	HmacSecret      string `json:"hmac_string,omitempty"`
+}
+
+func keyRotateHandler(w http.ResponseWriter, r *http.Request) {
+	keyName := mux.Vars(r)["keyName"]
+	apiID := r.URL.Query().Get("api_id")
+	isHashed := r.URL.Query().Get("hashed") != ""
+	if r.URL.Query().Get("username") == "true" {
+		keyName = generateToken(r.URL.Query().Get("org_id"), keyName)
+	}
+
+	var req KeyRotationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		doJSONWrite(w, http.StatusBadRequest, apiError("Request malformed"))
+		return
+	}
+
+	obj, code := handleRotateKey(keyName, apiID, isHashed, &req)
+	doJSONWrite(w, code, obj)
+}
+
+func handleRotateKey(keyName, apiID string, isHashed bool, req *KeyRotationRequest) (interface{}, int) {
+	if isHashed && !config.Global().HashKeys {
+		return apiError("Key requested by hash but key hashing is not enabled"), http.StatusBadRequest
+	}
+	if req.GracePeriod < 0 {
+		return apiError("Grace period can't be negative"), http.StatusBadRequest
+	}
+
+	sessionManager := FallbackKeySesionManager
+	var lifetime int64
+	spec := getApiSpec(apiID)
+	if spec != nil {
+		sessionManager = spec.SessionManager
+		lifetime = spec.SessionLifetime
+	}
+
+	session, ok := sessionManager.SessionDetail(keyName, isHashed)
+	if !ok {
+		return apiError("Key not found"), http.StatusNotFound
+	}
+
+	grace := req.GracePeriod
+	if grace == 0 {
+		grace = config.Global().KeyRotationGracePeriod
+	}
+
+	switch req.Secret {
+	case "":
+		return rotateKey(sessionManager, keyName, isHashed, &session, grace, req)
+	case rotateHMACSecret, rotateBasicAuthPassword:
+		return rotateSecret(sessionManager, keyName, isHashed, &session, session.Lifetime(lifetime), grace, req)
+	default:
+		return apiError("Unknown secret " + req.Secret), http.StatusBadRequest
+	}
+}
+
+// rotateKey copies the session to a new key. The old key expires at the end
+// of the grace period.
+func rotateKey(sessionManager SessionHandler, keyName string, isHashed bool, session *user.SessionState, grace int64, req *KeyRotationRequest) (interface{}, int) {
+	if session.BasicAuthData.Password != "" || session.Certificate != "" {
+		return apiError("Keys of basic auth users or certificates are named after them and can't be rotated"), http.StatusBadRequest
+	}
+
+	newKey := req.NewKey
+	if newKey == "" {
+		newKey = keyGen.GenerateAuthKey(session.OrgID)
+	} else if _, found := sessionManager.SessionDetail(newKey, false); found {
+		return apiError("Key " + newKey + " already exists"), http.StatusConflict
+	}
+
+	newSession := session.Clone()
+	newSession.DateCreated = time.Now()
+	newSession.RotatedSecrets.HmacSecret = ""
+	newSession.RotatedSecrets.BasicAuthPassword = ""
+	newSession.RotatedSecrets.Expires = 0
+	if newSession.HMACEnabled {
+		newSession.HmacSecret = keyGen.GenerateHMACSecret()
+	}
+	if !req.KeepCounters {
+		newSession.QuotaRemaining = newSession.QuotaMax
+		for _, access := range newSession.AccessRights {
+			if access.Limit != nil {
+				access.Limit.QuotaRemaining = access.Limit.QuotaMax
+			}
+		}
+	}
+
+	if err := doAddOrUpdate(newKey, &newSession, req.KeepCounters, false); err != nil {
+		return apiError("Failed to create key - " + err.Error()), http.StatusInternalServerError
+	}
+
+	oldKeyHash := keyName
+	if !isHashed {
+		oldKeyHash = storage.HashKey(keyName)
+	}
+	if req.KeepCounters {
+		copyLimitCounters(sessionManager.Store(), oldKeyHash, storage.HashKey(newKey), session)
+	}
+
+	graceEnds := time.Now().Unix() + grace
+	if grace > 0 {
+		if session.Expires <= 0 || session.Expires > graceEnds {
+			session.Expires = graceEnds
+		}
+		if err := sessionManager.UpdateSession(keyName, session, grace, isHashed); err != nil {
+			return apiError("Could not write key data"), http.StatusInternalServerError
+		}
+	}
+
+	orgID := session.OrgID
+	afterGracePeriod(grace, func() {
+		sessionManager.RemoveSession(keyName, isHashed)
+		sessionManager.ResetQuota(keyName, session, isHashed)
+		FireSystemEvent(EventKeyRotated, EventKeyRotatedMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Key rotated."},
+			Org:              orgID,
+			Key:              keyName,
+			NewKey:           newKey,
+		})
+	})
+
+	FireSystemEvent(EventTokenCreated, EventTokenMeta{
+		EventMetaDefault: EventMetaDefault{Message: "Key generated by rotation."},
+		Org:              orgID,
+		Key:              newKey,
+	})
+
+	log.WithFields(logrus.Fields{
+		"prefix":  "api",
+		"key":     obfuscateKey(keyName),
+		"new_key": obfuscateKey(newKey),
+		"org_id":  orgID,
+		"status":  "ok",
+	}).Info("Rotated key.")
+
+	obj := apiRotateKeySuccess{
+		apiModifyKeySuccess: apiModifyKeySuccess{
+			Key:    newKey,
+			Status: "ok",
+			Action: "rotated",
+		},
+		OldKey:          keyName,
+		GracePeriodEnds: graceEnds,
+		HmacSecret:      newSession.HmacSecret,
+	}
+	if config.Global().HashKeys {
+		obj.KeyHash = storage.HashKey(newKey)
+	}
+	return obj, http.StatusOK
+}
+
+// rotateSecret replaces the HMAC secret or the basic auth password of a key.
+// The old secret is still accepted until the end of the grace period.
+func rotateSecret(sessionManager SessionHandler, keyName string, isHashed bool, session *user.SessionState, lifetime, grace int64, req *KeyRotationRequest) (interface{}, int) {
+	if session.RotatedSecretsValid() {
+		return apiError("The grace period of the last rotation hasn't ended"), http.StatusConflict
+	}
+
+	graceEnds := time.Now().Unix() + grace
+	session.RotatedSecrets.HmacSecret = ""
+	session.RotatedSecrets.BasicAuthPassword = ""
+	session.RotatedSecrets.Expires = graceEnds
+
+	switch req.Secret {
+	case rotateHMACSecret:
+		if !session.HMACEnabled {
+			return apiError("HMAC is not enabled for the key"), http.StatusBadRequest
+		}
+		session.RotatedSecrets.HmacSecret = session.HmacSecret
+		session.HmacSecret = keyGen.GenerateHMACSecret()
+	case rotateBasicAuthPassword:
+		if session.BasicAuthData.Password == "" {
+			return apiError("The key has no basic auth password"), http.StatusBadRequest
+		}
+		if req.Password == "" {
+			return apiError("The new password is missing"), http.StatusBadRequest
+		}
+		session.RotatedSecrets.BasicAuthPassword = session.BasicAuthData.Password
+		session.RotatedSecrets.BasicAuthHash = session.BasicAuthData.Hash
+		session.BasicAuthData.Password = req.Password
+		setSessionPassword(session)
+	}
+	if grace == 0 {
+		session.RotatedSecrets.HmacSecret = ""
+		session.RotatedSecrets.BasicAuthPassword = ""
+	}
+
+	if err := sessionManager.UpdateSession(keyName, session, lifetime, isHashed); err != nil {
+		return apiError("Could not write key data"), http.StatusInternalServerError
+	}
+
+	orgID := session.OrgID
+	afterGracePeriod(grace, func() {
+		// drop the old secret, unless the key changed meanwhile
+		if current, ok := sessionManager.SessionDetail(keyName, isHashed); ok && current.RotatedSecrets.Expires == graceEnds {
+			current.RotatedSecrets.HmacSecret = ""
+			current.RotatedSecrets.BasicAuthPassword = ""
+			sessionManager.UpdateSession(keyName, &current, lifetime, isHashed)
+		}
+		FireSystemEvent(EventKeyRotated, EventKeyRotatedMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Key secret rotated."},
+			Org:              orgID,
+			Key:              keyName,
+			NewKey:           keyName,
+			Secret:           req.Secret,
+		})
+	})
+
+	log.WithFields(logrus.Fields{
+		"prefix": "api",
+		"key":    obfuscateKey(keyName),
+		"secret": req.Secret,
+		"org_id": orgID,
+		"status": "ok",
+	}).Info("Rotated key secret.")
+
+	obj := apiRotateKeySuccess{
+		apiModifyKeySuccess: apiModifyKeySuccess{
+			Key:    keyName,
+			Status: "ok",
+			Action: "rotated",
+		},
+		OldKey:          keyName,
+		GracePeriodEnds: graceEnds,
+	}
+	if req.Secret == rotateHMACSecret {
+		obj.HmacSecret = session.HmacSecret
+	}
+	return obj, http.StatusOK
+}
+
+// afterGracePeriod runs fn once the grace period of a rotation, in seconds,
+// has passed. The old key or secret is also rejected from then on if the
+// gateway restarts in between, but the event is then lost.
+func afterGracePeriod(grace int64, fn func()) {
+	if grace <= 0 {
+		fn()
+		return
+	}
+	time.AfterFunc(time.Duration(grace)*time.Second, fn)
+}
+
+// copyLimitCounters carries the quota and rate limit counters of a key
+// over to another one, both given hashed. The rolling window entries are
+// re-added with the current time, which can only make the limit stricter.
+func copyLimitCounters(store storage.Handler, from, to string, session *user.SessionState) {
+	now := time.Now().Unix()
+	copyCounters := func(prefix string, renews, renewalRate int64, per float64) {
+		if used, err := store.GetRawKey(QuotaKeyPrefix + prefix + from); err == nil {
+			ttl := renews - now
+			if ttl <= 0 {
+				ttl = renewalRate
+			}
+			store.SetRawKey(QuotaKeyPrefix+prefix+to, used, ttl)
+		}
+
+		rateLimiterKey := RateLimitKeyPrefix + prefix + from
+		count, _ := store.GetRollingWindow(rateLimiterKey, int64(per), false)
+		for i := 0; i < count; i++ {
+			store.SetRollingWindow(RateLimitKeyPrefix+prefix+to, int64(per), "-1", false)
+		}
+		if blocked, err := store.GetRawKey(rateLimiterKey + ".BLOCKED"); err == nil {
+			store.SetRawKey(RateLimitKeyPrefix+prefix+to+".BLOCKED", blocked, int64(per))
+		}
+	}
+
+	copyCounters("", session.QuotaRenews, session.QuotaRenewalRate, session.Per)
+	for apiID, access := range session.AccessRights {
+		if access.Limit != nil {
+			copyCounters(apiID+"-", access.Limit.QuotaRenews, access.Limit.QuotaRenewalRate, access.Limit.Per)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/storage"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func hmacHeadersForTest(t *testing.T, keyID, secret, path string) map[string]string {
+	signer, err := newHMACSigner(apidef.UpstreamHMAC{KeyID: keyID, Secret: secret})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	if err := signer.authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+	return map[string]string{
+		"Authorization": r.Header.Get("Authorization"),
+		"Date":          r.Header.Get("Date"),
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	events := make(chan EventKeyRotatedMeta, 10)
+	globalConf := config.Global()
+	globalConf.SetEventTriggers(map[apidef.TykEvent][]config.TykEventHandler{
+		EventKeyRotated: {&testEventHandler{func(em config.EventMessage) {
+			events <- em.Meta.(EventKeyRotatedMeta)
+		}}},
+	})
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	ts := StartTest()
+	defer ts.Close()
+
+	rotate := func(t *testing.T, path, body string) apiRotateKeySuccess {
+		resp, _ := ts.Run(t, test.TestCase{Method: http.MethodPost, Path: path, Data: body, AdminAuth: true, Code: http.StatusOK})
+		var obj apiRotateKeySuccess
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+	waitForRotation := func(t *testing.T, key string) EventKeyRotatedMeta {
+		select {
+		case meta := <-events:
+			if meta.Key != key {
+				t.Fatalf("Expected KeyRotated event for %s, got %+v", key, meta)
+			}
+			return meta
+		case <-time.After(3 * time.Second):
+			t.Fatal("KeyRotated event wasn't fired")
+		}
+		return EventKeyRotatedMeta{}
+	}
+
+	loadAPI := func(fn ...func(spec *APISpec)) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/"
+			for _, f := range fn {
+				f(spec)
+			}
+		})
+	}
+	withAccess := func(s *user.SessionState) {
+		s.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+		s.MetaData = map[string]interface{}{"team": "rotation"}
+	}
+	authHeader := func(key string) map[string]string {
+		return map[string]string{"Authorization": key}
+	}
+
+	t.Run("Key with grace period", func(t *testing.T) {
+		loadAPI()
+		oldKey := CreateSession(withAccess)
+
+		obj := rotate(t, "/tyk/keys/"+oldKey+"/rotate", `{"grace_period": 1}`)
+		if obj.Key == "" || obj.Key == oldKey || obj.OldKey != oldKey || obj.Action != "rotated" {
+			t.Fatalf("Unexpected rotation result: %+v", obj)
+		}
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(obj.Key), Code: http.StatusOK},
+			{Path: "/tyk/keys/" + obj.Key, AdminAuth: true, Code: http.StatusOK, BodyMatch: `"team":"rotation"`},
+		}...)
+
+		if meta := waitForRotation(t, oldKey); meta.NewKey != obj.Key {
+			t.Errorf("Expected the new key in the event, got %+v", meta)
+		}
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusForbidden},
+			{Path: "/", Headers: authHeader(obj.Key), Code: http.StatusOK},
+		}...)
+	})
+
+	t.Run("Custom key name without grace period", func(t *testing.T) {
+		loadAPI()
+		oldKey := CreateSession(withAccess)
+
+		obj := rotate(t, "/tyk/keys/"+oldKey+"/rotate", `{"new_key": "rotated-custom-key"}`)
+		defer FallbackKeySesionManager.RemoveSession("rotated-custom-key", false)
+		if obj.Key != "rotated-custom-key" {
+			t.Fatalf("Expected the custom key, got %+v", obj)
+		}
+		waitForRotation(t, oldKey)
+
+		otherKey := CreateSession(withAccess)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusForbidden},
+			{Path: "/", Headers: authHeader("rotated-custom-key"), Code: http.StatusOK},
+			{Method: http.MethodPost, Path: "/tyk/keys/" + otherKey + "/rotate", Data: `{"new_key": "rotated-custom-key"}`,
+				AdminAuth: true, Code: http.StatusConflict},
+			{Method: http.MethodPost, Path: "/tyk/keys/unknown/rotate", AdminAuth: true, Code: http.StatusNotFound},
+			{Method: http.MethodPost, Path: "/tyk/keys/" + otherKey + "/rotate", Data: `{"secret": "jwt"}`,
+				AdminAuth: true, Code: http.StatusBadRequest},
+		}...)
+	})
+
+	t.Run("Keep counters", func(t *testing.T) {
+		loadAPI()
+		withQuota := func(s *user.SessionState) {
+			withAccess(s)
+			s.QuotaMax = 3
+		}
+
+		oldKey := CreateSession(withQuota)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusOK},
+		}...)
+		kept := rotate(t, "/tyk/keys/"+oldKey+"/rotate", `{"keep_counters": true}`)
+		waitForRotation(t, oldKey)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(kept.Key), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(kept.Key), Code: http.StatusForbidden},
+		}...)
+
+		oldKey = CreateSession(withQuota)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusOK},
+		}...)
+		reset := rotate(t, "/tyk/keys/"+oldKey+"/rotate", ``)
+		waitForRotation(t, oldKey)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(reset.Key), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(reset.Key), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(reset.Key), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(reset.Key), Code: http.StatusForbidden},
+		}...)
+	})
+
+	t.Run("Hashed key", func(t *testing.T) {
+		globalConf := config.Global()
+		globalConf.HashKeys = true
+		config.SetGlobal(globalConf)
+		defer func() {
+			globalConf.HashKeys = false
+			config.SetGlobal(globalConf)
+		}()
+
+		loadAPI()
+		oldKey := CreateSession(withAccess)
+		oldKeyHash := storage.HashKey(oldKey)
+
+		obj := rotate(t, "/tyk/keys/"+oldKeyHash+"/rotate?hashed=1", `{"grace_period": 1}`)
+		if obj.KeyHash != storage.HashKey(obj.Key) {
+			t.Fatalf("Expected the hash of the new key, got %+v", obj)
+		}
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusOK},
+			{Path: "/", Headers: authHeader(obj.Key), Code: http.StatusOK},
+		}...)
+		waitForRotation(t, oldKeyHash)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: authHeader(oldKey), Code: http.StatusForbidden},
+			{Path: "/", Headers: authHeader(obj.Key), Code: http.StatusOK},
+		}...)
+	})
+
+	t.Run("HMAC secret", func(t *testing.T) {
+		loadAPI(func(spec *APISpec) {
+			spec.EnableSignatureChecking = true
+			spec.Auth.AuthHeaderName = "authorization"
+		})
+		key := CreateSession(func(s *user.SessionState) {
+			withAccess(s)
+			s.HMACEnabled = true
+			s.HmacSecret = "old-secret"
+		})
+
+		obj := rotate(t, "/tyk/keys/"+key+"/rotate", `{"secret": "hmac", "grace_period": 1}`)
+		if obj.Key != key || obj.HmacSecret == "" || obj.HmacSecret == "old-secret" {
+			t.Fatalf("Expected a new secret for the same key, got %+v", obj)
+		}
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: hmacHeadersForTest(t, key, "old-secret", "/"), Code: http.StatusOK},
+			{Path: "/", Headers: hmacHeadersForTest(t, key, obj.HmacSecret, "/"), Code: http.StatusOK},
+			{Method: http.MethodPost, Path: "/tyk/keys/" + key + "/rotate", Data: `{"secret": "hmac"}`,
+				AdminAuth: true, Code: http.StatusConflict},
+		}...)
+
+		if meta := waitForRotation(t, key); meta.Secret != "hmac" {
+			t.Errorf("Expected the rotated secret in the event, got %+v", meta)
+		}
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: hmacHeadersForTest(t, key, "old-secret", "/"), Code: http.StatusBadRequest},
+			{Path: "/", Headers: hmacHeadersForTest(t, key, obj.HmacSecret, "/"), Code: http.StatusOK},
+		}...)
+	})
+
+	t.Run("Basic auth password", func(t *testing.T) {
+		session := testPrepareBasicAuth(false)
+		ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/tyk/keys/rotating-user", Data: session, AdminAuth: true, Code: http.StatusOK})
+		key := generateToken("default", "rotating-user")
+
+		ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/tyk/keys/" + key + "/rotate",
+			AdminAuth: true, Code: http.StatusBadRequest, BodyMatch: "can't be rotated"})
+		rotate(t, "/tyk/keys/rotating-user/rotate?username=true&org_id=default",
+			`{"secret": "basic_auth", "password": "new-password", "grace_period": 2}`)
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: map[string]string{"Authorization": genAuthHeader("rotating-user", "password")}, Code: http.StatusOK},
+			{Path: "/", Headers: map[string]string{"Authorization": genAuthHeader("rotating-user", "new-password")}, Code: http.StatusOK},
+			{Path: "/", Headers: map[string]string{"Authorization": genAuthHeader("rotating-user", "wrong")}, Code: http.StatusUnauthorized},
+		}...)
+
+		waitForRotation(t, key)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: map[string]string{"Authorization": genAuthHeader("rotating-user", "password")}, Code: http.StatusUnauthorized},
+			{Path: "/", Headers: map[string]string{"Authorization": genAuthHeader("rotating-user", "new-password")}, Code: http.StatusOK},
+		}...)
+	})
+}
@@ -34,6 +34,9 @@ func getLogEntryForRequest(logger *logrus.Entry, r *http.Request, key string, da
 		"path":   r.URL.Path,
 		"origin": request.RealIP(r),
 	}
+	if id := ctxGetRequestID(r); id != "" {
+		fields["request_id"] = id
+	}
 	// add key to log if configured to do so
 	if key != "" {
 		fields["key"] = key
@@ -18,6 +18,7 @@ import (
 
 	"github.com/ins-tykgw/tyk/apidef"
 	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/coprocess"
 	"github.com/ins-tykgw/tyk/request"
 	"github.com/ins-tykgw/tyk/storage"
 	"github.com/ins-tykgw/tyk/trace"
@@ -122,8 +123,12 @@ func createMiddleware(actualMW TykMiddleware) func(http.Handler) http.Handler {
 				// but we still want to record error
 				_, isGoPlugin := actualMW.(*GoPluginMiddleware)
 
+				if _, ok := err.(*reasonError); !ok && isAuthMiddleware(actualMW) && errCode != http.StatusInternalServerError {
+					err = errorWithReason(ErrorReasonAuthFailed, err)
+				}
+
 				handler := ErrorHandler{*mw.Base()}
-				handler.HandleError(w, r, err.Error(), errCode, !isGoPlugin)
+				handler.handleError(w, r, err, errCode, !isGoPlugin)
 
 				meta["error"] = err.Error()
 
@@ -159,6 +164,22 @@ func createMiddleware(actualMW TykMiddleware) func(http.Handler) http.Handler {
 	}
 }
 
+// isAuthMiddleware reports whether mw authenticates requests, so that its
+// errors are reported as failed authentication.
+func isAuthMiddleware(mw TykMiddleware) bool {
+	switch x := mw.(type) {
+	case *AuthKey, *BasicAuthKeyIsValid, *HMACMiddleware, *JWTMiddleware,
+		*OpenIDMW, *Oauth2KeyExists, *ExternalOAuthMiddleware, *OIDCLoginMiddleware,
+		*AuthCompositionMiddleware, *KeyExpired, *AccessRightsCheck:
+		return true
+	case *DynamicMiddleware:
+		return x.Auth
+	case *CoProcessMiddleware:
+		return x.HookType == coprocess.HookType_CustomKeyCheck
+	}
+	return false
+}
+
 func mwAppendEnabled(chain *[]alice.Constructor, mw TykMiddleware) bool {
 	if mw.EnabledForSpec() {
 		*chain = append(*chain, createMiddleware(mw))
@@ -557,6 +578,8 @@ func responseProcessorByName(name string) TykResponseHandler {
 		return &ResponseTransformJQMiddleware{}
 	case "header_transform":
 		return &HeaderTransform{}
+	case "response_body_convert":
+		return &ResponseConvertBodyMiddleware{}
 	}
 	return nil
 }
@@ -57,7 +57,7 @@ func (k *RateLimitForAPI) handleRateLimitFailure(r *http.Request, token string)
 	// Report in health check
 	reportHealthValue(k.Spec, Throttle, "-1")
 
-	return errors.New("API Rate limit exceeded"), http.StatusTooManyRequests
+	return errorWithReason(ErrorReasonRateLimited, errors.New("API Rate limit exceeded")), http.StatusTooManyRequests
 }
 
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
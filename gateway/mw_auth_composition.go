@@ -0,0 +1,194 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/justinas/alice"
+
+	"github.com/ins-tykgw/tyk/apidef"
+)
+
+// authChain collects the auth middlewares of an API. They're chained, so
+// that all of them must pass, unless the API has an auth composition, in
+// which case they become the methods of an AuthCompositionMiddleware.
+type authChain struct {
+	array   []alice.Constructor
+	methods map[apidef.AuthTypeEnum][]TykMiddleware
+}
+
+func newAuthChain(spec *APISpec) *authChain {
+	c := &authChain{}
+	if spec.AuthComposition.Enabled {
+		c.methods = make(map[apidef.AuthTypeEnum][]TykMiddleware)
+	}
+	return c
+}
+
+func (c *authChain) appendEnabled(method apidef.AuthTypeEnum, mw TykMiddleware) bool {
+	if !mw.EnabledForSpec() {
+		return false
+	}
+	c.append(method, mw)
+	return true
+}
+
+func (c *authChain) append(method apidef.AuthTypeEnum, mw TykMiddleware) {
+	if c.composed() {
+		c.methods[method] = append(c.methods[method], mw)
+		return
+	}
+	c.array = append(c.array, createMiddleware(mw))
+}
+
+func (c *authChain) empty() bool {
+	return len(c.array) == 0 && len(c.methods) == 0
+}
+
+func (c *authChain) composed() bool {
+	return c.methods != nil
+}
+
+// unlisted returns the enabled methods no group of the auth composition
+// lists. Their checks would be dropped, so the API can't be loaded.
+func (c *authChain) unlisted(spec *APISpec) []apidef.AuthTypeEnum {
+	var unlisted []apidef.AuthTypeEnum
+	for method := range c.methods {
+		listed := false
+		for _, group := range spec.AuthComposition.Groups {
+			for _, m := range group.Methods {
+				listed = listed || m == method
+			}
+		}
+		if !listed {
+			unlisted = append(unlisted, method)
+		}
+	}
+	sort.Slice(unlisted, func(i, j int) bool { return unlisted[i] < unlisted[j] })
+	return unlisted
+}
+
+func (c *authChain) constructors(baseMid BaseMiddleware) []alice.Constructor {
+	if !c.composed() {
+		return c.array
+	}
+	composition := &AuthCompositionMiddleware{BaseMiddleware: baseMid, methods: c.methods}
+	return append([]alice.Constructor{createMiddleware(composition)}, c.array...)
+}
+
+type composedAuth struct {
+	mw   TykMiddleware
+	conf interface{}
+}
+
+// AuthCompositionMiddleware authenticates requests by the groups of auth
+// methods of the API, trying the groups in order until one passes.
+type AuthCompositionMiddleware struct {
+	BaseMiddleware
+	methods map[apidef.AuthTypeEnum][]TykMiddleware
+	auths   map[apidef.AuthTypeEnum][]composedAuth
+}
+
+func (k *AuthCompositionMiddleware) Name() string {
+	return "AuthCompositionMiddleware"
+}
+
+func (k *AuthCompositionMiddleware) EnabledForSpec() bool {
+	return k.Spec.AuthComposition.Enabled
+}
+
+func (k *AuthCompositionMiddleware) Init() {
+	for _, group := range k.Spec.AuthComposition.Groups {
+		for _, method := range group.Methods {
+			if _, ok := k.methods[method]; !ok {
+				k.Logger().Warning("Auth method ", method, " is composed but not enabled")
+			}
+		}
+	}
+
+	k.auths = make(map[apidef.AuthTypeEnum][]composedAuth)
+	for method, mws := range k.methods {
+		for _, actualMW := range mws {
+			mw := &TraceMiddleware{TykMiddleware: actualMW}
+			mw.Init()
+			mw.SetName(mw.Name())
+			conf, err := mw.Config()
+			if err != nil {
+				mw.Logger().Fatal("[Middleware] Configuration load failed")
+			}
+			k.auths[method] = append(k.auths[method], composedAuth{mw: mw, conf: conf})
+		}
+	}
+}
+
+func (k *AuthCompositionMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	groups := k.Spec.AuthComposition.Groups
+	if len(groups) == 0 {
+		return errors.New("Authentication failed: no auth methods configured"), http.StatusUnauthorized
+	}
+
+	initial := r.Context()
+	initialHeader := cloneHeader(w.Header())
+	var tried []string
+	var code int
+	for _, group := range groups {
+		// start each group from scratch, without the session or the
+		// response headers of a group that failed halfway
+		setContext(r, initial)
+		resetHeader(w.Header(), initialHeader)
+
+		var err error
+		if err, code = k.checkGroup(w, r, group); err == nil {
+			return nil, code
+		}
+		tried = append(tried, err.Error())
+	}
+	return errors.New("Authentication failed, tried: " + strings.Join(tried, "; ")), code
+}
+
+// resetHeader restores h to initial.
+func resetHeader(h, initial http.Header) {
+	for name := range h {
+		if _, ok := initial[name]; !ok {
+			delete(h, name)
+		}
+	}
+	for name, values := range initial {
+		h[name] = append([]string(nil), values...)
+	}
+}
+
+// checkGroup runs the methods of group, stopping at the first that fails.
+// The error names the methods of the group and why it failed.
+func (k *AuthCompositionMiddleware) checkGroup(w http.ResponseWriter, r *http.Request, group apidef.AuthGroup) (error, int) {
+	names := make([]string, len(group.Methods))
+	for i, method := range group.Methods {
+		names[i] = string(method)
+	}
+	tried := strings.Join(names, " and ")
+
+	for _, method := range group.Methods {
+		auths, ok := k.auths[method]
+		if !ok {
+			return fmt.Errorf("%s (%s is not enabled)", tried, method), http.StatusUnauthorized
+		}
+		for _, auth := range auths {
+			auth.mw.SetRequestLogger(r)
+			err, code := auth.mw.ProcessRequest(w, r, auth.conf)
+			if err != nil {
+				return fmt.Errorf("%s (%s: %v)", tried, method, err), code
+			}
+			if code == mwStatusRespond {
+				return nil, code
+			}
+		}
+	}
+
+	if ctxGetSession(r) == nil {
+		return fmt.Errorf("%s (no session)", tried), http.StatusUnauthorized
+	}
+	return nil, http.StatusOK
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"net/http"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestAuthComposition(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	policyID := CreatePolicy(func(p *user.Policy) {
+		p.OrgID = "default"
+		p.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+	})
+	_, key := ts.CreateSession(func(s *user.SessionState) {
+		s.OrgID = "default"
+		s.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+	})
+	basicSession := CreateStandardSession()
+	basicSession.BasicAuthData.Password = "password"
+	basicSession.OrgID = "default"
+	basicSession.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+
+	jwtToken := CreateJWKToken(func(t *jwt.Token) {
+		t.Claims.(jwt.MapClaims)["user_id"] = "user"
+		t.Claims.(jwt.MapClaims)["exp"] = time.Now().Add(time.Hour).Unix()
+	})
+
+	loadAPI := func(groups ...[]apidef.AuthTypeEnum) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/"
+			spec.OrgID = "default"
+			spec.Auth.AuthHeaderName = "X-Api-Key"
+			spec.JWTSigningMethod = RSASign
+			spec.JWTSource = base64.StdEncoding.EncodeToString([]byte(jwtRSAPubKey))
+			spec.JWTIdentityBaseField = "user_id"
+			spec.JWTDefaultPolicies = []string{policyID}
+			spec.ClientCertificates = []string{"unknown"}
+			spec.AuthComposition.Enabled = true
+			for _, methods := range groups {
+				spec.AuthComposition.Groups = append(spec.AuthComposition.Groups, apidef.AuthGroup{Methods: methods})
+				// enable the methods listed, but for HMAC
+				for _, method := range methods {
+					switch method {
+					case apidef.AuthToken:
+						spec.UseStandardAuth = true
+					case apidef.BasicAuthUser:
+						spec.UseBasicAuth = true
+					case apidef.JWTClaim:
+						spec.EnableJWT = true
+					case apidef.MutualTLS:
+						spec.UseMutualTLSAuth = true
+					}
+				}
+			}
+		})
+	}
+
+	// the JWT and the key share the auth header, basic auth has its own
+	withJWT := map[string]string{"X-Api-Key": jwtToken}
+	withKeyAndBasic := map[string]string{"X-Api-Key": key, "Authorization": genAuthHeader("user", "password")}
+
+	t.Run("JWT OR API key AND basic auth", func(t *testing.T) {
+		loadAPI([]apidef.AuthTypeEnum{apidef.JWTClaim}, []apidef.AuthTypeEnum{apidef.AuthToken, apidef.BasicAuthUser})
+
+		ts.Run(t, []test.TestCase{
+			{Method: http.MethodPost, Path: "/tyk/keys/defaultuser", Data: basicSession, AdminAuth: true, Code: http.StatusOK},
+			{Path: "/", Headers: withJWT, Code: http.StatusOK},
+			{Path: "/", Headers: withKeyAndBasic, Code: http.StatusOK},
+			{Path: "/", Headers: map[string]string{"X-Api-Key": key}, Code: http.StatusUnauthorized,
+				BodyMatch: `tried: jwt_claim (jwt_claim: Key not authorized`},
+			{Path: "/", Headers: map[string]string{"X-Api-Key": key}, Code: http.StatusUnauthorized,
+				HeadersMatch: map[string]string{"WWW-Authenticate": `Basic realm=""`}},
+			{Path: "/", Headers: map[string]string{"X-Api-Key": key}, Code: http.StatusUnauthorized,
+				BodyMatch: `auth_token and basic_auth_user (basic_auth_user: Authorization field missing)`},
+			{Path: "/", Headers: map[string]string{"Authorization": genAuthHeader("user", "password")}, Code: http.StatusUnauthorized,
+				BodyMatch: `auth_token and basic_auth_user (auth_token: Authorization field missing)`},
+		}...)
+	})
+
+	t.Run("Mutual TLS OR JWT", func(t *testing.T) {
+		loadAPI([]apidef.AuthTypeEnum{apidef.MutualTLS, apidef.AuthToken}, []apidef.AuthTypeEnum{apidef.JWTClaim})
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: withJWT, Code: http.StatusOK},
+			{Path: "/", Headers: map[string]string{"X-Api-Key": key}, Code: http.StatusForbidden,
+				BodyMatch: `tried: mutual_tls and auth_token (mutual_tls: TLS not enabled); jwt_claim (jwt_claim: `},
+		}...)
+	})
+
+	t.Run("Headers of failed groups", func(t *testing.T) {
+		loadAPI([]apidef.AuthTypeEnum{apidef.BasicAuthUser}, []apidef.AuthTypeEnum{apidef.JWTClaim})
+
+		resp, _ := ts.Run(t, test.TestCase{Path: "/", Headers: withJWT, Code: http.StatusOK})
+		if challenge := resp.Header.Get("WWW-Authenticate"); challenge != "" {
+			t.Errorf("Expected no basic auth challenge once JWT passed, got %q", challenge)
+		}
+	})
+
+	t.Run("Methods not enabled", func(t *testing.T) {
+		loadAPI([]apidef.AuthTypeEnum{apidef.HMACKey}, []apidef.AuthTypeEnum{apidef.MutualTLS})
+
+		ts.Run(t, test.TestCase{Path: "/", Headers: withKeyAndBasic, Code: http.StatusForbidden,
+			BodyMatch: `tried: hmac_key (hmac_key is not enabled); mutual_tls (mutual_tls: `})
+	})
+
+	t.Run("Methods not listed", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/"
+			spec.UseBasicAuth = true
+			spec.EnableJWT = true
+			spec.AuthComposition.Enabled = true
+			spec.AuthComposition.Groups = []apidef.AuthGroup{{Methods: []apidef.AuthTypeEnum{apidef.JWTClaim}}}
+		})
+
+		// basic auth would go unchecked, so the API isn't loaded
+		ts.Run(t, test.TestCase{Path: "/", Headers: withKeyAndBasic, Code: http.StatusNotFound})
+	})
+}
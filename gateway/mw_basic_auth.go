@@ -188,14 +188,11 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 		}
 	}
 
-	switch session.BasicAuthData.Hash {
-	case user.HashBCrypt:
-		if err := k.compareHashAndPassword(session.BasicAuthData.Password, password, logger); err != nil {
-			logger.Warn("Attempted access with existing user, failed password check.")
-			return k.handleAuthFail(w, r, token)
-		}
-	case user.HashPlainText:
-		if session.BasicAuthData.Password != password {
+	if err := k.checkPassword(session.BasicAuthData.Hash, session.BasicAuthData.Password, password, logger); err != nil {
+		// the password replaced by a rotation is accepted during the grace period
+		rotated := session.RotatedSecrets
+		if rotated.BasicAuthPassword == "" || !session.RotatedSecretsValid() ||
+			k.checkPassword(rotated.BasicAuthHash, rotated.BasicAuthPassword, password, logger) != nil {
 			logger.Warn("Attempted access with existing user, failed password check.")
 			return k.handleAuthFail(w, r, token)
 		}
@@ -210,6 +207,18 @@ func (k *BasicAuthKeyIsValid) ProcessRequest(w http.ResponseWriter, r *http.Requ
 	return nil, http.StatusOK
 }
 
+func (k *BasicAuthKeyIsValid) checkPassword(hash user.HashType, stored, password string, logger *logrus.Entry) error {
+	switch hash {
+	case user.HashBCrypt:
+		return k.compareHashAndPassword(stored, password, logger)
+	case user.HashPlainText:
+		if stored != password {
+			return errors.New("password doesn't match")
+		}
+	}
+	return nil
+}
+
 func (k *BasicAuthKeyIsValid) handleAuthFail(w http.ResponseWriter, r *http.Request, token string) (error, int) {
 
 	// Fire Authfailed Event
@@ -23,7 +23,12 @@ func (m *MiddlewareContextVars) EnabledForSpec() bool {
 
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
 func (m *MiddlewareContextVars) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	ctxSetData(r, contextVarsData(r))
+	return nil, http.StatusOK
+}
 
+// contextVarsData returns the context variables of a request.
+func contextVarsData(r *http.Request) map[string]interface{} {
 	parseForm(r)
 
 	contextDataObject := map[string]interface{}{
@@ -33,7 +38,10 @@ func (m *MiddlewareContextVars) ProcessRequest(w http.ResponseWriter, r *http.Re
 		"path_parts":   strings.Split(r.URL.Path, "/"), // Path parts
 		"path":         r.URL.Path,                     // path data
 		"remote_addr":  request.RealIP(r),              // IP
-		"request_id":   uuid.NewV4().String(),          //Correlation ID
+		"request_id":   ctxGetRequestID(r),             //Correlation ID
+	}
+	if contextDataObject["request_id"] == "" {
+		contextDataObject["request_id"] = uuid.NewV4().String()
 	}
 
 	for hname, vals := range r.Header {
@@ -46,7 +54,5 @@ func (m *MiddlewareContextVars) ProcessRequest(w http.ResponseWriter, r *http.Re
 		contextDataObject[name] = c.Value
 	}
 
-	ctxSetData(r, contextDataObject)
-
-	return nil, http.StatusOK
+	return contextDataObject
 }
@@ -0,0 +1,70 @@
+package gateway
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+// maxConvertBodySize is the largest body converted when the API version has
+// no global size limit.
+const maxConvertBodySize = 10 << 20
+
+// ConvertBodyMiddleware converts request bodies between JSON, XML, form
+// and protobuf encodings before they are sent upstream.
+type ConvertBodyMiddleware struct {
+	BaseMiddleware
+}
+
+func (c *ConvertBodyMiddleware) Name() string {
+	return "ConvertBodyMiddleware"
+}
+
+func (c *ConvertBodyMiddleware) EnabledForSpec() bool {
+	for _, version := range c.Spec.VersionData.Versions {
+		if len(version.ExtendedPaths.ConvertBody) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConvertBodyMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	vInfo, versionPaths, _, _ := c.Spec.Version(r)
+	found, meta := c.Spec.CheckSpecMatchesStatus(r, versionPaths, BodyConverted)
+	if !found {
+		return nil, http.StatusOK
+	}
+
+	limit := int64(maxConvertBodySize)
+	if vInfo.GlobalSizeLimit > 0 {
+		limit = vInfo.GlobalSizeLimit
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, limit+1))
+	r.Body.Close()
+	if err != nil {
+		return err, http.StatusBadRequest
+	}
+	if int64(len(body)) > limit {
+		return errorWithReason(ErrorReasonBodyTooLarge, errors.New("Request is too large")), http.StatusRequestEntityTooLarge
+	}
+
+	body, contentType, err := meta.(*bodyConverter).convert(body)
+	if err != nil {
+		c.Logger().WithError(err).Debug("Body conversion failure")
+		return errorWithReason(ErrorReasonValidationFailed, err), http.StatusBadRequest
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	r.ContentLength = int64(len(body))
+	r.Header.Set(headers.ContentLength, strconv.Itoa(len(body)))
+	if contentType != "" {
+		r.Header.Set(headers.ContentType, contentType)
+	}
+	return nil, http.StatusOK
+}
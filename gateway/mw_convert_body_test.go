@@ -0,0 +1,290 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func TestConvertBodyRequest(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.ConvertBody = []apidef.ConvertBodyMeta{
+				{
+					Path:   "/to-xml",
+					Method: http.MethodPost,
+					From:   apidef.BodyJSON,
+					To:     apidef.BodyXML,
+					XML: apidef.XMLConversionOptions{
+						RootElement:     "order",
+						Namespaces:      map[string]string{"ns": "urn:acme"},
+						NamespacePrefix: "ns",
+						AttributePrefix: "@",
+					},
+				},
+				{
+					Path:   "/from-xml",
+					Method: http.MethodPost,
+					From:   apidef.BodyXML,
+					To:     apidef.BodyJSON,
+					XML: apidef.XMLConversionOptions{
+						ArrayPaths: []string{"order.item"},
+						CastValues: true,
+					},
+				},
+				{
+					Path:   "/to-form",
+					Method: http.MethodPost,
+					From:   apidef.BodyJSON,
+					To:     apidef.BodyForm,
+				},
+				{
+					Path:   "/from-form",
+					Method: http.MethodPost,
+					From:   apidef.BodyForm,
+					To:     apidef.BodyJSON,
+				},
+			}
+		})
+	})
+
+	ts.Run(t, []test.TestCase{
+		{
+			Method: http.MethodPost, Path: "/to-xml",
+			Data:          `{"@id": "7", "item": ["a<b", "c"]}`,
+			Code:          http.StatusOK,
+			BodyMatchFunc: upstreamBodyContains(`<ns:order`, `id="7"`, `xmlns:ns="urn:acme"`, `<ns:item>a&lt;b</ns:item><ns:item>c</ns:item></ns:order>`),
+		},
+		{
+			Method: http.MethodPost, Path: "/to-xml",
+			Data: `not json`,
+			Code: http.StatusBadRequest,
+		},
+		{
+			Method: http.MethodPost, Path: "/from-xml",
+			Data:          `<order id="7"><item>3</item></order>`,
+			Code:          http.StatusOK,
+			BodyMatchFunc: upstreamBodyContains(`{"order":{"-id":7,"item":[3]}}`),
+		},
+		{
+			Method: http.MethodPost, Path: "/to-form",
+			Data:      `{"a": {"b": "1"}}`,
+			Code:      http.StatusOK,
+			BodyMatch: `"Form":{"a[b]":"1"}`,
+		},
+		{
+			Method: http.MethodPost, Path: "/from-form",
+			Data:          `a[b]=1&c[]=2&c[]=3`,
+			Code:          http.StatusOK,
+			BodyMatchFunc: upstreamBodyContains(`{"a":{"b":"1"},"c":["2","3"]}`),
+		},
+		{
+			Method: http.MethodPost, Path: "/to-xml",
+			Data: `"` + strings.Repeat("a", maxConvertBodySize) + `"`,
+			Code: http.StatusRequestEntityTooLarge,
+		},
+		// other methods are left alone
+		{
+			Method: http.MethodPut, Path: "/to-xml",
+			Data:      `not json`,
+			Code:      http.StatusOK,
+			BodyMatch: `"Body":"not json"`,
+		},
+	}...)
+}
+
+// upstreamBodyContains checks the request body echoed by the test upstream.
+func upstreamBodyContains(parts ...string) func([]byte) bool {
+	return func(b []byte) bool {
+		var echo struct{ Body string }
+		if err := json.Unmarshal(b, &echo); err != nil {
+			return false
+		}
+		for _, part := range parts {
+			if !strings.Contains(echo.Body, part) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+func TestConvertBodyResponse(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/xml")
+		w.Write([]byte(`<pets><pet name="Rex"/></pets>`))
+	}))
+	defer upstream.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.TargetURL = upstream.URL
+		spec.ResponseProcessors = []apidef.ResponseProcessor{{Name: "response_body_convert"}}
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.ConvertBodyResponse = []apidef.ConvertBodyMeta{{
+				Path:   "/pets",
+				Method: http.MethodGet,
+				From:   apidef.BodyXML,
+				To:     apidef.BodyJSON,
+				XML: apidef.XMLConversionOptions{
+					AttributePrefix: "@",
+					ArrayPaths:      []string{"pets.pet"},
+				},
+			}}
+		})
+	})
+
+	ts.Run(t, []test.TestCase{
+		{
+			Path: "/pets", Code: http.StatusOK, BodyMatch: `{"pets":{"pet":[{"@name":"Rex"}]}}`,
+			HeadersMatch: map[string]string{"Content-Type": "application/json"},
+		},
+		{Path: "/other", Code: http.StatusOK, BodyMatch: "<pets>"},
+	}...)
+}
+
+// testOrderDescriptorSet is a FileDescriptorSet for:
+//
+//	package acme;
+//	message Order {
+//	  enum Status { NEW = 0; PAID = 1; }
+//	  message Line { string sku = 1; sint32 qty = 2; }
+//	  string id = 1;
+//	  int64 total_cents = 2;
+//	  repeated int32 codes = 3;
+//	  Status status = 4;
+//	  repeated Line lines = 5;
+//	}
+func testOrderDescriptorSet() []byte {
+	message := func(fields ...[]byte) []byte {
+		var b []byte
+		for _, f := range fields {
+			b = append(b, f...)
+		}
+		return b
+	}
+	str := func(num uint64, s string) []byte {
+		return protoAppendBytes(protoAppendTag(nil, num, protoWireBytes), []byte(s))
+	}
+	sub := func(num uint64, b []byte) []byte {
+		return protoAppendBytes(protoAppendTag(nil, num, protoWireBytes), b)
+	}
+	varint := func(num, x uint64) []byte {
+		return protoAppendVarint(protoAppendTag(nil, num, protoWireVarint), x)
+	}
+	field := func(name string, num uint64, typ int, repeated bool, typeName string) []byte {
+		label := uint64(1)
+		if repeated {
+			label = protoLabelRepeated
+		}
+		b := message(str(1, name), varint(3, num), varint(4, label), varint(5, uint64(typ)))
+		if typeName != "" {
+			b = append(b, str(6, typeName)...)
+		}
+		return b
+	}
+
+	status := message(
+		str(1, "Status"),
+		sub(2, message(str(1, "NEW"), varint(2, 0))),
+		sub(2, message(str(1, "PAID"), varint(2, 1))),
+	)
+	line := message(
+		str(1, "Line"),
+		sub(2, field("sku", 1, protoTypeString, false, "")),
+		sub(2, field("qty", 2, protoTypeSint32, false, "")),
+	)
+	order := message(
+		str(1, "Order"),
+		sub(2, field("id", 1, protoTypeString, false, "")),
+		sub(2, field("total_cents", 2, protoTypeInt64, false, "")),
+		sub(2, field("codes", 3, protoTypeInt32, true, "")),
+		sub(2, field("status", 4, protoTypeEnum, false, ".acme.Order.Status")),
+		sub(2, field("lines", 5, protoTypeMessage, true, ".acme.Order.Line")),
+		sub(3, line),
+		sub(4, status),
+	)
+	file := message(str(1, "order.proto"), str(2, "acme"), sub(4, order))
+	return sub(1, file)
+}
+
+func TestConvertBodyProtobuf(t *testing.T) {
+	set := base64.StdEncoding.EncodeToString(testOrderDescriptorSet())
+	toProto, err := compileBodyConverter(apidef.ConvertBodyMeta{
+		From:     apidef.BodyJSON,
+		To:       apidef.BodyProtobuf,
+		Protobuf: apidef.ProtobufConversionOptions{DescriptorSet: set, Message: "acme.Order"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromProto, err := compileBodyConverter(apidef.ConvertBodyMeta{
+		From:     apidef.BodyProtobuf,
+		To:       apidef.BodyJSON,
+		Protobuf: apidef.ProtobufConversionOptions{DescriptorSet: set, Message: "acme.Order"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := `{"id":"o-1","totalCents":"12345678901","codes":[1,2,3],"status":"PAID","lines":[{"sku":"x","qty":-2}]}`
+	wire, contentType, err := toProto.convert([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if contentType != "application/x-protobuf" {
+		t.Errorf("wrong content type %q", contentType)
+	}
+
+	out, _, err := fromProto.convert(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var want, got interface{}
+	json.Unmarshal([]byte(in), &want)
+	json.Unmarshal(out, &got)
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("round trip mismatch:\nwant %s\ngot  %s", in, out)
+	}
+
+	if _, _, err := toProto.convert([]byte(`{"status":"LOST"}`)); err == nil {
+		t.Error("wanted error for unknown enum value")
+	}
+	if _, err := compileBodyConverter(apidef.ConvertBodyMeta{
+		From:     apidef.BodyJSON,
+		To:       apidef.BodyProtobuf,
+		Protobuf: apidef.ProtobufConversionOptions{DescriptorSet: set, Message: "acme.Missing"},
+	}); err == nil {
+		t.Error("wanted error for unknown message")
+	}
+}
+
+func TestConvertBodyEmpty(t *testing.T) {
+	c, err := compileBodyConverter(apidef.ConvertBodyMeta{From: apidef.BodyJSON, To: apidef.BodyXML})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, contentType, err := c.convert(nil)
+	if err != nil || len(body) != 0 || contentType != "" {
+		t.Errorf("empty body should be left alone, got %q %q %v", body, contentType, err)
+	}
+	if _, err := compileBodyConverter(apidef.ConvertBodyMeta{From: "yaml", To: apidef.BodyJSON}); err == nil {
+		t.Error("wanted error for unsupported format")
+	}
+}
@@ -0,0 +1,294 @@
+package gateway
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	cache "github.com/pmylund/go-cache"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/headers"
+	"github.com/ins-tykgw/tyk/storage"
+)
+
+const (
+	defaultExternalOAuthCacheTTL = 60
+	// externalOAuthInactiveTTL caps how long, in seconds, inactive tokens
+	// are cached, so that floods of bad tokens don't all reach the identity
+	// provider while new tokens are soon accepted.
+	externalOAuthInactiveTTL = 5
+)
+
+// externalOAuthCache keeps the introspection results of tokens, with a nil
+// map for inactive ones.
+var externalOAuthCache = cache.New(defaultExternalOAuthCacheTTL*time.Second, 10*time.Minute)
+
+var introspectionClient = &http.Client{Timeout: 10 * time.Second}
+
+// ExternalOAuthMiddleware validates opaque tokens of an external identity
+// provider with RFC 7662 token introspection, and gives each subject a
+// virtual session made from policies.
+type ExternalOAuthMiddleware struct {
+	BaseMiddleware
+	clientID, clientSecret string
+	secretErr              error
+}
+
+func (k *ExternalOAuthMiddleware) Name() string {
+	return "ExternalOAuthMiddleware"
+}
+
+func (k *ExternalOAuthMiddleware) EnabledForSpec() bool {
+	return k.Spec.UseExternalOAuth
+}
+
+func (k *ExternalOAuthMiddleware) Init() {
+	opts := k.Spec.ExternalOAuthOptions
+	k.clientID, k.clientSecret = opts.ClientID, opts.ClientSecret
+	if k.secretErr = secretValues(&k.clientID, &k.clientSecret); k.secretErr != nil {
+		k.Logger().WithError(k.secretErr).Error("Couldn't resolve introspection client credentials")
+	}
+}
+
+func (k *ExternalOAuthMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	logger := k.Logger()
+	opts := k.Spec.ExternalOAuthOptions
+
+	token := k.getToken(r)
+	if token == "" {
+		logger.Info("Attempted access with malformed header, no auth header found.")
+		k.reportLoginFailure("", r)
+		return errors.New("Authorization field missing"), http.StatusBadRequest
+	}
+
+	claims, err := k.introspect(token)
+	if err != nil {
+		logger.WithError(err).Error("Token introspection failed")
+		return errors.New("Could not validate the token"), http.StatusInternalServerError
+	}
+	if claims == nil {
+		logger.Info("Attempted access with an inactive token.")
+		k.reportLoginFailure(token, r)
+		return errors.New("Key not authorised"), http.StatusForbidden
+	}
+
+	if !k.validAudience(claims) {
+		logger.Info("Attempted access with a token issued for another audience.")
+		k.reportLoginFailure(token, r)
+		return errors.New("Key not authorised: invalid audience"), http.StatusForbidden
+	}
+
+	identity := introspectedIdentity(claims, opts.IdentityBaseField)
+	if identity == "" {
+		k.reportLoginFailure(token, r)
+		logger.Error("No identity found in the introspected token")
+		return errors.New("Key not authorised: no identity"), http.StatusForbidden
+	}
+
+	polIDs := append([]string{}, opts.DefaultPolicies...)
+	if len(opts.ScopeToPolicyMapping) != 0 {
+		scopeClaimName := opts.ScopeClaimName
+		if scopeClaimName == "" {
+			scopeClaimName = "scope"
+		}
+		mapped := mapScopeToPolicies(opts.ScopeToPolicyMapping, getScopeFromClaim(jwt.MapClaims(claims), scopeClaimName))
+		sort.Strings(mapped)
+		for _, id := range mapped {
+			if !containsString(polIDs, id) {
+				polIDs = append(polIDs, id)
+			}
+		}
+	}
+	if len(polIDs) == 0 {
+		k.reportLoginFailure(identity, r)
+		return errors.New("Key not authorised: no matching policy"), http.StatusForbidden
+	}
+
+	sessionID := generateToken(k.Spec.OrgID, fmt.Sprintf("%x", md5.Sum([]byte(identity))))
+	session, exists := k.CheckSessionAndIdentityForValidKey(sessionID, r)
+	updateSession := false
+	if !exists || !samePolicies(session.PolicyIDs(), polIDs) {
+		if !exists {
+			logger.Debug("Key does not exist, creating")
+			session, err = generateSessionFromPolicy(polIDs[0], k.Spec.OrgID, true)
+			if err != nil {
+				k.reportLoginFailure(identity, r)
+				logger.WithError(err).Error("Could not find a valid policy to apply to this token!")
+				return errors.New("Key not authorised: no matching policy"), http.StatusForbidden
+			}
+			session.MetaData = map[string]interface{}{"TykExternalOAuthSessionID": sessionID}
+			session.Alias = identity
+		}
+
+		session.SetPolicies(polIDs...)
+		if err := k.ApplyPolicies(&session); err != nil {
+			k.reportLoginFailure(identity, r)
+			logger.WithError(err).Error("Could not apply policies to session")
+			return errors.New("Key not authorised: could not apply policies"), http.StatusForbidden
+		}
+		updateSession = true
+	}
+
+	// the session lasts as long as the latest token of the subject
+	if exp, ok := claims["exp"].(float64); ok && int64(exp) > session.Expires && session.Expires != 0 {
+		session.Expires = int64(exp)
+		updateSession = true
+	}
+
+	switch k.Spec.BaseIdentityProvidedBy {
+	case apidef.ExternalOAuthUser, apidef.UnsetAuth:
+		ctxSetSession(r, &session, sessionID, updateSession)
+	}
+	if updateSession {
+		SessionCache.Set(session.KeyHash(), session, cache.DefaultExpiration)
+	}
+
+	return nil, http.StatusOK
+}
+
+func (k *ExternalOAuthMiddleware) getToken(r *http.Request) string {
+	config := k.Spec.Auth
+	token := r.Header.Get(config.AuthHeaderName)
+	if config.UseParam {
+		token = r.URL.Query().Get(config.AuthHeaderName)
+	}
+	if config.UseCookie {
+		token = ""
+		if authCookie, err := r.Cookie(config.AuthHeaderName); err == nil {
+			token = authCookie.Value
+		}
+	}
+	return stripBearer(token)
+}
+
+// introspect returns the claims of an active token, or nil for a token the
+// identity provider doesn't consider active. Results of active tokens are
+// cached until they expire, for at most CacheTTL, and those of inactive
+// ones for at most externalOAuthInactiveTTL.
+func (k *ExternalOAuthMiddleware) introspect(token string) (map[string]interface{}, error) {
+	opts := k.Spec.ExternalOAuthOptions
+	cacheKey := k.Spec.APIID + "-" + storage.HashKey(token)
+	if cached, found := externalOAuthCache.Get(cacheKey); found {
+		return cached.(map[string]interface{}), nil
+	}
+
+	if k.secretErr != nil {
+		return nil, k.secretErr
+	}
+	if opts.IntrospectionURL == "" {
+		return nil, errors.New("no introspection URL set")
+	}
+
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	ctx, cancel := context.WithTimeout(context.Background(), introspectionClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, opts.IntrospectionURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(headers.ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(headers.Accept, headers.ApplicationJSON)
+	if k.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(k.clientID), url.QueryEscape(k.clientSecret))
+	}
+
+	res, err := introspectionClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint returned %d: %s", res.StatusCode, body)
+	}
+
+	var claims map[string]interface{}
+	if err := json.Unmarshal(body, &claims); err != nil {
+		return nil, err
+	}
+
+	ttl := opts.CacheTTL
+	if ttl == 0 {
+		ttl = defaultExternalOAuthCacheTTL
+	}
+	if active, _ := claims["active"].(bool); !active {
+		claims = nil
+	} else if exp, ok := claims["exp"].(float64); ok {
+		left := int64(exp) - time.Now().Unix()
+		if left <= 0 {
+			claims = nil
+		} else if left < ttl {
+			ttl = left
+		}
+	}
+	if claims == nil && ttl > externalOAuthInactiveTTL {
+		ttl = externalOAuthInactiveTTL
+	}
+	if ttl > 0 {
+		externalOAuthCache.Set(cacheKey, claims, time.Duration(ttl)*time.Second)
+	}
+	return claims, nil
+}
+
+// validAudience checks that the token was issued for the API.
+func (k *ExternalOAuthMiddleware) validAudience(claims map[string]interface{}) bool {
+	audiences := k.Spec.ExternalOAuthOptions.Audiences
+	if len(audiences) == 0 {
+		audiences = []string{k.Spec.APIID}
+	}
+	for _, aud := range audiences {
+		if claimContains(claims["aud"], aud) {
+			return true
+		}
+	}
+	return false
+}
+
+// introspectedIdentity returns the subject of an introspected token, from
+// the configured claim, or else "sub", then "client_id" when none is set.
+func introspectedIdentity(claims map[string]interface{}, field string) string {
+	if field != "" {
+		id, _ := claims[field].(string)
+		return id
+	}
+	for _, name := range []string{"sub", "client_id"} {
+		if id, ok := claims[name].(string); ok && id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func samePolicies(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, id := range a {
+		if !containsString(b, id) {
+			return false
+		}
+	}
+	return true
+}
+
+func (k *ExternalOAuthMiddleware) reportLoginFailure(tykId string, r *http.Request) {
+	// Fire Authfailed Event
+	AuthFailed(k, r, tykId)
+
+	// Report in health check
+	reportHealthValue(k.Spec, KeyFailure, "1")
+}
@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/storage"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestExternalOAuth(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	exp := time.Now().Unix() + 3600
+	tokens := map[string]map[string]interface{}{
+		"alice-1":   {"active": true, "aud": "test", "sub": "alice", "scope": "read gold", "exp": exp},
+		"alice-2":   {"active": true, "aud": []string{"other", "test"}, "sub": "alice", "scope": "gold", "exp": exp},
+		"bob":       {"active": true, "aud": "test", "sub": "bob", "exp": exp},
+		"service":   {"active": true, "aud": "test", "client_id": "service", "exp": time.Now().Unix() + 5},
+		"nobody":    {"active": true, "aud": "test"},
+		"other-api": {"active": true, "aud": "other", "sub": "dave", "exp": exp},
+		"revoked":   {"active": false},
+		"expiring":  {"active": true, "aud": "test", "sub": "carol", "exp": time.Now().Unix() - 1},
+	}
+	var calls int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if user, pass, _ := r.BasicAuth(); user != "gateway" || pass != "idp-secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		r.ParseForm()
+		token := r.PostForm.Get("token")
+		if token == "broken" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		claims, ok := tokens[token]
+		if !ok {
+			claims = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(claims)
+	}))
+	defer idp.Close()
+
+	basePolicy := CreatePolicy(func(p *user.Policy) {
+		p.Partitions.Acl = true
+		p.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+	})
+	goldPolicy := CreatePolicy(func(p *user.Policy) {
+		p.Partitions.Quota = true
+		p.QuotaMax = 2
+		p.QuotaRenewalRate = 3600
+	})
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.Proxy.ListenPath = "/"
+		spec.UseExternalOAuth = true
+		spec.ExternalOAuthOptions.IntrospectionURL = idp.URL
+		spec.ExternalOAuthOptions.ClientID = "gateway"
+		spec.ExternalOAuthOptions.ClientSecret = "idp-secret"
+		spec.ExternalOAuthOptions.DefaultPolicies = []string{basePolicy}
+		spec.ExternalOAuthOptions.ScopeToPolicyMapping = map[string]string{"gold": goldPolicy}
+	})
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	t.Run("Quota per subject", func(t *testing.T) {
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: bearer("alice-1"), Code: http.StatusOK},
+			{Path: "/", Headers: bearer("alice-2"), Code: http.StatusOK},
+			{Path: "/", Headers: bearer("alice-1"), Code: http.StatusForbidden, BodyMatch: "Quota exceeded"},
+			{Path: "/", Headers: bearer("bob"), Code: http.StatusOK},
+			{Path: "/", Headers: bearer("bob"), Code: http.StatusOK},
+			{Path: "/", Headers: bearer("bob"), Code: http.StatusOK},
+			{Path: "/", Headers: bearer("service"), Code: http.StatusOK},
+		}...)
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		before := atomic.LoadInt32(&calls)
+		ts.Run(t, test.TestCase{Path: "/", Headers: bearer("bob"), Code: http.StatusOK})
+		if after := atomic.LoadInt32(&calls); after != before {
+			t.Errorf("Expected the introspection result to be cached, got %d calls", after-before)
+		}
+
+		// cached no longer than the token lives
+		item, ok := externalOAuthCache.Items()["test-"+storage.HashKey("service")]
+		if !ok || time.Unix(0, item.Expiration).After(time.Now().Add(6*time.Second)) {
+			t.Errorf("Expected the result to be cached until the token expires, got %+v", item)
+		}
+
+		// inactive tokens are cached briefly
+		before = atomic.LoadInt32(&calls)
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: bearer("revoked"), Code: http.StatusForbidden},
+			{Path: "/", Headers: bearer("revoked"), Code: http.StatusForbidden},
+		}...)
+		if after := atomic.LoadInt32(&calls); after != before+1 {
+			t.Errorf("Expected the inactive result to be cached, got %d calls", after-before)
+		}
+		item, ok = externalOAuthCache.Items()["test-"+storage.HashKey("revoked")]
+		if !ok || time.Unix(0, item.Expiration).After(time.Now().Add(externalOAuthInactiveTTL*time.Second)) {
+			t.Errorf("Expected the inactive result to be cached briefly, got %+v", item)
+		}
+	})
+
+	t.Run("Rejected tokens", func(t *testing.T) {
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Code: http.StatusBadRequest, BodyMatch: "Authorization field missing"},
+			{Path: "/", Headers: bearer("revoked"), Code: http.StatusForbidden},
+			{Path: "/", Headers: bearer("unknown"), Code: http.StatusForbidden},
+			{Path: "/", Headers: bearer("expiring"), Code: http.StatusForbidden},
+			{Path: "/", Headers: bearer("nobody"), Code: http.StatusForbidden, BodyMatch: "no identity"},
+			{Path: "/", Headers: bearer("other-api"), Code: http.StatusForbidden, BodyMatch: "invalid audience"},
+			{Path: "/", Headers: bearer("broken"), Code: http.StatusInternalServerError},
+		}...)
+	})
+
+	t.Run("Identity field", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/"
+			spec.UseExternalOAuth = true
+			spec.ExternalOAuthOptions.IntrospectionURL = idp.URL
+			spec.ExternalOAuthOptions.ClientID = "gateway"
+			spec.ExternalOAuthOptions.ClientSecret = "idp-secret"
+			spec.ExternalOAuthOptions.Audiences = []string{"other"}
+			spec.ExternalOAuthOptions.IdentityBaseField = "client_id"
+			spec.ExternalOAuthOptions.DefaultPolicies = []string{basePolicy}
+		})
+		// alice-2 has a sub but no client_id
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Headers: bearer("alice-2"), Code: http.StatusForbidden, BodyMatch: "no identity"},
+			{Path: "/", Headers: bearer("bob"), Code: http.StatusForbidden, BodyMatch: "invalid audience"},
+		}...)
+	})
+
+	t.Run("Introspection credentials", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/"
+			spec.UseExternalOAuth = true
+			spec.ExternalOAuthOptions.IntrospectionURL = idp.URL
+			spec.ExternalOAuthOptions.ClientID = "gateway"
+			spec.ExternalOAuthOptions.ClientSecret = "wrong"
+			spec.ExternalOAuthOptions.DefaultPolicies = []string{basePolicy}
+			spec.ExternalOAuthOptions.CacheTTL = -1
+		})
+		externalOAuthCache.Flush()
+		ts.Run(t, test.TestCase{Path: "/", Headers: bearer("bob"), Code: http.StatusInternalServerError})
+	})
+}
@@ -90,23 +90,11 @@ func (hm *HMACMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request,
 		}
 	}
 
-	// Create a signed string with the secret
-	encodedSignature := generateEncodedSignature(signatureString, secret, fieldValues.Algorthm)
+	matchPass, encodedSignature := hm.signatureMatches(signatureString, secret, fieldValues, logger)
 
-	// Compare
-	matchPass := encodedSignature == fieldValues.Signature
-
-	// Check for lower case encoding (.Net issues, again)
-	if !matchPass {
-		isLower, lowerList := hm.hasLowerCaseEscaped(fieldValues.Signature)
-		if isLower {
-			logger.Debug("--- Detected lower case encoding! ---")
-			upperedSignature := hm.replaceWithUpperCase(fieldValues.Signature, lowerList)
-			if encodedSignature == upperedSignature {
-				matchPass = true
-				encodedSignature = upperedSignature
-			}
-		}
+	// the secret replaced by a rotation is accepted during the grace period
+	if !matchPass && session.RotatedSecrets.HmacSecret != "" && session.RotatedSecretsValid() {
+		matchPass, _ = hm.signatureMatches(signatureString, session.RotatedSecrets.HmacSecret, fieldValues, logger)
 	}
 
 	if !matchPass {
@@ -135,6 +123,29 @@ func (hm *HMACMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request,
 	return nil, http.StatusOK
 }
 
+// signatureMatches compares the signature of the request with the one made
+// with secret, which it also returns.
+func (hm *HMACMiddleware) signatureMatches(signatureString, secret string, fieldValues *HMACFieldValues, logger *logrus.Entry) (bool, string) {
+	// Create a signed string with the secret
+	encodedSignature := generateEncodedSignature(signatureString, secret, fieldValues.Algorthm)
+
+	// Compare
+	if encodedSignature == fieldValues.Signature {
+		return true, encodedSignature
+	}
+
+	// Check for lower case encoding (.Net issues, again)
+	isLower, lowerList := hm.hasLowerCaseEscaped(fieldValues.Signature)
+	if isLower {
+		logger.Debug("--- Detected lower case encoding! ---")
+		upperedSignature := hm.replaceWithUpperCase(fieldValues.Signature, lowerList)
+		if encodedSignature == upperedSignature {
+			return true, upperedSignature
+		}
+	}
+	return false, encodedSignature
+}
+
 func stripSignature(token string) string {
 	token = strings.TrimPrefix(token, "Signature")
 	token = strings.TrimPrefix(token, "signature")
@@ -4,6 +4,8 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/ins-tykgw/tyk/request"
 )
 
 var testWhiteListIPData = []struct {
@@ -46,6 +48,36 @@ func TestIPMiddlewarePass(t *testing.T) {
 	}
 }
 
+func TestIPMiddlewareTrustedProxies(t *testing.T) {
+	spec := testPrepareIPMiddlewarePass()
+	request.SetTrustedProxies([]string{"10.0.0.0/8"}, 0, "")
+	defer request.SetTrustedProxies(nil, 0, "")
+
+	for ti, tc := range []struct {
+		remote, forwarded string
+		wantCode          int
+	}{
+		{"10.0.0.1:80", "127.0.0.1", http.StatusOK},                     // trusted proxy
+		{"192.168.0.1:80", "127.0.0.1", http.StatusForbidden},           // spoofed header
+		{"10.0.0.1:80", "127.0.0.1, 10.0.0.2", http.StatusOK},           // chain of trusted proxies
+		{"10.0.0.1:80", "127.0.0.1, 192.168.0.1", http.StatusForbidden}, // spoofed behind a proxy
+	} {
+		rec := httptest.NewRecorder()
+		req := TestReq(t, "GET", "/", nil)
+		req.RemoteAddr = tc.remote
+		req.Header.Set("X-Forwarded-For", tc.forwarded)
+
+		mw := &IPWhiteListMiddleware{}
+		mw.Spec = spec
+		_, code := mw.ProcessRequest(rec, req, nil)
+
+		if code != tc.wantCode {
+			t.Errorf("[%d] Response code %d should be %d\n%q %q", ti,
+				code, tc.wantCode, tc.remote, tc.forwarded)
+		}
+	}
+}
+
 func BenchmarkIPMiddlewarePass(b *testing.B) {
 	b.ReportAllocs()
 
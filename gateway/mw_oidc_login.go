@@ -0,0 +1,750 @@
+package gateway
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+const (
+	oidcCallbackPath      = "oidc/callback"
+	defaultOIDCCookieName = "tyk_oidc_session"
+	defaultIdentityHeader = "X-Tyk-Identity"
+	// oidcRefreshMargin is how long before expiry, in seconds, tokens are
+	// refreshed.
+	oidcRefreshMargin = 60
+	// oidcJWKSRefetch is how often the keys of the provider can be fetched
+	// again for an unknown key ID.
+	oidcJWKSRefetch = time.Minute
+	// oidcRefreshReuse is how long the tokens of a refresh are handed to
+	// requests still carrying the old refresh token. Providers that rotate
+	// refresh tokens revoke the login when one is used twice.
+	oidcRefreshReuse = time.Minute
+)
+
+var oidcClient = &http.Client{Timeout: 10 * time.Second}
+
+// oidcProviderMetadata is the part of the OpenID provider configuration the
+// login flow uses.
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// oidcLoginState is kept in a cookie while the user logs in with the
+// provider.
+type oidcLoginState struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	Redirect string `json:"redirect"`
+}
+
+// oidcLoginSession is kept in the session cookie once the user logged in.
+type oidcLoginSession struct {
+	Claims       jwt.MapClaims `json:"claims"`
+	RefreshToken string        `json:"refresh_token,omitempty"`
+	Expires      int64         `json:"expires"`
+}
+
+// oidcRefreshed is the outcome of refreshing the tokens of a login.
+type oidcRefreshed struct {
+	login oidcLoginSession
+	at    time.Time
+}
+
+type oidcTokenResponse struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+}
+
+// OIDCLoginMiddleware runs the OpenID Connect authorization code flow for
+// browsers, keeping the login in an encrypted cookie.
+type OIDCLoginMiddleware struct {
+	BaseMiddleware
+
+	issuer, clientID, policyID string
+	clientSecret               string
+	aead                       cipher.AEAD
+	initErr                    error
+
+	mu        sync.Mutex
+	metadata  *oidcProviderMetadata
+	keys      map[string]*rsa.PublicKey
+	keysFetch time.Time
+
+	// refreshes runs one refresh at a time per refresh token, and
+	// refreshed keeps the outcome by the old refresh token.
+	refreshes singleflight.Group
+	refreshed map[string]oidcRefreshed
+}
+
+func (k *OIDCLoginMiddleware) Name() string {
+	return "OIDCLoginMiddleware"
+}
+
+func (k *OIDCLoginMiddleware) EnabledForSpec() bool {
+	return k.Spec.UseOIDCLogin
+}
+
+func (k *OIDCLoginMiddleware) Init() {
+	if k.initErr = k.init(); k.initErr != nil {
+		k.Logger().WithError(k.initErr).Error("OpenID Connect login configuration error")
+	}
+}
+
+func (k *OIDCLoginMiddleware) init() error {
+	opts := k.Spec.OIDCLoginOptions
+
+	var provider *apidef.OIDProviderConfig
+	for i, p := range k.Spec.OpenIDOptions.Providers {
+		if opts.Issuer == "" || p.Issuer == opts.Issuer {
+			provider = &k.Spec.OpenIDOptions.Providers[i]
+			break
+		}
+	}
+	if provider == nil {
+		return errors.New("no OpenID provider found")
+	}
+	k.issuer = provider.Issuer
+
+	for encodedID, policyID := range provider.ClientIDs {
+		clientID, _ := base64.StdEncoding.DecodeString(encodedID)
+		if opts.ClientID == "" && len(provider.ClientIDs) == 1 || string(clientID) == opts.ClientID {
+			k.clientID, k.policyID = string(clientID), policyID
+		}
+	}
+	if k.clientID == "" {
+		return errors.New("no client of the OpenID provider found")
+	}
+
+	// without a custom domain the router accepts any host, so the
+	// callback URL can't come from the request
+	if opts.RedirectURL == "" && (k.Spec.Domain == "" || !config.Global().EnableCustomDomains) {
+		return errors.New("a redirect URL is required unless the API is served on a custom domain")
+	}
+
+	k.clientSecret = opts.ClientSecret
+	cookieSecret := opts.CookieSecret
+	if err := secretValues(&k.clientSecret, &cookieSecret); err != nil {
+		return err
+	}
+	if cookieSecret == "" {
+		return errors.New("a cookie secret is required")
+	}
+	key := sha256.Sum256([]byte(cookieSecret))
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return err
+	}
+	k.aead, err = cipher.NewGCM(block)
+	return err
+}
+
+func (k *OIDCLoginMiddleware) cookieName() string {
+	if name := k.Spec.OIDCLoginOptions.CookieName; name != "" {
+		return name
+	}
+	return defaultOIDCCookieName
+}
+
+func (k *OIDCLoginMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	if k.initErr != nil {
+		return errors.New("OpenID Connect login is misconfigured"), http.StatusInternalServerError
+	}
+
+	if r.URL.Path == k.Spec.Proxy.ListenPath+oidcCallbackPath {
+		return k.handleCallback(w, r)
+	}
+
+	var login oidcLoginSession
+	if err := k.readCookie(r, k.cookieName(), &login); err != nil {
+		return k.startLogin(w, r)
+	}
+
+	now := time.Now().Unix()
+	if now >= login.Expires-oidcRefreshMargin {
+		refreshed := false
+		if login.RefreshToken != "" {
+			if err := k.refresh(&login); err != nil {
+				k.Logger().WithError(err).Warning("Couldn't refresh OpenID Connect tokens")
+			} else {
+				refreshed = true
+			}
+		}
+		if refreshed {
+			if err := k.writeCookie(w, k.cookieName(), login, 0); err != nil {
+				return err, http.StatusInternalServerError
+			}
+		} else if now >= login.Expires {
+			return k.startLogin(w, r)
+		}
+	}
+
+	if err, code := k.setSession(r, login.Claims); err != nil {
+		return err, code
+	}
+	if err := k.forwardIdentity(r, login.Claims); err != nil {
+		k.Logger().WithError(err).Error("Couldn't forward identity upstream")
+		return errors.New("Could not forward identity"), http.StatusInternalServerError
+	}
+	k.stripCookies(r)
+
+	return nil, http.StatusOK
+}
+
+// startLogin redirects browsers to the provider. Other requests, which
+// can't follow the login, are rejected.
+func (k *OIDCLoginMiddleware) startLogin(w http.ResponseWriter, r *http.Request) (error, int) {
+	if r.Method != http.MethodGet {
+		AuthFailed(k, r, "")
+		return errors.New("Login required"), http.StatusUnauthorized
+	}
+
+	metadata, err := k.providerMetadata()
+	if err != nil {
+		k.Logger().WithError(err).Error("Couldn't load OpenID provider configuration")
+		return errors.New("OpenID provider unavailable"), http.StatusBadGateway
+	}
+
+	state := oidcLoginState{
+		State:    uuid.NewV4().String(),
+		Nonce:    uuid.NewV4().String(),
+		Verifier: newCodeVerifier(),
+		Redirect: r.URL.RequestURI(),
+	}
+	if err := k.writeCookie(w, k.cookieName()+"_state", state, 600); err != nil {
+		return err, http.StatusInternalServerError
+	}
+
+	scopes := k.Spec.OIDCLoginOptions.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	challenge := sha256.Sum256([]byte(state.Verifier))
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {k.clientID},
+		"redirect_uri":          {k.redirectURL(r)},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state.State},
+		"nonce":                 {state.Nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {pkceMethodS256},
+	}
+	sep := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	http.Redirect(w, r, metadata.AuthorizationEndpoint+sep+query.Encode(), http.StatusFound)
+	return nil, mwStatusRespond
+}
+
+// handleCallback finishes a login, exchanging the code of the provider for
+// tokens, and sends the user back to where they started.
+func (k *OIDCLoginMiddleware) handleCallback(w http.ResponseWriter, r *http.Request) (error, int) {
+	var state oidcLoginState
+	if err := k.readCookie(r, k.cookieName()+"_state", &state); err != nil || state.State != r.URL.Query().Get("state") {
+		AuthFailed(k, r, "")
+		return errors.New("Invalid login state"), http.StatusUnauthorized
+	}
+	if errCode := r.URL.Query().Get("error"); errCode != "" {
+		AuthFailed(k, r, "")
+		return errors.New("Login failed: " + errCode), http.StatusUnauthorized
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {r.URL.Query().Get("code")},
+		"redirect_uri":  {k.redirectURL(r)},
+		"code_verifier": {state.Verifier},
+	}
+	tokens, err := k.tokenRequest(form)
+	if err != nil {
+		k.Logger().WithError(err).Error("Couldn't exchange the OpenID Connect code")
+		AuthFailed(k, r, "")
+		return errors.New("Login failed"), http.StatusUnauthorized
+	}
+	claims, err := k.verifyIDToken(tokens.IDToken, state.Nonce)
+	if err != nil {
+		k.Logger().WithError(err).Error("Invalid ID token")
+		AuthFailed(k, r, "")
+		return errors.New("Login failed"), http.StatusUnauthorized
+	}
+
+	login := oidcLoginSession{Claims: claims, RefreshToken: tokens.RefreshToken}
+	login.Expires = tokenExpiry(tokens, claims)
+	if err := k.writeCookie(w, k.cookieName(), login, 0); err != nil {
+		return err, http.StatusInternalServerError
+	}
+	k.writeCookie(w, k.cookieName()+"_state", nil, -1)
+
+	http.Redirect(w, r, k.loginRedirect(state.Redirect), http.StatusFound)
+	return nil, mwStatusRespond
+}
+
+// loginRedirect returns target if it is a path under the listen path, and
+// the listen path otherwise. Browsers read paths such as //evil.example/
+// and /\evil.example/ as another host.
+func (k *OIDCLoginMiddleware) loginRedirect(target string) string {
+	listenPath := k.Spec.Proxy.ListenPath
+	// up to the first mux variable
+	if i := strings.IndexByte(listenPath, '{'); i >= 0 {
+		listenPath = listenPath[:i]
+	}
+	if !strings.HasPrefix(listenPath, "/") {
+		listenPath = "/" + listenPath
+	}
+	if strings.HasPrefix(target, "//") || strings.HasPrefix(target, "/\\") || !strings.HasPrefix(target, listenPath) {
+		return listenPath
+	}
+	return target
+}
+
+// refresh refreshes the tokens of a login. Concurrent requests of a login
+// share one refresh, and requests sent with the old cookie shortly after
+// get the same tokens, so that a refresh token is only used once.
+func (k *OIDCLoginMiddleware) refresh(login *oidcLoginSession) error {
+	token := login.RefreshToken
+	k.mu.Lock()
+	done, ok := k.refreshed[token]
+	k.mu.Unlock()
+	if ok && time.Since(done.at) < oidcRefreshReuse {
+		*login = done.login
+		return nil
+	}
+
+	v, err, _ := k.refreshes.Do(token, func() (interface{}, error) {
+		refreshed := *login
+		if err := k.refreshTokens(&refreshed); err != nil {
+			return nil, err
+		}
+		k.mu.Lock()
+		if k.refreshed == nil {
+			k.refreshed = make(map[string]oidcRefreshed)
+		}
+		for old, done := range k.refreshed {
+			if time.Since(done.at) >= oidcRefreshReuse {
+				delete(k.refreshed, old)
+			}
+		}
+		k.refreshed[token] = oidcRefreshed{login: refreshed, at: time.Now()}
+		k.mu.Unlock()
+		return refreshed, nil
+	})
+	if err != nil {
+		return err
+	}
+	*login = v.(oidcLoginSession)
+	return nil
+}
+
+func (k *OIDCLoginMiddleware) refreshTokens(login *oidcLoginSession) error {
+	tokens, err := k.tokenRequest(url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {login.RefreshToken},
+	})
+	if err != nil {
+		return err
+	}
+	if tokens.IDToken != "" {
+		claims, err := k.verifyIDToken(tokens.IDToken, "")
+		if err != nil {
+			return err
+		}
+		if claims["sub"] != login.Claims["sub"] {
+			return errors.New("refreshed ID token is for another subject")
+		}
+		login.Claims = claims
+	}
+	if tokens.RefreshToken != "" {
+		login.RefreshToken = tokens.RefreshToken
+	}
+	login.Expires = tokenExpiry(tokens, login.Claims)
+	return nil
+}
+
+// newCodeVerifier returns a PKCE code verifier of 43 characters.
+func newCodeVerifier() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// tokenExpiry is when the tokens expire, by the token response or else the
+// ID token.
+func tokenExpiry(tokens *oidcTokenResponse, claims jwt.MapClaims) int64 {
+	if tokens.ExpiresIn > 0 {
+		return time.Now().Unix() + tokens.ExpiresIn
+	}
+	exp, _ := claims["exp"].(float64)
+	return int64(exp)
+}
+
+func (k *OIDCLoginMiddleware) tokenRequest(form url.Values) (*oidcTokenResponse, error) {
+	metadata, err := k.providerMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), oidcClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(headers.ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(headers.Accept, headers.ApplicationJSON)
+	req.SetBasicAuth(url.QueryEscape(k.clientID), url.QueryEscape(k.clientSecret))
+
+	res, err := oidcClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("token endpoint returned %d: %s", res.StatusCode, body)
+	}
+
+	var tokens oidcTokenResponse
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, err
+	}
+	return &tokens, nil
+}
+
+// verifyIDToken checks the signature, issuer, audience, expiry and, when
+// given, nonce of an ID token.
+func (k *OIDCLoginMiddleware) verifyIDToken(idToken, nonce string) (jwt.MapClaims, error) {
+	if idToken == "" {
+		return nil, errors.New("no ID token")
+	}
+	token, err := jwt.Parse(idToken, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		kid, _ := token.Header["kid"].(string)
+		return k.signingKey(kid)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	if claims["iss"] != k.issuer {
+		return nil, fmt.Errorf("unexpected issuer: %v", claims["iss"])
+	}
+	if !claimContains(claims["aud"], k.clientID) {
+		return nil, errors.New("ID token isn't for this client")
+	}
+	if _, ok := claims["exp"].(float64); !ok {
+		return nil, errors.New("ID token has no expiry")
+	}
+	if nonce != "" && claims["nonce"] != nonce {
+		return nil, errors.New("ID token nonce doesn't match")
+	}
+	return claims, nil
+}
+
+func claimContains(claim interface{}, value string) bool {
+	switch v := claim.(type) {
+	case string:
+		return v == value
+	case []interface{}:
+		for _, item := range v {
+			if item == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (k *OIDCLoginMiddleware) providerMetadata() (*oidcProviderMetadata, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.metadata != nil {
+		return k.metadata, nil
+	}
+
+	var metadata oidcProviderMetadata
+	if err := getJSON(strings.TrimSuffix(k.issuer, "/")+"/.well-known/openid-configuration", &metadata); err != nil {
+		return nil, err
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, errors.New("OpenID provider configuration is incomplete")
+	}
+	k.metadata = &metadata
+	return k.metadata, nil
+}
+
+// signingKey returns a key of the provider, fetching the keys again when
+// the key isn't known yet, as providers rotate their keys.
+func (k *OIDCLoginMiddleware) signingKey(kid string) (*rsa.PublicKey, error) {
+	metadata, err := k.providerMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(k.keysFetch) < oidcJWKSRefetch {
+		return nil, errors.New("unknown signing key: " + kid)
+	}
+
+	var jwks JWKs
+	if err := getJSON(metadata.JWKSURI, &jwks); err != nil {
+		return nil, err
+	}
+	k.keysFetch = time.Now()
+	k.keys = make(map[string]*rsa.PublicKey)
+	for _, jwk := range jwks.Keys {
+		if key := jwkPublicKey(jwk); key != nil {
+			k.keys[jwk.KID] = key
+		}
+	}
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, errors.New("unknown signing key: " + kid)
+}
+
+// jwkPublicKey returns the RSA public key of a JWK, from its modulus and
+// exponent or else its certificate.
+func jwkPublicKey(jwk JWK) *rsa.PublicKey {
+	if jwk.Kty != "RSA" {
+		return nil
+	}
+	if jwk.N != "" && jwk.E != "" {
+		n, errN := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk.N, "="))
+		e, errE := base64.RawURLEncoding.DecodeString(strings.TrimRight(jwk.E, "="))
+		if errN == nil && errE == nil {
+			return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		}
+	}
+	if len(jwk.X5c) > 0 {
+		der, err := base64.StdEncoding.DecodeString(jwk.X5c[0])
+		if err != nil {
+			return nil
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil
+		}
+		key, _ := cert.PublicKey.(*rsa.PublicKey)
+		return key
+	}
+	return nil
+}
+
+func getJSON(url string, v interface{}) error {
+	res, err := oidcClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, res.StatusCode)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
+
+// redirectURL is the configured callback URL, or else the one on the host
+// of the request, which the router matched to the domain of the API.
+func (k *OIDCLoginMiddleware) redirectURL(r *http.Request) string {
+	if redirectURL := k.Spec.OIDCLoginOptions.RedirectURL; redirectURL != "" {
+		return redirectURL
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + k.Spec.Proxy.ListenPath + oidcCallbackPath
+}
+
+// setSession gives the user a session of their own, with the policy of the
+// client.
+func (k *OIDCLoginMiddleware) setSession(r *http.Request, claims jwt.MapClaims) (error, int) {
+	sub, _ := claims["sub"].(string)
+	if sub == "" || k.policyID == "" {
+		AuthFailed(k, r, "")
+		return errors.New("Key not authorised: no matching policy"), http.StatusForbidden
+	}
+
+	sessionID := generateToken(k.Spec.OrgID, fmt.Sprintf("%x", md5.Sum([]byte(k.issuer+sub))))
+	session, exists := k.CheckSessionAndIdentityForValidKey(sessionID, r)
+	if !exists {
+		var err error
+		session, err = generateSessionFromPolicy(k.policyID, k.Spec.OrgID, true)
+		if err != nil {
+			AuthFailed(k, r, sessionID)
+			k.Logger().WithError(err).Error("Could not find a valid policy to apply to this login!")
+			return errors.New("Key not authorised: no matching policy"), http.StatusForbidden
+		}
+		session.MetaData = map[string]interface{}{"TykOIDCLoginSessionID": sessionID, "ClientID": k.clientID}
+		session.Alias = sub
+	}
+	session.SetPolicies(k.policyID)
+	if err := k.ApplyPolicies(&session); err != nil {
+		k.Logger().WithError(err).Error("Could not apply policy to login session")
+		return errors.New("Key not authorised: could not apply policy"), http.StatusForbidden
+	}
+
+	switch k.Spec.BaseIdentityProvidedBy {
+	case apidef.OIDCLoginUser, apidef.UnsetAuth:
+		ctxSetSession(r, &session, sessionID, !exists)
+	}
+	return nil, http.StatusOK
+}
+
+// forwardIdentity sets the identity headers of the upstream request,
+// replacing any sent by the client.
+func (k *OIDCLoginMiddleware) forwardIdentity(r *http.Request, claims jwt.MapClaims) error {
+	opts := k.Spec.OIDCLoginOptions
+	for claim, header := range opts.IdentityHeaders {
+		r.Header.Del(header)
+		switch v := claims[claim].(type) {
+		case nil:
+		case string:
+			r.Header.Set(header, v)
+		case []interface{}:
+			values := make([]string, len(v))
+			for i, item := range v {
+				values[i] = fmt.Sprint(item)
+			}
+			r.Header.Set(header, strings.Join(values, ","))
+		default:
+			r.Header.Set(header, fmt.Sprint(v))
+		}
+	}
+
+	if !opts.IdentityJWT.Enabled {
+		return nil
+	}
+	header := opts.IdentityJWT.Header
+	if header == "" {
+		header = defaultIdentityHeader
+	}
+	r.Header.Del(header)
+
+	key, _, err := oauthSigningKey(opts.IdentityJWT.SigningCertID)
+	if err != nil {
+		return err
+	}
+	identity := jwt.MapClaims{}
+	for name, value := range claims {
+		switch name {
+		case "aud", "nonce", "at_hash", "azp", "iat", "exp", "nbf":
+		default:
+			identity[name] = value
+		}
+	}
+	now := time.Now().Unix()
+	identity["aud"] = k.Spec.APIID
+	identity["iat"] = now
+	identity["exp"] = now + 300
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, identity)
+	token.Header["kid"] = opts.IdentityJWT.SigningCertID
+	signed, err := token.SignedString(key)
+	if err != nil {
+		return err
+	}
+	r.Header.Set(header, signed)
+	return nil
+}
+
+// stripCookies keeps the login cookies from the upstream.
+func (k *OIDCLoginMiddleware) stripCookies(r *http.Request) {
+	cookies := r.Cookies()
+	r.Header.Del("Cookie")
+	for _, c := range cookies {
+		if c.Name != k.cookieName() && c.Name != k.cookieName()+"_state" {
+			r.AddCookie(c)
+		}
+	}
+}
+
+// writeCookie sets an encrypted cookie. A negative maxAge deletes it.
+func (k *OIDCLoginMiddleware) writeCookie(w http.ResponseWriter, name string, v interface{}, maxAge int) error {
+	cookie := &http.Cookie{
+		Name:     name,
+		Path:     k.Spec.Proxy.ListenPath,
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   !k.Spec.OIDCLoginOptions.InsecureCookie,
+	}
+	if maxAge >= 0 {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		nonce := make([]byte, k.aead.NonceSize())
+		if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+			return err
+		}
+		cookie.Value = base64.RawURLEncoding.EncodeToString(k.aead.Seal(nonce, nonce, data, []byte(name)))
+		if len(cookie.Value) > 4000 {
+			k.Logger().Warning("OpenID Connect session cookie is larger than browsers may keep")
+		}
+	}
+	// SameSite=Lax still sends the cookies on the redirect back from the
+	// provider. http.Cookie only has SameSite from Go 1.11.
+	w.Header().Add("Set-Cookie", cookie.String()+"; SameSite=Lax")
+	return nil
+}
+
+func (k *OIDCLoginMiddleware) readCookie(r *http.Request, name string, v interface{}) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil {
+		return err
+	}
+	nonceSize := k.aead.NonceSize()
+	if len(data) < nonceSize {
+		return errors.New("cookie is too short")
+	}
+	plain, err := k.aead.Open(nil, data[:nonceSize], data[nonceSize:], []byte(name))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(plain, v)
+}
@@ -0,0 +1,256 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestOIDCLogin(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	idpKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, combinedPEM, _ := genCertificate(&x509.Certificate{})
+	certID, err := CertificateManager.Add(combinedPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CertificateManager.Delete(certID)
+
+	var expiresIn, refreshes int64 = 3600, 0
+	var idp *httptest.Server
+	idToken := func(nonce string) string {
+		claims := jwt.MapClaims{
+			"iss":   idp.URL,
+			"aud":   "gateway",
+			"sub":   "alice",
+			"email": "alice@example.com",
+			"exp":   time.Now().Unix() + 3600,
+		}
+		if nonce != "" {
+			claims["nonce"] = nonce
+		}
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "idp-key"
+		signed, _ := token.SignedString(idpKey)
+		return signed
+	}
+	idp = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(oidcProviderMetadata{
+				Issuer:                idp.URL,
+				AuthorizationEndpoint: idp.URL + "/authorize",
+				TokenEndpoint:         idp.URL + "/token",
+				JWKSURI:               idp.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(JWKs{Keys: []JWK{{
+				Kty: "RSA",
+				KID: "idp-key",
+				N:   base64.RawURLEncoding.EncodeToString(idpKey.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idpKey.E)).Bytes()),
+			}}})
+		case "/token":
+			if user, pass, _ := r.BasicAuth(); user != "gateway" || pass != "idp-secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			r.ParseForm()
+			tokens := oidcTokenResponse{RefreshToken: "refresh", ExpiresIn: atomic.LoadInt64(&expiresIn)}
+			switch r.PostForm.Get("grant_type") {
+			case "authorization_code":
+				// the stub uses the nonce as code
+				if r.PostForm.Get("code_verifier") == "" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				tokens.IDToken = idToken(r.PostForm.Get("code"))
+			case "refresh_token":
+				atomic.AddInt64(&refreshes, 1)
+				tokens.IDToken = idToken("")
+			}
+			json.NewEncoder(w).Encode(tokens)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer idp.Close()
+
+	policyID := CreatePolicy(func(p *user.Policy) {
+		p.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+	})
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.UseKeylessAccess = false
+		spec.Proxy.ListenPath = "/"
+		spec.OpenIDOptions.Providers = []apidef.OIDProviderConfig{{
+			Issuer:    idp.URL,
+			ClientIDs: map[string]string{base64.StdEncoding.EncodeToString([]byte("gateway")): policyID},
+		}}
+		spec.UseOIDCLogin = true
+		spec.BaseIdentityProvidedBy = apidef.OIDCLoginUser
+		spec.OIDCLoginOptions.ClientSecret = "idp-secret"
+		spec.OIDCLoginOptions.CookieSecret = "cookie-secret"
+		spec.OIDCLoginOptions.RedirectURL = "https://gateway.example.com/oidc/callback"
+		spec.OIDCLoginOptions.IdentityHeaders = map[string]string{"email": "X-User-Email"}
+		spec.OIDCLoginOptions.IdentityJWT.Enabled = true
+		spec.OIDCLoginOptions.IdentityJWT.SigningCertID = certID
+	})
+
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	cookie := func(resp *http.Response, name string) *http.Cookie {
+		for _, c := range resp.Cookies() {
+			if c.Name == name {
+				return c
+			}
+		}
+		return nil
+	}
+	login := func(t *testing.T) *http.Cookie {
+		resp, _ := ts.Run(t, test.TestCase{Path: "/app?x=1", Client: client, Code: http.StatusFound})
+		location, _ := url.Parse(resp.Header.Get("Location"))
+		query := location.Query()
+		if !strings.HasPrefix(location.String(), idp.URL+"/authorize") || query.Get("client_id") != "gateway" ||
+			query.Get("code_challenge_method") != pkceMethodS256 || query.Get("redirect_uri") != "https://gateway.example.com/oidc/callback" {
+			t.Fatalf("Unexpected login redirect: %s", location)
+		}
+		state := cookie(resp, defaultOIDCCookieName+"_state")
+		if state == nil || !state.HttpOnly || !state.Secure || !strings.HasSuffix(resp.Header.Get("Set-Cookie"), "; SameSite=Lax") {
+			t.Fatalf("Expected a login state cookie, got %v", resp.Header["Set-Cookie"])
+		}
+
+		callback := "/oidc/callback?" + url.Values{"state": {query.Get("state")}, "code": {query.Get("nonce")}}.Encode()
+		resp, _ = ts.Run(t, test.TestCase{Path: callback, Client: client, Cookies: []*http.Cookie{state},
+			Code: http.StatusFound, HeadersMatch: map[string]string{"Location": "/app?x=1"}})
+		session := cookie(resp, defaultOIDCCookieName)
+		if session == nil {
+			t.Fatalf("Expected a session cookie, got %v", resp.Cookies())
+		}
+		return session
+	}
+
+	t.Run("Login", func(t *testing.T) {
+		session := login(t)
+		resp, _ := ts.Run(t, test.TestCase{Path: "/app", Client: client,
+			Headers: map[string]string{"X-User-Email": "mallory@example.com"},
+			Cookies: []*http.Cookie{session, {Name: "other", Value: "1"}},
+			Code:    http.StatusOK, BodyMatch: `"X-User-Email":"alice@example.com"`})
+
+		var upstream TestHttpResponse
+		json.NewDecoder(resp.Body).Decode(&upstream)
+		if cookies := upstream.Headers["Cookie"]; cookies != "other=1" {
+			t.Errorf("Expected the login cookie to be kept from the upstream, got %q", cookies)
+		}
+		key, _, _ := oauthSigningKey(certID)
+		token, err := jwt.Parse(upstream.Headers[defaultIdentityHeader], func(token *jwt.Token) (interface{}, error) {
+			return &key.PublicKey, nil
+		})
+		if err != nil {
+			t.Fatal("Identity JWT doesn't verify: ", err)
+		}
+		if claims := token.Claims.(jwt.MapClaims); claims["sub"] != "alice" || claims["aud"] != "test" || claims["nonce"] != nil {
+			t.Errorf("Unexpected identity claims: %v", claims)
+		}
+	})
+
+	t.Run("Rejected logins", func(t *testing.T) {
+		ts.Run(t, []test.TestCase{
+			{Method: http.MethodPost, Path: "/app", Code: http.StatusUnauthorized},
+			{Path: "/oidc/callback?state=forged&code=x", Code: http.StatusUnauthorized},
+			{Path: "/app", Client: client, Cookies: []*http.Cookie{{Name: defaultOIDCCookieName, Value: "tampered"}},
+				Code: http.StatusFound},
+		}...)
+	})
+
+	t.Run("Refresh", func(t *testing.T) {
+		atomic.StoreInt64(&expiresIn, 30)
+		session := login(t)
+		atomic.StoreInt64(&expiresIn, 3600)
+
+		resp, _ := ts.Run(t, test.TestCase{Path: "/app", Cookies: []*http.Cookie{session}, Code: http.StatusOK})
+		if atomic.LoadInt64(&refreshes) != 1 || cookie(resp, defaultOIDCCookieName) == nil {
+			t.Fatalf("Expected the tokens to be refreshed, got %d refreshes", refreshes)
+		}
+		ts.Run(t, test.TestCase{Path: "/app", Cookies: []*http.Cookie{cookie(resp, defaultOIDCCookieName)}, Code: http.StatusOK})
+		if atomic.LoadInt64(&refreshes) != 1 {
+			t.Error("Expected the refreshed cookie to last")
+		}
+
+		// a request sent with the old cookie before the new one arrived
+		resp, _ = ts.Run(t, test.TestCase{Path: "/app", Cookies: []*http.Cookie{session}, Code: http.StatusOK})
+		if atomic.LoadInt64(&refreshes) != 1 || cookie(resp, defaultOIDCCookieName) == nil {
+			t.Errorf("Expected the refresh token to be used once, got %d refreshes", refreshes)
+		}
+	})
+}
+
+func TestOIDCLoginRedirectURL(t *testing.T) {
+	spec := BuildAPI(func(spec *APISpec) {
+		spec.OpenIDOptions.Providers = []apidef.OIDProviderConfig{{
+			Issuer:    "https://idp.example.com",
+			ClientIDs: map[string]string{base64.StdEncoding.EncodeToString([]byte("gateway")): "policy"},
+		}}
+		spec.UseOIDCLogin = true
+		spec.OIDCLoginOptions.CookieSecret = "cookie-secret"
+	})[0]
+	mw := &OIDCLoginMiddleware{BaseMiddleware: BaseMiddleware{Spec: spec}}
+	if err := mw.init(); err == nil {
+		t.Error("Expected a redirect URL to be required without a custom domain")
+	}
+
+	globalConf := config.Global()
+	globalConf.EnableCustomDomains = true
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	spec.Domain = "gateway.example.com"
+	if err := mw.init(); err != nil {
+		t.Error("Expected the custom domain to be used for the redirect URL, got ", err)
+	}
+}
+
+func TestOIDCLoginRedirect(t *testing.T) {
+	tests := []struct {
+		listenPath, target, want string
+	}{
+		{"/", "/app?x=1", "/app?x=1"},
+		{"/", "//evil.example/", "/"},
+		{"/", "/\\evil.example/", "/"},
+		{"/", "https://evil.example/", "/"},
+		{"/app/{id}/", "/app/1/items", "/app/1/items"},
+		{"/app/{id}/", "/other", "/app/"},
+	}
+	for _, tc := range tests {
+		mw := &OIDCLoginMiddleware{BaseMiddleware: BaseMiddleware{Spec: BuildAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = tc.listenPath
+		})[0]}}
+		if got := mw.loginRedirect(tc.target); got != tc.want {
+			t.Errorf("%s %q: want %q, got %q", tc.listenPath, tc.target, tc.want, got)
+		}
+	}
+}
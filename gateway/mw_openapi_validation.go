@@ -0,0 +1,190 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/ins-tykgw/tyk/headers"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+// OpenAPIValidation checks requests against the OpenAPI document of the
+// API version. Paths and methods missing from the document are let through.
+// Requests to versions whose document couldn't be compiled are rejected.
+type OpenAPIValidation struct {
+	BaseMiddleware
+}
+
+func (k *OpenAPIValidation) Name() string {
+	return "OpenAPIValidation"
+}
+
+func (k *OpenAPIValidation) EnabledForSpec() bool {
+	for _, v := range k.Spec.VersionData.Versions {
+		if v.OpenAPI.Enabled {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (k *OpenAPIValidation) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	versionInfo, _, _, _ := k.Spec.Version(r)
+	if doc, ok := k.Spec.openAPIDocs[versionInfo.Name]; ok && doc == nil {
+		return errors.New("OpenAPI document could not be loaded"), http.StatusInternalServerError
+	}
+	op, pathParams := k.Spec.openAPIOperation(versionInfo.Name, r.URL.Path, r.Method)
+	if op == nil {
+		return nil, http.StatusOK
+	}
+
+	violations := op.validateRequest(r, pathParams)
+	if len(violations) == 0 {
+		return nil, http.StatusOK
+	}
+
+	code := versionInfo.OpenAPI.ErrorResponseCode
+	if code == 0 {
+		code = http.StatusUnprocessableEntity
+	}
+	return openAPIError(violations), code
+}
+
+// openAPIOperation matches a request path, including the listen path,
+// against the OpenAPI document of a version.
+func (a *APISpec) openAPIOperation(version, path, method string) (*openAPIOperation, map[string]string) {
+	doc := a.openAPIDocs[version]
+	if doc == nil {
+		return nil, nil
+	}
+	if a.Proxy.ListenPath != "/" {
+		path = strings.TrimPrefix(path, a.Proxy.ListenPath)
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return doc.match(method, path)
+}
+
+func openAPIError(violations []openAPIViolation) error {
+	messages := make([]string, len(violations))
+	details := make([]interface{}, len(violations))
+	for i, v := range violations {
+		messages[i] = v.String()
+		details[i] = v
+	}
+	return errorWithReason(ErrorReasonValidationFailed, errors.New(strings.Join(messages, "; ")), details...)
+}
+
+// OpenAPIResponseValidation checks upstream responses against the OpenAPI
+// document. It is added to the response chain of APIs with a version that
+// has ValidateResponses set.
+type OpenAPIResponseValidation struct {
+	Spec *APISpec
+}
+
+func (OpenAPIResponseValidation) Name() string {
+	return "OpenAPIResponseValidation"
+}
+
+func (h *OpenAPIResponseValidation) Init(c interface{}, spec *APISpec) error {
+	h.Spec = spec
+	return nil
+}
+
+func (h *OpenAPIResponseValidation) HandleResponse(rw http.ResponseWriter, res *http.Response, req *http.Request, ses *user.SessionState) error {
+	versionInfo, _, _, _ := h.Spec.Version(req)
+	conf := versionInfo.OpenAPI
+	if !conf.Enabled || !conf.ValidateResponses {
+		return nil
+	}
+
+	// match the path the client asked for, before any rewrite
+	path := ctxGetUrlRewritePath(req)
+	if path == "" {
+		path = req.URL.Path
+	}
+	op, _ := h.Spec.openAPIOperation(versionInfo.Name, path, ctxGetRequestMethod(req))
+	if op == nil {
+		return nil
+	}
+
+	// compressed bodies can't be checked, only their status and headers
+	var body []byte
+	if res.Header.Get(headers.ContentEncoding) == "" {
+		var err error
+		body, err = ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return err
+		}
+		res.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+
+	violations := op.validateResponse(res, body)
+	if len(violations) == 0 {
+		return nil
+	}
+
+	logger := log.WithFields(logrus.Fields{
+		"prefix": "openapi",
+		"api_id": h.Spec.APIID,
+		"path":   path,
+		"status": res.StatusCode,
+	})
+	if !conf.BlockInvalidResponses {
+		logger.Warning("Upstream response does not match the OpenAPI document: ", openAPIError(violations))
+		return nil
+	}
+	logger.Error("Blocked upstream response that does not match the OpenAPI document: ", openAPIError(violations))
+
+	h.replaceResponse(res, req, violations)
+	return nil
+}
+
+// replaceResponse turns res into a 502 Bad Gateway listing the violations.
+func (h *OpenAPIResponseValidation) replaceResponse(res *http.Response, req *http.Request, violations []openAPIViolation) {
+	const message = "Upstream response failed validation"
+	code := http.StatusBadGateway
+
+	details := make([]interface{}, len(violations))
+	for i, v := range violations {
+		details[i] = v
+	}
+
+	var body []byte
+	contentType := headers.ApplicationJSON
+	if h.Spec.ErrorOverrides.ProblemDetails {
+		contentType = problemContentType
+		body, _ = json.Marshal(problemDetails{
+			Type:      "about:blank",
+			Title:     http.StatusText(code),
+			Status:    code,
+			Detail:    message,
+			Instance:  req.URL.Path,
+			RequestID: errorRequestID(req),
+			Errors:    details,
+		})
+	} else {
+		body, _ = json.Marshal(map[string]interface{}{
+			"error":  message,
+			"errors": details,
+		})
+	}
+
+	res.StatusCode = code
+	res.Status = strconv.Itoa(code) + " " + http.StatusText(code)
+	res.Header = http.Header{}
+	res.Header.Set(headers.ContentType, contentType)
+	res.Header.Set(headers.ContentLength, strconv.Itoa(len(body)))
+	res.ContentLength = int64(len(body))
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+}
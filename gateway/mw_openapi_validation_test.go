@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+const testOpenAPIDocument = `{
+	"openapi": "3.0.0",
+	"paths": {
+		"/pets": {
+			"get": {
+				"parameters": [
+					{"name": "limit", "in": "query", "schema": {"type": "integer", "format": "int32", "maximum": 100}},
+					{"name": "tags", "in": "query", "schema": {"type": "array", "items": {"type": "string", "enum": ["cat", "dog"]}}},
+					{"name": "X-Tenant", "in": "header", "required": true, "schema": {"type": "string"}}
+				],
+				"responses": {
+					"200": {
+						"headers": {"X-Total": {"required": true, "schema": {"type": "integer"}}},
+						"content": {"application/json": {"schema": {"type": "array", "items": {"$ref": "#/components/schemas/Pet"}}}}
+					}
+				}
+			},
+			"post": {
+				"requestBody": {
+					"required": true,
+					"content": {
+						"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}},
+						"application/x-www-form-urlencoded": {"schema": {"$ref": "#/components/schemas/Pet"}}
+					}
+				},
+				"responses": {"default": {}}
+			}
+		},
+		"/pets/{id}": {
+			"parameters": [{"$ref": "#/components/parameters/id"}],
+			"get": {"responses": {"2XX": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/Pet"}}}}}}
+		},
+		"/pets/mine": {
+			"get": {"responses": {"200": {}}}
+		}
+	},
+	"components": {
+		"parameters": {
+			"id": {"name": "id", "in": "path", "schema": {"type": "string", "format": "uuid"}}
+		},
+		"schemas": {
+			"Pet": {
+				"type": "object",
+				"required": ["name"],
+				"properties": {
+					"name": {"type": "string"},
+					"age": {"type": "integer", "minimum": 0},
+					"born": {"type": "string", "format": "date", "nullable": true}
+				}
+			}
+		}
+	}
+}`
+
+func testPrepareOpenAPIValidation(upstreamURL string, conf apidef.OpenAPIValidation) {
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/api/"
+		spec.Proxy.StripListenPath = true
+		if upstreamURL != "" {
+			spec.Proxy.TargetURL = upstreamURL
+		}
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			json.Unmarshal([]byte(testOpenAPIDocument), &conf.Document)
+			conf.Enabled = true
+			v.OpenAPI = conf
+		})
+	})
+}
+
+func TestOpenAPIValidationRequest(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	testPrepareOpenAPIValidation("", apidef.OpenAPIValidation{})
+
+	tenant := map[string]string{"X-Tenant": "acme"}
+	form := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	ts.Run(t, []test.TestCase{
+		{Path: "/api/pets?limit=10&tags=cat,dog", Headers: tenant, Code: http.StatusOK},
+		{Path: "/api/pets", Code: http.StatusUnprocessableEntity, BodyMatch: "header X-Tenant: is required"},
+		{Path: "/api/pets?limit=ten", Headers: tenant, Code: http.StatusUnprocessableEntity, BodyMatch: "query limit"},
+		{Path: "/api/pets?limit=1000", Headers: tenant, Code: http.StatusUnprocessableEntity, BodyMatch: "query limit"},
+		{Path: "/api/pets?tags=cat,fish", Headers: tenant, Code: http.StatusUnprocessableEntity, BodyMatch: "query tags"},
+
+		{Method: http.MethodPost, Path: "/api/pets", Data: `{"name": "Rex", "born": null}`, Code: http.StatusOK},
+		{Method: http.MethodPost, Path: "/api/pets", Data: `{"age": -1, "born": "yesterday"}`, Code: http.StatusUnprocessableEntity, BodyMatch: "name is required"},
+		{Method: http.MethodPost, Path: "/api/pets", Code: http.StatusUnprocessableEntity, BodyMatch: "body: is required"},
+		{Method: http.MethodPost, Path: "/api/pets", Data: "name=Rex&age=3", Headers: form, Code: http.StatusOK},
+		{Method: http.MethodPost, Path: "/api/pets", Data: "age=3", Headers: form, Code: http.StatusUnprocessableEntity},
+		{Method: http.MethodPost, Path: "/api/pets", Data: "<pet/>", Headers: map[string]string{"Content-Type": "application/xml"}, Code: http.StatusUnprocessableEntity, BodyMatch: "is not allowed"},
+
+		{Path: "/api/pets/mine", Code: http.StatusOK},
+		{Path: "/api/pets/b3b1f9a4-5d3c-4d4e-9a53-6f1e0d8f2a11", Code: http.StatusOK},
+		{Path: "/api/pets/42", Code: http.StatusUnprocessableEntity, BodyMatch: "path id"},
+
+		// not in the document
+		{Path: "/api/owners", Code: http.StatusOK},
+		{Method: http.MethodDelete, Path: "/api/pets", Code: http.StatusOK},
+	}...)
+
+	t.Run("Problem details", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/api/"
+			spec.ErrorOverrides.ProblemDetails = true
+			UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+				v.OpenAPI.Enabled = true
+				v.OpenAPI.ErrorResponseCode = http.StatusBadRequest
+				json.Unmarshal([]byte(testOpenAPIDocument), &v.OpenAPI.Document)
+			})
+		})
+
+		ts.Run(t, test.TestCase{
+			Path:      "/api/pets?limit=ten",
+			Code:      http.StatusBadRequest,
+			BodyMatch: `{"in":"header","name":"X-Tenant","message":"is required"}`,
+		})
+	})
+
+	t.Run("Invalid document", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/api/"
+			UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+				v.OpenAPI.Enabled = true
+				v.OpenAPI.Document = map[string]interface{}{"openapi": "2.0"}
+			})
+		})
+
+		ts.Run(t, test.TestCase{Path: "/api/owners", Code: http.StatusInternalServerError,
+			BodyMatch: "OpenAPI document could not be loaded"})
+	})
+}
+
+func TestOpenAPIValidationResponse(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/pets":
+			if r.URL.Query().Get("limit") != "" {
+				w.Header().Set("X-Total", "1")
+			}
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`[{"name": "Rex"}]`))
+		case "/pets/mine":
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"age": "old"}`))
+		}
+	}))
+	defer upstream.Close()
+
+	tenant := map[string]string{"X-Tenant": "acme"}
+
+	t.Run("Log", func(t *testing.T) {
+		testPrepareOpenAPIValidation(upstream.URL, apidef.OpenAPIValidation{ValidateResponses: true})
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/api/pets", Headers: tenant, Code: http.StatusOK, BodyMatch: "Rex"},
+			{Path: "/api/pets/mine", Code: http.StatusNotFound},
+		}...)
+	})
+
+	t.Run("Block", func(t *testing.T) {
+		testPrepareOpenAPIValidation(upstream.URL, apidef.OpenAPIValidation{
+			ValidateResponses:     true,
+			BlockInvalidResponses: true,
+		})
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/api/pets?limit=1", Headers: tenant, Code: http.StatusOK, BodyMatch: "Rex"},
+			{Path: "/api/pets", Headers: tenant, Code: http.StatusBadGateway, BodyMatch: `"name":"X-Total","message":"is required"`},
+			{Path: "/api/pets/b3b1f9a4-5d3c-4d4e-9a53-6f1e0d8f2a11", Code: http.StatusBadGateway, BodyMatch: `"in":"response"`},
+			{Path: "/api/pets/mine", Code: http.StatusBadGateway, BodyMatch: "status 404 is not documented"},
+		}...)
+	})
+}
+
+func TestOpenAPICompile(t *testing.T) {
+	var conf apidef.OpenAPIValidation
+	json.Unmarshal([]byte(testOpenAPIDocument), &conf.Document)
+	doc, err := compileOpenAPI(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if op, params := doc.match(http.MethodGet, "/pets/mine"); op == nil || len(params) != 0 {
+		t.Error("concrete path should be matched before the templated one")
+	}
+	if _, params := doc.match(http.MethodGet, "/pets/a%20b"); params["id"] != "a b" {
+		t.Errorf("wanted unescaped path parameter, got %q", params["id"])
+	}
+
+	conf.Document["openapi"] = "2.0"
+	if _, err := compileOpenAPI(conf); err == nil {
+		t.Error("wanted error for Swagger 2 document")
+	}
+
+	conf.Document = map[string]interface{}{
+		"openapi": "3.0.1",
+		"paths": map[string]interface{}{
+			"/x": map[string]interface{}{"$ref": "#/components/pathItems/missing"},
+		},
+	}
+	if _, err := compileOpenAPI(conf); err == nil {
+		t.Error("wanted error for unresolved reference")
+	}
+}
@@ -39,7 +39,7 @@ func (k *RateLimitAndQuotaCheck) handleRateLimitFailure(r *http.Request, token s
 	// Report in health check
 	reportHealthValue(k.Spec, Throttle, "-1")
 
-	return errors.New("Rate limit exceeded"), http.StatusTooManyRequests
+	return errorWithReason(ErrorReasonRateLimited, errors.New("Rate limit exceeded")), http.StatusTooManyRequests
 }
 
 func (k *RateLimitAndQuotaCheck) handleQuotaFailure(r *http.Request, token string) (error, int) {
@@ -56,7 +56,7 @@ func (k *RateLimitAndQuotaCheck) handleQuotaFailure(r *http.Request, token strin
 	// Report in health check
 	reportHealthValue(k.Spec, QuotaViolation, "-1")
 
-	return errors.New("Quota exceeded"), http.StatusForbidden
+	return errorWithReason(ErrorReasonQuotaExceeded, errors.New("Quota exceeded")), http.StatusForbidden
 }
 
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
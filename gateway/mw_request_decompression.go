@@ -0,0 +1,119 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/andybalholm/brotli"
+
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+// defaultMaxDecompressedSize caps decoded request bodies when the API
+// doesn't set a limit.
+const defaultMaxDecompressedSize = 10 << 20
+
+// RequestDecompressionMiddleware decodes compressed request bodies, so the
+// rest of the chain and the upstream see the plain body.
+type RequestDecompressionMiddleware struct {
+	BaseMiddleware
+}
+
+func (m *RequestDecompressionMiddleware) Name() string {
+	return "RequestDecompressionMiddleware"
+}
+
+func (m *RequestDecompressionMiddleware) EnabledForSpec() bool {
+	return m.Spec.RequestDecompression.Enabled
+}
+
+func (m *RequestDecompressionMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	encodings := contentEncodings(r.Header.Get(headers.ContentEncoding))
+	if len(encodings) == 0 || r.Body == nil {
+		return nil, http.StatusOK
+	}
+
+	maxSize := m.Spec.RequestDecompression.MaxSize
+	if maxSize <= 0 {
+		maxSize = defaultMaxDecompressedSize
+	}
+
+	defer r.Body.Close()
+	var body io.Reader = r.Body
+	// encodings are listed in the order they were applied
+	for i := len(encodings) - 1; i >= 0; i-- {
+		decoder, err := newBodyDecoder(encodings[i], body)
+		if err == errUnsupportedEncoding {
+			return fmt.Errorf("Content encoding %q is not supported", encodings[i]), http.StatusUnsupportedMediaType
+		}
+		if err != nil {
+			m.Logger().WithError(err).Debug("Failed to decompress request body")
+			return errorWithReason(ErrorReasonValidationFailed, errors.New("Request body could not be decompressed")), http.StatusBadRequest
+		}
+		body = decoder
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(body, maxSize+1))
+	if err != nil {
+		m.Logger().WithError(err).Debug("Failed to decompress request body")
+		return errorWithReason(ErrorReasonValidationFailed, errors.New("Request body could not be decompressed")), http.StatusBadRequest
+	}
+	if int64(len(decoded)) > maxSize {
+		m.Logger().WithFields(logrus.Fields{"limit": maxSize}).Info("Attempted access with a request that is too large once decompressed, blocked.")
+		return errorWithReason(ErrorReasonBodyTooLarge, errors.New("Request is too large")), http.StatusRequestEntityTooLarge
+	}
+
+	r.Body = ioutil.NopCloser(bytes.NewReader(decoded))
+	r.ContentLength = int64(len(decoded))
+	r.Header.Set(headers.ContentLength, strconv.Itoa(len(decoded)))
+	r.Header.Del(headers.ContentEncoding)
+
+	return nil, http.StatusOK
+}
+
+var errUnsupportedEncoding = errors.New("unsupported content encoding")
+
+// contentEncodings splits a Content-Encoding header, leaving out identity.
+func contentEncodings(header string) []string {
+	var encodings []string
+	for _, enc := range strings.Split(header, ",") {
+		enc = strings.ToLower(strings.TrimSpace(enc))
+		if enc != "" && enc != "identity" {
+			encodings = append(encodings, enc)
+		}
+	}
+	return encodings
+}
+
+func newBodyDecoder(encoding string, r io.Reader) (io.Reader, error) {
+	switch encoding {
+	case "gzip", "x-gzip":
+		return gzip.NewReader(r)
+	case "deflate":
+		// deflate should be zlib wrapped, but some clients send raw
+		// deflate data
+		br := bufio.NewReader(r)
+		header, err := br.Peek(2)
+		if err != nil {
+			return nil, err
+		}
+		if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case "br":
+		return brotli.NewReader(r), nil
+	}
+	return nil, errUnsupportedEncoding
+}
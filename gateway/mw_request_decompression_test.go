@@ -0,0 +1,111 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func compressBody(t testing.TB, encoding string, body []byte) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	case "br":
+		w = brotli.NewWriter(&buf)
+	default:
+		t.Fatalf("unknown encoding %q", encoding)
+	}
+	w.Write(body)
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestRequestDecompression(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.RequestDecompression.Enabled = true
+		spec.RequestDecompression.MaxSize = 1000
+	})
+
+	body := []byte(`{"name":"tyk"}`)
+	plainBody := `"Body":"{\"name\":\"tyk\"}"`
+
+	for _, encoding := range []string{"gzip", "deflate", "br"} {
+		t.Run(encoding, func(t *testing.T) {
+			ts.Run(t, test.TestCase{
+				Method: http.MethodPost, Path: "/post", Code: http.StatusOK,
+				Data:         compressBody(t, encoding, body),
+				Headers:      map[string]string{"Content-Encoding": encoding},
+				BodyMatch:    plainBody,
+				BodyNotMatch: `"Content-Encoding"`,
+			})
+		})
+	}
+
+	t.Run("raw deflate", func(t *testing.T) {
+		ts.Run(t, test.TestCase{
+			Method: http.MethodPost, Path: "/post", Code: http.StatusOK,
+			Data:      compressBody(t, "raw-deflate", body),
+			Headers:   map[string]string{"Content-Encoding": "deflate"},
+			BodyMatch: plainBody,
+		})
+	})
+
+	t.Run("several encodings", func(t *testing.T) {
+		ts.Run(t, test.TestCase{
+			Method: http.MethodPost, Path: "/post", Code: http.StatusOK,
+			Data:      compressBody(t, "br", compressBody(t, "gzip", body)),
+			Headers:   map[string]string{"Content-Encoding": "gzip, br"},
+			BodyMatch: plainBody,
+		})
+	})
+
+	t.Run("not compressed", func(t *testing.T) {
+		ts.Run(t, test.TestCase{
+			Method: http.MethodPost, Path: "/post", Code: http.StatusOK,
+			Data:      body,
+			BodyMatch: plainBody,
+		})
+	})
+
+	t.Run("too large once decompressed", func(t *testing.T) {
+		ts.Run(t, test.TestCase{
+			Method: http.MethodPost, Path: "/post", Code: http.StatusRequestEntityTooLarge,
+			Data:    compressBody(t, "gzip", make([]byte, 1<<20)),
+			Headers: map[string]string{"Content-Encoding": "gzip"},
+		})
+	})
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		ts.Run(t, test.TestCase{
+			Method: http.MethodPost, Path: "/post", Code: http.StatusUnsupportedMediaType,
+			Data:    body,
+			Headers: map[string]string{"Content-Encoding": "compress"},
+		})
+	})
+
+	t.Run("invalid data", func(t *testing.T) {
+		ts.Run(t, test.TestCase{
+			Method: http.MethodPost, Path: "/post", Code: http.StatusBadRequest,
+			Data:    body,
+			Headers: map[string]string{"Content-Encoding": "gzip"},
+		})
+	})
+}
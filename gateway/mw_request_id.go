@@ -0,0 +1,100 @@
+package gateway
+
+import (
+	"crypto/rand"
+	"net/http"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	defaultRequestIDHeader = "X-Request-ID"
+	// longest incoming request ID that is accepted
+	maxRequestIDLength = 128
+)
+
+// RequestIDMiddleware sets a correlation ID on each request. It runs at the
+// start of the chain so that every middleware, log entry and analytics
+// record can use it.
+type RequestIDMiddleware struct {
+	BaseMiddleware
+}
+
+func (m *RequestIDMiddleware) Name() string {
+	return "RequestIDMiddleware"
+}
+
+func (m *RequestIDMiddleware) EnabledForSpec() bool {
+	return m.Spec.GlobalConfig.RequestID.Enabled
+}
+
+func (m *RequestIDMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Request, _ interface{}) (error, int) {
+	conf := m.Spec.GlobalConfig.RequestID
+	header := requestIDHeader(conf.Header)
+
+	id := r.Header.Get(header)
+	if !validRequestID(id) {
+		if conf.Generator == "ulid" {
+			id = newULID(time.Now())
+		} else {
+			id = uuid.NewV4().String()
+		}
+	}
+
+	ctxSetRequestID(r, id)
+	r.Header.Set(header, id)
+	w.Header().Set(header, id)
+
+	return nil, http.StatusOK
+}
+
+func requestIDHeader(header string) string {
+	if header == "" {
+		return defaultRequestIDHeader
+	}
+	return header
+}
+
+// validRequestID reports whether an incoming ID is safe to log and
+// forward: printable ASCII without spaces, of a bounded length.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < '!' || id[i] > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+const crockfordBase32 = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID, a 48 bit millisecond timestamp followed by 80
+// random bits in Crockford's base32, so IDs sort by creation time.
+func newULID(t time.Time) string {
+	var b [16]byte
+	ms := uint64(t.UnixNano() / int64(time.Millisecond))
+	for i := 0; i < 6; i++ {
+		b[i] = byte(ms >> uint(40-8*i))
+	}
+	rand.Read(b[6:])
+
+	// 26 characters of 5 bits hold the 128 bits, the top 2 bits are 0
+	var out [26]byte
+	for i := range out {
+		var v byte
+		for j := 0; j < 5; j++ {
+			// bit position counted from the most significant of 130 bits
+			bit := 5*i + j - 2
+			v <<= 1
+			if bit >= 0 && b[bit/8]>>uint(7-bit%8)&1 == 1 {
+				v |= 1
+			}
+		}
+		out[i] = crockfordBase32[v]
+	}
+	return string(out[:])
+}
@@ -0,0 +1,98 @@
+package gateway
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func TestRequestID(t *testing.T) {
+	globalConf := config.Global()
+	globalConf.RequestID.Enabled = true
+	globalConf.RequestID.Generator = "ulid"
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	ts := StartTest(TestConfig{
+		Delay: 20 * time.Millisecond,
+	})
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.EnableContextVars = true
+	}, func(spec *APISpec) {
+		spec.APIID = "protected"
+		spec.Proxy.ListenPath = "/protected/"
+		spec.UseKeylessAccess = false
+		spec.ErrorOverrides.ProblemDetails = true
+	})
+
+	t.Run("Generated", func(t *testing.T) {
+		resp, _ := ts.Run(t, test.TestCase{Path: "/", Code: http.StatusOK})
+		if id := resp.Header.Get("X-Request-ID"); len(id) != 26 {
+			t.Errorf("wanted a ULID request ID, got %q", id)
+		}
+	})
+
+	t.Run("Incoming", func(t *testing.T) {
+		ts.Run(t, []test.TestCase{
+			{
+				Path: "/", Headers: map[string]string{"X-Request-ID": "abc-123"}, Code: http.StatusOK,
+				HeadersMatch: map[string]string{"X-Request-ID": "abc-123"},
+				BodyMatch:    `"X-Request-Id":"abc-123"`,
+			},
+			{
+				// invalid IDs are replaced
+				Path: "/", Headers: map[string]string{"X-Request-ID": "a b"}, Code: http.StatusOK,
+				HeadersNotMatch: map[string]string{"X-Request-ID": "a b"},
+			},
+		}...)
+	})
+
+	t.Run("Errors and analytics", func(t *testing.T) {
+		// let records to to be sent
+		time.Sleep(recordsBufferFlushInterval + 50)
+		analytics.Store.GetAndDeleteSet(analyticsKeyName)
+
+		ts.Run(t, test.TestCase{
+			Path: "/protected/", Headers: map[string]string{"X-Request-ID": "err-1"}, Code: http.StatusUnauthorized,
+			HeadersMatch: map[string]string{"X-Request-ID": "err-1"},
+			BodyMatch:    `"request_id":"err-1"`,
+		})
+
+		time.Sleep(recordsBufferFlushInterval + 50)
+		results := analytics.Store.GetAndDeleteSet(analyticsKeyName)
+		if len(results) != 1 {
+			t.Fatal("Should return 1 record: ", len(results))
+		}
+		var record AnalyticsRecord
+		msgpack.Unmarshal(results[0].([]byte), &record)
+		if record.RequestID != "err-1" {
+			t.Errorf("wanted request ID in analytics record, got %q", record.RequestID)
+		}
+	})
+}
+
+func TestNewULID(t *testing.T) {
+	now := time.Now()
+	a, b := newULID(now), newULID(now.Add(time.Millisecond))
+	if len(a) != 26 || strings.Trim(a, crockfordBase32) != "" {
+		t.Fatalf("invalid ULID %q", a)
+	}
+	if a[0] > '7' {
+		t.Fatalf("ULID %q overflows 128 bits", a)
+	}
+	if a[:10] >= b[:10] {
+		t.Errorf("ULIDs should sort by time: %q, %q", a, b)
+	}
+	if got := newULID(time.Unix(0, 0))[:10]; got != "0000000000" {
+		t.Errorf("wanted zero timestamp, got %q", got)
+	}
+}
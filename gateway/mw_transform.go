@@ -2,6 +2,7 @@ package gateway
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -101,7 +102,7 @@ func transformBody(r *http.Request, tmeta *TransformSpec, contextVars bool) erro
 
 	// Apply to template
 	var bodyBuffer bytes.Buffer
-	if err := tmeta.Template.Execute(&bodyBuffer, bodyData); err != nil {
+	if err := tmeta.execute(r.Context(), &bodyBuffer, bodyData); err != nil {
 		return fmt.Errorf("failed to apply template to request: %v", err)
 	}
 	r.Body = ioutil.NopCloser(&bodyBuffer)
@@ -109,3 +110,23 @@ func transformBody(r *http.Request, tmeta *TransformSpec, contextVars bool) erro
 
 	return nil
 }
+
+// execute renders the template, or for the jq engine runs the filter
+// and writes its output as JSON.
+func (t *TransformSpec) execute(ctx context.Context, w io.Writer, data map[string]interface{}) error {
+	if t.Filter == nil {
+		return t.Template.Execute(w, data)
+	}
+	ctx, cancel := context.WithTimeout(ctx, jqTimeout(t.TemplateData.Timeout))
+	defer cancel()
+	value, err := t.Filter.Handle(ctx, data)
+	if err != nil {
+		return err
+	}
+	out, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
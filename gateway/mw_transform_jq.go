@@ -1,20 +1,39 @@
-// +build jq
-
 package gateway
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"time"
 
 	"github.com/Sirupsen/logrus"
 
 	"github.com/ins-tykgw/tyk/apidef"
 )
 
+// defaultJQTimeout is how long a jq filter can run when its definition
+// doesn't set a timeout.
+const defaultJQTimeout = 100 * time.Millisecond
+
+// jqFilter is a compiled jq program. Builds with the jq tag use libjq,
+// others the pure Go engine in the jq package.
+type jqFilter interface {
+	// Handle runs the filter on value and returns its first output. It
+	// gives up with ctx's error once ctx is done.
+	Handle(ctx context.Context, value interface{}) (interface{}, error)
+}
+
+func jqTimeout(ms int) time.Duration {
+	if ms <= 0 {
+		return defaultJQTimeout
+	}
+	return time.Duration(ms) * time.Millisecond
+}
+
 type TransformJQMiddleware struct {
 	BaseMiddleware
 }
@@ -77,8 +96,11 @@ func (t *TransformJQMiddleware) transformJQBody(r *http.Request, ts *TransformJQ
 		"body":         bodyObj,
 		"_tyk_context": ctxGetData(r),
 	}
+	if session := ctxGetSession(r); session != nil {
+		jqObj["_tyk_meta"] = session.MetaData
+	}
 
-	jqResult, err := lockedJQTransform(t.Spec, ts, jqObj)
+	jqResult, err := runJQTransform(r.Context(), ts, jqObj)
 	if err != nil {
 		return err
 	}
@@ -105,10 +127,10 @@ func (t *TransformJQMiddleware) transformJQBody(r *http.Request, ts *TransformJQ
 	return nil
 }
 
-func lockedJQTransform(s *APISpec, t *TransformJQSpec, jqObj map[string]interface{}) (JQResult, error) {
-	s.Lock()
-	value, err := t.JQFilter.Handle(jqObj)
-	s.Unlock()
+func runJQTransform(ctx context.Context, t *TransformJQSpec, jqObj map[string]interface{}) (JQResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, jqTimeout(t.Timeout))
+	value, err := t.JQFilter.Handle(ctx, jqObj)
+	cancel()
 	if err != nil {
 		return JQResult{}, err
 	}
@@ -149,7 +171,7 @@ func lockedJQTransform(s *APISpec, t *TransformJQSpec, jqObj map[string]interfac
 
 type TransformJQSpec struct {
 	apidef.TransformJQMeta
-	JQFilter *JQ
+	JQFilter jqFilter
 }
 
 func (a *APIDefinitionLoader) compileTransformJQPathSpec(paths []apidef.TransformJQMeta, stat URLStatus) []URLSpec {
@@ -162,7 +184,7 @@ func (a *APIDefinitionLoader) compileTransformJQPathSpec(paths []apidef.Transfor
 		newTransformSpec := TransformJQSpec{TransformJQMeta: stringSpec}
 
 		var err error
-		newTransformSpec.JQFilter, err = NewJQ(stringSpec.Filter)
+		newTransformSpec.JQFilter, err = newJQFilter(stringSpec.Filter)
 
 		if stat == TransformedJQ {
 			newSpec.TransformJQAction = newTransformSpec
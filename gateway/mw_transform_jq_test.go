@@ -1,5 +1,3 @@
-// +build jq
-
 package gateway
 
 import (
@@ -7,6 +5,7 @@ import (
 
 	"github.com/ins-tykgw/tyk/apidef"
 	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
 )
 
 func testPrepareJQMiddleware() {
@@ -40,6 +39,58 @@ func TestJQMiddleware(t *testing.T) {
 	}...)
 }
 
+func TestJQMiddlewareSession(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.UseKeylessAccess = false
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.TransformJQ = []apidef.TransformJQMeta{{
+				Path:   "/jq",
+				Method: "POST",
+				Filter: `{"body": {"tier": ._tyk_meta.tier, "foo": .body.foo}}`,
+			}}
+		})
+	})
+
+	key := CreateSession(func(s *user.SessionState) {
+		s.MetaData = map[string]interface{}{"tier": "gold"}
+	})
+
+	ts.Run(t, test.TestCase{
+		Path: "/jq", Method: "POST", Data: `{"foo": "bar"}`, Code: 200,
+		Headers:   map[string]string{"Authorization": key},
+		BodyMatch: `{\"foo\":\"bar\",\"tier\":\"gold\"}`,
+	})
+}
+
+func TestJQResponseMiddleware(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.ResponseProcessors = []apidef.ResponseProcessor{{Name: "response_body_transform_jq"}}
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.TransformJQResponse = []apidef.TransformJQMeta{{
+				Path:   "/jq",
+				Method: "GET",
+				Filter: `{"body": {"method": .body.Method, "type": ._tyk_response_headers["Content-Type"][0]}, "rewrite_headers": {"X-Transformed": "yes"}}`,
+			}}
+		})
+	})
+
+	ts.Run(t, test.TestCase{
+		Path: "/jq", Code: 200,
+		BodyMatch:    `{"method":"GET","type":"text/plain; charset=utf-8"}`,
+		HeadersMatch: map[string]string{"X-Transformed": "yes"},
+	})
+}
+
 func BenchmarkJQMiddleware(b *testing.B) {
 	b.ReportAllocs()
 
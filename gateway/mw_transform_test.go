@@ -269,3 +269,40 @@ func TestBodyTransformCaseSensitivity(t *testing.T) {
 		assert("/Get", "/Get", `{"http_method":"GET"}`)
 	})
 }
+
+func TestTransformJQEngine(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	filter := func(source string) apidef.TemplateData {
+		return apidef.TemplateData{
+			Input:          apidef.RequestJSON,
+			Mode:           apidef.UseBlob,
+			Engine:         apidef.JQTemplateEngine,
+			TemplateSource: base64.StdEncoding.EncodeToString([]byte(source)),
+		}
+	}
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.EnableContextVars = true
+		spec.ResponseProcessors = []apidef.ResponseProcessor{{Name: "response_body_transform"}}
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.ExtendedPaths.Transform = []apidef.TemplateMeta{{
+				Path:         "/request",
+				Method:       "POST",
+				TemplateData: filter(`{user: .name, path: ._tyk_context.path}`),
+			}}
+			v.ExtendedPaths.TransformResponse = []apidef.TemplateMeta{{
+				Path:         "/response",
+				Method:       "GET",
+				TemplateData: filter(`{method: .Method, agent: .Headers["User-Agent"]}`),
+			}}
+		})
+	})
+
+	ts.Run(t, []test.TestCase{
+		{Path: "/request", Method: "POST", Data: `{"name":"bob"}`, Code: 200, BodyMatch: `{\"path\":\"/request\",\"user\":\"bob\"}`},
+		{Path: "/response", Headers: map[string]string{"User-Agent": "jq-test"}, Code: 200, BodyMatch: `{"agent":"jq-test","method":"GET"}`},
+	}...)
+}
@@ -54,103 +54,11 @@ func urlRewrite(meta *apidef.URLRewriteMeta, r *http.Request) (string, error) {
 		}
 
 		for tn, triggerOpts := range meta.Triggers {
-			checkAny := false
-			setCount := 0
-			if triggerOpts.On == apidef.Any {
-				checkAny = true
-			}
-
-			// Check headers
-			if len(triggerOpts.Options.HeaderMatches) > 0 {
-				if checkHeaderTrigger(r, triggerOpts.Options.HeaderMatches, checkAny, tn) {
-					setCount += 1
-					if checkAny {
-						rewriteToPath = triggerOpts.RewriteTo
-						break
-					}
-				}
-			}
-
-			// Check query string
-			if len(triggerOpts.Options.QueryValMatches) > 0 {
-				if checkQueryString(r, triggerOpts.Options.QueryValMatches, checkAny, tn) {
-					setCount += 1
-					if checkAny {
-						rewriteToPath = triggerOpts.RewriteTo
-						break
-					}
-				}
-			}
-
-			// Check path parts
-			if len(triggerOpts.Options.PathPartMatches) > 0 {
-				if checkPathParts(r, triggerOpts.Options.PathPartMatches, checkAny, tn) {
-					setCount += 1
-					if checkAny {
-						rewriteToPath = triggerOpts.RewriteTo
-						break
-					}
-				}
-			}
-
-			// Check session meta
-			if session := ctxGetSession(r); session != nil {
-				if len(triggerOpts.Options.SessionMetaMatches) > 0 {
-					if checkSessionTrigger(r, session, triggerOpts.Options.SessionMetaMatches, checkAny, tn) {
-						setCount += 1
-						if checkAny {
-							rewriteToPath = triggerOpts.RewriteTo
-							break
-						}
-					}
-				}
-			}
-
-			// Request context meta
-			if len(triggerOpts.Options.RequestContextMatches) > 0 {
-				if checkContextTrigger(r, triggerOpts.Options.RequestContextMatches, checkAny, tn) {
-					setCount += 1
-					if checkAny {
-						rewriteToPath = triggerOpts.RewriteTo
-						break
-					}
-				}
-			}
-
-			// Check payload
-			if triggerOpts.Options.PayloadMatches.MatchPattern != "" {
-				if checkPayload(r, triggerOpts.Options.PayloadMatches, tn) {
-					setCount += 1
-					if checkAny {
-						rewriteToPath = triggerOpts.RewriteTo
-						break
-					}
-				}
-			}
-
-			if !checkAny {
-				// Set total count:
-				total := 0
-				if len(triggerOpts.Options.HeaderMatches) > 0 {
-					total += 1
-				}
-				if len(triggerOpts.Options.QueryValMatches) > 0 {
-					total += 1
-				}
-				if len(triggerOpts.Options.PathPartMatches) > 0 {
-					total += 1
-				}
-				if len(triggerOpts.Options.SessionMetaMatches) > 0 {
-					total += 1
-				}
-				if len(triggerOpts.Options.RequestContextMatches) > 0 {
-					total += 1
-				}
-				if triggerOpts.Options.PayloadMatches.MatchPattern != "" {
-					total += 1
-				}
-				if total == setCount {
-					rewriteToPath = triggerOpts.RewriteTo
+			checkAny := triggerOpts.On == apidef.Any
+			if checkTriggerOptions(r, triggerOpts.Options, checkAny, tn) {
+				rewriteToPath = triggerOpts.RewriteTo
+				if checkAny {
+					break
 				}
 			}
 		}
@@ -286,31 +194,7 @@ func (m *URLRewriteMiddleware) InitTriggerRx() {
 
 			for trKey := range rewrite.Triggers {
 				tr := rewrite.Triggers[trKey]
-
-				for key, h := range tr.Options.HeaderMatches {
-					h.Init()
-					tr.Options.HeaderMatches[key] = h
-				}
-				for key, q := range tr.Options.QueryValMatches {
-					q.Init()
-					tr.Options.QueryValMatches[key] = q
-				}
-				for key, h := range tr.Options.SessionMetaMatches {
-					h.Init()
-					tr.Options.SessionMetaMatches[key] = h
-				}
-				for key, h := range tr.Options.RequestContextMatches {
-					h.Init()
-					tr.Options.RequestContextMatches[key] = h
-				}
-				for key, h := range tr.Options.PathPartMatches {
-					h.Init()
-					tr.Options.PathPartMatches[key] = h
-				}
-				if tr.Options.PayloadMatches.MatchPattern != "" {
-					tr.Options.PayloadMatches.Init()
-				}
-
+				initTriggerOptions(&tr.Options)
 				rewrite.Triggers[trKey] = tr
 			}
 
@@ -389,6 +273,128 @@ func (m *URLRewriteMiddleware) ProcessRequest(w http.ResponseWriter, r *http.Req
 	return nil, http.StatusOK
 }
 
+// initTriggerOptions compiles the regular expressions of trigger options.
+func initTriggerOptions(opts *apidef.RoutingTriggerOptions) {
+	for key, h := range opts.HeaderMatches {
+		h.Init()
+		opts.HeaderMatches[key] = h
+	}
+	for key, q := range opts.QueryValMatches {
+		q.Init()
+		opts.QueryValMatches[key] = q
+	}
+	for key, h := range opts.SessionMetaMatches {
+		h.Init()
+		opts.SessionMetaMatches[key] = h
+	}
+	for key, h := range opts.RequestContextMatches {
+		h.Init()
+		opts.RequestContextMatches[key] = h
+	}
+	for key, h := range opts.PathPartMatches {
+		h.Init()
+		opts.PathPartMatches[key] = h
+	}
+	if opts.PayloadMatches.MatchPattern != "" {
+		opts.PayloadMatches.Init()
+	}
+}
+
+// checkTriggerOptions reports whether the request matches any or all of
+// the trigger options. Matches are added to the context data, which must
+// be set.
+func checkTriggerOptions(r *http.Request, opts apidef.RoutingTriggerOptions, any bool, triggernum int) bool {
+	setCount := 0
+
+	// Check headers
+	if len(opts.HeaderMatches) > 0 {
+		if checkHeaderTrigger(r, opts.HeaderMatches, any, triggernum) {
+			setCount += 1
+			if any {
+				return true
+			}
+		}
+	}
+
+	// Check query string
+	if len(opts.QueryValMatches) > 0 {
+		if checkQueryString(r, opts.QueryValMatches, any, triggernum) {
+			setCount += 1
+			if any {
+				return true
+			}
+		}
+	}
+
+	// Check path parts
+	if len(opts.PathPartMatches) > 0 {
+		if checkPathParts(r, opts.PathPartMatches, any, triggernum) {
+			setCount += 1
+			if any {
+				return true
+			}
+		}
+	}
+
+	// Check session meta
+	if session := ctxGetSession(r); session != nil {
+		if len(opts.SessionMetaMatches) > 0 {
+			if checkSessionTrigger(r, session, opts.SessionMetaMatches, any, triggernum) {
+				setCount += 1
+				if any {
+					return true
+				}
+			}
+		}
+	}
+
+	// Request context meta
+	if len(opts.RequestContextMatches) > 0 {
+		if checkContextTrigger(r, opts.RequestContextMatches, any, triggernum) {
+			setCount += 1
+			if any {
+				return true
+			}
+		}
+	}
+
+	// Check payload
+	if opts.PayloadMatches.MatchPattern != "" {
+		if checkPayload(r, opts.PayloadMatches, triggernum) {
+			setCount += 1
+			if any {
+				return true
+			}
+		}
+	}
+
+	if any {
+		return false
+	}
+
+	// Set total count:
+	total := 0
+	if len(opts.HeaderMatches) > 0 {
+		total += 1
+	}
+	if len(opts.QueryValMatches) > 0 {
+		total += 1
+	}
+	if len(opts.PathPartMatches) > 0 {
+		total += 1
+	}
+	if len(opts.SessionMetaMatches) > 0 {
+		total += 1
+	}
+	if len(opts.RequestContextMatches) > 0 {
+		total += 1
+	}
+	if opts.PayloadMatches.MatchPattern != "" {
+		total += 1
+	}
+	return total == setCount
+}
+
 func checkHeaderTrigger(r *http.Request, options map[string]apidef.StringRegexMap, any bool, triggernum int) bool {
 	contextData := ctxGetData(r)
 	fCount := 0
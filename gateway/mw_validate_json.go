@@ -59,7 +59,7 @@ func (k *ValidateJSON) ProcessRequest(w http.ResponseWriter, r *http.Request, _
 	// Perform validation
 	result, err := gojsonschema.Validate(vPathMeta.SchemaCache, inputLoader)
 	if err != nil {
-		return fmt.Errorf("JSON parsing error: %v", err), http.StatusBadRequest
+		return errorWithReason(ErrorReasonValidationFailed, fmt.Errorf("JSON parsing error: %v", err)), http.StatusBadRequest
 	}
 
 	// Handle Failure
@@ -77,7 +77,9 @@ func (k *ValidateJSON) ProcessRequest(w http.ResponseWriter, r *http.Request, _
 
 func (k *ValidateJSON) formatError(schemaErrors []gojsonschema.ResultError) error {
 	errStr := ""
+	details := make([]interface{}, len(schemaErrors))
 	for i, desc := range schemaErrors {
+		details[i] = desc.String()
 		if i == 0 {
 			errStr = desc.String()
 		} else {
@@ -85,5 +87,5 @@ func (k *ValidateJSON) formatError(schemaErrors []gojsonschema.ResultError) erro
 		}
 	}
 
-	return errors.New(errStr)
+	return errorWithReason(ErrorReasonValidationFailed, errors.New(errStr), details...)
 }
@@ -1,11 +1,17 @@
 package gateway
 
 import (
+	"bytes"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"text/template"
 	"time"
 
 	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/regexp"
 	"github.com/ins-tykgw/tyk/request"
 )
 
@@ -23,16 +29,138 @@ func (v *VersionCheck) Name() string {
 	return "VersionCheck"
 }
 
-func (v *VersionCheck) DoMockReply(w http.ResponseWriter, meta interface{}) {
+var mockPathParam = regexp.MustCompile(`{([^}]*)}`)
+
+// mockReply is a compiled reply method action.
+type mockReply struct {
+	apidef.EndpointMethodMeta
+	pathRx     *regexp.Regexp
+	pathParams []string
+	reply      mockResponse
+	variants   []mockVariant
+}
+
+type mockVariant struct {
+	any      bool
+	options  apidef.RoutingTriggerOptions
+	response mockResponse
+}
+
+type mockResponse struct {
+	code    int
+	data    string
+	headers map[string]string
+	// body and headerTmpls are only set for templated replies
+	body        *template.Template
+	headerTmpls map[string]*template.Template
+}
+
+// DoMockReply replies with the mock response that matches the request,
+// after any delay, or fails the request for the share set in FailureRate.
+func (v *VersionCheck) DoMockReply(w http.ResponseWriter, r *http.Request, mock *mockReply) (error, int) {
+	if mock.Delay > 0 || mock.DelayJitter > 0 {
+		delay := time.Duration(mock.Delay) * time.Millisecond
+		if mock.DelayJitter > 0 {
+			delay += time.Duration(rand.Intn(mock.DelayJitter+1)) * time.Millisecond
+		}
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			// the client went away, there is no one to reply to
+			return nil, mwStatusRespond
+		}
+	}
+
+	if mock.FailureRate > 0 && rand.Float64() < mock.FailureRate {
+		code := mock.FailureCode
+		if code == 0 {
+			code = http.StatusInternalServerError
+		}
+		return errors.New("Mock failure"), code
+	}
+
+	if len(mock.variants) > 0 || mock.Template {
+		// triggers and templates use the body and context variables
+		nopCloseRequestBody(r)
+		if ctxGetData(r) == nil {
+			ctxSetData(r, contextVarsData(r))
+		}
+	}
+
+	res := &mock.reply
+	for i := range mock.variants {
+		variant := &mock.variants[i]
+		if checkTriggerOptions(r, variant.options, variant.any, i) {
+			res = &variant.response
+			break
+		}
+	}
+
 	// Reply with some alternate data
-	emeta := meta.(*apidef.EndpointMethodMeta)
-	responseMessage := []byte(emeta.Data)
-	for header, value := range emeta.Headers {
+	var data map[string]interface{}
+	if mock.Template {
+		data = mock.templateData(r)
+	}
+	for header, value := range res.headers {
+		if tmpl := res.headerTmpls[header]; tmpl != nil {
+			value = executeMockTemplate(r, tmpl, data)
+		}
 		w.Header().Add(header, value)
 	}
 
-	w.WriteHeader(emeta.Code)
-	w.Write(responseMessage)
+	code := res.code
+	if code == 0 {
+		code = http.StatusOK
+	}
+	w.WriteHeader(code)
+
+	if res.body != nil {
+		w.Write([]byte(executeMockTemplate(r, res.body, data)))
+	} else {
+		w.Write([]byte(res.data))
+	}
+	return nil, mwStatusRespond
+}
+
+// templateData is the data mock templates are executed with.
+func (m *mockReply) templateData(r *http.Request) map[string]interface{} {
+	params := make(map[string]string, len(m.pathParams))
+	if m.pathRx != nil {
+		if match := m.pathRx.FindStringSubmatch(r.URL.Path); len(match) == len(m.pathParams)+1 {
+			for i, name := range m.pathParams {
+				params[name] = match[i+1]
+			}
+		}
+	}
+
+	body, _ := ioutil.ReadAll(r.Body)
+	var bodyJSON interface{}
+	json.Unmarshal(body, &bodyJSON)
+
+	data := map[string]interface{}{
+		"Method":       r.Method,
+		"Path":         r.URL.Path,
+		"Query":        r.URL.Query(),
+		"Headers":      r.Header,
+		"Params":       params,
+		"Body":         string(body),
+		"JSON":         bodyJSON,
+		"_tyk_context": ctxGetData(r),
+	}
+	if session := ctxGetSession(r); session != nil {
+		data["_tyk_meta"] = session.MetaData
+	}
+	return data
+}
+
+// executeMockTemplate renders a mock template, then any $tyk_context and
+// $tyk_meta variables in the result.
+func executeMockTemplate(r *http.Request, tmpl *template.Template, data map[string]interface{}) string {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		log.WithError(err).Error("Failed to execute mock reply template")
+	}
+	return replaceTykVariables(r, buf.String(), false)
 }
 
 // ProcessRequest will run any checks on the request on the way through the system, return an error to have the chain fail
@@ -55,8 +183,7 @@ func (v *VersionCheck) ProcessRequest(w http.ResponseWriter, r *http.Request, _
 
 	// We handle redirects before ignores in case we aren't using a whitelist
 	if stat == StatusRedirectFlowByReply {
-		v.DoMockReply(w, meta)
-		return nil, mwStatusRespond
+		return v.DoMockReply(w, r, meta.(*mockReply))
 	}
 
 	if expTime, _ := meta.(*time.Time); expTime != nil {
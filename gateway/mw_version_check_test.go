@@ -3,6 +3,7 @@ package gateway
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/ins-tykgw/tyk/apidef"
 	"github.com/ins-tykgw/tyk/test"
@@ -158,3 +159,93 @@ func BenchmarkVersioning(b *testing.B) {
 		}...)
 	}
 }
+
+func TestMockReplyTemplatesAndVariants(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.WhiteList = []apidef.EndPointMeta{{
+				Path: "/users/{id}",
+				MethodActions: map[string]apidef.EndpointMethodMeta{
+					http.MethodPost: {
+						Action:   apidef.Reply,
+						Code:     http.StatusCreated,
+						Template: true,
+						Data:     `{"id": "{{.Params.id}}", "name": "{{.JSON.name | upper}}", "q": "{{index .Query "q" 0}}", "ip": "$tyk_context.remote_addr"}`,
+						Headers:  map[string]string{"X-User": "{{.Params.id}}"},
+						Variants: []apidef.MockVariant{
+							{
+								On: apidef.Any,
+								Options: apidef.RoutingTriggerOptions{
+									HeaderMatches: map[string]apidef.StringRegexMap{"X-Mock": {MatchPattern: "^missing$"}},
+								},
+								Code: http.StatusNotFound,
+								Data: `{"error": "user {{.Params.id}} not found"}`,
+							},
+							{
+								On: apidef.All,
+								Options: apidef.RoutingTriggerOptions{
+									QueryValMatches: map[string]apidef.StringRegexMap{"q": {MatchPattern: "^admin$"}},
+									PayloadMatches:  apidef.StringRegexMap{MatchPattern: "root"},
+								},
+								Data: "admin",
+							},
+						},
+					},
+				},
+			}}
+		})
+	})
+
+	ts.Run(t, []test.TestCase{
+		{
+			Method: http.MethodPost, Path: "/users/42?q=x", Data: `{"name": "bob"}`, Code: http.StatusCreated,
+			BodyMatch:    `{"id": "42", "name": "BOB", "q": "x", "ip": "127.0.0.1"}`,
+			HeadersMatch: map[string]string{"X-User": "42"},
+		},
+		{
+			Method: http.MethodPost, Path: "/users/7?q=x", Headers: map[string]string{"X-Mock": "missing"},
+			Code: http.StatusNotFound, BodyMatch: "user 7 not found",
+		},
+		{Method: http.MethodPost, Path: "/users/1?q=admin", Data: `{"name": "root"}`, Code: http.StatusCreated, BodyMatch: "admin"},
+		{Method: http.MethodPost, Path: "/users/1?q=admin", Data: `{"name": "bob"}`, Code: http.StatusCreated, BodyMatch: `"name": "BOB"`},
+	}...)
+}
+
+func TestMockReplyChaos(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+			v.UseExtendedPaths = true
+			v.ExtendedPaths.WhiteList = []apidef.EndPointMeta{
+				{
+					Path: "/slow",
+					MethodActions: map[string]apidef.EndpointMethodMeta{
+						http.MethodGet: {Action: apidef.Reply, Code: http.StatusOK, Data: "slow", Delay: 50},
+					},
+				},
+				{
+					Path: "/broken",
+					MethodActions: map[string]apidef.EndpointMethodMeta{
+						http.MethodGet: {Action: apidef.Reply, Code: http.StatusOK, Data: "ok", FailureRate: 1, FailureCode: http.StatusServiceUnavailable},
+					},
+				},
+			}
+		})
+	})
+
+	start := time.Now()
+	ts.Run(t, test.TestCase{Path: "/slow", Code: http.StatusOK, BodyMatch: "slow"})
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("wanted the reply to be delayed, took %v", elapsed)
+	}
+
+	ts.Run(t, test.TestCase{Path: "/broken", Code: http.StatusServiceUnavailable, BodyMatch: "Mock failure"})
+}
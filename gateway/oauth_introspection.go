@@ -0,0 +1,191 @@
+package gateway
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"time"
+
+	"github.com/lonelycode/osin"
+
+	"github.com/ins-tykgw/tyk/headers"
+	"github.com/ins-tykgw/tyk/storage"
+)
+
+// Token type hints of introspection and revocation requests.
+const (
+	tokenTypeAccess  = "access_token"
+	tokenTypeRefresh = "refresh_token"
+)
+
+// oauthIntrospection is an RFC 7662 introspection response. Inactive
+// tokens only have Active set.
+type oauthIntrospection struct {
+	Active    bool   `json:"active"`
+	Scope     string `json:"scope,omitempty"`
+	ClientID  string `json:"client_id,omitempty"`
+	TokenType string `json:"token_type,omitempty"`
+	Exp       int64  `json:"exp,omitempty"`
+	Iat       int64  `json:"iat,omitempty"`
+}
+
+// oauthError is an RFC 6749 error response.
+type oauthError struct {
+	Error string `json:"error"`
+}
+
+// authenticateClient checks the client credentials of an introspection or
+// revocation request, given with basic auth or as client_id and
+// client_secret in the body.
+func (o *OAuthHandlers) authenticateClient(r *http.Request) osin.Client {
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID == "" || secret == "" {
+		return nil
+	}
+
+	client, err := o.Manager.OsinServer.Storage.GetClient(clientID)
+	if err != nil || client == nil || client.GetId() == "" {
+		return nil
+	}
+	if subtle.ConstantTimeCompare([]byte(client.GetSecret()), []byte(secret)) != 1 {
+		return nil
+	}
+	return client
+}
+
+// loadToken finds a token, as an access token or a refresh token, trying
+// the type hinted by the client first.
+func (o *OAuthHandlers) loadToken(token, hint string) (*osin.AccessData, string) {
+	order := []string{tokenTypeAccess, tokenTypeRefresh}
+	if hint == tokenTypeRefresh {
+		order = []string{tokenTypeRefresh, tokenTypeAccess}
+	}
+
+	for _, tokenType := range order {
+		var data *osin.AccessData
+		var err error
+		if tokenType == tokenTypeAccess {
+			data, err = o.Manager.OsinServer.Storage.LoadAccess(token)
+		} else {
+			data, err = o.Manager.OsinServer.Storage.LoadRefresh(token)
+		}
+		if err == nil && data != nil && data.Client != nil {
+			return data, tokenType
+		}
+	}
+	return nil, ""
+}
+
+func (o *OAuthHandlers) writeClientError(w http.ResponseWriter, code int, err string) {
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	doJSONWrite(w, code, oauthError{Error: err})
+}
+
+// HandleIntrospection handles RFC 7662 token introspection requests from
+// resource servers, which authenticate as clients of the API.
+func (o *OAuthHandlers) HandleIntrospection(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	if o.authenticateClient(r) == nil {
+		o.writeClientError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		o.writeClientError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	w.Header().Set(headers.CacheControl, "no-cache, no-store, must-revalidate")
+	w.Header().Set(headers.Pragma, "no-cache")
+
+	resp := oauthIntrospection{}
+	data, tokenType := o.loadToken(token, r.PostForm.Get("token_type_hint"))
+	switch tokenType {
+	case tokenTypeAccess:
+		session, found := o.Manager.API.SessionManager.SessionDetail(token, false)
+		if !found || session.IsInactive || data.IsExpired() ||
+			(session.Expires > 0 && session.Expires < time.Now().Unix()) {
+			break
+		}
+		resp = oauthIntrospection{
+			Active:    true,
+			Scope:     data.Scope,
+			ClientID:  data.Client.GetId(),
+			TokenType: "bearer",
+			Exp:       data.ExpireAt().Unix(),
+			Iat:       data.CreatedAt.Unix(),
+		}
+	case tokenTypeRefresh:
+		resp = oauthIntrospection{
+			Active:   true,
+			Scope:    data.Scope,
+			ClientID: data.Client.GetId(),
+			Exp:      data.CreatedAt.Unix() + oauthRefreshExpire(),
+			Iat:      data.CreatedAt.Unix(),
+		}
+	}
+
+	doJSONWrite(w, http.StatusOK, resp)
+}
+
+// HandleRevocation handles RFC 7009 token revocation requests from the
+// clients the tokens were issued to. Revoking a refresh token also revokes
+// the access token issued with it. Unknown tokens are ignored.
+func (o *OAuthHandlers) HandleRevocation(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	client := o.authenticateClient(r)
+	if client == nil {
+		o.writeClientError(w, http.StatusUnauthorized, "invalid_client")
+		return
+	}
+	token := r.PostForm.Get("token")
+	if token == "" {
+		o.writeClientError(w, http.StatusBadRequest, "invalid_request")
+		return
+	}
+
+	data, tokenType := o.loadToken(token, r.PostForm.Get("token_type_hint"))
+	if data == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if data.Client.GetId() != client.GetId() {
+		o.writeClientError(w, http.StatusBadRequest, "unauthorized_client")
+		return
+	}
+
+	if tokenType == tokenTypeRefresh {
+		o.Manager.OsinServer.Storage.RemoveRefresh(token)
+		o.tokenRevoked(client.GetId(), token, tokenTypeRefresh)
+
+		token = data.AccessToken
+		if access, err := o.Manager.OsinServer.Storage.LoadAccess(token); err != nil || access == nil {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+	}
+
+	// access data is stored by the hash of the token, or by the token
+	// itself for tokens issued before hashing, and the session by the token
+	o.Manager.OsinServer.Storage.RemoveAccess(storage.HashKey(token))
+	o.Manager.OsinServer.Storage.RemoveAccess(token)
+	o.tokenRevoked(client.GetId(), token, tokenTypeAccess)
+
+	w.WriteHeader(http.StatusOK)
+}
+
+func (o *OAuthHandlers) tokenRevoked(clientID, token, tokenType string) {
+	log.WithField("client_id", clientID).Info("[OAuth] Revoked ", tokenType)
+
+	o.Manager.API.FireEvent(EventOAuthTokenRevoked, EventOAuthTokenRevokedMeta{
+		EventMetaDefault: EventMetaDefault{Message: "OAuth token revoked by its client."},
+		APIID:            o.Manager.API.APIID,
+		ClientID:         clientID,
+		Token:            token,
+		TokenType:        tokenType,
+	})
+}
@@ -0,0 +1,135 @@
+package gateway
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func TestOAuthIntrospectionAndRevocation(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	spec := loadTestOAuthSpec()
+	createTestOAuthClient(spec, authClientID)
+	createTestOAuthClient(spec, "5678")
+
+	events := make(chan EventOAuthTokenRevokedMeta, 10)
+	spec.EventPaths = map[apidef.TykEvent][]config.TykEventHandler{
+		EventOAuthTokenRevoked: {&testEventHandler{func(em config.EventMessage) {
+			events <- em.Meta.(EventOAuthTokenRevokedMeta)
+		}}},
+	}
+	waitForRevocation := func(t *testing.T, token, tokenType string) {
+		select {
+		case meta := <-events:
+			if meta.Token != token || meta.TokenType != tokenType || meta.ClientID != authClientID {
+				t.Errorf("Unexpected revocation event: %+v", meta)
+			}
+		case <-time.After(3 * time.Second):
+			t.Fatal("OAuthTokenRevoked event wasn't fired")
+		}
+	}
+
+	formHeaders := func(clientID, secret string) map[string]string {
+		h := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+		if clientID != "" {
+			h["Authorization"] = genAuthHeader(clientID, secret)
+		}
+		return h
+	}
+	form := func(token, hint string) string {
+		param := url.Values{}
+		param.Set("token", token)
+		if hint != "" {
+			param.Set("token_type_hint", hint)
+		}
+		return param.Encode()
+	}
+	introspect := func(t *testing.T, token, hint string) oauthIntrospection {
+		resp, _ := ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/APIID/oauth/introspect",
+			Headers: formHeaders(authClientID, authClientSecret), Data: form(token, hint), Code: http.StatusOK})
+		var obj oauthIntrospection
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	tokens := getToken(t, &ts)
+
+	t.Run("Introspect", func(t *testing.T) {
+		if obj := introspect(t, tokens.AccessToken, ""); !obj.Active || obj.ClientID != authClientID ||
+			obj.TokenType != "bearer" || obj.Exp <= time.Now().Unix() {
+			t.Errorf("Expected an active access token, got %+v", obj)
+		}
+		if obj := introspect(t, tokens.RefreshToken, tokenTypeRefresh); !obj.Active || obj.ClientID != authClientID || obj.TokenType != "" {
+			t.Errorf("Expected an active refresh token, got %+v", obj)
+		}
+		if obj := introspect(t, "unknown", ""); obj.Active {
+			t.Errorf("Expected an inactive token, got %+v", obj)
+		}
+
+		ts.Run(t, []test.TestCase{
+			{Method: http.MethodPost, Path: "/APIID/oauth/introspect", Headers: formHeaders("", ""),
+				Data: form(tokens.AccessToken, ""), Code: http.StatusUnauthorized, BodyMatch: "invalid_client"},
+			{Method: http.MethodPost, Path: "/APIID/oauth/introspect", Headers: formHeaders(authClientID, "wrong"),
+				Data: form(tokens.AccessToken, ""), Code: http.StatusUnauthorized},
+			{Method: http.MethodPost, Path: "/APIID/oauth/introspect", Headers: formHeaders(authClientID, authClientSecret),
+				Code: http.StatusBadRequest, BodyMatch: "invalid_request"},
+			{Method: http.MethodGet, Path: "/APIID/oauth/introspect", Headers: formHeaders(authClientID, authClientSecret),
+				Code: http.StatusMethodNotAllowed},
+		}...)
+
+		// client credentials can also be sent in the body
+		param := url.Values{}
+		param.Set("token", tokens.AccessToken)
+		param.Set("client_id", authClientID)
+		param.Set("client_secret", authClientSecret)
+		ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/APIID/oauth/introspect", Headers: formHeaders("", ""),
+			Data: param.Encode(), Code: http.StatusOK, BodyMatch: `"active":true`})
+	})
+
+	t.Run("Revoke access token", func(t *testing.T) {
+		authHeader := map[string]string{"Authorization": "Bearer " + tokens.AccessToken}
+		ts.Run(t, []test.TestCase{
+			{Path: "/APIID/", Headers: authHeader, Code: http.StatusOK},
+			{Method: http.MethodPost, Path: "/APIID/oauth/revoke", Headers: formHeaders("5678", authClientSecret),
+				Data: form(tokens.AccessToken, ""), Code: http.StatusBadRequest, BodyMatch: "unauthorized_client"},
+			{Method: http.MethodPost, Path: "/APIID/oauth/revoke", Headers: formHeaders(authClientID, authClientSecret),
+				Data: form(tokens.AccessToken, tokenTypeAccess), Code: http.StatusOK},
+		}...)
+		waitForRevocation(t, tokens.AccessToken, tokenTypeAccess)
+
+		if obj := introspect(t, tokens.AccessToken, ""); obj.Active {
+			t.Errorf("Expected the revoked token to be inactive, got %+v", obj)
+		}
+		ts.Run(t, []test.TestCase{
+			{Path: "/APIID/", Headers: authHeader, Code: http.StatusForbidden},
+			// revoking an unknown or revoked token succeeds
+			{Method: http.MethodPost, Path: "/APIID/oauth/revoke", Headers: formHeaders(authClientID, authClientSecret),
+				Data: form(tokens.AccessToken, ""), Code: http.StatusOK},
+		}...)
+	})
+
+	t.Run("Revoke refresh token", func(t *testing.T) {
+		tokens := getToken(t, &ts)
+		ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/APIID/oauth/revoke", Headers: formHeaders(authClientID, authClientSecret),
+			Data: form(tokens.RefreshToken, ""), Code: http.StatusOK})
+		waitForRevocation(t, tokens.RefreshToken, tokenTypeRefresh)
+		waitForRevocation(t, tokens.AccessToken, tokenTypeAccess)
+
+		if obj := introspect(t, tokens.RefreshToken, tokenTypeRefresh); obj.Active {
+			t.Errorf("Expected the revoked refresh token to be inactive, got %+v", obj)
+		}
+		if obj := introspect(t, tokens.AccessToken, ""); obj.Active {
+			t.Errorf("Expected the access token to be revoked with the refresh token, got %+v", obj)
+		}
+	})
+}
@@ -50,6 +50,9 @@ type OAuthClient struct {
 	MetaData          interface{} `json:"meta_data,omitempty"`
 	PolicyID          string      `json:"policyid"`
 	Description       string      `json:"description"`
+	// AllowedScopes are the scopes the client may request, see
+	// OAuthServerOptions.ScopeToPolicyMapping.
+	AllowedScopes []string `json:"allowed_scopes,omitempty"`
 }
 
 func (oc *OAuthClient) GetId() string {
@@ -134,9 +137,21 @@ func (o *OAuthHandlers) HandleGenerateAuthCodeData(w http.ResponseWriter, r *htt
 		log.Warning("Authorise request is missing key_rules in params, policy will be required!")
 	}
 
+	challenge, method, err := o.Manager.codeChallenge(r)
+	if err != nil {
+		doJSONWrite(w, http.StatusBadRequest, apiError(err.Error()))
+		return
+	}
+
 	// Handle the authorisation and write the JSON output to the resource provider
 	resp := o.Manager.HandleAuthorisation(r, true, sessionJSONData)
 	code := http.StatusOK
+	if authCode, ok := resp.Output["code"].(string); ok && !resp.IsError && challenge != "" {
+		if err := o.Manager.OsinServer.Storage.SaveCodeChallenge(authCode, challenge, method, o.Manager.OsinServer.Config.AuthorizationExpiration); err != nil {
+			log.Error("[OAuth] Couldn't save PKCE code challenge: ", err)
+			resp.SetError(osin.E_SERVER_ERROR, "")
+		}
+	}
 	msg := o.generateOAuthOutputFromOsinResponse(resp)
 	if resp.IsError {
 		code = resp.ErrorStatusCode
@@ -157,6 +172,11 @@ func (o *OAuthHandlers) HandleAuthorizePassthrough(w http.ResponseWriter, r *htt
 		doJSONWrite(w, resp.ErrorStatusCode, apiError(resp.StatusText))
 		return
 	}
+	challenge, method, err := o.Manager.codeChallenge(r)
+	if err != nil {
+		doJSONWrite(w, http.StatusBadRequest, apiError(err.Error()))
+		return
+	}
 	if r.Method == "GET" {
 		var buffer bytes.Buffer
 		buffer.WriteString(o.Manager.API.Oauth2Meta.AuthorizeLoginRedirect)
@@ -166,6 +186,12 @@ func (o *OAuthHandlers) HandleAuthorizePassthrough(w http.ResponseWriter, r *htt
 		buffer.WriteString(r.FormValue("redirect_uri"))
 		buffer.WriteString("&response_type=")
 		buffer.WriteString(r.FormValue("response_type"))
+		if challenge != "" {
+			buffer.WriteString("&code_challenge=")
+			buffer.WriteString(url.QueryEscape(challenge))
+			buffer.WriteString("&code_challenge_method=")
+			buffer.WriteString(method)
+		}
 		w.Header().Add("Location", buffer.String())
 	} else {
 		w.Header().Add("Location", o.Manager.API.Oauth2Meta.AuthorizeLoginRedirect)
@@ -352,6 +378,24 @@ func (o *OAuthManager) HandleAccess(r *http.Request) *osin.Response {
 			ar.Authorized = true
 		}
 
+		if ar.Type == osin.AUTHORIZATION_CODE && !o.verifyCodeChallenge(ar.Code, r.Form.Get("code_verifier")) {
+			log.WithField("client_id", ar.Client.GetId()).Warning("[OAuth] PKCE code verifier check failed")
+			resp.SetError(osin.E_INVALID_GRANT, "")
+			return resp
+		}
+
+		if ar.Authorized {
+			if err := o.applyScopePolicies(ar); err != nil {
+				log.WithField("client_id", ar.Client.GetId()).Error("[OAuth] Couldn't map scope to policies: ", err)
+				if _, ok := err.(scopeError); ok {
+					resp.SetError(osin.E_INVALID_SCOPE, err.Error())
+				} else {
+					resp.SetError(osin.E_SERVER_ERROR, "")
+				}
+				return resp
+			}
+		}
+
 		// Does the user have an old OAuth token for this client?
 		if session != nil && session.OauthKeys != nil {
 			log.Debug("There's keys here bill...")
@@ -418,6 +462,7 @@ const (
 	prefixAccess    = "oauth-access."
 	prefixRefresh   = "oauth-refresh."
 	prefixClientset = "oauth-clientset."
+	prefixPKCE      = "oauth-pkce."
 
 	prefixClientTokens = "oauth-client-tokens."
 )
@@ -459,6 +504,12 @@ type ExtendedOsinStorageInterface interface {
 
 	// SetUser updates a Basic Access user token type in the key store
 	SetUser(string, *user.SessionState, int64) error
+
+	// SaveCodeChallenge stores the PKCE code challenge of an authorization code
+	SaveCodeChallenge(code, challenge, method string, expiresIn int32) error
+
+	// LoadCodeChallenge loads the PKCE code challenge of an authorization code
+	LoadCodeChallenge(code string) (challenge, method string, err error)
 }
 
 // TykOsinServer subclasses osin.Server so we can add the SetClient method without wrecking the lbrary
@@ -773,9 +824,37 @@ func (r *RedisOsinStorageInterface) LoadAuthorize(code string) (*osin.AuthorizeD
 func (r *RedisOsinStorageInterface) RemoveAuthorize(code string) error {
 	key := prefixAuth + code
 	r.store.DeleteKey(key)
+	r.store.DeleteKey(prefixPKCE + code)
 	return nil
 }
 
+type codeChallenge struct {
+	Challenge string `json:"challenge"`
+	Method    string `json:"method"`
+}
+
+// SaveCodeChallenge saves the PKCE code challenge of an auth code to Redis
+func (r *RedisOsinStorageInterface) SaveCodeChallenge(code, challenge, method string, expiresIn int32) error {
+	data, err := json.Marshal(codeChallenge{Challenge: challenge, Method: method})
+	if err != nil {
+		return err
+	}
+	return r.store.SetKey(prefixPKCE+code, string(data), int64(expiresIn))
+}
+
+// LoadCodeChallenge loads the PKCE code challenge of an auth code from Redis
+func (r *RedisOsinStorageInterface) LoadCodeChallenge(code string) (string, string, error) {
+	data, err := r.store.GetKey(prefixPKCE + code)
+	if err != nil {
+		return "", "", err
+	}
+	var cc codeChallenge
+	if err := json.Unmarshal([]byte(data), &cc); err != nil {
+		return "", "", err
+	}
+	return cc.Challenge, cc.Method, nil
+}
+
 // SaveAccess will save a token and it's access data to redis
 func (r *RedisOsinStorageInterface) SaveAccess(accessData *osin.AccessData) error {
 	authDataJSON, err := json.Marshal(accessData)
@@ -803,31 +882,11 @@ func (r *RedisOsinStorageInterface) SaveAccess(accessData *osin.AccessData) erro
 	)
 
 	// Create a user.SessionState object and register it with the authmanager
-	var newSession user.SessionState
-
-	// ------
-	checkPolicy := true
-	if accessData.UserData != nil {
-		checkPolicy = false
-		err := json.Unmarshal([]byte(accessData.UserData.(string)), &newSession)
-		if err != nil {
-			log.Info("Couldn't decode user.SessionState from UserData, checking policy: ", err)
-			checkPolicy = true
-		}
-	}
-
-	if checkPolicy {
-		// defined in JWT middleware
-		sessionFromPolicy, err := generateSessionFromPolicy(accessData.Client.GetPolicyID(), "", false)
-		if err != nil {
-			return errors.New("Couldn't use policy or key rules to create token, failing")
-		}
-
-		newSession = sessionFromPolicy
+	newSession, err := oauthSession(accessData.UserData, accessData.Client)
+	if err != nil {
+		return err
 	}
 
-	// ------
-
 	// Set the client ID for analytics
 	newSession.OauthClientID = accessData.Client.GetId()
 
@@ -845,11 +904,7 @@ func (r *RedisOsinStorageInterface) SaveAccess(accessData *osin.AccessData) erro
 		}
 		key := prefixRefresh + accessData.RefreshToken
 		log.Debug("Saving REFRESH key: ", key)
-		refreshExpire := int64(1209600) // 14 days
-		if oauthRefreshExpire := config.Global().OauthRefreshExpire; oauthRefreshExpire != 0 {
-			refreshExpire = oauthRefreshExpire
-		}
-		r.store.SetKey(key, string(accessDataJSON), refreshExpire)
+		r.store.SetKey(key, string(accessDataJSON), oauthRefreshExpire())
 		log.Debug("STORING ACCESS DATA: ", string(accessDataJSON))
 		return nil
 	}
@@ -857,6 +912,14 @@ func (r *RedisOsinStorageInterface) SaveAccess(accessData *osin.AccessData) erro
 	return nil
 }
 
+// oauthRefreshExpire is how long refresh tokens are kept, in seconds.
+func oauthRefreshExpire() int64 {
+	if refreshExpire := config.Global().OauthRefreshExpire; refreshExpire != 0 {
+		return refreshExpire
+	}
+	return 1209600 // 14 days
+}
+
 // LoadAccess will load access data from redis
 func (r *RedisOsinStorageInterface) LoadAccess(token string) (*osin.AccessData, error) {
 	key := prefixAccess + storage.HashKey(token)
@@ -930,36 +993,45 @@ type accessTokenGen struct{}
 func (accessTokenGen) GenerateAccessToken(data *osin.AccessData, generaterefresh bool) (accesstoken, refreshtoken string, err error) {
 	log.Info("[OAuth] Generating new token")
 
-	var newSession user.SessionState
-	checkPolicy := true
-	if data.UserData != nil {
-		checkPolicy = false
-		err := json.Unmarshal([]byte(data.UserData.(string)), &newSession)
-		if err != nil {
-			log.Info("[GenerateAccessToken] Couldn't decode user.SessionState from UserData, checking policy: ", err)
-			checkPolicy = true
-		}
-	}
-
-	if checkPolicy {
-		// defined in JWT middleware
-		sessionFromPolicy, err := generateSessionFromPolicy(data.Client.GetPolicyID(), "", false)
-		if err != nil {
-			return "", "", errors.New("Couldn't use policy or key rules to create token, failing")
-		}
-
-		newSession = sessionFromPolicy
+	newSession, err := oauthSession(data.UserData, data.Client)
+	if err != nil {
+		return "", "", err
 	}
 
 	accesstoken = keyGen.GenerateAuthKey(newSession.OrgID)
 
 	if generaterefresh {
-		u6 := uuid.NewV4()
-		refreshtoken = base64.StdEncoding.EncodeToString([]byte(u6.String()))
+		refreshtoken = newRefreshToken()
 	}
 	return
 }
 
+func newRefreshToken() string {
+	u6 := uuid.NewV4()
+	return base64.StdEncoding.EncodeToString([]byte(u6.String()))
+}
+
+// oauthSession creates the session a token is issued for, from the key
+// rules or user the token was authorised with, or else from the policy of
+// the client.
+func oauthSession(userData interface{}, client osin.Client) (user.SessionState, error) {
+	var session user.SessionState
+	if data, ok := userData.(string); ok && data != "" {
+		err := json.Unmarshal([]byte(data), &session)
+		if err == nil {
+			return session, nil
+		}
+		log.Info("Couldn't decode user.SessionState from UserData, checking policy: ", err)
+	}
+
+	// defined in JWT middleware
+	session, err := generateSessionFromPolicy(client.GetPolicyID(), "", false)
+	if err != nil {
+		return session, errors.New("Couldn't use policy or key rules to create token, failing")
+	}
+	return session, nil
+}
+
 // LoadRefresh will load access data from Redis
 func (r *RedisOsinStorageInterface) GetUser(username string) (*user.SessionState, error) {
 	key := username
@@ -0,0 +1,233 @@
+package gateway
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	"github.com/lonelycode/osin"
+	uuid "github.com/satori/go.uuid"
+
+	"github.com/ins-tykgw/tyk/certs"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+// PKCE code challenge methods, see RFC 7636.
+const (
+	pkceMethodS256  = "S256"
+	pkceMethodPlain = "plain"
+)
+
+// codeChallenge reads the PKCE code challenge of an authorization code
+// request. The method defaults to plain.
+func (o *OAuthManager) codeChallenge(r *http.Request) (challenge, method string, err error) {
+	if r.FormValue("response_type") != string(osin.CODE) {
+		return "", "", nil
+	}
+
+	challenge, method = r.FormValue("code_challenge"), r.FormValue("code_challenge_method")
+	if challenge == "" {
+		if o.API.OAuthServer.RequirePKCE {
+			return "", "", errors.New("code_challenge is required")
+		}
+		return "", "", nil
+	}
+	if method == "" {
+		method = pkceMethodPlain
+	}
+	if method != pkceMethodS256 && method != pkceMethodPlain {
+		return "", "", errors.New("code_challenge_method is not supported")
+	}
+	if len(challenge) < 43 || len(challenge) > 128 {
+		return "", "", errors.New("code_challenge must be 43 to 128 characters long")
+	}
+	return challenge, method, nil
+}
+
+// verifyCodeChallenge checks the code verifier of an authorization code
+// exchange against the challenge the code was issued with.
+func (o *OAuthManager) verifyCodeChallenge(code, verifier string) bool {
+	challenge, method, err := o.OsinServer.Storage.LoadCodeChallenge(code)
+	if err != nil {
+		return !o.API.OAuthServer.RequirePKCE
+	}
+	if verifier == "" {
+		return false
+	}
+
+	if method == pkceMethodS256 {
+		sum := sha256.Sum256([]byte(verifier))
+		verifier = base64.RawURLEncoding.EncodeToString(sum[:])
+	}
+	return subtle.ConstantTimeCompare([]byte(verifier), []byte(challenge)) == 1
+}
+
+// scopeError rejects a token request for a scope the client can't have.
+type scopeError string
+
+func (e scopeError) Error() string {
+	return string(e)
+}
+
+// applyScopePolicies adds the policies mapped from the requested scope to
+// the session the token is issued for. Every scope must be mapped and
+// allowed for the client. For authorization codes the scope is the one the
+// resource owner approved, and refreshed tokens can't widen their scope.
+func (o *OAuthManager) applyScopePolicies(ar *osin.AccessRequest) error {
+	mapping := o.API.OAuthServer.ScopeToPolicyMapping
+	if len(mapping) == 0 || ar.Scope == "" {
+		return nil
+	}
+
+	var allowed []string
+	if client, ok := ar.Client.(*OAuthClient); ok {
+		allowed = client.AllowedScopes
+	}
+	scopes := strings.Fields(ar.Scope)
+	for _, scope := range scopes {
+		if _, ok := mapping[scope]; !ok {
+			return scopeError("unknown scope: " + scope)
+		}
+		if !containsString(allowed, scope) {
+			return scopeError("scope not allowed for the client: " + scope)
+		}
+		if ar.Type == osin.REFRESH_TOKEN && ar.AccessData != nil &&
+			!containsString(strings.Fields(ar.AccessData.Scope), scope) {
+			return scopeError("scope wider than the refreshed token: " + scope)
+		}
+	}
+	mapped := mapScopeToPolicies(mapping, scopes)
+
+	session, err := oauthSession(ar.UserData, ar.Client)
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(mapped)
+	polIDs := session.PolicyIDs()
+	for _, id := range mapped {
+		if !containsString(polIDs, id) {
+			polIDs = append(polIDs, id)
+		}
+	}
+	session.SetPolicies(polIDs...)
+
+	mw := BaseMiddleware{Spec: o.API}
+	if err := mw.ApplyPolicies(&session); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+	ar.UserData = string(data)
+	return nil
+}
+
+// jwtAccessTokenGen issues access tokens as JWTs signed with the
+// certificate of the API, with the policies of the session as claims.
+type jwtAccessTokenGen struct {
+	spec *APISpec
+}
+
+// GenerateAccessToken generates signed JWT access tokens and base64-encoded
+// UUID refresh tokens
+func (g jwtAccessTokenGen) GenerateAccessToken(data *osin.AccessData, generaterefresh bool) (accesstoken, refreshtoken string, err error) {
+	log.Info("[OAuth] Generating new JWT token")
+
+	session, err := oauthSession(data.UserData, data.Client)
+	if err != nil {
+		return "", "", err
+	}
+
+	opts := g.spec.OAuthServer.JWTAccessTokens
+	key, _, err := oauthSigningKey(opts.SigningCertID)
+	if err != nil {
+		return "", "", err
+	}
+
+	expiresIn := int64(data.ExpiresIn)
+	if oauthTokenExpire := config.Global().OauthTokenExpire; oauthTokenExpire != 0 {
+		expiresIn = int64(oauthTokenExpire)
+	}
+
+	claims := jwt.MapClaims{
+		"sub":       data.Client.GetId(),
+		"client_id": data.Client.GetId(),
+		"iat":       data.CreatedAt.Unix(),
+		"exp":       data.CreatedAt.Unix() + expiresIn,
+		"jti":       uuid.NewV4().String(),
+		"pol":       session.PolicyIDs(),
+	}
+	if opts.Issuer != "" {
+		claims["iss"] = opts.Issuer
+	}
+	if data.Scope != "" {
+		claims["scope"] = data.Scope
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = opts.SigningCertID
+
+	if accesstoken, err = token.SignedString(key); err != nil {
+		return "", "", err
+	}
+	if generaterefresh {
+		refreshtoken = newRefreshToken()
+	}
+	return accesstoken, refreshtoken, nil
+}
+
+// oauthSigningKey loads the RSA private key and certificate JWT access
+// tokens are signed with.
+func oauthSigningKey(certID string) (*rsa.PrivateKey, *tls.Certificate, error) {
+	if certID == "" {
+		return nil, nil, errors.New("no signing certificate set for JWT access tokens")
+	}
+	list := CertificateManager.List([]string{certID}, certs.CertificatePrivate)
+	if len(list) == 0 || list[0] == nil {
+		return nil, nil, errors.New("JWT access token signing certificate not found: " + certID)
+	}
+	key, ok := list[0].PrivateKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, errors.New("JWT access token signing certificate must have an RSA key")
+	}
+	return key, list[0], nil
+}
+
+// HandleJWKS publishes the key JWT access tokens are signed with, so that
+// services can verify tokens without calling the gateway.
+func (o *OAuthHandlers) HandleJWKS(w http.ResponseWriter, r *http.Request) {
+	certID := o.Manager.API.OAuthServer.JWTAccessTokens.SigningCertID
+	key, cert, err := oauthSigningKey(certID)
+	if err != nil {
+		log.Error("[OAuth] Couldn't load JWKS: ", err)
+		doJSONWrite(w, http.StatusInternalServerError, apiError("Signing key not found"))
+		return
+	}
+
+	jwk := JWK{
+		Alg: "RS256",
+		Kty: "RSA",
+		Use: "sig",
+		KID: certID,
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+	for _, der := range cert.Certificate {
+		jwk.X5c = append(jwk.X5c, base64.StdEncoding.EncodeToString(der))
+	}
+
+	w.Header().Set(headers.CacheControl, "max-age=300")
+	doJSONWrite(w, http.StatusOK, JWKs{Keys: []JWK{jwk}})
+}
@@ -0,0 +1,242 @@
+package gateway
+
+import (
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	jwt "github.com/dgrijalva/jwt-go"
+
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestOAuthServerPKCE(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	spec := loadTestOAuthSpec()
+	createTestOAuthClient(spec, authClientID)
+
+	verifier := strings.Repeat("verifier-", 6)
+	sum := sha256.Sum256([]byte(verifier))
+	s256Challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	formHeaders := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	authorize := func(t *testing.T, challenge, method string, code int) string {
+		param := url.Values{}
+		param.Set("response_type", "code")
+		param.Set("redirect_uri", authRedirectUri)
+		param.Set("client_id", authClientID)
+		param.Set("key_rules", keyRules)
+		if challenge != "" {
+			param.Set("code_challenge", challenge)
+		}
+		if method != "" {
+			param.Set("code_challenge_method", method)
+		}
+		resp, _ := ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/APIID/tyk/oauth/authorize-client/",
+			AdminAuth: true, Headers: formHeaders, Data: param.Encode(), Code: code})
+		out := map[string]string{}
+		json.NewDecoder(resp.Body).Decode(&out)
+		return out["code"]
+	}
+	exchange := func(t *testing.T, authCode, verifier string, code int) {
+		param := url.Values{}
+		param.Set("grant_type", "authorization_code")
+		param.Set("redirect_uri", authRedirectUri)
+		param.Set("client_id", authClientID)
+		param.Set("code", authCode)
+		if verifier != "" {
+			param.Set("code_verifier", verifier)
+		}
+		ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/APIID/oauth/token/",
+			Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded",
+				"Authorization": genAuthHeader(authClientID, authClientSecret)},
+			Data: param.Encode(), Code: code})
+	}
+
+	t.Run("S256", func(t *testing.T) {
+		authCode := authorize(t, s256Challenge, pkceMethodS256, http.StatusOK)
+		exchange(t, authCode, "", http.StatusForbidden)
+		exchange(t, authCode, verifier+"x", http.StatusForbidden)
+		exchange(t, authCode, verifier, http.StatusOK)
+	})
+
+	t.Run("Plain", func(t *testing.T) {
+		authCode := authorize(t, verifier, "", http.StatusOK)
+		exchange(t, authCode, s256Challenge, http.StatusForbidden)
+		exchange(t, authCode, verifier, http.StatusOK)
+	})
+
+	t.Run("Invalid challenge", func(t *testing.T) {
+		authorize(t, s256Challenge, "S512", http.StatusBadRequest)
+		authorize(t, "short", pkceMethodPlain, http.StatusBadRequest)
+	})
+
+	t.Run("Optional", func(t *testing.T) {
+		exchange(t, authorize(t, "", "", http.StatusOK), "", http.StatusOK)
+	})
+
+	t.Run("Required", func(t *testing.T) {
+		spec := LoadAPI(buildTestOAuthSpec(func(spec *APISpec) {
+			spec.OAuthServer.RequirePKCE = true
+		}))[0]
+		createTestOAuthClient(spec, authClientID)
+
+		authorize(t, "", "", http.StatusBadRequest)
+		exchange(t, authorize(t, s256Challenge, pkceMethodS256, http.StatusOK), verifier, http.StatusOK)
+
+		client := &http.Client{
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		}
+		param := url.Values{}
+		param.Set("response_type", "code")
+		param.Set("redirect_uri", authRedirectUri)
+		param.Set("client_id", authClientID)
+		ts.Run(t, test.TestCase{Path: "/APIID/oauth/authorize/?" + param.Encode(), Client: client,
+			Code: http.StatusBadRequest, BodyMatch: "code_challenge is required"})
+
+		param.Set("code_challenge", s256Challenge)
+		param.Set("code_challenge_method", pkceMethodS256)
+		resp, _ := ts.Run(t, test.TestCase{Path: "/APIID/oauth/authorize/?" + param.Encode(), Client: client,
+			Code: http.StatusTemporaryRedirect})
+		if location := resp.Header.Get("Location"); !strings.Contains(location, "code_challenge="+s256Challenge) ||
+			!strings.Contains(location, "code_challenge_method=S256") {
+			t.Errorf("Expected the code challenge to be passed to the login page, got %s", location)
+		}
+	})
+}
+
+func TestOAuthServerScopesAndJWTAccessTokens(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	_, _, combinedPEM, _ := genCertificate(&x509.Certificate{})
+	certID, err := CertificateManager.Add(combinedPEM, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer CertificateManager.Delete(certID)
+
+	clientPolicy := CreatePolicy(func(p *user.Policy) {
+		p.OrgID = "default"
+		p.Partitions.PerAPI = true
+		p.AccessRights = map[string]user.AccessDefinition{"test": {APIID: "test", Versions: []string{"v1"}}}
+	})
+	// a client of its own, as other tests delete the usual one
+	const clientID = "scopes-client"
+	readPolicy := CreatePolicy(func(p *user.Policy) {
+		p.OrgID = "default"
+		p.Partitions.PerAPI = true
+		p.AccessRights = map[string]user.AccessDefinition{"999999": {APIID: "999999", Versions: []string{"v1"}}}
+	})
+	adminPolicy := CreatePolicy(func(p *user.Policy) {
+		p.OrgID = "default"
+		p.Partitions.PerAPI = true
+		p.AccessRights = map[string]user.AccessDefinition{"888888": {APIID: "888888", Versions: []string{"v1"}}}
+	})
+
+	loadSpec := func(jwtTokens bool) {
+		spec := LoadAPI(buildTestOAuthSpec(func(spec *APISpec) {
+			spec.OAuthServer.ScopeToPolicyMapping = map[string]string{"read": readPolicy, "admin": adminPolicy}
+			spec.OAuthServer.JWTAccessTokens.Enabled = jwtTokens
+			spec.OAuthServer.JWTAccessTokens.SigningCertID = certID
+			spec.OAuthServer.JWTAccessTokens.Issuer = "https://tyk.example.com"
+		}))[0]
+		spec.OAuthManager.OsinServer.Storage.SetClient(clientID, &OAuthClient{
+			ClientID:          clientID,
+			ClientSecret:      authClientSecret,
+			ClientRedirectURI: authRedirectUri,
+			PolicyID:          clientPolicy,
+			AllowedScopes:     []string{"read"},
+		}, false)
+	}
+	clientCredentials := func(t *testing.T, scope string, code ...int) string {
+		expected := http.StatusOK
+		if len(code) > 0 {
+			expected = code[0]
+		}
+		param := url.Values{}
+		param.Set("grant_type", "client_credentials")
+		param.Set("client_id", clientID)
+		param.Set("client_secret", authClientSecret)
+		if scope != "" {
+			param.Set("scope", scope)
+		}
+		resp, _ := ts.Run(t, test.TestCase{Method: http.MethodPost, Path: "/APIID/oauth/token/",
+			Headers: map[string]string{"Content-Type": "application/x-www-form-urlencoded",
+				"Authorization": genAuthHeader(clientID, authClientSecret)},
+			Data: param.Encode(), Code: expected})
+		token := tokenData{}
+		json.NewDecoder(resp.Body).Decode(&token)
+		return token.AccessToken
+	}
+	bearer := func(token string) map[string]string {
+		return map[string]string{"Authorization": "Bearer " + token}
+	}
+
+	t.Run("Scope to policy mapping", func(t *testing.T) {
+		loadSpec(false)
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/APIID/", Headers: bearer(clientCredentials(t, "")), Code: http.StatusForbidden},
+			{Path: "/APIID/", Headers: bearer(clientCredentials(t, "read")), Code: http.StatusOK},
+		}...)
+
+		// unknown scopes, and scopes the client isn't allowed, get no token
+		clientCredentials(t, "read unmapped", http.StatusForbidden)
+		clientCredentials(t, "read admin", http.StatusForbidden)
+	})
+
+	t.Run("JWT access tokens", func(t *testing.T) {
+		loadSpec(true)
+
+		resp, _ := ts.Run(t, test.TestCase{Path: "/APIID/oauth/jwks", Code: http.StatusOK})
+		var jwks JWKs
+		if err := json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+			t.Fatal(err)
+		}
+		if len(jwks.Keys) != 1 || jwks.Keys[0].KID != certID || jwks.Keys[0].Alg != "RS256" || len(jwks.Keys[0].X5c) != 1 {
+			t.Fatalf("Unexpected JWKS: %+v", jwks)
+		}
+		n, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].N)
+		e, _ := base64.RawURLEncoding.DecodeString(jwks.Keys[0].E)
+		publicKey := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+
+		accessToken := clientCredentials(t, "read")
+		token, err := jwt.Parse(accessToken, func(token *jwt.Token) (interface{}, error) {
+			if token.Header["kid"] != certID {
+				t.Errorf("Unexpected kid: %v", token.Header["kid"])
+			}
+			return publicKey, nil
+		})
+		if err != nil || !token.Valid {
+			t.Fatal("Token doesn't verify with the JWKS: ", err)
+		}
+
+		claims := token.Claims.(jwt.MapClaims)
+		if claims["iss"] != "https://tyk.example.com" || claims["client_id"] != clientID || claims["scope"] != "read" {
+			t.Errorf("Unexpected claims: %v", claims)
+		}
+		if pol, _ := claims["pol"].([]interface{}); len(pol) != 2 || pol[0] != clientPolicy || pol[1] != readPolicy {
+			t.Errorf("Expected the policies of the session, got %v", claims["pol"])
+		}
+
+		ts.Run(t, test.TestCase{Path: "/APIID/", Headers: bearer(accessToken), Code: http.StatusOK})
+	})
+
+	t.Run("JWKS disabled", func(t *testing.T) {
+		loadSpec(false)
+		ts.Run(t, test.TestCase{Path: "/APIID/oauth/jwks", Code: http.StatusBadRequest, BodyMatch: "Authorization field missing"})
+	})
+}
@@ -0,0 +1,651 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"mime"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TykTechnologies/gojsonschema"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/headers"
+	"github.com/ins-tykgw/tyk/regexp"
+)
+
+// OpenAPI 3 request and response validation. Schemas in the document are
+// converted to JSON Schema draft 4 and checked with gojsonschema, like
+// ValidateJSON does.
+
+const openAPIMaxRefDepth = 32
+
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+func init() {
+	gojsonschema.FormatCheckers.Add("date", openAPIDateFormat{})
+	gojsonschema.FormatCheckers.Add("byte", openAPIByteFormat{})
+}
+
+type openAPIDateFormat struct{}
+
+func (openAPIDateFormat) IsFormat(input string) bool {
+	_, err := time.Parse("2006-01-02", input)
+	return err == nil
+}
+
+type openAPIByteFormat struct{}
+
+func (openAPIByteFormat) IsFormat(input string) bool {
+	_, err := base64.StdEncoding.DecodeString(input)
+	return err == nil
+}
+
+// openAPIViolation is a single validation failure.
+type openAPIViolation struct {
+	// In is where the violation is: "path", "query", "header",
+	// "cookie", "body" or "response".
+	In      string `json:"in"`
+	Name    string `json:"name,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v openAPIViolation) String() string {
+	if v.Name == "" {
+		return v.In + ": " + v.Message
+	}
+	return v.In + " " + v.Name + ": " + v.Message
+}
+
+// openAPIDocument is an OpenAPI document compiled for validation.
+type openAPIDocument struct {
+	routes []*openAPIRoute
+}
+
+type openAPIRoute struct {
+	template string
+	rx       *regexp.Regexp
+	params   []string
+	ops      map[string]*openAPIOperation
+}
+
+type openAPIOperation struct {
+	params    []*openAPIParam
+	body      *openAPIBody
+	responses map[string]*openAPIResponse
+}
+
+type openAPIParam struct {
+	name     string
+	in       string
+	required bool
+	schema   map[string]interface{}
+	// validator is nil if the parameter has no schema
+	validator *gojsonschema.Schema
+}
+
+type openAPIBody struct {
+	required bool
+	content  map[string]*openAPIMediaType
+}
+
+type openAPIMediaType struct {
+	schema    map[string]interface{}
+	validator *gojsonschema.Schema
+}
+
+type openAPIResponse struct {
+	headers []*openAPIParam
+	content map[string]*openAPIMediaType
+}
+
+// openAPICompiler resolves references against the whole document.
+type openAPICompiler struct {
+	doc map[string]interface{}
+}
+
+func compileOpenAPI(conf apidef.OpenAPIValidation) (*openAPIDocument, error) {
+	if conf.Document == nil {
+		return nil, errors.New("no OpenAPI document")
+	}
+	if v, _ := conf.Document["openapi"].(string); !strings.HasPrefix(v, "3.") {
+		return nil, fmt.Errorf("unsupported OpenAPI version %q", v)
+	}
+
+	c := &openAPICompiler{doc: conf.Document}
+	paths, _ := conf.Document["paths"].(map[string]interface{})
+	doc := &openAPIDocument{}
+	for template, item := range paths {
+		itemMap, err := c.resolve(item)
+		if err != nil {
+			return nil, err
+		}
+		route, err := c.route(template, itemMap)
+		if err != nil {
+			return nil, fmt.Errorf("path %s: %v", template, err)
+		}
+		doc.routes = append(doc.routes, route)
+	}
+
+	// concrete paths are matched before templated ones
+	sort.Slice(doc.routes, func(i, j int) bool {
+		a, b := doc.routes[i], doc.routes[j]
+		if len(a.params) != len(b.params) {
+			return len(a.params) < len(b.params)
+		}
+		return len(a.template) > len(b.template)
+	})
+	return doc, nil
+}
+
+var openAPIPathParam = regexp.MustCompile(`\{([^}/]+)\}`)
+
+func (c *openAPICompiler) route(template string, item map[string]interface{}) (*openAPIRoute, error) {
+	route := &openAPIRoute{
+		template: template,
+		ops:      make(map[string]*openAPIOperation),
+	}
+
+	pattern := "^"
+	last := 0
+	for _, loc := range openAPIPathParam.FindAllStringSubmatchIndex(template, -1) {
+		pattern += regexp.QuoteMeta(template[last:loc[0]]) + "([^/]+)"
+		route.params = append(route.params, template[loc[2]:loc[3]])
+		last = loc[1]
+	}
+	pattern += regexp.QuoteMeta(template[last:]) + "$"
+	rx, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	route.rx = rx
+
+	shared, err := c.params(item["parameters"])
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range openAPIMethods {
+		opNode, ok := item[method]
+		if !ok {
+			continue
+		}
+		opMap, err := c.resolve(opNode)
+		if err != nil {
+			return nil, err
+		}
+		op, err := c.operation(opMap, shared)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", method, err)
+		}
+		route.ops[strings.ToUpper(method)] = op
+	}
+	return route, nil
+}
+
+func (c *openAPICompiler) operation(opMap map[string]interface{}, shared []*openAPIParam) (*openAPIOperation, error) {
+	params, err := c.params(opMap["parameters"])
+	if err != nil {
+		return nil, err
+	}
+	op := &openAPIOperation{responses: make(map[string]*openAPIResponse)}
+
+	// operation parameters override the path item ones
+	seen := make(map[string]bool, len(params))
+	for _, p := range params {
+		seen[p.in+":"+p.name] = true
+	}
+	op.params = params
+	for _, p := range shared {
+		if !seen[p.in+":"+p.name] {
+			op.params = append(op.params, p)
+		}
+	}
+
+	if bodyNode, ok := opMap["requestBody"]; ok {
+		bodyMap, err := c.resolve(bodyNode)
+		if err != nil {
+			return nil, err
+		}
+		op.body = &openAPIBody{}
+		op.body.required, _ = bodyMap["required"].(bool)
+		if op.body.content, err = c.content(bodyMap["content"]); err != nil {
+			return nil, err
+		}
+	}
+
+	responses, _ := opMap["responses"].(map[string]interface{})
+	for code, resNode := range responses {
+		resMap, err := c.resolve(resNode)
+		if err != nil {
+			return nil, err
+		}
+		res := &openAPIResponse{}
+		resHeaders, _ := resMap["headers"].(map[string]interface{})
+		for name, hNode := range resHeaders {
+			hMap, err := c.resolve(hNode)
+			if err != nil {
+				return nil, err
+			}
+			h, err := c.param(name, "header", hMap)
+			if err != nil {
+				return nil, err
+			}
+			res.headers = append(res.headers, h)
+		}
+		if res.content, err = c.content(resMap["content"]); err != nil {
+			return nil, err
+		}
+		op.responses[strings.ToUpper(code)] = res
+	}
+	return op, nil
+}
+
+func (c *openAPICompiler) params(node interface{}) ([]*openAPIParam, error) {
+	list, _ := node.([]interface{})
+	params := make([]*openAPIParam, 0, len(list))
+	for _, pNode := range list {
+		pMap, err := c.resolve(pNode)
+		if err != nil {
+			return nil, err
+		}
+		name, _ := pMap["name"].(string)
+		in, _ := pMap["in"].(string)
+		p, err := c.param(name, in, pMap)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, p)
+	}
+	return params, nil
+}
+
+func (c *openAPICompiler) param(name, in string, pMap map[string]interface{}) (*openAPIParam, error) {
+	p := &openAPIParam{name: name, in: in}
+	p.required, _ = pMap["required"].(bool)
+	if in == "path" {
+		p.required = true
+	}
+	if schemaNode, ok := pMap["schema"]; ok {
+		var err error
+		if p.schema, p.validator, err = c.schema(schemaNode); err != nil {
+			return nil, fmt.Errorf("parameter %s: %v", name, err)
+		}
+	}
+	return p, nil
+}
+
+func (c *openAPICompiler) content(node interface{}) (map[string]*openAPIMediaType, error) {
+	contentMap, _ := node.(map[string]interface{})
+	content := make(map[string]*openAPIMediaType, len(contentMap))
+	for mediaType, mtNode := range contentMap {
+		mtMap, err := c.resolve(mtNode)
+		if err != nil {
+			return nil, err
+		}
+		mt := &openAPIMediaType{}
+		if schemaNode, ok := mtMap["schema"]; ok {
+			if mt.schema, mt.validator, err = c.schema(schemaNode); err != nil {
+				return nil, fmt.Errorf("%s: %v", mediaType, err)
+			}
+		}
+		content[strings.ToLower(mediaType)] = mt
+	}
+	return content, nil
+}
+
+func (c *openAPICompiler) schema(node interface{}) (map[string]interface{}, *gojsonschema.Schema, error) {
+	converted, err := c.convertSchema(node, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	schemaMap, _ := converted.(map[string]interface{})
+	validator, err := gojsonschema.NewSchema(gojsonschema.NewGoLoader(schemaMap))
+	if err != nil {
+		return nil, nil, err
+	}
+	return schemaMap, validator, nil
+}
+
+// resolve follows a local $ref, such as "#/components/parameters/limit".
+func (c *openAPICompiler) resolve(node interface{}) (map[string]interface{}, error) {
+	for depth := 0; depth < openAPIMaxRefDepth; depth++ {
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected an object")
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return m, nil
+		}
+		if node, ok = c.lookup(ref); !ok {
+			return nil, fmt.Errorf("unresolved reference %q", ref)
+		}
+	}
+	return nil, errors.New("reference loop")
+}
+
+func (c *openAPICompiler) lookup(ref string) (interface{}, bool) {
+	if !strings.HasPrefix(ref, "#/") {
+		return nil, false
+	}
+	var node interface{} = c.doc
+	for _, part := range strings.Split(ref[2:], "/") {
+		part = strings.Replace(strings.Replace(part, "~1", "/", -1), "~0", "~", -1)
+		m, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if node, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+// convertSchema copies an OpenAPI schema object into JSON Schema draft 4,
+// inlining references, turning nullable into a "null" type and dropping
+// or translating the formats gojsonschema doesn't know about. Recursive
+// schemas are cut off at openAPIMaxRefDepth.
+func (c *openAPICompiler) convertSchema(node interface{}, depth int) (interface{}, error) {
+	switch x := node.(type) {
+	case map[string]interface{}:
+		if ref, ok := x["$ref"].(string); ok {
+			if depth >= openAPIMaxRefDepth {
+				return map[string]interface{}{}, nil
+			}
+			target, ok := c.lookup(ref)
+			if !ok {
+				return nil, fmt.Errorf("unresolved reference %q", ref)
+			}
+			return c.convertSchema(target, depth+1)
+		}
+
+		out := make(map[string]interface{}, len(x))
+		for k, v := range x {
+			switch k {
+			case "nullable", "discriminator", "readOnly", "writeOnly", "xml",
+				"externalDocs", "example", "deprecated":
+				continue
+			case "enum", "required", "type", "format":
+				// not schemas, copied as they are
+				out[k] = v
+				continue
+			}
+			converted, err := c.convertSchema(v, depth)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = converted
+		}
+
+		if nullable, _ := x["nullable"].(bool); nullable {
+			if t, ok := out["type"].(string); ok {
+				out["type"] = []interface{}{t, "null"}
+			}
+		}
+		if format, ok := out["format"].(string); ok && !gojsonschema.FormatCheckers.Has(format) {
+			delete(out, "format")
+			if format == "int32" {
+				if _, ok := out["minimum"]; !ok {
+					out["minimum"] = math.MinInt32
+				}
+				if _, ok := out["maximum"]; !ok {
+					out["maximum"] = math.MaxInt32
+				}
+			}
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, v := range x {
+			converted, err := c.convertSchema(v, depth)
+			if err != nil {
+				return nil, err
+			}
+			out[i] = converted
+		}
+		return out, nil
+	}
+	return node, nil
+}
+
+// match returns the operation for a request path relative to the listen
+// path, and the path parameters. It returns nil if the path or method are
+// not in the document.
+func (d *openAPIDocument) match(method, path string) (*openAPIOperation, map[string]string) {
+	for _, route := range d.routes {
+		m := route.rx.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		op := route.ops[method]
+		if op == nil {
+			return nil, nil
+		}
+		params := make(map[string]string, len(route.params))
+		for i, name := range route.params {
+			value, err := url.PathUnescape(m[i+1])
+			if err != nil {
+				value = m[i+1]
+			}
+			params[name] = value
+		}
+		return op, params
+	}
+	return nil, nil
+}
+
+// validateRequest checks the parameters and body of r. The body is left
+// ready to be read again.
+func (op *openAPIOperation) validateRequest(r *http.Request, pathParams map[string]string) []openAPIViolation {
+	var violations []openAPIViolation
+	for _, p := range op.params {
+		var values []string
+		switch p.in {
+		case "path":
+			if v, ok := pathParams[p.name]; ok {
+				values = []string{v}
+			}
+		case "query":
+			values = r.URL.Query()[p.name]
+		case "header":
+			values = r.Header[http.CanonicalHeaderKey(p.name)]
+		case "cookie":
+			if cookie, err := r.Cookie(p.name); err == nil {
+				values = []string{cookie.Value}
+			}
+		}
+		violations = append(violations, p.validate(values)...)
+	}
+
+	if op.body != nil {
+		body, _ := ioutil.ReadAll(r.Body)
+		r.Body.Close()
+		violations = append(violations, validateOpenAPIContent("body", op.body.content,
+			r.Header.Get("Content-Type"), body, op.body.required)...)
+	}
+	return violations
+}
+
+// validate checks the raw values of a parameter.
+func (p *openAPIParam) validate(values []string) []openAPIViolation {
+	if len(values) == 0 {
+		if p.required {
+			return []openAPIViolation{{In: p.in, Name: p.name, Message: "is required"}}
+		}
+		return nil
+	}
+	if p.validator == nil {
+		return nil
+	}
+
+	value, err := coerceOpenAPIValue(p.schema, values)
+	if err != nil {
+		return []openAPIViolation{{In: p.in, Name: p.name, Message: err.Error()}}
+	}
+	return schemaViolations(p.in, p.name, p.validator, gojsonschema.NewGoLoader(value))
+}
+
+// coerceOpenAPIValue converts parameter strings to the type in schema.
+func coerceOpenAPIValue(schema map[string]interface{}, values []string) (interface{}, error) {
+	switch openAPIType(schema) {
+	case "array":
+		if len(values) == 1 {
+			values = strings.Split(values[0], ",")
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		out := make([]interface{}, len(values))
+		for i, v := range values {
+			item, err := coerceOpenAPIValue(items, []string{v})
+			if err != nil {
+				return nil, err
+			}
+			out[i] = item
+		}
+		return out, nil
+	case "integer":
+		n, err := strconv.ParseInt(values[0], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an integer", values[0])
+		}
+		return n, nil
+	case "number":
+		n, err := strconv.ParseFloat(values[0], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", values[0])
+		}
+		return n, nil
+	case "boolean":
+		b, err := strconv.ParseBool(values[0])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a boolean", values[0])
+		}
+		return b, nil
+	}
+	return values[0], nil
+}
+
+func openAPIType(schema map[string]interface{}) string {
+	switch t := schema["type"].(type) {
+	case string:
+		return t
+	case []interface{}:
+		// nullable types
+		for _, v := range t {
+			if s, _ := v.(string); s != "null" {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// validateOpenAPIContent checks a body against the media types allowed
+// for it. JSON and form bodies are checked against their schema.
+func validateOpenAPIContent(in string, content map[string]*openAPIMediaType, contentType string, body []byte, required bool) []openAPIViolation {
+	if len(body) == 0 {
+		if required {
+			return []openAPIViolation{{In: in, Message: "is required"}}
+		}
+		return nil
+	}
+	if len(content) == 0 {
+		return nil
+	}
+
+	// clients often leave out the content type of JSON bodies
+	mediaType := headers.ApplicationJSON
+	if contentType != "" {
+		var err error
+		if mediaType, _, err = mime.ParseMediaType(contentType); err != nil {
+			mediaType = strings.ToLower(contentType)
+		}
+	}
+	mt := content[mediaType]
+	if mt == nil {
+		if i := strings.IndexByte(mediaType, '/'); i > 0 {
+			mt = content[mediaType[:i]+"/*"]
+		}
+	}
+	if mt == nil {
+		mt = content["*/*"]
+	}
+	if mt == nil {
+		return []openAPIViolation{{In: in, Message: fmt.Sprintf("content type %q is not allowed", contentType)}}
+	}
+	if mt.validator == nil {
+		return nil
+	}
+
+	switch {
+	case mediaType == "application/json" || strings.HasSuffix(mediaType, "+json"):
+		return schemaViolations(in, "", mt.validator, gojsonschema.NewBytesLoader(body))
+	case mediaType == "application/x-www-form-urlencoded":
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return []openAPIViolation{{In: in, Message: err.Error()}}
+		}
+		props, _ := mt.schema["properties"].(map[string]interface{})
+		obj := make(map[string]interface{}, len(form))
+		for k, values := range form {
+			propSchema, _ := props[k].(map[string]interface{})
+			v, err := coerceOpenAPIValue(propSchema, values)
+			if err != nil {
+				return []openAPIViolation{{In: in, Name: k, Message: err.Error()}}
+			}
+			obj[k] = v
+		}
+		return schemaViolations(in, "", mt.validator, gojsonschema.NewGoLoader(obj))
+	}
+	return nil
+}
+
+func schemaViolations(in, name string, validator *gojsonschema.Schema, loader gojsonschema.JSONLoader) []openAPIViolation {
+	result, err := validator.Validate(loader)
+	if err != nil {
+		return []openAPIViolation{{In: in, Name: name, Message: err.Error()}}
+	}
+	var violations []openAPIViolation
+	for _, desc := range result.Errors() {
+		v := openAPIViolation{In: in, Name: name, Message: desc.Description()}
+		if field := desc.Field(); field != "(root)" && name == "" {
+			v.Name = field
+		}
+		violations = append(violations, v)
+	}
+	return violations
+}
+
+// validateResponse checks the status, headers and body of res against the
+// documented responses.
+func (op *openAPIOperation) validateResponse(res *http.Response, body []byte) []openAPIViolation {
+	code := strconv.Itoa(res.StatusCode)
+	spec := op.responses[code]
+	if spec == nil {
+		spec = op.responses[code[:1]+"XX"]
+	}
+	if spec == nil {
+		spec = op.responses["DEFAULT"]
+	}
+	if spec == nil {
+		return []openAPIViolation{{In: "response", Message: "status " + code + " is not documented"}}
+	}
+
+	var violations []openAPIViolation
+	for _, h := range spec.headers {
+		v := h.validate(res.Header[http.CanonicalHeaderKey(h.name)])
+		for i := range v {
+			v[i].In = "response header"
+		}
+		violations = append(violations, v...)
+	}
+	return append(violations, validateOpenAPIContent("response", spec.content,
+		res.Header.Get("Content-Type"), body, false)...)
+}
@@ -0,0 +1,233 @@
+package gateway
+
+import (
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/storage"
+)
+
+const (
+	defaultOutlierConsecutiveErrors = 5
+	defaultOutlierBaseEjectionTime  = 30
+	defaultOutlierMaxEjectionTime   = 300
+
+	outlierEjectedKeyPrefix   = "outlier-ejected-"
+	outlierEjectionsKeyPrefix = "outlier-ejections-"
+)
+
+// outlierSyncInterval is how often the ejection of a host is read from the
+// store.
+var outlierSyncInterval = time.Second
+
+// outlierDetector tracks the results of proxied requests per upstream host
+// and ejects hosts that keep failing. Consecutive failures are counted by
+// each gateway on its own, while ejections are stored in Redis so that the
+// whole cluster stops sending traffic to an ejected host. Each gateway
+// keeps the ejections in memory and reads them from Redis in the
+// background.
+type outlierDetector struct {
+	apiID string
+	conf  apidef.OutlierDetectionConfig
+	store storage.Handler
+
+	mu    sync.Mutex
+	hosts map[string]*outlierHostState
+}
+
+type outlierHostState struct {
+	failures int
+	// ejected is set on the gateway that ejected the host, which is
+	// the one to fire the recovery event once the ejection expires.
+	ejected bool
+	// ejectedUntil is when the ejection of the host ends, as last read
+	// from the store or set by this gateway at ejectedAt
+	ejectedUntil time.Time
+	ejectedAt    time.Time
+	syncedAt     time.Time
+	syncing      bool
+}
+
+func newOutlierDetector(spec *APISpec, store storage.Handler) *outlierDetector {
+	conf := spec.Proxy.OutlierDetection
+	if conf.ConsecutiveErrors <= 0 {
+		conf.ConsecutiveErrors = defaultOutlierConsecutiveErrors
+	}
+	if conf.BaseEjectionTime <= 0 {
+		conf.BaseEjectionTime = defaultOutlierBaseEjectionTime
+	}
+	if conf.MaxEjectionTime <= 0 {
+		conf.MaxEjectionTime = defaultOutlierMaxEjectionTime
+	}
+	if conf.MaxEjectionTime < conf.BaseEjectionTime {
+		conf.MaxEjectionTime = conf.BaseEjectionTime
+	}
+	return &outlierDetector{
+		apiID: spec.APIID,
+		conf:  conf,
+		store: store,
+		hosts: make(map[string]*outlierHostState),
+	}
+}
+
+func (o *outlierDetector) ejectedKey(host string) string {
+	return outlierEjectedKeyPrefix + o.apiID + "-" + host
+}
+
+func (o *outlierDetector) ejectionsKey(host string) string {
+	return outlierEjectionsKeyPrefix + o.apiID + "-" + host
+}
+
+func (o *outlierDetector) state(host string) *outlierHostState {
+	st := o.hosts[host]
+	if st == nil {
+		st = &outlierHostState{}
+		o.hosts[host] = st
+	}
+	return st
+}
+
+// ejectionTime returns how long a host is ejected for on its nth ejection.
+func (o *outlierDetector) ejectionTime(n int64) int64 {
+	t := o.conf.BaseEjectionTime
+	for i := int64(1); i < n && t < o.conf.MaxEjectionTime; i++ {
+		t *= 2
+	}
+	if t > o.conf.MaxEjectionTime {
+		t = o.conf.MaxEjectionTime
+	}
+	return t
+}
+
+// Ejected reports whether the host of target is currently ejected. If this
+// gateway ejected the host and the ejection has since expired, the host is
+// marked as recovered.
+func (o *outlierDetector) Ejected(spec *APISpec, target string) bool {
+	u, err := url.Parse(target)
+	if err != nil {
+		return false
+	}
+
+	now := time.Now()
+	o.mu.Lock()
+	st := o.state(u.Host)
+	if !st.syncing && now.Sub(st.syncedAt) >= outlierSyncInterval {
+		st.syncing = true
+		go o.sync(u.Host)
+	}
+	if now.Before(st.ejectedUntil) {
+		o.mu.Unlock()
+		return true
+	}
+	recovered := st.ejected
+	if recovered {
+		st.ejected = false
+		st.failures = 0
+	}
+	o.mu.Unlock()
+
+	if recovered {
+		log.WithFields(logrus.Fields{
+			"prefix": "outlier-detection",
+			"api_id": o.apiID,
+		}).Info("Host returned to load balancing: ", u.Host)
+		spec.FireEvent(EventHostRecovered, EventHostEjectedMeta{
+			EventMetaDefault: EventMetaDefault{Message: "Upstream host returned to load balancing"},
+			APIID:            o.apiID,
+			Host:             u.Host,
+		})
+	}
+	return false
+}
+
+// sync reads the ejection of host from the store.
+func (o *outlierDetector) sync(host string) {
+	started := time.Now()
+	// TTL is negative if the key does not exist
+	ttl, _ := o.store.GetExp(o.ejectedKey(host))
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	st := o.state(host)
+	st.syncing = false
+	st.syncedAt = time.Now()
+	if st.ejectedAt.After(started) {
+		// ejected by this gateway since, the store may not have it
+		return
+	}
+	st.ejectedUntil = time.Time{}
+	if ttl > 0 {
+		st.ejectedUntil = started.Add(time.Duration(ttl) * time.Second)
+	}
+}
+
+// RecordSuccess resets the consecutive failure count for host.
+func (o *outlierDetector) RecordSuccess(host string) {
+	o.mu.Lock()
+	if st := o.hosts[host]; st != nil {
+		st.failures = 0
+	}
+	o.mu.Unlock()
+}
+
+// RecordFailure counts a failed request to host, ejecting it once it has
+// failed ConsecutiveErrors times in a row.
+func (o *outlierDetector) RecordFailure(spec *APISpec, host string) {
+	o.mu.Lock()
+	st := o.state(host)
+	st.failures++
+	failures := st.failures
+	if failures < o.conf.ConsecutiveErrors || st.ejected {
+		o.mu.Unlock()
+		return
+	}
+	st.ejected = true
+	st.failures = 0
+	// until the ejection time is known
+	st.ejectedAt = time.Now()
+	st.ejectedUntil = st.ejectedAt.Add(time.Duration(o.conf.BaseEjectionTime) * time.Second)
+	o.mu.Unlock()
+
+	// Another gateway may have ejected the host already.
+	if _, err := o.store.GetKey(o.ejectedKey(host)); err == nil {
+		o.mu.Lock()
+		st.ejected = false
+		o.mu.Unlock()
+		return
+	}
+
+	// The ejection count is kept for MaxEjectionTime after the ejection
+	// ends, so a host that fails again soon after recovering is ejected
+	// for longer.
+	ejectionsKey := o.ejectionsKey(host)
+	n := o.store.IncrememntWithExpire(o.store.GetKeyPrefix()+ejectionsKey, o.conf.MaxEjectionTime)
+	ejectFor := o.ejectionTime(n)
+	o.store.SetExp(ejectionsKey, ejectFor+o.conf.MaxEjectionTime)
+	if err := o.store.SetKey(o.ejectedKey(host), strconv.FormatInt(n, 10), ejectFor); err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "outlier-detection",
+			"api_id": o.apiID,
+		}).Error("Failed to store host ejection: ", err)
+	}
+	o.mu.Lock()
+	st.ejectedAt = time.Now()
+	st.ejectedUntil = st.ejectedAt.Add(time.Duration(ejectFor) * time.Second)
+	o.mu.Unlock()
+
+	log.WithFields(logrus.Fields{
+		"prefix": "outlier-detection",
+		"api_id": o.apiID,
+	}).Warningf("Host ejected from load balancing for %ds: %s", ejectFor, host)
+	spec.FireEvent(EventHostEjected, EventHostEjectedMeta{
+		EventMetaDefault:  EventMetaDefault{Message: "Upstream host ejected from load balancing"},
+		APIID:             o.apiID,
+		Host:              host,
+		ConsecutiveErrors: failures,
+		EjectionTime:      ejectFor,
+	})
+}
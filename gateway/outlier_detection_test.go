@@ -0,0 +1,163 @@
+package gateway
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+)
+
+func outlierTestSpec(targets ...string) *APISpec {
+	spec := BuildAPI(func(spec *APISpec) {
+		spec.APIID = "outlier-test"
+		spec.Proxy.ListenPath = "/"
+		spec.Proxy.EnableLoadBalancing = true
+		spec.Proxy.Targets = targets
+		spec.Proxy.OutlierDetection = apidef.OutlierDetectionConfig{
+			Enabled:           true,
+			ConsecutiveErrors: 2,
+			BaseEjectionTime:  10,
+			MaxEjectionTime:   60,
+		}
+	})[0]
+	spec.Proxy.StructuredTargetList = apidef.NewHostListFromList(spec.Proxy.Targets)
+	spec.outliers = newOutlierDetector(spec, GlobalHostChecker.store)
+	return spec
+}
+
+func TestOutlierDetection(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	spec := outlierTestSpec(bad.URL, good.URL)
+	badHost := bad.Listener.Addr().String()
+	defer func() {
+		spec.outliers.store.DeleteKey(spec.outliers.ejectedKey(badHost))
+		spec.outliers.store.DeleteKey(spec.outliers.ejectionsKey(badHost))
+	}()
+
+	events := make(chan config.EventMessage, 2)
+	cb := func(em config.EventMessage) {
+		events <- em
+	}
+	spec.EventPaths = map[apidef.TykEvent][]config.TykEventHandler{
+		EventHostEjected:   {&testEventHandler{cb}},
+		EventHostRecovered: {&testEventHandler{cb}},
+	}
+
+	remote, _ := url.Parse(good.URL)
+	proxy := TykNewSingleHostReverseProxy(remote, spec)
+	do := func() int {
+		rec := httptest.NewRecorder()
+		proxy.ServeHTTP(rec, TestReq(t, "GET", "/", nil))
+		return rec.Code
+	}
+
+	// round robin sends every other request to the bad host
+	failures := 0
+	for i := 0; i < 4; i++ {
+		if do() != http.StatusOK {
+			failures++
+		}
+	}
+	if failures != 2 {
+		t.Fatalf("wanted 2 failed requests before ejection, got %d", failures)
+	}
+
+	em := <-events
+	if em.Type != EventHostEjected {
+		t.Fatalf("wanted %s event, got %s", EventHostEjected, em.Type)
+	}
+	if meta := em.Meta.(EventHostEjectedMeta); meta.Host != badHost || meta.EjectionTime != 10 {
+		t.Fatalf("unexpected event meta: %+v", meta)
+	}
+
+	for i := 0; i < 4; i++ {
+		if code := do(); code != http.StatusOK {
+			t.Fatalf("ejected host should not receive traffic, got %d", code)
+		}
+	}
+
+	// Expire the ejection, the host should be back in rotation once the
+	// ejections are read from the store.
+	spec.outliers.store.DeleteKey(spec.outliers.ejectedKey(badHost))
+	spec.outliers.sync(badHost)
+	failures = 0
+	for i := 0; i < 2; i++ {
+		if do() != http.StatusOK {
+			failures++
+		}
+	}
+	if failures != 1 {
+		t.Fatalf("recovered host should receive traffic again, got %d failures", failures)
+	}
+	if em := <-events; em.Type != EventHostRecovered {
+		t.Fatalf("wanted %s event, got %s", EventHostRecovered, em.Type)
+	}
+}
+
+func TestOutlierDetectionAllEjected(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer bad.Close()
+
+	spec := outlierTestSpec(bad.URL)
+	badHost := bad.Listener.Addr().String()
+	defer func() {
+		spec.outliers.store.DeleteKey(spec.outliers.ejectedKey(badHost))
+		spec.outliers.store.DeleteKey(spec.outliers.ejectionsKey(badHost))
+	}()
+
+	spec.outliers.RecordFailure(spec, badHost)
+	spec.outliers.RecordFailure(spec, badHost)
+	if !spec.outliers.Ejected(spec, bad.URL) {
+		t.Fatal("host should be ejected")
+	}
+
+	// With nothing else to send traffic to, the ejected host is used
+	// rather than failing every request.
+	host, err := nextTarget(spec.Proxy.StructuredTargetList, spec)
+	if err != nil || host != bad.URL {
+		t.Fatalf("wanted %s, got %q (%v)", bad.URL, host, err)
+	}
+}
+
+func TestOutlierDetectionShared(t *testing.T) {
+	spec := outlierTestSpec("http://10.0.0.1:8080")
+	other := newOutlierDetector(spec, GlobalHostChecker.store)
+	defer func() {
+		spec.outliers.store.DeleteKey(spec.outliers.ejectedKey("10.0.0.1:8080"))
+		spec.outliers.store.DeleteKey(spec.outliers.ejectionsKey("10.0.0.1:8080"))
+	}()
+
+	if other.Ejected(spec, "http://10.0.0.1:8080") {
+		t.Fatal("host should not be ejected yet")
+	}
+	spec.outliers.RecordFailure(spec, "10.0.0.1:8080")
+	spec.outliers.RecordFailure(spec, "10.0.0.1:8080")
+
+	// the other gateway reads the ejection in the background
+	other.sync("10.0.0.1:8080")
+	if !other.Ejected(spec, "http://10.0.0.1:8080") {
+		t.Fatal("host ejected by another gateway should be ejected")
+	}
+}
+
+func TestOutlierEjectionTime(t *testing.T) {
+	o := newOutlierDetector(outlierTestSpec("http://example.com"), nil)
+	for n, want := range map[int64]int64{1: 10, 2: 20, 3: 40, 4: 60, 10: 60} {
+		if got := o.ejectionTime(n); got != want {
+			t.Errorf("ejection %d: wanted %ds, got %ds", n, want, got)
+		}
+	}
+}
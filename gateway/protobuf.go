@@ -0,0 +1,705 @@
+package gateway
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// A small protobuf codec driven by a FileDescriptorSet, as written by
+// protoc --descriptor_set_out --include_imports. It converts between the
+// binary wire format and the proto3 JSON mapping for body conversion.
+// Groups and the well-known types' special JSON forms are not supported.
+
+const (
+	protoWireVarint  = 0
+	protoWireFixed64 = 1
+	protoWireBytes   = 2
+	protoWireFixed32 = 5
+)
+
+// field types, as in google.protobuf.FieldDescriptorProto.Type
+const (
+	protoTypeDouble   = 1
+	protoTypeFloat    = 2
+	protoTypeInt64    = 3
+	protoTypeUint64   = 4
+	protoTypeInt32    = 5
+	protoTypeFixed64  = 6
+	protoTypeFixed32  = 7
+	protoTypeBool     = 8
+	protoTypeString   = 9
+	protoTypeGroup    = 10
+	protoTypeMessage  = 11
+	protoTypeBytes    = 12
+	protoTypeUint32   = 13
+	protoTypeEnum     = 14
+	protoTypeSfixed32 = 15
+	protoTypeSfixed64 = 16
+	protoTypeSint32   = 17
+	protoTypeSint64   = 18
+)
+
+const protoLabelRepeated = 3
+
+type protoMessage struct {
+	name     string
+	fields   []*protoField
+	byNumber map[uint64]*protoField
+	byName   map[string]*protoField
+	mapEntry bool
+}
+
+type protoField struct {
+	name     string
+	jsonName string
+	number   uint64
+	typ      int
+	repeated bool
+	typeName string
+	message  *protoMessage
+	enum     *protoEnum
+}
+
+type protoEnum struct {
+	byName   map[string]int32
+	byNumber map[int32]string
+}
+
+// isMap reports whether the field is a map, which protobuf encodes as a
+// repeated message with a key and a value field.
+func (f *protoField) isMap() bool {
+	return f.repeated && f.message != nil && f.message.mapEntry
+}
+
+func (f *protoField) packable() bool {
+	switch f.typ {
+	case protoTypeString, protoTypeBytes, protoTypeMessage, protoTypeGroup:
+		return false
+	}
+	return true
+}
+
+// loadProtoMessage parses a FileDescriptorSet and returns the message
+// with the given full name, such as "acme.v1.Order".
+func loadProtoMessage(descriptorSet []byte, name string) (*protoMessage, error) {
+	messages := make(map[string]*protoMessage)
+	enums := make(map[string]*protoEnum)
+	var fields []*protoField
+
+	err := protoEachField(descriptorSet, func(num uint64, _ int, file []byte, _ uint64) error {
+		if num != 1 {
+			return nil
+		}
+		var pkg string
+		var messageTypes, enumTypes [][]byte
+		err := protoEachField(file, func(num uint64, _ int, b []byte, _ uint64) error {
+			switch num {
+			case 2:
+				pkg = string(b)
+			case 4:
+				messageTypes = append(messageTypes, b)
+			case 5:
+				enumTypes = append(enumTypes, b)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		scope := ""
+		if pkg != "" {
+			scope = "." + pkg
+		}
+		for _, b := range enumTypes {
+			if err := parseProtoEnum(b, scope, enums); err != nil {
+				return err
+			}
+		}
+		for _, b := range messageTypes {
+			if err := parseProtoMessage(b, scope, messages, enums, &fields); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %v", err)
+	}
+
+	for _, f := range fields {
+		switch f.typ {
+		case protoTypeMessage:
+			if f.message = messages[f.typeName]; f.message == nil {
+				return nil, fmt.Errorf("unknown message type %s", f.typeName)
+			}
+		case protoTypeEnum:
+			if f.enum = enums[f.typeName]; f.enum == nil {
+				return nil, fmt.Errorf("unknown enum type %s", f.typeName)
+			}
+		case protoTypeGroup:
+			return nil, fmt.Errorf("field %s: groups are not supported", f.name)
+		}
+	}
+
+	msg := messages["."+strings.TrimPrefix(name, ".")]
+	if msg == nil {
+		return nil, fmt.Errorf("message %s not found in descriptor set", name)
+	}
+	return msg, nil
+}
+
+func parseProtoMessage(b []byte, scope string, messages map[string]*protoMessage, enums map[string]*protoEnum, fields *[]*protoField) error {
+	msg := &protoMessage{
+		byNumber: make(map[uint64]*protoField),
+		byName:   make(map[string]*protoField),
+	}
+	var fieldBufs, nested, nestedEnums [][]byte
+	err := protoEachField(b, func(num uint64, _ int, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			msg.name = string(v)
+		case 2:
+			fieldBufs = append(fieldBufs, v)
+		case 3:
+			nested = append(nested, v)
+		case 4:
+			nestedEnums = append(nestedEnums, v)
+		case 7:
+			// MessageOptions.map_entry
+			return protoEachField(v, func(num uint64, _ int, _ []byte, x uint64) error {
+				if num == 7 {
+					msg.mapEntry = x != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	fullName := scope + "." + msg.name
+	messages[fullName] = msg
+
+	for _, fb := range fieldBufs {
+		f := &protoField{}
+		err := protoEachField(fb, func(num uint64, _ int, v []byte, x uint64) error {
+			switch num {
+			case 1:
+				f.name = string(v)
+			case 3:
+				f.number = x
+			case 4:
+				f.repeated = x == protoLabelRepeated
+			case 5:
+				f.typ = int(x)
+			case 6:
+				f.typeName = string(v)
+			case 10:
+				f.jsonName = string(v)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if f.jsonName == "" {
+			f.jsonName = protoJSONName(f.name)
+		}
+		msg.fields = append(msg.fields, f)
+		msg.byNumber[f.number] = f
+		msg.byName[f.name] = f
+		msg.byName[f.jsonName] = f
+		*fields = append(*fields, f)
+	}
+
+	for _, eb := range nestedEnums {
+		if err := parseProtoEnum(eb, fullName, enums); err != nil {
+			return err
+		}
+	}
+	for _, nb := range nested {
+		if err := parseProtoMessage(nb, fullName, messages, enums, fields); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseProtoEnum(b []byte, scope string, enums map[string]*protoEnum) error {
+	enum := &protoEnum{
+		byName:   make(map[string]int32),
+		byNumber: make(map[int32]string),
+	}
+	var name string
+	err := protoEachField(b, func(num uint64, _ int, v []byte, _ uint64) error {
+		switch num {
+		case 1:
+			name = string(v)
+		case 2:
+			var valueName string
+			var number int32
+			err := protoEachField(v, func(num uint64, _ int, v []byte, x uint64) error {
+				switch num {
+				case 1:
+					valueName = string(v)
+				case 2:
+					number = int32(x)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			enum.byName[valueName] = number
+			if _, ok := enum.byNumber[number]; !ok {
+				enum.byNumber[number] = valueName
+			}
+		}
+		return nil
+	})
+	enums[scope+"."+name] = enum
+	return err
+}
+
+// protoJSONName is the lowerCamelCase JSON name protoc gives a field.
+func protoJSONName(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// protoEachField calls fn for each field of an encoded message. Length
+// delimited fields are passed as b, the others as x.
+func protoEachField(buf []byte, fn func(num uint64, wire int, b []byte, x uint64) error) error {
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return errors.New("bad field tag")
+		}
+		buf = buf[n:]
+		num, wire := tag>>3, int(tag&7)
+
+		var b []byte
+		var x uint64
+		switch wire {
+		case protoWireVarint:
+			if x, n = binary.Uvarint(buf); n <= 0 {
+				return errors.New("bad varint")
+			}
+			buf = buf[n:]
+		case protoWireFixed64:
+			if len(buf) < 8 {
+				return errors.New("truncated fixed64")
+			}
+			x = binary.LittleEndian.Uint64(buf)
+			buf = buf[8:]
+		case protoWireFixed32:
+			if len(buf) < 4 {
+				return errors.New("truncated fixed32")
+			}
+			x = uint64(binary.LittleEndian.Uint32(buf))
+			buf = buf[4:]
+		case protoWireBytes:
+			l, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < l {
+				return errors.New("truncated length delimited field")
+			}
+			b = buf[n : n+int(l)]
+			buf = buf[n+int(l):]
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+		if err := fn(num, wire, b, x); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// decode converts an encoded message into its JSON form.
+func (m *protoMessage) decode(buf []byte) (map[string]interface{}, error) {
+	out := make(map[string]interface{})
+	err := protoEachField(buf, func(num uint64, wire int, b []byte, x uint64) error {
+		f := m.byNumber[num]
+		if f == nil {
+			// unknown fields are dropped
+			return nil
+		}
+
+		if f.isMap() {
+			entry, err := f.message.decode(b)
+			if err != nil {
+				return err
+			}
+			obj, _ := out[f.jsonName].(map[string]interface{})
+			if obj == nil {
+				obj = make(map[string]interface{})
+				out[f.jsonName] = obj
+			}
+			keyField, valueField := f.message.byNumber[1], f.message.byNumber[2]
+			key := ""
+			switch k := entry[keyField.jsonName].(type) {
+			case nil:
+				// a default key isn't on the wire
+				if keyField.typ == protoTypeBool {
+					key = "false"
+				} else if keyField.typ != protoTypeString {
+					key = "0"
+				}
+			default:
+				key = fmt.Sprint(k)
+			}
+			obj[key] = entry[valueField.jsonName]
+			return nil
+		}
+
+		var values []interface{}
+		if wire == protoWireBytes && f.packable() {
+			// packed repeated scalars
+			for len(b) > 0 {
+				var x uint64
+				switch protoWireType(f.typ) {
+				case protoWireVarint:
+					var n int
+					if x, n = binary.Uvarint(b); n <= 0 {
+						return errors.New("bad packed varint")
+					}
+					b = b[n:]
+				case protoWireFixed64:
+					if len(b) < 8 {
+						return errors.New("truncated packed fixed64")
+					}
+					x, b = binary.LittleEndian.Uint64(b), b[8:]
+				case protoWireFixed32:
+					if len(b) < 4 {
+						return errors.New("truncated packed fixed32")
+					}
+					x, b = uint64(binary.LittleEndian.Uint32(b)), b[4:]
+				}
+				values = append(values, f.decodeScalar(x))
+			}
+		} else {
+			v, err := f.decodeValue(b, x)
+			if err != nil {
+				return err
+			}
+			values = []interface{}{v}
+		}
+
+		if !f.repeated {
+			out[f.jsonName] = values[len(values)-1]
+			return nil
+		}
+		list, _ := out[f.jsonName].([]interface{})
+		out[f.jsonName] = append(list, values...)
+		return nil
+	})
+	return out, err
+}
+
+func (f *protoField) decodeValue(b []byte, x uint64) (interface{}, error) {
+	switch f.typ {
+	case protoTypeString:
+		return string(b), nil
+	case protoTypeBytes:
+		return base64.StdEncoding.EncodeToString(b), nil
+	case protoTypeMessage:
+		return f.message.decode(b)
+	}
+	return f.decodeScalar(x), nil
+}
+
+func (f *protoField) decodeScalar(x uint64) interface{} {
+	switch f.typ {
+	case protoTypeDouble:
+		return protoJSONFloat(math.Float64frombits(x))
+	case protoTypeFloat:
+		return protoJSONFloat(float64(math.Float32frombits(uint32(x))))
+	case protoTypeInt64, protoTypeSfixed64:
+		// 64 bit integers are strings in JSON, so they aren't rounded
+		return strconv.FormatInt(int64(x), 10)
+	case protoTypeSint64:
+		return strconv.FormatInt(int64(x>>1)^-int64(x&1), 10)
+	case protoTypeUint64, protoTypeFixed64:
+		return strconv.FormatUint(x, 10)
+	case protoTypeInt32, protoTypeSfixed32:
+		return json.Number(strconv.FormatInt(int64(int32(x)), 10))
+	case protoTypeSint32:
+		return json.Number(strconv.FormatInt(int64(int32(uint32(x)>>1)^-int32(x&1)), 10))
+	case protoTypeUint32, protoTypeFixed32:
+		return json.Number(strconv.FormatUint(uint64(uint32(x)), 10))
+	case protoTypeBool:
+		return x != 0
+	case protoTypeEnum:
+		if name, ok := f.enum.byNumber[int32(x)]; ok {
+			return name
+		}
+		return json.Number(strconv.FormatInt(int64(int32(x)), 10))
+	}
+	return nil
+}
+
+func protoJSONFloat(v float64) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return v
+}
+
+func protoWireType(typ int) int {
+	switch typ {
+	case protoTypeDouble, protoTypeFixed64, protoTypeSfixed64:
+		return protoWireFixed64
+	case protoTypeFloat, protoTypeFixed32, protoTypeSfixed32:
+		return protoWireFixed32
+	case protoTypeString, protoTypeBytes, protoTypeMessage:
+		return protoWireBytes
+	}
+	return protoWireVarint
+}
+
+// encode converts the JSON form of a message into the wire format.
+func (m *protoMessage) encode(obj map[string]interface{}) ([]byte, error) {
+	var buf []byte
+	for key, value := range obj {
+		f := m.byName[key]
+		if f == nil {
+			return nil, fmt.Errorf("unknown field %q", key)
+		}
+		if value == nil {
+			continue
+		}
+		var err error
+		if buf, err = f.encode(buf, value); err != nil {
+			return nil, fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return buf, nil
+}
+
+func (f *protoField) encode(buf []byte, value interface{}) ([]byte, error) {
+	if f.isMap() {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected an object")
+		}
+		keyField, valueField := f.message.byNumber[1], f.message.byNumber[2]
+		for k, v := range obj {
+			var key interface{} = k
+			if keyField.typ == protoTypeBool {
+				key = k == "true"
+			}
+			entry, err := keyField.encode(nil, key)
+			if err != nil {
+				return nil, err
+			}
+			if entry, err = valueField.encode(entry, v); err != nil {
+				return nil, err
+			}
+			buf = protoAppendTag(buf, f.number, protoWireBytes)
+			buf = protoAppendBytes(buf, entry)
+		}
+		return buf, nil
+	}
+
+	if !f.repeated {
+		return f.encodeValue(buf, value)
+	}
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, errors.New("expected an array")
+	}
+	if !f.packable() {
+		for _, v := range list {
+			var err error
+			if buf, err = f.encodeValue(buf, v); err != nil {
+				return nil, err
+			}
+		}
+		return buf, nil
+	}
+
+	var packed []byte
+	for _, v := range list {
+		x, err := f.scalarBits(v)
+		if err != nil {
+			return nil, err
+		}
+		packed = protoAppendScalar(packed, protoWireType(f.typ), x)
+	}
+	buf = protoAppendTag(buf, f.number, protoWireBytes)
+	return protoAppendBytes(buf, packed), nil
+}
+
+func (f *protoField) encodeValue(buf []byte, value interface{}) ([]byte, error) {
+	switch f.typ {
+	case protoTypeString:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected a string")
+		}
+		buf = protoAppendTag(buf, f.number, protoWireBytes)
+		return protoAppendBytes(buf, []byte(s)), nil
+	case protoTypeBytes:
+		s, ok := value.(string)
+		if !ok {
+			return nil, errors.New("expected a base64 string")
+		}
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			if b, err = base64.URLEncoding.DecodeString(s); err != nil {
+				return nil, err
+			}
+		}
+		buf = protoAppendTag(buf, f.number, protoWireBytes)
+		return protoAppendBytes(buf, b), nil
+	case protoTypeMessage:
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("expected an object")
+		}
+		b, err := f.message.encode(obj)
+		if err != nil {
+			return nil, err
+		}
+		buf = protoAppendTag(buf, f.number, protoWireBytes)
+		return protoAppendBytes(buf, b), nil
+	}
+
+	x, err := f.scalarBits(value)
+	if err != nil {
+		return nil, err
+	}
+	wire := protoWireType(f.typ)
+	buf = protoAppendTag(buf, f.number, wire)
+	return protoAppendScalar(buf, wire, x), nil
+}
+
+// scalarBits returns the wire value of a JSON number, string, bool or
+// enum name.
+func (f *protoField) scalarBits(value interface{}) (uint64, error) {
+	if f.typ == protoTypeBool {
+		b, ok := value.(bool)
+		if !ok {
+			return 0, errors.New("expected a boolean")
+		}
+		if b {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	var s string
+	switch v := value.(type) {
+	case json.Number:
+		s = v.String()
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'g', -1, 64)
+	default:
+		return 0, fmt.Errorf("unexpected %T", value)
+	}
+
+	switch f.typ {
+	case protoTypeDouble, protoTypeFloat:
+		var v float64
+		switch s {
+		case "NaN":
+			v = math.NaN()
+		case "Infinity":
+			v = math.Inf(1)
+		case "-Infinity":
+			v = math.Inf(-1)
+		default:
+			var err error
+			if v, err = strconv.ParseFloat(s, 64); err != nil {
+				return 0, err
+			}
+		}
+		if f.typ == protoTypeFloat {
+			return uint64(math.Float32bits(float32(v))), nil
+		}
+		return math.Float64bits(v), nil
+	case protoTypeEnum:
+		if n, ok := f.enum.byName[s]; ok {
+			return uint64(int64(n)), nil
+		}
+		n, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("unknown enum value %q", s)
+		}
+		return uint64(n), nil
+	case protoTypeUint64, protoTypeFixed64:
+		return strconv.ParseUint(s, 10, 64)
+	case protoTypeUint32, protoTypeFixed32:
+		return strconv.ParseUint(s, 10, 32)
+	}
+
+	bits := 64
+	switch f.typ {
+	case protoTypeInt32, protoTypeSint32, protoTypeSfixed32:
+		bits = 32
+	}
+	n, err := strconv.ParseInt(s, 10, bits)
+	if err != nil {
+		return 0, err
+	}
+	if f.typ == protoTypeSint32 || f.typ == protoTypeSint64 {
+		return uint64(n<<1) ^ uint64(n>>63), nil
+	}
+	return uint64(n), nil
+}
+
+func protoAppendTag(buf []byte, num uint64, wire int) []byte {
+	return protoAppendVarint(buf, num<<3|uint64(wire))
+}
+
+func protoAppendVarint(buf []byte, x uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], x)
+	return append(buf, tmp[:n]...)
+}
+
+func protoAppendBytes(buf, b []byte) []byte {
+	buf = protoAppendVarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+func protoAppendScalar(buf []byte, wire int, x uint64) []byte {
+	switch wire {
+	case protoWireFixed64:
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], x)
+		return append(buf, tmp[:]...)
+	case protoWireFixed32:
+		var tmp [4]byte
+		binary.LittleEndian.PutUint32(tmp[:], uint32(x))
+		return append(buf, tmp[:]...)
+	}
+	return protoAppendVarint(buf, x)
+}
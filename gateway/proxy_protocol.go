@@ -0,0 +1,221 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+const defaultProxyProtocolTimeout = 5 * time.Second
+
+var (
+	proxyProtocolV1Prefix = []byte("PROXY ")
+	proxyProtocolV2Sig    = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+// the longest v1 header, including the CRLF
+const proxyProtocolV1MaxLen = 107
+
+// proxyProtocolListener wraps ln to read a PROXY protocol header from
+// each connection if it is enabled for the listener on listenPort.
+func proxyProtocolListener(ln net.Listener, listenPort int) net.Listener {
+	globalConf := config.Global()
+	conf := globalConf.HttpServerOptions.ProxyProtocol
+	isControlAPI := listenPort != 0 && listenPort == globalConf.ControlAPIPort && listenPort != globalConf.ListenPort
+	if !conf.Enabled || (isControlAPI && !conf.EnableControlAPI) {
+		return ln
+	}
+
+	timeout := defaultProxyProtocolTimeout
+	if conf.HeaderTimeout > 0 {
+		timeout = time.Duration(conf.HeaderTimeout) * time.Second
+	}
+	mainLog.WithField("port", listenPort).Info("--> PROXY protocol enabled")
+	return &proxyProtocolLn{Listener: ln, timeout: timeout}
+}
+
+type proxyProtocolLn struct {
+	net.Listener
+	timeout time.Duration
+}
+
+// Accept doesn't read the header, so that a slow client can't hold up
+// the accept loop. It is read on the first Read or RemoteAddr call.
+func (l *proxyProtocolLn) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &proxyProtocolConn{
+		Conn:    conn,
+		reader:  bufio.NewReader(conn),
+		timeout: l.timeout,
+	}, nil
+}
+
+type proxyProtocolConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+
+	once       sync.Once
+	err        error
+	remoteAddr net.Addr
+	localAddr  net.Addr
+}
+
+func (c *proxyProtocolConn) init() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+		c.remoteAddr, c.localAddr, c.err = readProxyProtocolHeader(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if c.err != nil {
+			mainLog.WithField("peer", c.Conn.RemoteAddr().String()).Warning("Closing connection with invalid PROXY header: ", c.err)
+			c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyProtocolConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyProtocolConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remoteAddr != nil {
+		return c.remoteAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtocolConn) LocalAddr() net.Addr {
+	c.init()
+	if c.localAddr != nil {
+		return c.localAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// readProxyProtocolHeader reads a v1 or v2 PROXY header. The addresses
+// are nil if the header carries none, as with v1 UNKNOWN and v2 LOCAL.
+func readProxyProtocolHeader(r *bufio.Reader) (src, dst net.Addr, err error) {
+	sig, err := r.Peek(len(proxyProtocolV1Prefix))
+	if err != nil {
+		return nil, nil, err
+	}
+	if bytes.Equal(sig, proxyProtocolV1Prefix) {
+		return readProxyProtocolV1(r)
+	}
+	if sig, err = r.Peek(len(proxyProtocolV2Sig)); err == nil && bytes.Equal(sig, proxyProtocolV2Sig) {
+		return readProxyProtocolV2(r)
+	}
+	return nil, nil, errors.New("missing PROXY protocol header")
+}
+
+func readProxyProtocolV1(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var line []byte
+	for len(line) < proxyProtocolV1MaxLen {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, nil, errors.New("PROXY v1 header too long")
+	}
+
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, nil, fmt.Errorf("invalid PROXY v1 header %q", line)
+	}
+
+	srcAddr, err := proxyProtocolV1Addr(fields[2], fields[4])
+	if err != nil {
+		return nil, nil, err
+	}
+	dstAddr, err := proxyProtocolV1Addr(fields[3], fields[5])
+	if err != nil {
+		return nil, nil, err
+	}
+	return srcAddr, dstAddr, nil
+}
+
+func proxyProtocolV1Addr(host, port string) (*net.TCPAddr, error) {
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid PROXY v1 address %q", host)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROXY v1 port %q", port)
+	}
+	return &net.TCPAddr{IP: ip, Port: int(p)}, nil
+}
+
+func readProxyProtocolV2(r *bufio.Reader) (src, dst net.Addr, err error) {
+	var hdr [16]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, nil, err
+	}
+	if hdr[12]>>4 != 2 {
+		return nil, nil, fmt.Errorf("unsupported PROXY protocol version %d", hdr[12]>>4)
+	}
+	payload := make([]byte, binary.BigEndian.Uint16(hdr[14:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, nil, err
+	}
+
+	switch hdr[12] & 0xF {
+	case 0x0: // LOCAL, e.g. health checks from the proxy itself
+		return nil, nil, nil
+	case 0x1: // PROXY
+	default:
+		return nil, nil, fmt.Errorf("unsupported PROXY v2 command %d", hdr[12]&0xF)
+	}
+
+	var ipLen int
+	switch hdr[13] {
+	case 0x11: // TCP over IPv4
+		ipLen = net.IPv4len
+	case 0x21: // TCP over IPv6
+		ipLen = net.IPv6len
+	default:
+		// other protocols are allowed, but carry no usable address
+		return nil, nil, nil
+	}
+	if len(payload) < 2*ipLen+4 {
+		return nil, nil, errors.New("PROXY v2 address block too short")
+	}
+	// any TLVs after the addresses are skipped
+	src = &net.TCPAddr{
+		IP:   net.IP(payload[:ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen:])),
+	}
+	dst = &net.TCPAddr{
+		IP:   net.IP(payload[ipLen : 2*ipLen]),
+		Port: int(binary.BigEndian.Uint16(payload[2*ipLen+2:])),
+	}
+	return src, dst, nil
+}
@@ -0,0 +1,108 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+func proxyProtocolV2Header(src, dst *net.TCPAddr) []byte {
+	hdr := append([]byte{}, proxyProtocolV2Sig...)
+	hdr = append(hdr, 0x21, 0x11, 0, 12)
+	hdr = append(hdr, src.IP.To4()...)
+	hdr = append(hdr, dst.IP.To4()...)
+	hdr = append(hdr, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(hdr[len(hdr)-4:], uint16(src.Port))
+	binary.BigEndian.PutUint16(hdr[len(hdr)-2:], uint16(dst.Port))
+	return hdr
+}
+
+func TestProxyProtocolListener(t *testing.T) {
+	globalConf := config.Global()
+	globalConf.HttpServerOptions.ProxyProtocol.Enabled = true
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln = proxyProtocolListener(ln, 0)
+	defer ln.Close()
+
+	go http.Serve(ln, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.RemoteAddr))
+	}))
+
+	v2 := proxyProtocolV2Header(
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 4000},
+		&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 8080},
+	)
+	tests := []struct {
+		name   string
+		header string
+		want   string
+	}{
+		{"V1", "PROXY TCP4 192.0.2.10 192.0.2.1 4000 8080\r\n", "192.0.2.10:4000"},
+		{"V1IPv6", "PROXY TCP6 2001:db8::1 2001:db8::2 4000 8080\r\n", "[2001:db8::1]:4000"},
+		{"V1Unknown", "PROXY UNKNOWN\r\n", "127.0.0.1:"},
+		{"V2", string(v2), "192.0.2.10:4000"},
+		{"Missing", "", ""},
+		{"Invalid", "PROXY TCP4 bad 192.0.2.1 4000 8080\r\n", ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", ln.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			conn.Write([]byte(tc.header + "GET / HTTP/1.1\r\nHost: localhost\r\nConnection: close\r\n\r\n"))
+			res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+			if tc.want == "" {
+				if err == nil {
+					t.Fatal("connection should be closed")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := ioutil.ReadAll(res.Body)
+			res.Body.Close()
+			if got := string(body); len(got) < len(tc.want) || got[:len(tc.want)] != tc.want {
+				t.Errorf("wanted remote address %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolControlAPI(t *testing.T) {
+	globalConf := config.Global()
+	globalConf.HttpServerOptions.ProxyProtocol.Enabled = true
+	globalConf.ControlAPIPort = 9999
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if proxyProtocolListener(ln, 9999) != ln {
+		t.Error("control API listener should not expect a PROXY header by default")
+	}
+
+	globalConf.HttpServerOptions.ProxyProtocol.EnableControlAPI = true
+	config.SetGlobal(globalConf)
+	if proxyProtocolListener(ln, 9999) == ln {
+		t.Error("control API listener should expect a PROXY header")
+	}
+}
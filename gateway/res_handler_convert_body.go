@@ -0,0 +1,63 @@
+package gateway
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/ins-tykgw/tyk/headers"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+// ResponseConvertBodyMiddleware converts upstream response bodies between
+// JSON, XML, form and protobuf encodings.
+type ResponseConvertBodyMiddleware struct {
+	Spec *APISpec
+}
+
+func (ResponseConvertBodyMiddleware) Name() string {
+	return "ResponseConvertBodyMiddleware"
+}
+
+func (h *ResponseConvertBodyMiddleware) Init(c interface{}, spec *APISpec) error {
+	h.Spec = spec
+	return nil
+}
+
+func (h *ResponseConvertBodyMiddleware) HandleResponse(rw http.ResponseWriter, res *http.Response, req *http.Request, ses *user.SessionState) error {
+	_, versionPaths, _, _ := h.Spec.Version(req)
+	found, meta := h.Spec.CheckSpecMatchesStatus(req, versionPaths, BodyConvertedResponse)
+	if !found {
+		return nil
+	}
+
+	respBody := respBodyReader(req, res)
+	body, err := ioutil.ReadAll(respBody)
+	respBody.Close()
+	if err != nil {
+		return err
+	}
+
+	converted, contentType, err := meta.(*bodyConverter).convert(body)
+	if err != nil {
+		log.WithFields(logrus.Fields{
+			"prefix": "outbound-convert",
+			"api_id": h.Spec.APIID,
+			"path":   req.URL.Path,
+		}).WithError(err).Error("Response body conversion failure")
+		converted = body
+	} else if contentType != "" {
+		res.Header.Set(headers.ContentType, contentType)
+	}
+
+	// Re-compress if original upstream response was compressed
+	bodyBuffer := compressBuffer(*bytes.NewBuffer(converted), res.Header.Get(headers.ContentEncoding))
+
+	res.ContentLength = int64(bodyBuffer.Len())
+	res.Header.Set(headers.ContentLength, strconv.Itoa(bodyBuffer.Len()))
+	res.Body = ioutil.NopCloser(&bodyBuffer)
+	return nil
+}
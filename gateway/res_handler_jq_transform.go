@@ -1,5 +1,3 @@
-//+build jq
-
 package gateway
 
 import (
@@ -16,6 +14,10 @@ type ResponseTransformJQMiddleware struct {
 	Spec *APISpec
 }
 
+func (ResponseTransformJQMiddleware) Name() string {
+	return "ResponseTransformJQMiddleware"
+}
+
 func (h *ResponseTransformJQMiddleware) Init(c interface{}, spec *APISpec) error {
 	h.Spec = spec
 
@@ -43,8 +45,11 @@ func (h *ResponseTransformJQMiddleware) HandleResponse(rw http.ResponseWriter, r
 		"_tyk_context":          ctxGetData(req),
 		"_tyk_response_headers": res.Header,
 	}
+	if ses != nil {
+		jqObj["_tyk_meta"] = ses.MetaData
+	}
 
-	jqResult, err := lockedJQTransform(h.Spec, ts, jqObj)
+	jqResult, err := runJQTransform(req.Context(), ts, jqObj)
 	if err != nil {
 		return err
 	}
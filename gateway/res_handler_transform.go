@@ -150,7 +150,7 @@ func (h *ResponseTransformMiddleware) HandleResponse(rw http.ResponseWriter, res
 
 	// Apply to template
 	var bodyBuffer bytes.Buffer
-	if err := tmeta.Template.Execute(&bodyBuffer, bodyData); err != nil {
+	if err := tmeta.execute(req.Context(), &bodyBuffer, bodyData); err != nil {
 		logger.WithError(err).Error("Failed to apply template to request")
 	}
 
@@ -0,0 +1,218 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+var defaultCompressionEncodings = []string{"br", "gzip", "deflate"}
+
+var defaultCompressionContentTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/csv",
+	"text/xml",
+	"text/javascript",
+	"application/json",
+	"application/problem+json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+}
+
+// compressResponse encodes res.Body with the best encoding the client
+// accepts, if the API compresses responses and the response qualifies.
+func compressResponse(conf apidef.ResponseCompression, req *http.Request, res *http.Response) {
+	if !conf.Enabled || req.Method == http.MethodHead || res.Body == nil {
+		return
+	}
+	switch {
+	case res.StatusCode < http.StatusOK,
+		res.StatusCode == http.StatusNoContent,
+		res.StatusCode == http.StatusNotModified,
+		res.StatusCode == http.StatusPartialContent:
+		return
+	}
+	if len(contentEncodings(res.Header.Get(headers.ContentEncoding))) > 0 {
+		return
+	}
+	if strings.Contains(strings.ToLower(res.Header.Get(headers.CacheControl)), "no-transform") {
+		return
+	}
+	if !compressibleType(conf.ContentTypes, res.Header.Get(headers.ContentType)) {
+		return
+	}
+	// the response depends on Accept-Encoding whether it is compressed
+	// this time or not
+	res.Header.Add("Vary", headers.AcceptEncoding)
+
+	encoding := negotiateEncoding(conf.Encodings, req.Header.Get(headers.AcceptEncoding))
+	if encoding == "" {
+		return
+	}
+
+	if conf.MinSize > 0 {
+		if res.ContentLength >= 0 && res.ContentLength < conf.MinSize {
+			return
+		}
+		if res.ContentLength < 0 {
+			// read enough of the body to know it's big enough
+			head := make([]byte, conf.MinSize)
+			n, err := io.ReadFull(res.Body, head)
+			rest := res.Body
+			res.Body = readCloser{io.MultiReader(bytes.NewReader(head[:n]), rest), rest}
+			if err != nil {
+				return
+			}
+		}
+	}
+
+	res.Body = newCompressReader(res.Body, encoding)
+	res.ContentLength = -1
+	res.Header.Del(headers.ContentLength)
+	res.Header.Set(headers.ContentEncoding, encoding)
+}
+
+func compressibleType(allowed []string, contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if len(allowed) == 0 {
+		allowed = defaultCompressionContentTypes
+	}
+	for _, t := range allowed {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == mediaType || t == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, t[:len(t)-1]) {
+			return true
+		}
+	}
+	return false
+}
+
+// negotiateEncoding picks the offered encoding with the highest quality in
+// an Accept-Encoding header, preferring earlier offers on a tie.
+func negotiateEncoding(offered []string, acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q
+	}
+
+	if len(offered) == 0 {
+		offered = defaultCompressionEncodings
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range offered {
+		if !supportedEncoding(enc) {
+			continue
+		}
+		q, ok := accepted[enc]
+		if !ok {
+			if q, ok = accepted["*"]; !ok {
+				continue
+			}
+		}
+		if q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func supportedEncoding(encoding string) bool {
+	switch encoding {
+	case "br", "gzip", "deflate":
+		return true
+	}
+	return false
+}
+
+func newBodyEncoder(encoding string, w io.Writer) io.WriteCloser {
+	switch encoding {
+	case "br":
+		return brotli.NewWriter(w)
+	case "deflate":
+		return zlib.NewWriter(w)
+	}
+	return gzip.NewWriter(w)
+}
+
+// compressReader compresses a body as it is read, so responses are still
+// streamed to the client.
+type compressReader struct {
+	src   io.ReadCloser
+	enc   io.WriteCloser
+	out   bytes.Buffer
+	chunk []byte
+	done  bool
+}
+
+func newCompressReader(src io.ReadCloser, encoding string) *compressReader {
+	c := &compressReader{src: src, chunk: make([]byte, 32*1024)}
+	c.enc = newBodyEncoder(encoding, &c.out)
+	return c
+}
+
+func (c *compressReader) Read(p []byte) (int, error) {
+	for c.out.Len() == 0 && !c.done {
+		n, err := c.src.Read(c.chunk)
+		if n > 0 {
+			c.enc.Write(c.chunk[:n])
+		}
+		if err == io.EOF {
+			c.enc.Close()
+			c.done = true
+		} else if err != nil {
+			return 0, err
+		}
+	}
+	if c.out.Len() == 0 {
+		return 0, io.EOF
+	}
+	return c.out.Read(p)
+}
+
+func (c *compressReader) Close() error {
+	return c.src.Close()
+}
+
+// readCloser reads from one reader and closes another.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
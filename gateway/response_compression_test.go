@@ -0,0 +1,211 @@
+package gateway
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	msgpack "gopkg.in/vmihailenco/msgpack.v2"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+)
+
+func TestResponseSizeLimit(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.ResponseSizeLimit = 1000
+	})
+
+	ts.Run(t, []test.TestCase{
+		{Path: "/get", Code: http.StatusOK},
+		// the upstream declares the length of small bodies
+		{Method: http.MethodPost, Path: "/post", Data: strings.Repeat("a", 1200), Code: http.StatusBadGateway, BodyMatch: "Upstream response is too large"},
+	}...)
+
+	t.Run("Cut off", func(t *testing.T) {
+		globalConf := config.Global()
+		globalConf.AnalyticsConfig.EnableDetailedRecording = false
+		config.SetGlobal(globalConf)
+		defer ResetTestConfig()
+
+		// the upstream streams bigger bodies, which can only be cut off
+		res, err := http.Post(ts.URL+"/cut-off", "text/plain", strings.NewReader(strings.Repeat("a", 5000)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if _, err := ioutil.ReadAll(res.Body); err != io.ErrUnexpectedEOF {
+			t.Errorf("Expected the body to be cut off, got %v", err)
+		}
+
+		var codes []int
+		for i := 0; i < 5 && len(codes) == 0; i++ {
+			time.Sleep(recordsBufferFlushInterval + 50)
+			for _, result := range analytics.Store.GetAndDeleteSet(analyticsKeyName) {
+				var record AnalyticsRecord
+				msgpack.Unmarshal(result.([]byte), &record)
+				if strings.HasSuffix(record.Path, "cut-off") {
+					codes = append(codes, record.ResponseCode)
+				}
+			}
+		}
+		if len(codes) != 1 || codes[0] != http.StatusBadGateway {
+			t.Errorf("Expected a failed request in analytics, got %v", codes)
+		}
+	})
+
+	t.Run("Read by a response processor", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.ResponseSizeLimit = 1000
+			spec.ResponseProcessors = []apidef.ResponseProcessor{{Name: "response_body_transform"}}
+			UpdateAPIVersion(spec, "v1", func(v *apidef.VersionInfo) {
+				v.ExtendedPaths.TransformResponse = []apidef.TemplateMeta{{
+					Path:   "/post",
+					Method: http.MethodPost,
+					TemplateData: apidef.TemplateData{
+						Mode:           apidef.UseBlob,
+						TemplateSource: base64.StdEncoding.EncodeToString([]byte(`{"method":"{{.Method}}"}`)),
+					},
+				}}
+			})
+		})
+
+		ts.Run(t, []test.TestCase{
+			{Method: http.MethodPost, Path: "/post", Data: "a", Code: http.StatusOK, BodyMatch: `{"method":"POST"}`},
+			{Method: http.MethodPost, Path: "/post", Data: strings.Repeat("a", 5000), Code: http.StatusBadGateway},
+		}...)
+	})
+}
+
+func TestResponseCompression(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	decoded := func(encoding string, contains string) func([]byte) bool {
+		return func(body []byte) bool {
+			var plain []byte
+			switch encoding {
+			case "gzip":
+				r, err := gzip.NewReader(bytes.NewReader(body))
+				if err != nil {
+					return false
+				}
+				plain, _ = ioutil.ReadAll(r)
+			case "br":
+				plain, _ = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(body)))
+			}
+			return bytes.Contains(plain, []byte(contains))
+		}
+	}
+
+	compression := apidef.ResponseCompression{Enabled: true}
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Proxy.ListenPath = "/"
+		spec.ResponseCompression = compression
+	})
+
+	ts.Run(t, []test.TestCase{
+		{
+			Path: "/get", Code: http.StatusOK,
+			Headers:       map[string]string{"Accept-Encoding": "gzip"},
+			HeadersMatch:  map[string]string{"Content-Encoding": "gzip", "Vary": "Accept-Encoding"},
+			BodyMatchFunc: decoded("gzip", `"Method":"GET"`),
+		},
+		{
+			Path: "/get", Code: http.StatusOK,
+			Headers:       map[string]string{"Accept-Encoding": "gzip;q=0.5, br"},
+			HeadersMatch:  map[string]string{"Content-Encoding": "br"},
+			BodyMatchFunc: decoded("br", `"Method":"GET"`),
+		},
+		{
+			Path: "/get", Code: http.StatusOK,
+			Headers:         map[string]string{"Accept-Encoding": "identity"},
+			HeadersNotMatch: map[string]string{"Content-Encoding": "gzip"},
+			BodyMatch:       `"Method":"GET"`,
+		},
+		{
+			// the test upstream doesn't allow HEAD, the error body would
+			// still be compressed for a GET
+			Method: http.MethodHead, Path: "/get", Code: http.StatusMethodNotAllowed,
+			Headers:         map[string]string{"Accept-Encoding": "gzip"},
+			HeadersNotMatch: map[string]string{"Content-Encoding": "gzip"},
+		},
+	}...)
+
+	t.Run("Minimum size", func(t *testing.T) {
+		compression.MinSize = 2000
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.ResponseCompression = compression
+		})
+
+		ts.Run(t, []test.TestCase{
+			{
+				Path: "/get", Code: http.StatusOK,
+				Headers:         map[string]string{"Accept-Encoding": "gzip"},
+				HeadersNotMatch: map[string]string{"Content-Encoding": "gzip"},
+				BodyMatch:       `"Method":"GET"`,
+			},
+			// streamed without a length
+			{
+				Method: http.MethodPost, Path: "/post", Data: strings.Repeat("a", 5000), Code: http.StatusOK,
+				Headers:       map[string]string{"Accept-Encoding": "gzip"},
+				HeadersMatch:  map[string]string{"Content-Encoding": "gzip"},
+				BodyMatchFunc: decoded("gzip", strings.Repeat("a", 5000)),
+			},
+		}...)
+	})
+
+	t.Run("Content types", func(t *testing.T) {
+		compression.MinSize = 0
+		compression.ContentTypes = []string{"application/json"}
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.ResponseCompression = compression
+		})
+
+		// the test upstream doesn't set a JSON content type
+		ts.Run(t, test.TestCase{
+			Path: "/get", Code: http.StatusOK,
+			Headers:         map[string]string{"Accept-Encoding": "gzip"},
+			HeadersNotMatch: map[string]string{"Content-Encoding": "gzip"},
+		})
+	})
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		offered        []string
+		acceptEncoding string
+		want           string
+	}{
+		{nil, "", ""},
+		{nil, "gzip", "gzip"},
+		{nil, "gzip, deflate, br", "br"},
+		{nil, "gzip;q=1.0, br;q=0.8", "gzip"},
+		{nil, "br;q=0, gzip;q=0.1", "gzip"},
+		{nil, "*", "br"},
+		{nil, "*;q=0.5, br;q=0", "gzip"},
+		{nil, "compress, identity", ""},
+		{[]string{"gzip"}, "br, gzip", "gzip"},
+		{[]string{"deflate", "gzip"}, "gzip, deflate", "deflate"},
+	}
+	for _, tc := range tests {
+		if got := negotiateEncoding(tc.offered, tc.acceptEncoding); got != tc.want {
+			t.Errorf("%v %q: want %q, got %q", tc.offered, tc.acceptEncoding, tc.want, got)
+		}
+	}
+}
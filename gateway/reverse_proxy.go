@@ -15,6 +15,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -124,6 +125,9 @@ func nextTarget(targetData *apidef.HostList, spec *APISpec) (string, error) {
 		// Use a HostList
 		startPos := spec.RoundRobin.WithLen(targetData.Len())
 		pos := startPos
+		// first host that is up but ejected by outlier detection,
+		// used if every host that is up has been ejected
+		ejectedHost := ""
 		for {
 			gotHost, err := targetData.GetIndex(pos)
 			if err != nil {
@@ -132,15 +136,20 @@ func nextTarget(targetData *apidef.HostList, spec *APISpec) (string, error) {
 
 			host := EnsureTransport(gotHost)
 
-			if !spec.Proxy.CheckHostAgainstUptimeTests {
-				return host, nil // we don't care if it's up
-			}
-			if !GlobalHostChecker.HostDown(host) {
-				return host, nil // we do care and it's up
+			if !spec.Proxy.CheckHostAgainstUptimeTests || !GlobalHostChecker.HostDown(host) {
+				if spec.outliers == nil || !spec.outliers.Ejected(spec, host) {
+					return host, nil
+				}
+				if ejectedHost == "" {
+					ejectedHost = host
+				}
 			}
-			// if the host is down, keep trying all the rest
-			// in order from where we started.
+			// if the host is down or ejected, keep trying all the
+			// rest in order from where we started.
 			if pos = (pos + 1) % targetData.Len(); pos == startPos {
+				if ejectedHost != "" {
+					return ejectedHost, nil
+				}
 				return "", fmt.Errorf("all hosts are down, uptime tests are failing")
 			}
 		}
@@ -470,9 +479,13 @@ func proxyFromAPI(api *APISpec) func(*http.Request) (*url.URL, error) {
 	}
 }
 
-func httpTransport(timeOut float64, rw http.ResponseWriter, req *http.Request, p *ReverseProxy) http.RoundTripper {
+func httpTransport(timeOut float64, rw http.ResponseWriter, req *http.Request, p *ReverseProxy, certID string) http.RoundTripper {
 	transport := defaultTransport(timeOut) // modifies a newly created transport
 	transport.TLSClientConfig = &tls.Config{}
+	transport.TLSClientConfig.RootCAs = upstreamCAPool(p.TykAPISpec)
+	if cert := getUpstreamCertificate(certID, p.TykAPISpec); cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
 	transport.Proxy = proxyFromAPI(p.TykAPISpec)
 
 	if config.Global().ProxySSLInsecureSkipVerify {
@@ -525,17 +538,14 @@ func httpTransport(timeOut float64, rw http.ResponseWriter, req *http.Request, p
 	return transport
 }
 
-func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Request, withCache bool) *http.Response {
-	if trace.IsEnabled() {
-		span, ctx := trace.Span(req.Context(), req.URL.Path)
-		defer span.Finish()
-		ext.SpanKindRPCClient.Set(span)
-		req = req.WithContext(ctx)
-	}
-	outReqIsWebsocket := IsWebsocket(req)
-	var roundTripper http.RoundTripper
-
+// upstreamTransport returns the transport for a request. Transports are
+// kept on the API spec and recreated after MaxConnTime. Requests presenting
+// a client certificate get a transport of their own per certificate, so that
+// pooled connections are never shared between certificates.
+func (p *ReverseProxy) upstreamTransport(rw http.ResponseWriter, req *http.Request, certID string, outReqIsWebsocket bool) http.RoundTripper {
 	p.TykAPISpec.Lock()
+	defer p.TykAPISpec.Unlock()
+
 	if !outReqIsWebsocket { // check if it is a regular HTTP request
 		// create HTTP transport
 		createTransport := p.TykAPISpec.HTTPTransport == nil
@@ -546,34 +556,72 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		}
 
 		if createTransport {
+			closeIdleTransports(p.TykAPISpec)
 			_, timeout := p.CheckHardTimeoutEnforced(p.TykAPISpec, req)
-			p.TykAPISpec.HTTPTransport = httpTransport(timeout, rw, req, p)
+			p.TykAPISpec.HTTPTransport = httpTransport(timeout, rw, req, p, "")
 			p.TykAPISpec.HTTPTransportCreated = time.Now()
+			p.TykAPISpec.certTransports = nil
 		}
 
-		roundTripper = p.TykAPISpec.HTTPTransport
-	} else { // this is NEW WS-connection upgrade request
-		// create WS transport
-		createTransport := p.TykAPISpec.WSTransport == nil
-
-		// Check if timeouts are set for this endpoint
-		if !createTransport && config.Global().MaxConnTime != 0 {
-			createTransport = time.Since(p.TykAPISpec.WSTransportCreated) > time.Duration(config.Global().MaxConnTime)*time.Second
+		if certID == "" {
+			return p.TykAPISpec.HTTPTransport
 		}
 
-		if createTransport {
+		transport, ok := p.TykAPISpec.certTransports[certID]
+		if !ok {
 			_, timeout := p.CheckHardTimeoutEnforced(p.TykAPISpec, req)
-			p.TykAPISpec.WSTransport = httpTransport(timeout, rw, req, p)
-			p.TykAPISpec.WSTransportCreated = time.Now()
+			transport = httpTransport(timeout, rw, req, p, certID)
+			if p.TykAPISpec.certTransports == nil {
+				p.TykAPISpec.certTransports = make(map[string]http.RoundTripper)
+			}
+			p.TykAPISpec.certTransports[certID] = transport
 		}
+		return transport
+	}
+
+	// this is NEW WS-connection upgrade request
+	// create WS transport
+	createTransport := p.TykAPISpec.WSTransport == nil
+
+	// Check if timeouts are set for this endpoint
+	if !createTransport && config.Global().MaxConnTime != 0 {
+		createTransport = time.Since(p.TykAPISpec.WSTransportCreated) > time.Duration(config.Global().MaxConnTime)*time.Second
+	}
 
-		// overwrite transport's ResponseWriter from previous upgrade request
-		// as it was already hijacked and now is being used for other connection
-		p.TykAPISpec.WSTransport.(*WSDialer).RW = rw
+	if createTransport {
+		_, timeout := p.CheckHardTimeoutEnforced(p.TykAPISpec, req)
+		p.TykAPISpec.WSTransport = httpTransport(timeout, rw, req, p, "")
+		p.TykAPISpec.WSTransportCreated = time.Now()
+	}
+
+	// overwrite transport's ResponseWriter from previous upgrade request
+	// as it was already hijacked and now is being used for other connection
+	wsTransport := p.TykAPISpec.WSTransport.(*WSDialer)
+	wsTransport.RW = rw
+
+	// every upgrade dials a new connection, so the certificate can be
+	// set on the shared transport
+	wsTransport.TLSClientConfig.Certificates = nil
+	if cert := getUpstreamCertificate(certID, p.TykAPISpec); cert != nil {
+		wsTransport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+
+	return wsTransport
+}
 
-		roundTripper = p.TykAPISpec.WSTransport
+func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Request, withCache bool) *http.Response {
+	if trace.IsEnabled() {
+		span, ctx := trace.Span(req.Context(), req.URL.Path)
+		defer span.Finish()
+		ext.SpanKindRPCClient.Set(span)
+		req = req.WithContext(ctx)
+	}
+	outReqIsWebsocket := IsWebsocket(req)
+	var wsc *wsConn
+	if outReqIsWebsocket {
+		wsc = newWSConn(p.TykAPISpec, req)
+		ctxSetWebSocketConn(req, wsc)
 	}
-	p.TykAPISpec.Unlock()
 
 	reqCtx := req.Context()
 	if cn, ok := rw.(http.CloseNotifier); ok {
@@ -657,43 +705,71 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		outreq.Header.Set(headers.XForwardFor, addrs)
 	}
 
+	// credentials for the upstream go on last, so signatures cover the
+	// request as it's sent
+	if auth := p.TykAPISpec.upstreamAuth; auth != nil {
+		if err := auth.authenticate(outreq); err != nil {
+			log.WithFields(logrus.Fields{
+				"prefix": "proxy",
+				"api_id": p.TykAPISpec.APIID,
+			}).WithError(err).Error("Failed to authenticate upstream request")
+			if err == errSignedBodyTooLarge {
+				p.ErrorHandler.handleError(rw, logreq, err, http.StatusRequestEntityTooLarge, true)
+				return nil
+			}
+			p.ErrorHandler.handleError(rw, logreq, errUpstreamAuth, http.StatusBadGateway, true)
+			return nil
+		}
+	}
+
 	// Circuit breaker
 	breakerEnforced, breakerConf := p.CheckCircuitBreakerEnforced(p.TykAPISpec, req)
 
-	// set up TLS certificates for upstream if needed
-	var tlsCertificates []tls.Certificate
-	if cert := getUpstreamCertificate(outreq.Host, p.TykAPISpec); cert != nil {
-		tlsCertificates = []tls.Certificate{*cert}
-	}
-
-	p.TykAPISpec.Lock()
-	if outReqIsWebsocket {
-		roundTripper.(*WSDialer).TLSClientConfig.Certificates = tlsCertificates
-	} else {
-		roundTripper.(*http.Transport).TLSClientConfig.Certificates = tlsCertificates
-	}
-	p.TykAPISpec.Unlock()
+	// pick the transport, and with it the client certificate, for the upstream
+	certID := upstreamCertificateID(req, outreq, p.TykAPISpec)
+	roundTripper := p.upstreamTransport(rw, req, certID, outReqIsWebsocket)
 
 	// do request round trip
 	var res *http.Response
 	var err error
 	if breakerEnforced {
-		if !breakerConf.CB.Ready() {
+		if !breakerConf.CB.Ready(outreq.URL.Host) {
 			log.Debug("ON REQUEST: Circuit Breaker is in OPEN state")
-			p.ErrorHandler.HandleError(rw, logreq, "Service temporarily unavailable.", 503, true)
-			return nil
-		}
-		log.Debug("ON REQUEST: Circuit Breaker is in CLOSED or HALF-OPEN state")
-		res, err = roundTripper.RoundTrip(outreq)
-		if err != nil || res.StatusCode == http.StatusInternalServerError {
-			breakerConf.CB.Fail()
+			res = breakerConf.CB.Fallback(outreq)
+			if res == nil {
+				p.ErrorHandler.HandleError(rw, logreq, "Service temporarily unavailable.", 503, true)
+				return nil
+			}
 		} else {
-			breakerConf.CB.Success()
+			log.Debug("ON REQUEST: Circuit Breaker is in CLOSED or HALF-OPEN state")
+			start := time.Now()
+			res, err = roundTripper.RoundTrip(outreq)
+			failed := err != nil || res.StatusCode >= http.StatusInternalServerError
+			breakerConf.CB.Record(outreq.URL.Host, failed, time.Since(start))
+			if !failed {
+				breakerConf.CB.CacheResponse(outreq, res)
+			}
 		}
 	} else {
 		res, err = roundTripper.RoundTrip(outreq)
 	}
 
+	if outliers := p.TykAPISpec.outliers; outliers != nil {
+		switch {
+		case err != nil && strings.Contains(err.Error(), "context canceled"):
+			// the client went away, says nothing about the upstream
+		case err != nil || res.StatusCode >= http.StatusInternalServerError:
+			outliers.RecordFailure(p.TykAPISpec, outreq.URL.Host)
+		default:
+			outliers.RecordSuccess(outreq.URL.Host)
+		}
+	}
+
+	// a rejected token is fetched again for the next request
+	if c, ok := p.TykAPISpec.upstreamAuth.(*clientCredentials); ok && res != nil && res.StatusCode == http.StatusUnauthorized {
+		c.expire()
+	}
+
 	if err != nil {
 
 		token := ctxGetAuthToken(req)
@@ -714,7 +790,7 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		}).Error("http: proxy error: ", err)
 
 		if strings.Contains(err.Error(), "timeout awaiting response headers") {
-			p.ErrorHandler.HandleError(rw, logreq, "Upstream service reached hard timeout.", http.StatusGatewayTimeout, true)
+			p.ErrorHandler.handleError(rw, logreq, errorWithReason(ErrorReasonUpstreamTimeout, errors.New("Upstream service reached hard timeout.")), http.StatusGatewayTimeout, true)
 
 			if p.TykAPISpec.Proxy.ServiceDiscovery.UseDiscoveryService {
 				if ServiceCache != nil {
@@ -741,6 +817,11 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 	}
 
 	if IsWebsocket(req) {
+		// record the connection once it's closed
+		if record := wsc.record(); record != nil {
+			handler := SuccessHandler{BaseMiddleware{Spec: p.TykAPISpec}}
+			handler.RecordHit(req, record.Duration, http.StatusSwitchingProtocols, nil)
+		}
 		return nil
 	}
 
@@ -749,6 +830,17 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		ses = session
 	}
 
+	var limited *limitedBody
+	if limit := p.TykAPISpec.ResponseSizeLimit; limit > 0 {
+		if res.ContentLength > limit {
+			res.Body.Close()
+			p.ErrorHandler.handleError(rw, logreq, errResponseTooLarge, http.StatusBadGateway, true)
+			return nil
+		}
+		limited = &limitedBody{ReadCloser: res.Body, remaining: limit}
+		res.Body = limited
+	}
+
 	// Middleware chain handling here - very simple, but should do
 	// the trick. Chain can be empty, in which case this is a no-op.
 	if err := handleResponseChain(p.TykAPISpec.ResponseChain, rw, res, req, ses); err != nil {
@@ -773,13 +865,56 @@ func (p *ReverseProxy) WrappedServeHTTP(rw http.ResponseWriter, req *http.Reques
 		inres.Body = ioutil.NopCloser(bodyBuffer2)
 	}
 
+	if limited != nil && limited.exceeded {
+		// the body was read past the limit before anything was sent
+		res.Body.Close()
+		p.ErrorHandler.handleError(rw, logreq, errResponseTooLarge, http.StatusBadGateway, true)
+		return nil
+	}
+
 	// We should at least copy the status code in
 	inres.StatusCode = res.StatusCode
 	inres.ContentLength = res.ContentLength
-	p.HandleResponse(rw, res, ses)
+	compressResponse(p.TykAPISpec.ResponseCompression, req, res)
+	if err := p.HandleResponse(rw, res, ses); err == errResponseTooLarge {
+		// the headers are gone, drop the connection so that the client
+		// can tell the body is incomplete
+		log.WithFields(logrus.Fields{
+			"prefix": "proxy",
+			"org_id": p.TykAPISpec.OrgID,
+			"api_id": p.TykAPISpec.APIID,
+		}).Warning("Upstream response exceeded the size limit, aborted")
+		// recorded first, as abortResponse may not return
+		p.ErrorHandler.handleError(rw, logreq, errResponseTooLarge, http.StatusBadGateway, false)
+		abortResponse(rw)
+		return nil
+	}
 	return inres
 }
 
+// abortResponse closes the client connection of a response that has
+// already been started. Writers that can't be hijacked, as with HTTP/2,
+// are aborted by net/http when the handler panics with ErrAbortHandler.
+func abortResponse(rw http.ResponseWriter) {
+	for {
+		w, ok := rw.(*customResponseWriter)
+		if !ok {
+			break
+		}
+		rw = w.ResponseWriter
+	}
+	hj, ok := rw.(http.Hijacker)
+	if !ok {
+		panic(http.ErrAbortHandler)
+	}
+	conn, _, err := hj.Hijack()
+	if err != nil {
+		log.WithError(err).Warning("Could not close the client connection")
+		panic(http.ErrAbortHandler)
+	}
+	conn.Close()
+}
+
 func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response, ses *user.SessionState) error {
 
 	// Remove hop-by-hop headers listed in the
@@ -811,6 +946,13 @@ func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response
 		res.Header.Set(XRateLimitReset, strconv.Itoa(int(quotaRenews)))
 	}
 
+	// the gateway's request ID, already set on rw, wins over the upstream's
+	if conf := p.TykAPISpec.GlobalConfig.RequestID; conf.Enabled {
+		if header := requestIDHeader(conf.Header); rw.Header().Get(header) != "" {
+			res.Header.Del(header)
+		}
+	}
+
 	copyHeader(rw.Header(), res.Header)
 
 	announcedTrailers := len(res.Trailer)
@@ -833,7 +975,9 @@ func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response
 		}
 	}
 
-	p.CopyResponse(rw, res.Body)
+	if _, err := p.copyResponse(rw, res.Body); err != nil && err != io.EOF {
+		return err
+	}
 
 	if len(res.Trailer) == announcedTrailers {
 		copyHeader(rw.Header(), res.Trailer)
@@ -850,6 +994,10 @@ func (p *ReverseProxy) HandleResponse(rw http.ResponseWriter, res *http.Response
 }
 
 func (p *ReverseProxy) CopyResponse(dst io.Writer, src io.Reader) {
+	p.copyResponse(dst, src)
+}
+
+func (p *ReverseProxy) copyResponse(dst io.Writer, src io.Reader) (int64, error) {
 	if p.FlushInterval != 0 {
 		if wf, ok := dst.(writeFlusher); ok {
 			mlw := &maxLatencyWriter{
@@ -863,7 +1011,7 @@ func (p *ReverseProxy) CopyResponse(dst io.Writer, src io.Reader) {
 		}
 	}
 
-	p.copyBuffer(dst, src, nil)
+	return p.copyBuffer(dst, src, nil)
 }
 
 func (p *ReverseProxy) copyBuffer(dst io.Writer, src io.Reader, buf []byte) (int64, error) {
@@ -948,6 +1096,28 @@ func requestIPHops(r *http.Request) string {
 	return clientIP
 }
 
+var errResponseTooLarge = errorWithReason(ErrorReasonBodyTooLarge, errors.New("Upstream response is too large"))
+
+// limitedBody fails reads once more than remaining bytes have been read.
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+	exceeded  bool
+}
+
+func (l *limitedBody) Read(p []byte) (int, error) {
+	if l.exceeded {
+		return 0, errResponseTooLarge
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		l.exceeded = true
+		return 0, errResponseTooLarge
+	}
+	return n, err
+}
+
 // nopCloser is just like ioutil's, but here to let us re-read the same
 // buffer inside by moving position to the start every time we done with reading
 type nopCloser struct {
@@ -0,0 +1,82 @@
+package gateway
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+// secretEnvPrefix is the prefix of the environment variables that API
+// definitions can read, so that they can't read the rest of the gateway's
+// environment.
+const secretEnvPrefix = "TYK_SECRET_"
+
+// secretValue resolves a secret given in an API definition. Secrets can be
+// "env://TYK_SECRET_NAME" for an environment variable, "secrets://name"
+// for an entry in the gateway's secrets config or "file://name" for the
+// contents of a file in the gateway's secrets_dir. Other values are used
+// as they are.
+func secretValue(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, "env://"):
+		name := strings.TrimPrefix(value, "env://")
+		if !strings.HasPrefix(name, secretEnvPrefix) {
+			return "", fmt.Errorf("environment variable %q doesn't start with %s", name, secretEnvPrefix)
+		}
+		v, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %q is not set", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "secrets://"):
+		name := strings.TrimPrefix(value, "secrets://")
+		v, ok := config.Global().Secrets[name]
+		if !ok {
+			return "", fmt.Errorf("secret %q is not in the gateway config", name)
+		}
+		return v, nil
+	case strings.HasPrefix(value, "file://"):
+		path, err := secretPath(strings.TrimPrefix(value, "file://"))
+		if err != nil {
+			return "", err
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(b)), nil
+	}
+	return value, nil
+}
+
+// secretPath resolves the name of a secret file within the secrets_dir.
+// Absolute names must be in it too.
+func secretPath(name string) (string, error) {
+	dir := config.Global().SecretsDir
+	if dir == "" {
+		return "", errors.New("file secrets need a secrets_dir in the gateway config")
+	}
+	for _, elem := range strings.Split(filepath.ToSlash(name), "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("secret file %q is outside the secrets_dir", name)
+		}
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	path := name
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	if rel, err := filepath.Rel(dir, filepath.Clean(path)); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("secret file %q is outside the secrets_dir", name)
+	}
+	return path, nil
+}
@@ -0,0 +1,56 @@
+package gateway
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+func TestSecretValue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	globalConf := config.Global()
+	globalConf.Secrets = map[string]string{"name": "from-config"}
+	globalConf.SecretsDir = filepath.Join(dir, "secrets")
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	os.Setenv("TYK_SECRET_VALUE", "from-env")
+	defer os.Unsetenv("TYK_SECRET_VALUE")
+	os.Setenv("TEST_SECRET_VALUE", "from-env")
+	defer os.Unsetenv("TEST_SECRET_VALUE")
+
+	os.Mkdir(globalConf.SecretsDir, 0700)
+	ioutil.WriteFile(filepath.Join(globalConf.SecretsDir, "name"), []byte("from-file\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "outside"), []byte("outside\n"), 0600)
+
+	tests := []struct {
+		value, want string
+		wantErr     bool
+	}{
+		{"plain", "plain", false},
+		{"env://TYK_SECRET_VALUE", "from-env", false},
+		{"env://TYK_SECRET_MISSING", "", true},
+		{"env://TEST_SECRET_VALUE", "", true},
+		{"secrets://name", "from-config", false},
+		{"secrets://missing", "", true},
+		{"file://name", "from-file", false},
+		{"file://" + filepath.Join(globalConf.SecretsDir, "name"), "from-file", false},
+		{"file://missing", "", true},
+		{"file://../outside", "", true},
+		{"file://" + filepath.Join(dir, "outside"), "", true},
+	}
+	for _, tc := range tests {
+		got, err := secretValue(tc.value)
+		if (err != nil) != tc.wantErr || got != tc.want {
+			t.Errorf("%s: want %q (error %v), got %q (%v)", tc.value, tc.want, tc.wantErr, got, err)
+		}
+	}
+}
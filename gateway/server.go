@@ -46,6 +46,7 @@ import (
 	"github.com/ins-tykgw/tyk/headers"
 	logger "github.com/ins-tykgw/tyk/log"
 	"github.com/ins-tykgw/tyk/regexp"
+	"github.com/ins-tykgw/tyk/request"
 	"github.com/ins-tykgw/tyk/rpc"
 	"github.com/ins-tykgw/tyk/storage"
 	"github.com/ins-tykgw/tyk/trace"
@@ -133,6 +134,11 @@ func setupGlobals() {
 	reloadMu.Lock()
 	defer reloadMu.Unlock()
 
+	httpServerOptions := config.Global().HttpServerOptions
+	if err := request.SetTrustedProxies(httpServerOptions.TrustedProxies, httpServerOptions.TrustedProxyHops, httpServerOptions.TrustedProxyHeader); err != nil {
+		mainLog.Error("Invalid trusted_proxies: ", err)
+	}
+
 	dnsCacheManager = dnscache.NewDnsCacheManager(config.Global().DnsCache.MultipleIPsHandleStrategy)
 	if config.Global().DnsCache.Enabled {
 		dnsCacheManager.InitDNSCaching(
@@ -143,7 +149,7 @@ func setupGlobals() {
 	mainRouter = mux.NewRouter()
 	controlRouter = mux.NewRouter()
 
-	if config.Global().EnableAnalytics && config.Global().Storage.Type != "redis" {
+	if config.Global().EnableAnalytics && config.Global().Storage.Type != "redis" && config.Global().Storage.Type != storage.EmbeddedType {
 		mainLog.Fatal("Analytics requires Redis Storage backend, please enable Redis in the tyk.conf file.")
 	}
 
@@ -394,6 +400,9 @@ func loadAPIEndpoints(muxer *mux.Router) {
 		r.HandleFunc("/org/keys/{keyName:[^/]*}", orgHandler).Methods("POST", "PUT", "GET", "DELETE")
 		r.HandleFunc("/keys/policy/{keyName}", policyUpdateHandler).Methods("POST")
 		r.HandleFunc("/keys/create", createKeyHandler).Methods("POST")
+		r.HandleFunc("/keys/jobs", keyJobsHandler).Methods("POST", "GET")
+		r.HandleFunc("/keys/jobs/{jobID}", keyJobsHandler).Methods("GET")
+		r.HandleFunc("/keys/{keyName:[^/]*}/rotate", keyRotateHandler).Methods("POST")
 		r.HandleFunc("/apis", apiHandler).Methods("GET", "POST", "PUT", "DELETE")
 		r.HandleFunc("/apis/{apiID}", apiHandler).Methods("GET", "POST", "PUT", "DELETE")
 		r.HandleFunc("/health", healthCheckhandler).Methods("GET")
@@ -411,6 +420,7 @@ func loadAPIEndpoints(muxer *mux.Router) {
 	r.HandleFunc("/keys/{keyName:[^/]*}", keyHandler).Methods("POST", "PUT", "GET", "DELETE")
 	r.HandleFunc("/certs", certHandler).Methods("POST", "GET")
 	r.HandleFunc("/certs/{certID:[^/]*}", certHandler).Methods("POST", "GET", "DELETE")
+	r.HandleFunc("/breakers/{apiID}", circuitBreakerHandler).Methods("GET", "DELETE")
 	r.HandleFunc("/oauth/clients/{apiID}", oAuthClientHandler).Methods("GET", "DELETE")
 	r.HandleFunc("/oauth/clients/{apiID}/{keyName:[^/]*}", oAuthClientHandler).Methods("GET", "DELETE")
 	r.HandleFunc("/oauth/clients/{apiID}/{keyName}/tokens", oAuthClientTokensHandler).Methods("GET")
@@ -446,6 +456,9 @@ func addOAuthHandlers(spec *APISpec, muxer *mux.Router) *OAuthManager {
 	apiAuthorizePath := spec.Proxy.ListenPath + "tyk/oauth/authorize-client{_:/?}"
 	clientAuthPath := spec.Proxy.ListenPath + "oauth/authorize{_:/?}"
 	clientAccessPath := spec.Proxy.ListenPath + "oauth/token{_:/?}"
+	introspectPath := spec.Proxy.ListenPath + "oauth/introspect{_:/?}"
+	revokePath := spec.Proxy.ListenPath + "oauth/revoke{_:/?}"
+	jwksPath := spec.Proxy.ListenPath + "oauth/jwks{_:/?}"
 
 	serverConfig := osin.NewServerConfig()
 	serverConfig.ErrorStatusCode = http.StatusForbidden
@@ -459,6 +472,10 @@ func addOAuthHandlers(spec *APISpec, muxer *mux.Router) *OAuthManager {
 	osinStorage := &RedisOsinStorageInterface{storageManager, spec.SessionManager} //TODO: Needs storage manager from APISpec
 
 	osinServer := TykOsinNewServer(serverConfig, osinStorage)
+	if spec.OAuthServer.JWTAccessTokens.Enabled {
+		osinServer.AccessTokenGen = jwtAccessTokenGen{spec}
+		osinServer.Server.AccessTokenGen = osinServer.AccessTokenGen
+	}
 
 	oauthManager := OAuthManager{spec, osinServer}
 	oauthHandlers := OAuthHandlers{oauthManager}
@@ -466,6 +483,11 @@ func addOAuthHandlers(spec *APISpec, muxer *mux.Router) *OAuthManager {
 	muxer.Handle(apiAuthorizePath, checkIsAPIOwner(allowMethods(oauthHandlers.HandleGenerateAuthCodeData, "POST")))
 	muxer.HandleFunc(clientAuthPath, allowMethods(oauthHandlers.HandleAuthorizePassthrough, "GET", "POST"))
 	muxer.HandleFunc(clientAccessPath, allowMethods(oauthHandlers.HandleAccessRequest, "GET", "POST"))
+	muxer.HandleFunc(introspectPath, allowMethods(oauthHandlers.HandleIntrospection, "POST"))
+	muxer.HandleFunc(revokePath, allowMethods(oauthHandlers.HandleRevocation, "POST"))
+	if spec.OAuthServer.JWTAccessTokens.Enabled {
+		muxer.HandleFunc(jwksPath, allowMethods(oauthHandlers.HandleJWKS, "GET"))
+	}
 
 	return &oauthManager
 }
@@ -578,6 +600,15 @@ func createResponseMiddlewareChain(spec *APISpec) {
 		mainLog.Debug("Loading Response processor: ", processorDetail.Name)
 		responseChain[i] = processor
 	}
+
+	for _, v := range spec.VersionData.Versions {
+		if v.OpenAPI.Enabled && v.OpenAPI.ValidateResponses {
+			processor := &OpenAPIResponseValidation{}
+			processor.Init(nil, spec)
+			responseChain = append(responseChain, processor)
+			break
+		}
+	}
 	spec.ResponseChain = responseChain
 }
 
@@ -858,7 +889,11 @@ func initialiseSystem() error {
 		}
 	}
 
-	if config.Global().Storage.Type != "redis" {
+	switch config.Global().Storage.Type {
+	case "redis":
+	case storage.EmbeddedType:
+		mainLog.Info("Using the embedded storage, data is not shared with other gateways")
+	default:
 		mainLog.Fatal("Redis connection details not set, please ensure that the storage type is set to Redis and that the connection parameters are correct.")
 	}
 
@@ -1052,7 +1087,8 @@ func Start() {
 
 		listen(listener, controlListener, goAgainErr)
 	} else {
-		listen(listener, controlListener, nil)
+		// goagain needs the inherited listener unwrapped to fork again
+		listen(proxyProtocolListener(listener, config.Global().ListenPort), controlListener, nil)
 
 		// Kill the parent, now that the child has started successfully.
 		mainLog.Debug("KILLING PARENT PROCESS")
@@ -1161,6 +1197,7 @@ func start() {
 	// interval counts from the start of one reload to the next.
 	go reloadLoop(time.Tick(time.Second))
 	go reloadQueueLoop()
+	go certificateExpiryLoop(time.Tick(certificateExpiryCheckInterval))
 }
 
 func generateListener(listenPort int) (net.Listener, error) {
@@ -1168,6 +1205,13 @@ func generateListener(listenPort int) (net.Listener, error) {
 
 	targetPort := listenAddress + ":" + strconv.Itoa(listenPort)
 
+	ln, err := net.Listen("tcp", targetPort)
+	if err != nil {
+		return nil, err
+	}
+	// the PROXY header comes before the TLS handshake
+	ln = proxyProtocolListener(ln, listenPort)
+
 	if httpServerOptions := config.Global().HttpServerOptions; httpServerOptions.UseSSL {
 		mainLog.Info("--> Using SSL (https)")
 
@@ -1186,7 +1230,7 @@ func generateListener(listenPort int) (net.Listener, error) {
 
 		tlsConfig.GetConfigForClient = getTLSConfigForClient(&tlsConfig, listenPort)
 
-		return tls.Listen("tcp", targetPort, &tlsConfig)
+		return tls.NewListener(ln, &tlsConfig), nil
 	} else if config.Global().HttpServerOptions.UseLE_SSL {
 
 		mainLog.Info("--> Using SSL LE (https)")
@@ -1198,10 +1242,10 @@ func generateListener(listenPort int) (net.Listener, error) {
 		}
 		conf.GetConfigForClient = getTLSConfigForClient(&conf, listenPort)
 
-		return tls.Listen("tcp", targetPort, &conf)
+		return tls.NewListener(ln, &conf), nil
 	} else {
 		mainLog.WithField("port", targetPort).Info("--> Standard listener (http)")
-		return net.Listen("tcp", targetPort)
+		return ln, nil
 	}
 }
 
@@ -1,6 +1,7 @@
 package gateway
 
 import (
+	"context"
 	"io/ioutil"
 	"net/http"
 	"strconv"
@@ -23,6 +24,8 @@ type ServiceDiscovery struct {
 	parentPath          string
 	portPath            string
 	targetPath          string
+	provider            serviceDiscoveryProvider
+	providerErr         error
 }
 
 func (s *ServiceDiscovery) Init(spec *apidef.ServiceDiscoveryConfiguration) {
@@ -42,6 +45,7 @@ func (s *ServiceDiscovery) Init(spec *apidef.ServiceDiscoveryConfiguration) {
 	}
 
 	s.dataPath = spec.DataPath
+	s.provider, s.providerErr = newServiceDiscoveryProvider(spec)
 }
 
 func (s *ServiceDiscovery) getServiceData(name string) (string, error) {
@@ -265,6 +269,17 @@ func (s *ServiceDiscovery) ProcessRawData(rawData string) (*apidef.HostList, err
 }
 
 func (s *ServiceDiscovery) Target(serviceURL string) (*apidef.HostList, error) {
+	if s.providerErr != nil {
+		return nil, s.providerErr
+	}
+	if s.provider != nil {
+		hosts, _, err := s.provider.Targets(context.Background(), "")
+		if err != nil {
+			return nil, err
+		}
+		return apidef.NewHostListFromList(hosts), nil
+	}
+
 	// Get the data
 	rawData, err := s.getServiceData(serviceURL)
 	if err != nil {
@@ -0,0 +1,502 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	cache "github.com/pmylund/go-cache"
+
+	"github.com/ins-tykgw/tyk/apidef"
+)
+
+// Native service discovery providers, selected with the "provider" option of
+// a service discovery configuration. An empty provider keeps the legacy
+// behaviour of querying a JSON endpoint and extracting hosts with data paths.
+const (
+	ServiceDiscoveryDNSSRV     = "dns_srv"
+	ServiceDiscoveryConsul     = "consul"
+	ServiceDiscoveryKubernetes = "kubernetes"
+)
+
+const (
+	defaultServiceDiscoveryWatchWait = 5 * time.Minute
+	serviceDiscoveryRetryWait        = 5 * time.Second
+
+	kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	kubernetesCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+)
+
+// serviceDiscoveryProvider resolves the upstream hosts of a service.
+//
+// Targets returns the current hosts and an opaque index. When called with a
+// non-empty index the provider blocks until the service changes or its watch
+// times out. Providers that can't watch always return an empty index.
+type serviceDiscoveryProvider interface {
+	Targets(ctx context.Context, index string) ([]string, string, error)
+}
+
+func newServiceDiscoveryProvider(conf *apidef.ServiceDiscoveryConfiguration) (serviceDiscoveryProvider, error) {
+	switch conf.Provider {
+	case "":
+		return nil, nil
+	case ServiceDiscoveryDNSSRV:
+		return &dnsSRVProvider{conf: conf}, nil
+	case ServiceDiscoveryConsul:
+		return &consulProvider{conf: conf, client: &http.Client{}}, nil
+	case ServiceDiscoveryKubernetes:
+		p, err := newKubernetesProvider(conf)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("unknown service discovery provider: %q", conf.Provider)
+}
+
+// formatDiscoveredHost builds a host list entry the same way the legacy
+// discovery does: host and port, followed by the configured target path.
+func formatDiscoveredHost(conf *apidef.ServiceDiscoveryConfiguration, host string, port int) string {
+	hostPort := net.JoinHostPort(host, strconv.Itoa(port))
+	if conf.Scheme != "" {
+		hostPort = conf.Scheme + "://" + hostPort
+	}
+	return hostPort + conf.TargetPath
+}
+
+func serviceDiscoveryWatchWait(conf *apidef.ServiceDiscoveryConfiguration) time.Duration {
+	if conf.CacheTimeout > 0 {
+		return time.Duration(conf.CacheTimeout) * time.Second
+	}
+	return defaultServiceDiscoveryWatchWait
+}
+
+// dnsSRVProvider resolves hosts from DNS SRV records. Only records in the
+// lowest priority class are used; weights are left to the round robin. DNS
+// has no watch support, so watches fall back to polling.
+type dnsSRVProvider struct {
+	conf *apidef.ServiceDiscoveryConfiguration
+}
+
+func (p *dnsSRVProvider) resolver() *net.Resolver {
+	if p.conf.DNSServer == "" {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, p.conf.DNSServer)
+		},
+	}
+}
+
+func (p *dnsSRVProvider) Targets(ctx context.Context, _ string) ([]string, string, error) {
+	_, records, err := p.resolver().LookupSRV(ctx, "", "", p.conf.ServiceName)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(records) == 0 {
+		return nil, "", nil
+	}
+
+	// LookupSRV sorts by priority, so the first record has the lowest.
+	priority := records[0].Priority
+	hosts := []string{}
+	for _, srv := range records {
+		if srv.Priority != priority {
+			break
+		}
+		hosts = append(hosts, formatDiscoveredHost(p.conf, strings.TrimSuffix(srv.Target, "."), int(srv.Port)))
+	}
+	return hosts, "", nil
+}
+
+// consulProvider resolves passing instances from the Consul health API,
+// using blocking queries to watch for changes.
+type consulProvider struct {
+	conf   *apidef.ServiceDiscoveryConfiguration
+	client *http.Client
+}
+
+type consulServiceEntry struct {
+	Node struct {
+		Address string
+	}
+	Service struct {
+		Address string
+		Port    int
+	}
+}
+
+func (p *consulProvider) Targets(ctx context.Context, index string) ([]string, string, error) {
+	endpoint := strings.TrimSuffix(p.conf.QueryEndpoint, "/")
+	if endpoint == "" {
+		endpoint = "http://127.0.0.1:8500"
+	}
+
+	query := url.Values{"passing": {"true"}}
+	if p.conf.Tag != "" {
+		query.Set("tag", p.conf.Tag)
+	}
+	if index != "" {
+		query.Set("index", index)
+		query.Set("wait", fmt.Sprintf("%ds", int(serviceDiscoveryWatchWait(p.conf).Seconds())))
+	}
+
+	req, err := http.NewRequest(http.MethodGet, endpoint+"/v1/health/service/"+url.PathEscape(p.conf.ServiceName)+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req = req.WithContext(ctx)
+	if p.conf.Token != "" {
+		req.Header.Set("X-Consul-Token", p.conf.Token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("consul returned status code %d", resp.StatusCode)
+	}
+
+	var entries []consulServiceEntry
+	if err := json.NewDecoder(resp.Body).Decode(&entries); err != nil {
+		return nil, "", err
+	}
+
+	hosts := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		address := entry.Service.Address
+		if address == "" {
+			address = entry.Node.Address
+		}
+		hosts = append(hosts, formatDiscoveredHost(p.conf, address, entry.Service.Port))
+	}
+	return hosts, resp.Header.Get("X-Consul-Index"), nil
+}
+
+// kubernetesProvider resolves ready endpoints of a Kubernetes service from the
+// Endpoints or EndpointSlice API, using watch requests to wait for changes.
+// With no query endpoint configured it uses the in-cluster service account.
+type kubernetesProvider struct {
+	conf      *apidef.ServiceDiscoveryConfiguration
+	client    *http.Client
+	endpoint  string
+	namespace string
+	tokenPath string
+}
+
+func newKubernetesProvider(conf *apidef.ServiceDiscoveryConfiguration) (*kubernetesProvider, error) {
+	p := &kubernetesProvider{
+		conf:      conf,
+		client:    &http.Client{},
+		endpoint:  strings.TrimSuffix(conf.QueryEndpoint, "/"),
+		namespace: conf.Namespace,
+	}
+
+	if p.endpoint == "" {
+		host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+		if host == "" || port == "" {
+			return nil, errors.New("kubernetes service discovery requires a query endpoint outside of a cluster")
+		}
+		p.endpoint = "https://" + net.JoinHostPort(host, port)
+		p.tokenPath = kubernetesTokenPath
+
+		if ca, err := ioutil.ReadFile(kubernetesCAPath); err == nil {
+			pool := x509.NewCertPool()
+			pool.AppendCertsFromPEM(ca)
+			p.client.Transport = &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}
+		}
+	}
+	if p.namespace == "" {
+		p.namespace = "default"
+	}
+	return p, nil
+}
+
+type kubernetesPort struct {
+	Name string `json:"name"`
+	Port int    `json:"port"`
+}
+
+type kubernetesEndpoints struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Subsets []struct {
+		Addresses []struct {
+			IP string `json:"ip"`
+		} `json:"addresses"`
+		Ports []kubernetesPort `json:"ports"`
+	} `json:"subsets"`
+}
+
+type kubernetesEndpointSliceList struct {
+	Metadata struct {
+		ResourceVersion string `json:"resourceVersion"`
+	} `json:"metadata"`
+	Items []struct {
+		Endpoints []struct {
+			Addresses  []string `json:"addresses"`
+			Conditions struct {
+				Ready *bool `json:"ready"`
+			} `json:"conditions"`
+		} `json:"endpoints"`
+		Ports []kubernetesPort `json:"ports"`
+	} `json:"items"`
+}
+
+func (p *kubernetesProvider) do(ctx context.Context, path string, query url.Values) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, p.endpoint+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	token := p.conf.Token
+	if token == "" && p.tokenPath != "" {
+		data, err := ioutil.ReadFile(p.tokenPath)
+		if err != nil {
+			return nil, err
+		}
+		token = strings.TrimSpace(string(data))
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("kubernetes API returned status code %d", resp.StatusCode)
+	}
+	return resp, nil
+}
+
+func (p *kubernetesProvider) path() string {
+	if p.conf.UseEndpointSlices {
+		return "/apis/discovery.k8s.io/v1/namespaces/" + p.namespace + "/endpointslices"
+	}
+	return "/api/v1/namespaces/" + p.namespace + "/endpoints"
+}
+
+func (p *kubernetesProvider) selector() url.Values {
+	if p.conf.UseEndpointSlices {
+		return url.Values{"labelSelector": {"kubernetes.io/service-name=" + p.conf.ServiceName}}
+	}
+	return url.Values{"fieldSelector": {"metadata.name=" + p.conf.ServiceName}}
+}
+
+// watch blocks until the API server reports a change after index, or the
+// watch times out.
+func (p *kubernetesProvider) watch(ctx context.Context, index string) error {
+	query := p.selector()
+	query.Set("watch", "true")
+	query.Set("resourceVersion", index)
+	query.Set("timeoutSeconds", strconv.Itoa(int(serviceDiscoveryWatchWait(p.conf).Seconds())))
+
+	resp, err := p.do(ctx, p.path(), query)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Watch events are streamed one JSON object per line; any event means
+	// the list has to be read again.
+	bufio.NewReader(resp.Body).ReadBytes('\n')
+	return nil
+}
+
+func (p *kubernetesProvider) portFor(ports []kubernetesPort) (int, bool) {
+	for _, port := range ports {
+		if p.conf.PortName == "" || port.Name == p.conf.PortName {
+			return port.Port, true
+		}
+	}
+	return 0, false
+}
+
+func (p *kubernetesProvider) Targets(ctx context.Context, index string) ([]string, string, error) {
+	if index != "" {
+		if err := p.watch(ctx, index); err != nil {
+			return nil, "", err
+		}
+	}
+
+	hosts := []string{}
+	if p.conf.UseEndpointSlices {
+		resp, err := p.do(ctx, p.path(), p.selector())
+		if err != nil {
+			return nil, "", err
+		}
+		defer resp.Body.Close()
+
+		var list kubernetesEndpointSliceList
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			return nil, "", err
+		}
+		for _, slice := range list.Items {
+			port, ok := p.portFor(slice.Ports)
+			if !ok {
+				continue
+			}
+			for _, endpoint := range slice.Endpoints {
+				if ready := endpoint.Conditions.Ready; ready != nil && !*ready {
+					continue
+				}
+				for _, address := range endpoint.Addresses {
+					hosts = append(hosts, formatDiscoveredHost(p.conf, address, port))
+				}
+			}
+		}
+		return hosts, list.Metadata.ResourceVersion, nil
+	}
+
+	resp, err := p.do(ctx, p.path()+"/"+url.PathEscape(p.conf.ServiceName), url.Values{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	var endpoints kubernetesEndpoints
+	if err := json.NewDecoder(resp.Body).Decode(&endpoints); err != nil {
+		return nil, "", err
+	}
+	for _, subset := range endpoints.Subsets {
+		port, ok := p.portFor(subset.Ports)
+		if !ok {
+			continue
+		}
+		for _, address := range subset.Addresses {
+			hosts = append(hosts, formatDiscoveredHost(p.conf, address.IP, port))
+		}
+	}
+	return hosts, endpoints.Metadata.ResourceVersion, nil
+}
+
+// startServiceDiscoveryWatches watches the proxy and uptime test services of
+// spec when they use a native provider with watch enabled. Changes to the
+// proxy service replace the cached host list, changes to the uptime test
+// service refresh the host checker. The watches stop when spec is released.
+func startServiceDiscoveryWatches(spec *APISpec) {
+	proxySD := &spec.Proxy.ServiceDiscovery
+	uptimeSD := &spec.UptimeTests.Config.ServiceDiscovery
+
+	watchProxy := proxySD.UseDiscoveryService && proxySD.Provider != "" && proxySD.Watch
+	watchUptime := uptimeSD.UseDiscoveryService && uptimeSD.Provider != "" && uptimeSD.Watch
+	if !watchProxy && !watchUptime {
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	spec.serviceDiscoveryCancel = cancel
+
+	if watchProxy {
+		go watchServiceDiscovery(ctx, proxySD, func(hosts *apidef.HostList) {
+			spec.Lock()
+			spec.LastGoodHostList = hosts
+			spec.HasRun = true
+			spec.Unlock()
+			ServiceCache.Set(spec.APIID, hosts, cache.NoExpiration)
+		})
+	}
+
+	if watchUptime {
+		go watchServiceDiscovery(ctx, uptimeSD, func(*apidef.HostList) {
+			// The initial list is loaded with the rest of the uptime
+			// tests once the spec is registered.
+			if getApiSpec(spec.APIID) != spec {
+				return
+			}
+			GlobalHostChecker.DoServiceDiscoveryListUpdateForID(spec.APIID)
+		})
+	}
+}
+
+func sameHosts(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// watchServiceDiscovery follows a provider's watch and calls onChange every
+// time the host list changes, until ctx is cancelled. Empty host lists are
+// not reported, so the last good list stays in use.
+func watchServiceDiscovery(ctx context.Context, conf *apidef.ServiceDiscoveryConfiguration, onChange func(*apidef.HostList)) {
+	logger := log.WithFields(logrus.Fields{
+		"prefix":   "service-discovery",
+		"provider": conf.Provider,
+		"service":  conf.ServiceName,
+	})
+
+	provider, err := newServiceDiscoveryProvider(conf)
+	if err != nil || provider == nil {
+		logger.Error("Can't watch service: ", err)
+		return
+	}
+
+	var last []string
+	index := ""
+	for {
+		hosts, newIndex, err := provider.Targets(ctx, index)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			logger.Warning("Watch failed, retrying: ", err)
+			index = ""
+			select {
+			case <-time.After(serviceDiscoveryRetryWait):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+
+		if len(hosts) > 0 && !sameHosts(hosts, last) {
+			logger.Debug("Host list changed: ", hosts)
+			last = hosts
+			onChange(apidef.NewHostListFromList(hosts))
+		}
+
+		// Providers without watch support are polled on the cache timeout.
+		index = newIndex
+		if index == "" {
+			select {
+			case <-time.After(serviceDiscoveryWatchWait(conf)):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
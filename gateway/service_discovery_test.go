@@ -1,7 +1,18 @@
 package gateway
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/ins-tykgw/tyk/apidef"
 )
 
 const consul = `
@@ -370,3 +381,180 @@ func TestServiceDiscovery_MESOSPHERE(t *testing.T) {
 	}
 
 }
+
+func assertHosts(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if !sameHosts(got, want) {
+		t.Fatalf("want hosts %v, got %v", want, got)
+	}
+}
+
+func TestServiceDiscovery_DNSSRV(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{PacketConn: conn, Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+		msg := dns.Msg{}
+		msg.SetReply(r)
+		if r.Question[0].Qtype == dns.TypeSRV && r.Question[0].Name == "_http._tcp.upstream.local." {
+			for i, target := range []string{"a.upstream.local.", "b.upstream.local.", "backup.upstream.local."} {
+				priority := uint16(10)
+				if i == 2 {
+					priority = 20
+				}
+				msg.Answer = append(msg.Answer, &dns.SRV{
+					Hdr:      dns.RR_Header{Name: r.Question[0].Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: 60},
+					Priority: priority,
+					Weight:   1,
+					Port:     8080 + uint16(i),
+					Target:   target,
+				})
+			}
+		}
+		w.WriteMsg(&msg)
+	})}
+	go server.ActivateAndServe()
+	defer server.Shutdown()
+
+	sd := ServiceDiscovery{}
+	sd.Init(&apidef.ServiceDiscoveryConfiguration{
+		Provider:    ServiceDiscoveryDNSSRV,
+		ServiceName: "_http._tcp.upstream.local",
+		DNSServer:   conn.LocalAddr().String(),
+		Scheme:      "http",
+	})
+	data, err := sd.Target("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, data.All(), "http://a.upstream.local:8080", "http://b.upstream.local:8081")
+}
+
+func TestServiceDiscovery_ConsulProvider(t *testing.T) {
+	var index int32 = 1
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/health/service/web" || r.URL.Query().Get("passing") != "true" {
+			http.NotFound(w, r)
+			return
+		}
+		if r.Header.Get("X-Consul-Token") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		current := atomic.LoadInt32(&index)
+		if r.URL.Query().Get("index") == strconv.Itoa(int(current)) {
+			// Blocking query: simulate a registration arriving.
+			current = atomic.AddInt32(&index, 1)
+		}
+		w.Header().Set("X-Consul-Index", strconv.Itoa(int(current)))
+		entries := `[{"Node":{"Address":"10.0.0.1"},"Service":{"Address":"","Port":80}}`
+		if current > 1 {
+			entries += `,{"Node":{"Address":"10.0.0.1"},"Service":{"Address":"10.0.1.2","Port":8080}}`
+		}
+		w.Write([]byte(entries + "]"))
+	}))
+	defer ts.Close()
+
+	conf := &apidef.ServiceDiscoveryConfiguration{
+		Provider:      ServiceDiscoveryConsul,
+		QueryEndpoint: ts.URL,
+		ServiceName:   "web",
+		Token:         "secret",
+	}
+
+	sd := ServiceDiscovery{}
+	sd.Init(conf)
+	data, err := sd.Target("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertHosts(t, data.All(), "10.0.0.1:80")
+
+	t.Run("Watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		updates := make(chan []string, 2)
+		go watchServiceDiscovery(ctx, conf, func(hosts *apidef.HostList) {
+			updates <- hosts.All()
+		})
+		for _, want := range [][]string{{"10.0.0.1:80"}, {"10.0.0.1:80", "10.0.1.2:8080"}} {
+			select {
+			case got := <-updates:
+				assertHosts(t, got, want...)
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for host list update")
+			}
+		}
+	})
+}
+
+func TestServiceDiscovery_KubernetesProvider(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/api/v1/namespaces/apps/endpoints/web":
+			w.Write([]byte(`{
+				"metadata": {"resourceVersion": "42"},
+				"subsets": [{
+					"addresses": [{"ip": "10.1.0.1"}, {"ip": "10.1.0.2"}],
+					"ports": [{"name": "metrics", "port": 9090}, {"name": "http", "port": 8080}]
+				}]
+			}`))
+		case "/apis/discovery.k8s.io/v1/namespaces/apps/endpointslices":
+			if r.URL.Query().Get("labelSelector") != "kubernetes.io/service-name=web" {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(`{
+				"metadata": {"resourceVersion": "43"},
+				"items": [{
+					"endpoints": [
+						{"addresses": ["10.1.0.1"], "conditions": {"ready": true}},
+						{"addresses": ["10.1.0.3"], "conditions": {"ready": false}}
+					],
+					"ports": [{"name": "http", "port": 8080}]
+				}]
+			}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	conf := apidef.ServiceDiscoveryConfiguration{
+		Provider:      ServiceDiscoveryKubernetes,
+		QueryEndpoint: ts.URL,
+		ServiceName:   "web",
+		Namespace:     "apps",
+		PortName:      "http",
+		Token:         "token",
+		TargetPath:    "/v1",
+	}
+
+	t.Run("Endpoints", func(t *testing.T) {
+		sd := ServiceDiscovery{}
+		sd.Init(&conf)
+		data, err := sd.Target("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertHosts(t, data.All(), "10.1.0.1:8080/v1", "10.1.0.2:8080/v1")
+	})
+
+	t.Run("EndpointSlices", func(t *testing.T) {
+		sliceConf := conf
+		sliceConf.UseEndpointSlices = true
+		sd := ServiceDiscovery{}
+		sd.Init(&sliceConf)
+		data, err := sd.Target("")
+		if err != nil {
+			t.Fatal(err)
+		}
+		assertHosts(t, data.All(), "10.1.0.1:8080/v1")
+	})
+}
@@ -0,0 +1,140 @@
+package gateway
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/ins-tykgw/tyk/storage"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+const defaultSessionIntrospectionPath = "tyk/session"
+
+// sessionIntrospection is the view API consumers get of their own key. It
+// must not include secrets, such as HMAC secrets or passwords, or metadata
+// only meant for the gateway and the upstream.
+//
+// swagger:model
+type sessionIntrospection struct {
+	// Quota.Remaining and RateLimit.Remaining are -1 when unlimited.
+	Quota struct {
+		Max       int64 `json:"max"`
+		Remaining int64 `json:"remaining"`
+		Resets    int64 `json:"resets"`
+	} `json:"quota"`
+	RateLimit struct {
+		Rate      float64 `json:"rate"`
+		Per       float64 `json:"per"`
+		Remaining int64   `json:"remaining"`
+		Resets    int64   `json:"resets"`
+	} `json:"rate_limit"`
+	Expires      int64                `json:"expires"`
+	Policies     []introspectedPolicy `json:"policies"`
+	AccessRights []introspectedAccess `json:"access_rights"`
+}
+
+type introspectedPolicy struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type introspectedAccess struct {
+	APIID       string            `json:"api_id"`
+	APIName     string            `json:"api_name"`
+	Versions    []string          `json:"versions"`
+	AllowedURLs []user.AccessSpec `json:"allowed_urls"`
+}
+
+// sessionIntrospectionHandler serves the key of an authenticated request
+// to its owner. Looking at the key doesn't count towards its limits.
+func sessionIntrospectionHandler(spec *APISpec) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		session := ctxGetSession(r)
+		if session == nil {
+			doJSONWrite(w, http.StatusBadRequest, apiError("No session for the request"))
+			return
+		}
+		token := ctxGetAuthToken(r)
+		store := spec.SessionManager.Store()
+
+		obj := sessionIntrospection{
+			Expires:      session.Expires,
+			Policies:     []introspectedPolicy{},
+			AccessRights: []introspectedAccess{},
+		}
+
+		obj.Quota.Max, obj.Quota.Remaining, obj.Quota.Resets = -1, -1, 0
+		if !spec.DisableQuota {
+			obj.Quota.Max, obj.Quota.Remaining, obj.Quota.Resets = quotaRemaining(session, store, spec.APIID)
+		}
+
+		obj.RateLimit.Rate, obj.RateLimit.Per = session.Rate, session.Per
+		if rights, ok := session.AccessRights[spec.APIID]; ok && rights.Limit != nil {
+			obj.RateLimit.Rate, obj.RateLimit.Per = rights.Limit.Rate, rights.Limit.Per
+		}
+		obj.RateLimit.Remaining = -1
+		if !spec.DisableRateLimit {
+			remaining, resets := sessionLimiter.RateLimitRemaining(session, token, store, &spec.GlobalConfig, spec.APIID)
+			obj.RateLimit.Remaining = remaining
+			if !resets.IsZero() {
+				obj.RateLimit.Resets = resets.Unix()
+			}
+		}
+
+		for _, polID := range session.PolicyIDs() {
+			policiesMu.RLock()
+			policy := policiesByID[polID]
+			policiesMu.RUnlock()
+			obj.Policies = append(obj.Policies, introspectedPolicy{ID: polID, Name: policy.Name})
+		}
+
+		for apiID, access := range session.AccessRights {
+			name := access.APIName
+			if name == "" {
+				if accessSpec := getApiSpec(apiID); accessSpec != nil {
+					name = accessSpec.Name
+				}
+			}
+			obj.AccessRights = append(obj.AccessRights, introspectedAccess{
+				APIID:       apiID,
+				APIName:     name,
+				Versions:    access.Versions,
+				AllowedURLs: access.AllowedURLs,
+			})
+		}
+		sort.Slice(obj.AccessRights, func(i, j int) bool {
+			return obj.AccessRights[i].APIID < obj.AccessRights[j].APIID
+		})
+
+		w.Header().Set("Cache-Control", "no-store")
+		doJSONWrite(w, http.StatusOK, obj)
+	}
+}
+
+// quotaRemaining returns the quota of a session for an API, what's left of
+// it and when it renews, without counting a request.
+func quotaRemaining(session *user.SessionState, store storage.Handler, apiID string) (max, remaining, renews int64) {
+	rawKey := QuotaKeyPrefix + session.KeyHash()
+	max, renews = session.QuotaMax, session.QuotaRenews
+	if rights, ok := session.AccessRights[apiID]; ok && rights.Limit != nil {
+		rawKey = QuotaKeyPrefix + apiID + "-" + session.KeyHash()
+		max, renews = rights.Limit.QuotaMax, rights.Limit.QuotaRenews
+	}
+	if max == -1 {
+		return -1, -1, 0
+	}
+
+	remaining = max
+	if time.Now().Unix() < renews {
+		value, err := store.GetRawKey(rawKey)
+		if used, convErr := strconv.ParseInt(value, 10, 64); err == nil && convErr == nil {
+			remaining = max - used
+		}
+	}
+	if remaining < 0 {
+		remaining = 0
+	}
+	return max, remaining, renews
+}
@@ -0,0 +1,134 @@
+package gateway
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestSessionIntrospection(t *testing.T) {
+	ts := StartTest()
+	defer ts.Close()
+
+	DRLManager.CurrentTokenValue = 1
+	DRLManager.RequestTokenValue = 1
+	defer func() {
+		DRLManager.CurrentTokenValue = 0
+		DRLManager.RequestTokenValue = 0
+	}()
+
+	pID := CreatePolicy(func(p *user.Policy) {
+		p.Name = "Gold plan"
+		p.Rate = 5
+		p.Per = 60
+		p.QuotaMax = 10
+		p.QuotaRenewalRate = 3600
+		p.AccessRights = map[string]user.AccessDefinition{"test": {
+			APIID:       "test",
+			Versions:    []string{"v1"},
+			AllowedURLs: []user.AccessSpec{{URL: "/", Methods: []string{"GET"}}},
+		}}
+	})
+
+	BuildAndLoadAPI(func(spec *APISpec) {
+		spec.Name = "Introspected API"
+		spec.UseKeylessAccess = false
+		spec.Proxy.ListenPath = "/api/"
+		spec.SessionIntrospection.Enabled = true
+	})
+
+	key := CreateSession(func(s *user.SessionState) {
+		s.SetPolicies(pID)
+		s.HMACEnabled = true
+		s.HmacSecret = "very-secret"
+		s.MetaData = map[string]interface{}{"internal": "hidden"}
+		s.Expires = 4102444800
+	})
+	authHeader := map[string]string{"Authorization": key}
+
+	introspect := func(t *testing.T) sessionIntrospection {
+		resp, _ := ts.Run(t, test.TestCase{Path: "/api/tyk/session", Headers: authHeader, Code: http.StatusOK,
+			BodyNotMatch: "very-secret", BodyMatchFunc: func(body []byte) bool {
+				return !bytes.Contains(body, []byte("hidden"))
+			}})
+		var obj sessionIntrospection
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			t.Fatal(err)
+		}
+		return obj
+	}
+
+	ts.Run(t, []test.TestCase{
+		{Path: "/api/", Headers: authHeader, Code: http.StatusOK},
+		{Path: "/api/", Headers: authHeader, Code: http.StatusOK},
+		{Path: "/api/tyk/session", Code: http.StatusUnauthorized},
+		{Path: "/api/tyk/session", Headers: map[string]string{"Authorization": "unknown"}, Code: http.StatusForbidden},
+	}...)
+
+	obj := introspect(t)
+	if obj.Quota.Max != 10 || obj.Quota.Remaining != 8 || obj.Quota.Resets == 0 {
+		t.Errorf("Unexpected quota: %+v", obj.Quota)
+	}
+	if obj.RateLimit.Rate != 5 || obj.RateLimit.Per != 60 || obj.RateLimit.Remaining != 3 || obj.RateLimit.Resets == 0 {
+		t.Errorf("Unexpected rate limit: %+v", obj.RateLimit)
+	}
+	if obj.Expires != 4102444800 {
+		t.Errorf("Expected the expiry of the key, got %d", obj.Expires)
+	}
+	if len(obj.Policies) != 1 || obj.Policies[0].Name != "Gold plan" {
+		t.Errorf("Expected the policy name, got %+v", obj.Policies)
+	}
+	if len(obj.AccessRights) != 1 || obj.AccessRights[0].APIName != "Introspected API" ||
+		len(obj.AccessRights[0].AllowedURLs) != 1 {
+		t.Errorf("Unexpected access rights: %+v", obj.AccessRights)
+	}
+
+	// looking at the key doesn't use it up
+	if again := introspect(t); again.Quota.Remaining != 8 || again.RateLimit.Remaining != 3 {
+		t.Errorf("Expected the same limits, got %+v %+v", again.Quota, again.RateLimit)
+	}
+
+	t.Run("Custom path", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/api/"
+			spec.SessionIntrospection.Enabled = true
+			spec.SessionIntrospection.Path = "/me"
+		})
+		ts.Run(t, test.TestCase{Path: "/api/me", Headers: authHeader, Code: http.StatusOK, BodyMatch: `"name":"Gold plan"`})
+	})
+
+	t.Run("Redis rate limiter", func(t *testing.T) {
+		globalConf := config.Global()
+		globalConf.EnableRedisRollingLimiter = true
+		config.SetGlobal(globalConf)
+		defer ResetTestConfig()
+
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/api/"
+			spec.SessionIntrospection.Enabled = true
+		})
+		key := CreateSession(func(s *user.SessionState) {
+			s.SetPolicies(pID)
+		})
+		authHeader := map[string]string{"Authorization": key}
+		ts.Run(t, []test.TestCase{
+			{Path: "/api/", Headers: authHeader, Code: http.StatusOK},
+			{Path: "/api/tyk/session", Headers: authHeader, Code: http.StatusOK, BodyMatch: `"remaining":4`},
+		}...)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.UseKeylessAccess = false
+			spec.Proxy.ListenPath = "/api/"
+		})
+		ts.Run(t, test.TestCase{Path: "/api/tyk/session", Headers: authHeader, Code: http.StatusOK, BodyNotMatch: `"access_rights"`})
+	})
+}
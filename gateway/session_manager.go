@@ -139,32 +139,7 @@ func (l *SessionLimiter) ForwardMessage(r *http.Request, currentSession *user.Se
 			}
 		} else {
 			// In-memory limiter
-			if l.bucketStore == nil {
-				l.bucketStore = memorycache.New()
-			}
-
-			// If a token has been updated, we must ensure we don't use
-			// an old bucket an let the cache deal with it
-			bucketKey := ""
-			var currRate float64
-			var per float64
-			if apiLimit == nil {
-				bucketKey = key + ":" + currentSession.LastUpdated
-				currRate = currentSession.Rate
-				per = currentSession.Per
-			} else { // respect limit on API level
-				bucketKey = apiID + ":" + key + ":" + currentSession.LastUpdated
-				currRate = apiLimit.Rate
-				per = apiLimit.Per
-			}
-
-			// DRL will always overflow with more servers on low rates
-			rate := uint(currRate * float64(DRLManager.RequestTokenValue))
-			if rate < uint(DRLManager.CurrentTokenValue) {
-				rate = uint(DRLManager.CurrentTokenValue)
-			}
-
-			userBucket, err := l.bucketStore.Create(bucketKey, rate, time.Duration(per)*time.Second)
+			userBucket, err := l.bucket(currentSession, key, apiID, apiLimit)
 			if err != nil {
 				log.Error("Failed to create bucket!")
 				return sessionFailRateLimit
@@ -198,6 +173,83 @@ func (l *SessionLimiter) ForwardMessage(r *http.Request, currentSession *user.Se
 
 }
 
+// bucket returns the in-memory rate limiter bucket of a session.
+func (l *SessionLimiter) bucket(currentSession *user.SessionState, key, apiID string, apiLimit *user.APILimit) (leakybucket.Bucket, error) {
+	if l.bucketStore == nil {
+		l.bucketStore = memorycache.New()
+	}
+
+	// If a token has been updated, we must ensure we don't use
+	// an old bucket an let the cache deal with it
+	bucketKey := ""
+	var currRate float64
+	var per float64
+	if apiLimit == nil {
+		bucketKey = key + ":" + currentSession.LastUpdated
+		currRate = currentSession.Rate
+		per = currentSession.Per
+	} else { // respect limit on API level
+		bucketKey = apiID + ":" + key + ":" + currentSession.LastUpdated
+		currRate = apiLimit.Rate
+		per = apiLimit.Per
+	}
+
+	// DRL will always overflow with more servers on low rates
+	rate := uint(currRate * float64(DRLManager.RequestTokenValue))
+	if rate < uint(DRLManager.CurrentTokenValue) {
+		rate = uint(DRLManager.CurrentTokenValue)
+	}
+
+	return l.bucketStore.Create(bucketKey, rate, time.Duration(per)*time.Second)
+}
+
+// RateLimitRemaining returns how many more requests a session can make
+// before it's rate limited, and the latest time its full rate is available
+// again, without counting a request. It returns -1 if there is no rate to
+// count against.
+func (l *SessionLimiter) RateLimitRemaining(currentSession *user.SessionState, key string, store storage.Handler, globalConf *config.Config, apiID string) (int64, time.Time) {
+	rate, per := currentSession.Rate, currentSession.Per
+	var apiLimit *user.APILimit
+	if rights, ok := currentSession.AccessRights[apiID]; ok && rights.Limit != nil {
+		apiLimit = rights.Limit
+		rate, per = apiLimit.Rate, apiLimit.Per
+	}
+	if per <= 0 {
+		return -1, time.Time{}
+	}
+	resets := time.Now().Add(time.Duration(per) * time.Second)
+
+	var remaining int64
+	if globalConf.EnableSentinelRateLimiter || globalConf.EnableRedisRollingLimiter {
+		rateLimiterKey := RateLimitKeyPrefix + currentSession.KeyHash()
+		if apiLimit != nil {
+			rateLimiterKey = RateLimitKeyPrefix + apiID + "-" + currentSession.KeyHash()
+		}
+		if globalConf.EnableSentinelRateLimiter {
+			if _, err := store.GetRawKey(rateLimiterKey + ".BLOCKED"); err == nil {
+				return 0, resets
+			}
+		}
+		count, _ := store.GetRollingWindow(rateLimiterKey, int64(per), globalConf.EnableNonTransactionalRateLimiter)
+		remaining = int64(rate) - int64(count)
+	} else {
+		userBucket, err := l.bucket(currentSession, key, apiID, apiLimit)
+		if err != nil || DRLManager.CurrentTokenValue <= 0 {
+			return -1, time.Time{}
+		}
+		remaining = int64(rate)
+		if time.Now().Before(userBucket.Reset()) {
+			remaining = int64(userBucket.Remaining()) / int64(DRLManager.CurrentTokenValue)
+			resets = userBucket.Reset()
+		}
+	}
+
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, resets
+}
+
 func (l *SessionLimiter) RedisQuotaExceeded(r *http.Request, currentSession *user.SessionState, key string, store storage.Handler, apiID string) bool {
 	log.Debug("[QUOTA] Inbound raw key is: ", key)
 
@@ -152,6 +152,10 @@ func ResetTestConfig() {
 }
 
 func emptyRedis() error {
+	if config.Global().Storage.Type == storage.EmbeddedType {
+		// the embedded store starts out empty
+		return nil
+	}
 	addr := config.Global().Storage.Host + ":" + strconv.Itoa(config.Global().Storage.Port)
 	c, err := redis.Dial("tcp", addr)
 	if err != nil {
@@ -0,0 +1,439 @@
+package gateway
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/headers"
+)
+
+var errUpstreamAuth = errorWithReason(ErrorReasonUpstreamAuth, errors.New("Upstream authentication failed"))
+
+var errSignedBodyTooLarge = errorWithReason(ErrorReasonBodyTooLarge, errors.New("Request is too large to sign"))
+
+const (
+	// maxSigV4BodySize is the largest request body read to be signed
+	maxSigV4BodySize = 10 << 20
+	// maxTokenResponseSize is the largest token endpoint response read
+	maxTokenResponseSize = 1 << 20
+)
+
+// upstreamAuthenticator adds credentials to the requests the gateway sends
+// upstream.
+type upstreamAuthenticator interface {
+	authenticate(r *http.Request) error
+}
+
+// newUpstreamAuth returns the authenticator for an API, nil if it has none.
+// An API that can't authenticate fails its requests rather than sending
+// them without credentials.
+func newUpstreamAuth(conf apidef.UpstreamAuth) upstreamAuthenticator {
+	var methods []upstreamAuthenticator
+	var err error
+	add := func(method upstreamAuthenticator, methodErr error) {
+		methods = append(methods, method)
+		if err == nil {
+			err = methodErr
+		}
+	}
+	if conf.SigV4.Enabled {
+		add(newSigV4Signer(conf.SigV4))
+	}
+	if conf.HMAC.Enabled {
+		add(newHMACSigner(conf.HMAC))
+	}
+	if conf.OAuth.Enabled {
+		add(newClientCredentials(conf.OAuth))
+	}
+
+	switch {
+	case len(methods) == 0:
+		return nil
+	case len(methods) > 1:
+		err = errors.New("only one upstream authentication method can be enabled")
+	}
+	if err != nil {
+		return failedUpstreamAuth{err}
+	}
+	return methods[0]
+}
+
+// failedUpstreamAuth is the authenticator of an API whose upstream auth
+// is misconfigured.
+type failedUpstreamAuth struct {
+	err error
+}
+
+func (f failedUpstreamAuth) authenticate(*http.Request) error {
+	return f.err
+}
+
+// secretValues resolves secrets in place, stopping at the first error.
+func secretValues(values ...*string) error {
+	for _, v := range values {
+		s, err := secretValue(*v)
+		if err != nil {
+			return err
+		}
+		*v = s
+	}
+	return nil
+}
+
+// sigV4Now is the signing time, replaced in tests.
+var sigV4Now = time.Now
+
+// sigV4Signer signs requests with AWS Signature Version 4.
+type sigV4Signer struct {
+	conf apidef.UpstreamSigV4
+}
+
+func newSigV4Signer(conf apidef.UpstreamSigV4) (*sigV4Signer, error) {
+	if err := secretValues(&conf.AccessKeyID, &conf.SecretAccessKey, &conf.SessionToken); err != nil {
+		return nil, err
+	}
+	if conf.AccessKeyID == "" || conf.SecretAccessKey == "" {
+		return nil, errors.New("AWS credentials are missing")
+	}
+	if conf.Region == "" || conf.Service == "" {
+		return nil, errors.New("AWS region and service are required")
+	}
+	return &sigV4Signer{conf: conf}, nil
+}
+
+func (s *sigV4Signer) authenticate(r *http.Request) error {
+	var body []byte
+	if r.Body != nil && r.Body != http.NoBody {
+		if r.ContentLength > maxSigV4BodySize {
+			return errSignedBodyTooLarge
+		}
+		var err error
+		if body, err = ioutil.ReadAll(io.LimitReader(r.Body, maxSigV4BodySize+1)); err != nil {
+			return err
+		}
+		if len(body) > maxSigV4BodySize {
+			return errSignedBodyTooLarge
+		}
+		r.Body.Close()
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+	}
+	payloadHash := sha256Hex(body)
+
+	now := sigV4Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	r.Header.Set("X-Amz-Date", amzDate)
+	if s.conf.SessionToken != "" {
+		r.Header.Set("X-Amz-Security-Token", s.conf.SessionToken)
+	}
+	if s.conf.Service == "s3" {
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+	signed := map[string]string{"host": host}
+	for name, values := range r.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			trimmed := make([]string, len(values))
+			for i, v := range values {
+				trimmed[i] = strings.Join(strings.Fields(v), " ")
+			}
+			signed[name] = strings.Join(trimmed, ",")
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	// S3 paths are encoded once, other services sign the path as sent,
+	// encoded again
+	path := r.URL.EscapedPath()
+	if s.conf.Service == "s3" {
+		path = r.URL.Path
+	}
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		sigV4Escape(path, false),
+		sigV4Query(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	date := now.Format("20060102")
+	scope := date + "/" + s.conf.Region + "/" + s.conf.Service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+s.conf.SecretAccessKey), date)
+	key = hmacSHA256(key, s.conf.Region)
+	key = hmacSHA256(key, s.conf.Service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set(headers.Authorization, fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.conf.AccessKeyID, scope, signedHeaders, signature))
+	return nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// sigV4Escape percent-encodes everything but unreserved characters, and
+// slashes unless escapeSlash is set.
+func sigV4Escape(s string, escapeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !escapeSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// sigV4Query is the canonical query string, sorted by encoded key and
+// then by encoded value.
+func sigV4Query(query url.Values) string {
+	var pairs [][2]string
+	for key, values := range query {
+		key = sigV4Escape(key, true)
+		for _, v := range values {
+			pairs = append(pairs, [2]string{key, sigV4Escape(v, true)})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i][0] != pairs[j][0] {
+			return pairs[i][0] < pairs[j][0]
+		}
+		return pairs[i][1] < pairs[j][1]
+	})
+	encoded := make([]string, len(pairs))
+	for i, p := range pairs {
+		encoded[i] = p[0] + "=" + p[1]
+	}
+	return strings.Join(encoded, "&")
+}
+
+// hmacSigner signs requests the way HMACMiddleware checks them.
+type hmacSigner struct {
+	conf apidef.UpstreamHMAC
+}
+
+func newHMACSigner(conf apidef.UpstreamHMAC) (*hmacSigner, error) {
+	if err := secretValues(&conf.KeyID, &conf.Secret); err != nil {
+		return nil, err
+	}
+	if conf.KeyID == "" || conf.Secret == "" {
+		return nil, errors.New("HMAC key ID and secret are required")
+	}
+	if conf.Algorithm == "" {
+		conf.Algorithm = "hmac-sha256"
+	}
+	if len(conf.Headers) == 0 {
+		conf.Headers = []string{"(request-target)", "date"}
+	}
+	return &hmacSigner{conf: conf}, nil
+}
+
+func (h *hmacSigner) authenticate(r *http.Request) error {
+	if r.Header.Get(dateHeaderSpec) == "" && r.Header.Get(altHeaderSpec) == "" {
+		r.Header.Set(dateHeaderSpec, time.Now().UTC().Format(http.TimeFormat))
+	}
+	fieldValues := &HMACFieldValues{
+		KeyID:    h.conf.KeyID,
+		Algorthm: h.conf.Algorithm,
+		Headers:  h.conf.Headers,
+	}
+	signatureString, err := generateHMACSignatureStringFromRequest(r, fieldValues)
+	if err != nil {
+		return err
+	}
+	signature := generateEncodedSignature(signatureString, h.conf.Secret, h.conf.Algorithm)
+
+	r.Header.Set(headers.Authorization, fmt.Sprintf(`Signature keyId="%s",algorithm="%s",headers="%s",signature="%s"`,
+		h.conf.KeyID, h.conf.Algorithm, strings.Join(h.conf.Headers, " "), signature))
+	return nil
+}
+
+// upstreamTokenExpiryDelta is how long before they expire tokens are
+// fetched again, so they don't expire on the way upstream.
+const upstreamTokenExpiryDelta = 10 * time.Second
+
+// upstreamTokenRetryDelay is how long a failed token fetch is returned to
+// requests before the token endpoint is tried again.
+const upstreamTokenRetryDelay = 5 * time.Second
+
+var upstreamTokenClient = &http.Client{Timeout: 10 * time.Second}
+
+// clientCredentials fetches and caches tokens with the OAuth 2.0 client
+// credentials grant. Concurrent requests share a single fetch, and a
+// failed fetch is cached for upstreamTokenRetryDelay.
+type clientCredentials struct {
+	conf  apidef.UpstreamOAuth
+	fetch singleflight.Group
+
+	mu          sync.Mutex
+	accessToken string
+	expiry      time.Time // zero if the token endpoint didn't say
+	err         error
+	retryAt     time.Time
+}
+
+func newClientCredentials(conf apidef.UpstreamOAuth) (*clientCredentials, error) {
+	if err := secretValues(&conf.ClientID, &conf.ClientSecret); err != nil {
+		return nil, err
+	}
+	if conf.TokenURL == "" || conf.ClientID == "" {
+		return nil, errors.New("OAuth token URL and client ID are required")
+	}
+	return &clientCredentials{conf: conf}, nil
+}
+
+func (c *clientCredentials) authenticate(r *http.Request) error {
+	token, err := c.token()
+	if err != nil {
+		return err
+	}
+	r.Header.Set(headers.Authorization, "Bearer "+token)
+	return nil
+}
+
+// expire drops the cached token, after the upstream rejected it.
+func (c *clientCredentials) expire() {
+	c.mu.Lock()
+	c.accessToken = ""
+	c.mu.Unlock()
+}
+
+func (c *clientCredentials) token() (string, error) {
+	c.mu.Lock()
+	token, expiry, err, retryAt := c.accessToken, c.expiry, c.err, c.retryAt
+	c.mu.Unlock()
+	if token != "" && (expiry.IsZero() || time.Now().Before(expiry)) {
+		return token, nil
+	}
+	if err != nil && time.Now().Before(retryAt) {
+		return "", err
+	}
+
+	// the fetch runs without the lock, so that requests with a cached
+	// token don't wait for it
+
+	fetched, err, _ := c.fetch.Do("", func() (interface{}, error) {
+		token, expiry, err := c.fetchToken()
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if err != nil {
+			c.err, c.retryAt = err, time.Now().Add(upstreamTokenRetryDelay)
+			return "", err
+		}
+		c.accessToken, c.expiry, c.err = token, expiry, nil
+		return token, nil
+	})
+	return fetched.(string), err
+}
+
+// fetchToken requests a token from the token endpoint. The expiry is zero
+// if the endpoint didn't say.
+func (c *clientCredentials) fetchToken() (string, time.Time, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(c.conf.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.conf.Scopes, " "))
+	}
+	for k, v := range c.conf.EndpointParams {
+		form.Set(k, v)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTokenClient.Timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, c.conf.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set(headers.ContentType, "application/x-www-form-urlencoded")
+	req.Header.Set(headers.Accept, headers.ApplicationJSON)
+	req.SetBasicAuth(url.QueryEscape(c.conf.ClientID), url.QueryEscape(c.conf.ClientSecret))
+
+	res, err := upstreamTokenClient.Do(req)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(res.Body, maxTokenResponseSize+1))
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if len(body) > maxTokenResponseSize {
+		return "", time.Time{}, errors.New("token endpoint response is too large")
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token endpoint returned %d: %s", res.StatusCode, body)
+	}
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", time.Time{}, err
+	}
+	if token.AccessToken == "" {
+		return "", time.Time{}, errors.New("token endpoint returned no access token")
+	}
+
+	var expiry time.Time
+	if token.ExpiresIn > 0 {
+		lifetime := time.Duration(token.ExpiresIn) * time.Second
+		if lifetime > 2*upstreamTokenExpiryDelta {
+			lifetime -= upstreamTokenExpiryDelta
+		}
+		expiry = time.Now().Add(lifetime)
+	}
+	return token.AccessToken, expiry, nil
+}
@@ -0,0 +1,274 @@
+package gateway
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/apidef"
+	"github.com/ins-tykgw/tyk/config"
+	"github.com/ins-tykgw/tyk/test"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+func TestSigV4Signer(t *testing.T) {
+	defer func() { sigV4Now = time.Now }()
+	sigV4Now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	}
+
+	// the request and credentials of the get-vanilla case in the AWS SigV4
+	// test suite
+	signer, err := newSigV4Signer(apidef.UpstreamSigV4{
+		Region:          "us-east-1",
+		Service:         "service",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodGet, "http://example.amazon.com/", nil)
+	r.Header = http.Header{}
+	if err := signer.authenticate(r); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=7ab4567ae243ee168f6bf18206b2b40b61ce08277323168138fa113ed23c538e"
+	if got := r.Header.Get("Authorization"); got != want {
+		t.Errorf("Wrong Authorization header:\nwant %s\ngot  %s", want, got)
+	}
+	if got := r.Header.Get("X-Amz-Date"); got != "20150830T123600Z" {
+		t.Errorf("Wrong X-Amz-Date header: %s", got)
+	}
+}
+
+func TestSigV4Query(t *testing.T) {
+	defer func() { sigV4Now = time.Now }()
+	sigV4Now = func() time.Time {
+		return time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	}
+	signer, err := newSigV4Signer(apidef.UpstreamSigV4{
+		Region:          "us-east-1",
+		Service:         "service",
+		AccessKeyID:     "AKIDEXAMPLE",
+		SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the query ordering cases of the AWS SigV4 test suite, and a key that
+	// is a prefix of another, signed the same by the AWS SDK
+	tests := []struct {
+		name, query, signature string
+	}{
+		{"get-vanilla-query-order-key-case", "Param2=value2&Param1=value1", "ca0a842792a27475df455b2925aa79d50a98e27d1733a46b57c22810e6b1a7bc"},
+		{"get-vanilla-query-order-value", "Param1=value2&Param1=Value1", "28abbe1f514877c4320393e2a26fd581c1a9d48c3bf06e87986a965890de0b70"},
+		{"get-vanilla-query-order-key", "Param1=value1&Param1=value2", "00f20304c47a6a7407a9d3bedc7ae771551d69902b59ba53638943cc2d1a1640"},
+		{"get-vanilla-query-unreserved", "-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz=-._~0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz", "363c9b49b327e3a9657ad11e74c1830a350b17233293ed874bd0a63406a11cc7"},
+		{"prefix-key", "a-b=1&a=2&a=1", "d3ee586d3db6e092fcf925b59c4612e3d15013ea902848fabf03d629ef84108d"},
+	}
+	for _, tc := range tests {
+		r := httptest.NewRequest(http.MethodGet, "http://example.amazon.com/?"+tc.query, nil)
+		r.Header = http.Header{}
+		if err := signer.authenticate(r); err != nil {
+			t.Fatal(err)
+		}
+		if got := r.Header.Get("Authorization"); !strings.HasSuffix(got, "Signature="+tc.signature) {
+			t.Errorf("%s: wrong Authorization header %s", tc.name, got)
+		}
+	}
+
+	// a key that is a prefix of another sorts first, whatever the values
+	query := url.Values{"a-b": {"1"}, "a": {"2", "1"}}
+	if got, want := sigV4Query(query), "a=1&a=2&a-b=1"; got != want {
+		t.Errorf("want %s, got %s", want, got)
+	}
+}
+
+func TestSigV4SignerCredentials(t *testing.T) {
+	// the credentials of the gateway process are not picked up
+	os.Setenv("AWS_ACCESS_KEY_ID", "AKIDPROCESS")
+	os.Setenv("AWS_SECRET_ACCESS_KEY", "process-secret")
+	defer os.Unsetenv("AWS_ACCESS_KEY_ID")
+	defer os.Unsetenv("AWS_SECRET_ACCESS_KEY")
+
+	for _, conf := range []apidef.UpstreamSigV4{
+		{Region: "us-east-1", Service: "service"},
+		{Region: "us-east-1", Service: "service", AccessKeyID: "AKID"},
+		{Region: "us-east-1", Service: "service", AccessKeyID: "AKID", SecretAccessKey: "env://TYK_SECRET_UNSET"},
+	} {
+		if _, err := newSigV4Signer(conf); err == nil {
+			t.Errorf("%+v: want an error for missing credentials", conf)
+		}
+	}
+}
+
+func TestClientCredentialsLargeResponse(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"access_token":"%s"}`, strings.Repeat("a", maxTokenResponseSize))
+	}))
+	defer tokenServer.Close()
+
+	c, err := newClientCredentials(apidef.UpstreamOAuth{TokenURL: tokenServer.URL, ClientID: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := c.fetchToken(); err == nil || !strings.Contains(err.Error(), "too large") {
+		t.Errorf("Expected the token response to be too large, got %v", err)
+	}
+}
+
+func TestUpstreamAuth(t *testing.T) {
+	globalConf := config.Global()
+	globalConf.Secrets = map[string]string{"hmac": "hmac-secret"}
+	config.SetGlobal(globalConf)
+	defer ResetTestConfig()
+
+	ts := StartTest()
+	defer ts.Close()
+
+	t.Run("HMAC", func(t *testing.T) {
+		key := CreateSession(func(s *user.SessionState) {
+			s.HMACEnabled = true
+			s.HmacSecret = "hmac-secret"
+		})
+
+		// the upstream is an API on the gateway that checks signatures
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.APIID = "signed"
+			spec.UseKeylessAccess = false
+			spec.EnableSignatureChecking = true
+			spec.Auth.AuthHeaderName = "authorization"
+			spec.Proxy.ListenPath = "/signed/"
+		}, func(spec *APISpec) {
+			spec.APIID = "signer"
+			spec.Proxy.ListenPath = "/signer/"
+			spec.Proxy.StripListenPath = true
+			spec.Proxy.TargetURL = ts.URL + "/signed"
+			spec.UpstreamAuth.HMAC = apidef.UpstreamHMAC{
+				Enabled: true,
+				KeyID:   key,
+				Secret:  "secrets://hmac",
+			}
+		})
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/signer/get", Code: http.StatusOK, BodyMatch: `"Authorization":"Signature keyId=\"` + key + `\",algorithm=\"hmac-sha256\"`},
+			{Path: "/signed/get", Code: http.StatusBadRequest},
+		}...)
+	})
+
+	t.Run("SigV4", func(t *testing.T) {
+		os.Setenv("TYK_SECRET_AWS", "secret")
+		defer os.Unsetenv("TYK_SECRET_AWS")
+
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.UpstreamAuth.SigV4 = apidef.UpstreamSigV4{
+				Enabled:         true,
+				Region:          "eu-west-1",
+				Service:         "execute-api",
+				AccessKeyID:     "AKID",
+				SecretAccessKey: "env://TYK_SECRET_AWS",
+			}
+		})
+
+		ts.Run(t, []test.TestCase{
+			{
+				Method: http.MethodPost, Path: "/post", Data: "body", Code: http.StatusOK,
+				BodyMatch: `"Authorization":"AWS4-HMAC-SHA256 Credential=AKID/`,
+			},
+			{Method: http.MethodPost, Path: "/post", Data: strings.Repeat("a", maxSigV4BodySize+1), Code: http.StatusRequestEntityTooLarge},
+		}...)
+	})
+
+	t.Run("OAuth client credentials", func(t *testing.T) {
+		var fetches, failures int32
+		tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			clientID, secret, _ := r.BasicAuth()
+			if clientID != "client" || secret != "client-secret" || r.FormValue("grant_type") != "client_credentials" || r.FormValue("scope") != "read write" {
+				atomic.AddInt32(&failures, 1)
+				http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+				return
+			}
+			n := atomic.AddInt32(&fetches, 1)
+			fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"bearer","expires_in":3600}`, n)
+		}))
+		defer tokenServer.Close()
+
+		upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/reject" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(r.Header.Get("Authorization")))
+		}))
+		defer upstream.Close()
+
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.Proxy.TargetURL = upstream.URL
+			spec.UpstreamAuth.OAuth = apidef.UpstreamOAuth{
+				Enabled:      true,
+				TokenURL:     tokenServer.URL,
+				ClientID:     "client",
+				ClientSecret: "secrets://hmac",
+				Scopes:       []string{"read", "write"},
+			}
+		})
+
+		// the secret doesn't match, the failure is cached for a while
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Code: http.StatusBadGateway, BodyMatch: "Upstream authentication failed"},
+			{Path: "/", Code: http.StatusBadGateway, BodyMatch: "Upstream authentication failed"},
+		}...)
+		if n := atomic.LoadInt32(&failures); n != 1 {
+			t.Errorf("Expected 1 failed token fetch, got %d", n)
+		}
+
+		globalConf.Secrets["client"] = "client-secret"
+		config.SetGlobal(globalConf)
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.Proxy.TargetURL = upstream.URL
+			spec.UpstreamAuth.OAuth = apidef.UpstreamOAuth{
+				Enabled:      true,
+				TokenURL:     tokenServer.URL,
+				ClientID:     "client",
+				ClientSecret: "secrets://client",
+				Scopes:       []string{"read", "write"},
+			}
+		})
+
+		ts.Run(t, []test.TestCase{
+			{Path: "/", Code: http.StatusOK, BodyMatch: "Bearer token-1"},
+			{Path: "/", Code: http.StatusOK, BodyMatch: "Bearer token-1"},
+			{Path: "/reject", Code: http.StatusUnauthorized},
+			{Path: "/", Code: http.StatusOK, BodyMatch: "Bearer token-2"},
+		}...)
+		if n := atomic.LoadInt32(&fetches); n != 2 {
+			t.Errorf("Expected 2 token fetches, got %d", n)
+		}
+	})
+
+	t.Run("Misconfigured", func(t *testing.T) {
+		BuildAndLoadAPI(func(spec *APISpec) {
+			spec.Proxy.ListenPath = "/"
+			spec.UpstreamAuth.HMAC = apidef.UpstreamHMAC{
+				Enabled: true,
+				KeyID:   "key",
+				Secret:  "env://TYK_SECRET_MISSING",
+			}
+		})
+
+		ts.Run(t, test.TestCase{Path: "/", Code: http.StatusBadGateway, BodyMatch: "Upstream authentication failed"})
+	})
+}
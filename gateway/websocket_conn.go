@@ -0,0 +1,460 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+
+	"github.com/ins-tykgw/tyk/request"
+	"github.com/ins-tykgw/tyk/user"
+)
+
+// defaultWSSessionCheckInterval is how often the key of a WebSocket
+// connection is checked again, unless the API sets it.
+const defaultWSSessionCheckInterval = 60 * time.Second
+
+// wsMonitorPeriod is how often open connections are checked for idling
+// and for their key being due another check.
+var wsMonitorPeriod = time.Second
+
+// WebSocket opcodes and close codes, see RFC 6455 sections 5.2 and 7.4.1.
+const (
+	wsOpContinuation = 0x0
+	wsOpClose        = 0x8
+
+	wsCloseGoingAway       = 1001
+	wsClosePolicyViolation = 1008
+	wsCloseMessageTooBig   = 1009
+	wsCloseTryAgainLater   = 1013
+)
+
+var errWSFrameTooLarge = errors.New("websocket frame length out of range")
+
+// wsConn is a proxied WebSocket connection. It applies the API's WebSocket
+// options to the frames passing through and counts messages for analytics.
+type wsConn struct {
+	spec  *APISpec
+	req   *http.Request // the client's upgrade request
+	token string
+
+	// limitMessages counts client messages towards the key's limits,
+	// checkEvery is how often the key is checked again, 0 for never
+	limitMessages bool
+	checkEvery    time.Duration
+
+	client, upstream *wsPeer
+
+	mu          sync.Mutex
+	session     *user.SessionState
+	upgraded    bool
+	start       time.Time
+	end         time.Time
+	closeReason string
+
+	lastActive  int64 // unix nanoseconds, updated atomically
+	messagesIn  int64 // updated atomically
+	messagesOut int64 // updated atomically
+}
+
+func newWSConn(spec *APISpec, r *http.Request) *wsConn {
+	c := &wsConn{
+		spec:    spec,
+		req:     r,
+		token:   ctxGetAuthToken(r),
+		session: ctxGetSession(r),
+	}
+
+	if spec.UseKeylessAccess || c.session == nil || c.token == "" {
+		return c
+	}
+
+	c.limitMessages = spec.WebSocket.RateLimitMessages && ctxCheckLimits(r) &&
+		(!spec.DisableRateLimit || !spec.DisableQuota)
+
+	switch interval := spec.WebSocket.SessionCheckInterval; {
+	case interval == 0:
+		c.checkEvery = defaultWSSessionCheckInterval
+	case interval > 0:
+		c.checkEvery = time.Duration(interval) * time.Second
+	}
+	return c
+}
+
+// wsPeer is one side of a proxied connection. Frames are written to it
+// whole, holding the lock, so that close frames from the gateway never end
+// up in the middle of a proxied one.
+type wsPeer struct {
+	sync.Mutex
+	conn net.Conn
+	// frames sent to an upstream must be masked, as the gateway is its
+	// client
+	mask bool
+}
+
+// writeClose sends a close frame with a status code and reason.
+func (p *wsPeer) writeClose(code int, reason string) error {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	frame := []byte{0x80 | wsOpClose, byte(len(payload))}
+	if p.mask {
+		key := make([]byte, 4)
+		rand.Read(key)
+		frame[1] |= 0x80
+		frame = append(frame, key...)
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	frame = append(frame, payload...)
+
+	p.Lock()
+	defer p.Unlock()
+	_, err := p.conn.Write(frame)
+	return err
+}
+
+// wsFrameHeader is the header of a WebSocket frame.
+type wsFrameHeader struct {
+	fin    bool
+	opcode byte
+	length int64
+	// raw is the header as read, so it can be passed on unchanged
+	raw []byte
+}
+
+func (h wsFrameHeader) isControl() bool {
+	return h.opcode&0x8 != 0
+}
+
+func readWSFrameHeader(r io.Reader) (wsFrameHeader, error) {
+	var h wsFrameHeader
+	buf := make([]byte, 2, 14)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return h, err
+	}
+	h.fin = buf[0]&0x80 != 0
+	h.opcode = buf[0] & 0x0f
+	h.length = int64(buf[1] & 0x7f)
+
+	extra := 0
+	switch h.length {
+	case 126:
+		extra = 2
+	case 127:
+		extra = 8
+	}
+	if buf[1]&0x80 != 0 {
+		// masking key
+		extra += 4
+	}
+	buf = buf[:2+extra]
+	if _, err := io.ReadFull(r, buf[2:]); err != nil {
+		return h, err
+	}
+
+	switch h.length {
+	case 126:
+		h.length = int64(binary.BigEndian.Uint16(buf[2:]))
+	case 127:
+		h.length = int64(binary.BigEndian.Uint64(buf[2:]))
+		if h.length < 0 {
+			return h, errWSFrameTooLarge
+		}
+	}
+	h.raw = buf
+	return h, nil
+}
+
+// readResponseHead reads the upstream response up to the end of its
+// headers, returning the bytes read so they can be passed on unchanged.
+func readResponseHead(r *bufio.Reader, req *http.Request) ([]byte, *http.Response, error) {
+	var head []byte
+	for {
+		line, err := r.ReadSlice('\n')
+		if err != nil {
+			return nil, nil, err
+		}
+		head = append(head, line...)
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break
+		}
+	}
+	res, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(head)), req)
+	return head, res, err
+}
+
+// serve proxies the connection once the upgrade request has been sent
+// upstream. clientR reads from client, and may hold data the client sent
+// already.
+func (c *wsConn) serve(req *http.Request, client net.Conn, clientR io.Reader, upstream net.Conn) error {
+	upstreamR := bufio.NewReader(upstream)
+	head, res, err := readResponseHead(upstreamR, req)
+	if err != nil {
+		return err
+	}
+	if _, err := client.Write(head); err != nil {
+		return err
+	}
+	if res.StatusCode != http.StatusSwitchingProtocols || !strings.EqualFold(res.Header.Get("Upgrade"), "websocket") {
+		// not a WebSocket, such as an event stream
+		return copyStreams(req, client, clientR, upstream, upstreamR)
+	}
+
+	c.client = &wsPeer{conn: client}
+	c.upstream = &wsPeer{conn: upstream, mask: true}
+	c.mu.Lock()
+	c.upgraded = true
+	c.start = time.Now()
+	c.mu.Unlock()
+	c.touch()
+
+	done := make(chan struct{})
+	go c.monitor(done)
+
+	errc := make(chan error, 2)
+	go func() { errc <- c.proxy(c.upstream, clientR, true) }()
+	go func() { errc <- c.proxy(c.client, upstreamR, false) }()
+
+	// once either side is done, so is the connection
+	err = <-errc
+	close(done)
+	client.Close()
+	upstream.Close()
+	<-errc
+
+	c.mu.Lock()
+	c.end = time.Now()
+	c.mu.Unlock()
+
+	if err != nil && err != io.EOF && !c.closedByGateway() {
+		log.WithFields(logrus.Fields{
+			"path":   req.URL.Path,
+			"origin": request.RealIP(req),
+		}).Debug("WebSocket connection ended: ", err)
+	}
+	return nil
+}
+
+// proxy passes frames from src on to dst until either side goes away.
+func (c *wsConn) proxy(dst *wsPeer, src io.Reader, fromClient bool) error {
+	var messageSize int64
+	for {
+		h, err := readWSFrameHeader(src)
+		if err != nil {
+			return err
+		}
+		c.touch()
+
+		switch {
+		case h.opcode == wsOpClose:
+			if fromClient {
+				c.setCloseReason("Closed by client")
+			} else {
+				c.setCloseReason("Closed by upstream")
+			}
+		case h.isControl():
+		case h.opcode != wsOpContinuation:
+			// the first frame of a message
+			messageSize = 0
+			if !fromClient {
+				atomic.AddInt64(&c.messagesOut, 1)
+				break
+			}
+			atomic.AddInt64(&c.messagesIn, 1)
+			if code, reason := c.allowMessage(); code != 0 {
+				c.close(code, reason)
+				return nil
+			}
+		}
+
+		if !h.isControl() {
+			messageSize += h.length
+			if max := c.spec.WebSocket.MaxMessageSize; max > 0 && messageSize > max {
+				c.close(wsCloseMessageTooBig, "Message is too large")
+				return nil
+			}
+		}
+
+		dst.Lock()
+		_, err = dst.conn.Write(h.raw)
+		if err == nil {
+			_, err = io.CopyN(dst.conn, src, h.length)
+		}
+		dst.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// allowMessage counts a client message towards the key's rate limit and
+// quota, returning a close code and reason if it's over either.
+func (c *wsConn) allowMessage() (int, string) {
+	if !c.limitMessages {
+		return 0, ""
+	}
+	c.mu.Lock()
+	session := c.session
+	c.mu.Unlock()
+
+	reason := sessionLimiter.ForwardMessage(
+		c.req,
+		session,
+		c.token,
+		c.spec.SessionManager.Store(),
+		!c.spec.DisableRateLimit,
+		!c.spec.DisableQuota,
+		&c.spec.GlobalConfig,
+		c.spec.APIID,
+		false,
+	)
+
+	k := &RateLimitAndQuotaCheck{BaseMiddleware{Spec: c.spec}}
+	switch reason {
+	case sessionFailNone:
+		return 0, ""
+	case sessionFailRateLimit:
+		err, _ := k.handleRateLimitFailure(c.req, c.token)
+		return wsCloseTryAgainLater, err.Error()
+	case sessionFailQuota:
+		err, _ := k.handleQuotaFailure(c.req, c.token)
+		return wsClosePolicyViolation, err.Error()
+	}
+	return wsClosePolicyViolation, "Access denied"
+}
+
+// checkSession looks the key up again, returning a close code and reason
+// if it's no longer valid. Policy changes apply to the rest of the
+// connection.
+func (c *wsConn) checkSession() (int, string) {
+	base := BaseMiddleware{Spec: c.spec}
+	session, found := base.CheckSessionAndIdentityForValidKey(c.token, c.req)
+	switch {
+	case !found:
+		return wsClosePolicyViolation, "Key not authorised"
+	case session.IsInactive:
+		return wsClosePolicyViolation, "Key is inactive, please renew"
+	case c.spec.AuthManager.KeyExpired(&session):
+		return wsClosePolicyViolation, "Key has expired, please renew"
+	}
+
+	c.mu.Lock()
+	c.session = &session
+	c.mu.Unlock()
+	return 0, ""
+}
+
+// monitor closes the connection once it's idle for too long or its key
+// is no longer valid.
+func (c *wsConn) monitor(done <-chan struct{}) {
+	ticker := time.NewTicker(wsMonitorPeriod)
+	defer ticker.Stop()
+
+	idleTimeout := time.Duration(c.spec.WebSocket.IdleTimeout) * time.Second
+	lastCheck := time.Now()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if idleTimeout > 0 && now.Sub(time.Unix(0, atomic.LoadInt64(&c.lastActive))) >= idleTimeout {
+				c.close(wsCloseGoingAway, "Idle timeout")
+				return
+			}
+			if c.checkEvery > 0 && now.Sub(lastCheck) >= c.checkEvery {
+				lastCheck = now
+				if code, reason := c.checkSession(); code != 0 {
+					c.close(code, reason)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *wsConn) touch() {
+	atomic.StoreInt64(&c.lastActive, time.Now().UnixNano())
+}
+
+// setCloseReason records why the connection closed, unless it's known
+// already.
+func (c *wsConn) setCloseReason(reason string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closeReason != "" {
+		return false
+	}
+	c.closeReason = reason
+	return true
+}
+
+func (c *wsConn) closedByGateway() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	switch c.closeReason {
+	case "", "Closed by client", "Closed by upstream":
+		return false
+	}
+	return true
+}
+
+// close ends the connection, sending both sides a close frame.
+func (c *wsConn) close(code int, reason string) {
+	if !c.setCloseReason(reason) {
+		return
+	}
+	log.WithFields(logrus.Fields{
+		"prefix": "websocket",
+		"path":   c.req.URL.Path,
+		"origin": request.RealIP(c.req),
+		"key":    obfuscateKey(c.token),
+		"api_id": c.spec.APIID,
+	}).Info("Closing WebSocket connection: ", reason)
+
+	// stop frames being passed on, which also makes sure the close
+	// frames aren't written in the middle of one
+	now := time.Now()
+	for _, p := range []*wsPeer{c.client, c.upstream} {
+		p.conn.SetReadDeadline(now)
+		p.conn.SetWriteDeadline(now.Add(time.Second))
+	}
+	c.client.writeClose(code, reason)
+	c.upstream.writeClose(code, reason)
+	c.client.conn.Close()
+	c.upstream.conn.Close()
+}
+
+// record returns the connection's analytics, nil if it was never upgraded.
+func (c *wsConn) record() *WebSocketRecord {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.upgraded {
+		return nil
+	}
+	end := c.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return &WebSocketRecord{
+		MessagesIn:  atomic.LoadInt64(&c.messagesIn),
+		MessagesOut: atomic.LoadInt64(&c.messagesOut),
+		Duration:    int64(end.Sub(c.start) / time.Millisecond),
+		CloseReason: c.closeReason,
+	}
+}
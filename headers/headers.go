@@ -25,6 +25,7 @@ const (
 const (
 	XRealIP             = "X-Real-IP"
 	XForwardFor         = "X-Forwarded-For"
+	Forwarded           = "Forwarded"
 	XAuthResult         = "X-Auth-Result"
 	XSessionAlias       = "X-Session-Alias"
 	XInitialURI         = "X-Initial-URI"
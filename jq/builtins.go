@@ -0,0 +1,692 @@
+package jq
+
+import (
+	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// prelude holds the builtins that are defined in jq itself. A definition
+// can only use the ones before it and the natives.
+const prelude = `
+def not: if . then false else true end;
+def select(f): if f then . else empty end;
+def recurse(f): def r: ., (f | r); r;
+def recurse(f; cond): def r: ., (f | select(cond) | r); r;
+def recurse: recurse(.[]?);
+def map(f): [.[] | f];
+def map_values(f): .[] |= f;
+def range($x): range(0; $x);
+def values: select(. != null);
+def nulls: select(. == null);
+def booleans: select(type == "boolean");
+def numbers: select(type == "number");
+def strings: select(type == "string");
+def arrays: select(type == "array");
+def objects: select(type == "object");
+def iterables: select(type | . == "array" or . == "object");
+def scalars: select(type | . != "array" and . != "object");
+def finites: select(isinfinite or isnan | not);
+def normals: select(isnormal);
+def add(f): reduce f as $x (null; . + $x);
+def add: add(.[]);
+def any: reduce .[] as $x (false; . or $x);
+def all: reduce .[] as $x (true; . and $x);
+def first: .[0];
+def last: .[-1];
+def nth($n): .[$n];
+def first(f): label $__first | (f | ., break $__first);
+def last(f): reduce f as $x (null; $x);
+def any(g; cond): isempty(first(g | cond | select(.))) | not;
+def all(g; cond): isempty(first(g | cond | select(. | not)));
+def any(f): any(.[]; f);
+def all(f): all(.[]; f);
+def nth($n; f): if $n < 0 then error("Out of bounds negative array index") else last(limit($n + 1; f)) end;
+def until(cond; update): def _until: if cond then . else (update | _until) end; _until;
+def while(cond; update): def _while: if cond then ., (update | _while) else empty end; _while;
+def repeat(f): def _repeat: ., (f | _repeat); _repeat;
+def in(xs): . as $x | xs | has($x);
+def inside(xs): . as $x | xs | contains($x);
+def paths: path(..) | select(length > 0);
+def paths(node_filter): . as $dot | paths | select(. as $p | $dot | getpath($p) | node_filter);
+def leaf_paths: paths(scalars);
+def del(f): delpaths([path(f)]);
+def pick(pathexps): . as $top | reduce path(pathexps) as $p (null; setpath($p; $top | getpath($p)));
+def to_entries: [keys_unsorted[] as $k | {key: $k, value: .[$k]}];
+def from_entries: reduce .[] as $x ({};
+	. + {($x | if .key == null then .k // .name // .Name // .K // .Key else .key end
+		| if type == "string" then . else tojson end):
+		($x | if has("value") then .value else .v end)});
+def with_entries(f): to_entries | map(f) | from_entries;
+def walk(f): def w: if type == "object" then map_values(w) elif type == "array" then map(w) else . end | f; w;
+def sort_by(f): _sort_by(map([f]));
+def group_by(f): _group_by(map([f]));
+def unique_by(f): [group_by(f)[] | .[0]];
+def unique: unique_by(.);
+def min_by(f): _min_by(map([f]));
+def max_by(f): _max_by(map([f]));
+def reverse: if type == "string" then explode | _reverse | implode elif . == null then [] else _reverse end;
+def join($x): reduce .[] as $i (null; (if . == null then "" else . + $x end) +
+	($i | if . == null then "" elif type == "string" then . else tojson end)) // "";
+def flatten: flatten(1e9);
+def indices($i): if type == "array" and ($i | type) == "array" then .[$i]
+	elif type == "array" then .[[$i]]
+	elif type == "string" and ($i | type) == "string" then _strindices($i)
+	else .[[$i]] end;
+def index($i): indices($i) | .[0];
+def rindex($i): indices($i) | .[-1:][0];
+def transpose: if . == [] then [] else . as $in | (map(length) | max) as $max
+	| [range(0; $max) as $j | [range(0; $in | length) as $i | $in[$i][$j]]] end;
+def abs: if type == "number" and . < 0 then -. else . end;
+def toarray: if type == "array" then . else [.] end;
+def env: $ENV;
+def todate: strftime("%Y-%m-%dT%H:%M:%SZ");
+def fromdate: strptime("%Y-%m-%dT%H:%M:%SZ") | mktime;
+def todateiso8601: todate;
+def fromdateiso8601: fromdate;
+def dateadd(u; n): . + n;
+def datesub(u; n): . - n;
+def date: todate;
+def match(re; mode): _match(re; mode; false) | .[];
+def match($val): ($val | type) as $vt | if $vt == "string" then match($val; null)
+	elif $vt == "array" and ($val | length) > 1 then match($val[0]; $val[1])
+	elif $vt == "array" and ($val | length) > 0 then match($val[0]; null)
+	else error($vt + " not a string or array") end;
+def test(re; mode): _match(re; mode; true);
+def test($val): ($val | type) as $vt | if $vt == "string" then test($val; null)
+	elif $vt == "array" and ($val | length) > 1 then test($val[0]; $val[1])
+	elif $vt == "array" and ($val | length) > 0 then test($val[0]; null)
+	else error($vt + " not a string or array") end;
+def capture(re; mods): match(re; mods) | [.captures[] | select(.name != null) | {key: .name, value: .string}] | from_entries;
+def capture($val): ($val | type) as $vt | if $vt == "string" then capture($val; null)
+	elif $vt == "array" and ($val | length) > 1 then capture($val[0]; $val[1])
+	elif $vt == "array" and ($val | length) > 0 then capture($val[0]; null)
+	else error($vt + " not a string or array") end;
+def scan(re; $flags): match(re; "g" + $flags) | if (.captures | length) > 0 then [.captures[].string] else .string end;
+def scan(re): scan(re; null);
+def _nwise($n): def n: if length <= $n then . else .[0:$n], (.[$n:] | n) end; n;
+def split($re; flags): . as $s | [match($re; "g" + flags) | (.offset, .offset + .length)] as $ms
+	| [[0] + $ms + [$s | length] | _nwise(2) | $s[.[0]:.[1]]];
+def splits($re; flags): split($re; flags) | .[];
+def splits($re): splits($re; null);
+def sub(re; str): sub(re; str; "");
+def gsub(re; str; flags): sub(re; str; flags + "g");
+def gsub(re; str): sub(re; str; "g");
+def ascii: [.] | implode;
+def IN(s): any(s == .; .);
+def IN(src; s): any(src == s; .);
+def INDEX(stream; idx_expr): reduce stream as $row ({}; .[$row | idx_expr | tostring] |= $row);
+def INDEX(idx_expr): INDEX(.[]; idx_expr);
+def debug: .;
+def debug(msg): .;
+def stderr: .;
+def input_filename: null;
+.`
+
+type native struct {
+	// fn is called with each combination of argument values
+	fn func(it *interp, in interface{}, args []interface{}) (interface{}, error)
+	// gen is used by natives that take filters or yield several outputs
+	gen func(it *interp, e *env, in item, args []*node, yield func(item) error) error
+}
+
+func nativeKey(name string, arity int) string {
+	return name + "/" + strconv.Itoa(arity)
+}
+
+var natives map[string]*native
+
+func init() {
+	natives = map[string]*native{
+		"empty/0": {gen: func(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+			return nil
+		}},
+		"error/0": {gen: func(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+			return &valueError{value: in.v}
+		}},
+		"error/1": {gen: func(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+			return it.eval(args[0], e, item{v: in.v}, func(x item) error {
+				return &valueError{value: x.v}
+			})
+		}},
+		"path/1": {gen: func(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+			return it.eval(args[0], e, item{v: in.v, path: rootPath}, func(x item) error {
+				return it.value(in, x.path.slice(), yield)
+			})
+		}},
+		"getpath/1": {gen: genGetpath},
+		"range/2":   {gen: genRange},
+		"range/3":   {gen: genRange},
+		"limit/2":   {gen: genLimit},
+		"isempty/1": {gen: func(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+			empty := true
+			stop := &breakError{label: new(int)}
+			err := it.eval(args[0], e, item{v: in.v}, func(item) error {
+				empty = false
+				return stop
+			})
+			if err != nil && err != stop {
+				return err
+			}
+			return it.value(in, empty, yield)
+		}},
+		"sub/3": {gen: genSub},
+
+		"length/0":         {fn: func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) { return length(in) }},
+		"utf8bytelength/0": {fn: fnUTF8ByteLength},
+		"keys/0":           {fn: func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) { return keys(in) }},
+		"keys_unsorted/0":  {fn: func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) { return keys(in) }},
+		"has/1":            {fn: func(_ *interp, in interface{}, args []interface{}) (interface{}, error) { return has(in, args[0]) }},
+		"contains/1":       {fn: func(_ *interp, in interface{}, args []interface{}) (interface{}, error) { return contains(in, args[0]) }},
+		"setpath/2":        {fn: fnSetpath},
+		"delpaths/1":       {fn: fnDelpaths},
+		"type/0":           {fn: func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) { return typeName(in), nil }},
+		"tostring/0":       {fn: func(it *interp, in interface{}, _ []interface{}) (interface{}, error) { return it.tostring(in) }},
+		"tonumber/0":       {fn: fnToNumber},
+		"tojson/0":         {fn: func(it *interp, in interface{}, _ []interface{}) (interface{}, error) { return it.toJSON(in) }},
+		"fromjson/0":       {fn: fnFromJSON},
+		"infinite/0":       {fn: func(*interp, interface{}, []interface{}) (interface{}, error) { return math.Inf(1), nil }},
+		"nan/0":            {fn: func(*interp, interface{}, []interface{}) (interface{}, error) { return math.NaN(), nil }},
+		"isinfinite/0":     {fn: numberPredicate(func(f float64) bool { return math.IsInf(f, 0) })},
+		"isnan/0":          {fn: numberPredicate(math.IsNaN)},
+		"isnormal/0":       {fn: numberPredicate(isNormal)},
+		"sort/0":           {fn: fnSort},
+		"_sort_by/1":       {fn: fnSortBy},
+		"_group_by/1":      {fn: fnGroupBy},
+		"_min_by/1": {fn: func(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+			return extremeBy(in, args[0], -1)
+		}},
+		"_max_by/1": {fn: func(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+			return extremeBy(in, args[0], 1)
+		}},
+		"min/0":            {fn: func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) { return extremeBy(in, in, -1) }},
+		"max/0":            {fn: func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) { return extremeBy(in, in, 1) }},
+		"_reverse/0":       {fn: fnReverse},
+		"flatten/1":        {fn: fnFlatten},
+		"_strindices/1":    {fn: fnStrIndices},
+		"explode/0":        {fn: fnExplode},
+		"implode/0":        {fn: fnImplode},
+		"split/1":          {fn: fnSplit},
+		"ltrimstr/1":       {fn: fnTrimStr(strings.TrimPrefix)},
+		"rtrimstr/1":       {fn: fnTrimStr(strings.TrimSuffix)},
+		"startswith/1":     {fn: fnStringTest("startswith", strings.HasPrefix)},
+		"endswith/1":       {fn: fnStringTest("endswith", strings.HasSuffix)},
+		"ascii_downcase/0": {fn: fnStringMap("ascii_downcase", asciiCase('A', 'Z', 'a'-'A'))},
+		"ascii_upcase/0":   {fn: fnStringMap("ascii_upcase", asciiCase('a', 'z', 'A'-'a'))},
+		"trim/0":           {fn: fnStringMap("trim", func(s string) string { return strings.TrimSpace(s) })},
+		"ltrim/0":          {fn: fnStringMap("ltrim", func(s string) string { return strings.TrimLeft(s, " \t\n\r\f\v") })},
+		"rtrim/0":          {fn: fnStringMap("rtrim", func(s string) string { return strings.TrimRight(s, " \t\n\r\f\v") })},
+		"_match/3":         {fn: fnMatch},
+		"format/1":         {fn: fnFormat},
+		"now/0":            {fn: fnNow},
+		"mktime/0":         {fn: fnMktime},
+		"gmtime/0":         {fn: fnGmtime},
+		"localtime/0":      {fn: fnGmtime},
+		"strftime/1":       {fn: fnStrftime},
+		"strptime/1":       {fn: fnStrptime},
+		"pow/2":            {fn: fnPow},
+		"log/0":            {fn: mathFunc(math.Log)},
+		"log10/0":          {fn: mathFunc(math.Log10)},
+		"log2/0":           {fn: mathFunc(math.Log2)},
+		"exp/0":            {fn: mathFunc(math.Exp)},
+		"exp2/0":           {fn: mathFunc(math.Exp2)},
+		"exp10/0":          {fn: mathFunc(func(f float64) float64 { return math.Pow(10, f) })},
+		"floor/0":          {fn: mathFunc(math.Floor)},
+		"ceil/0":           {fn: mathFunc(math.Ceil)},
+		"round/0":          {fn: mathFunc(math.Round)},
+		"trunc/0":          {fn: mathFunc(math.Trunc)},
+		"sqrt/0":           {fn: mathFunc(math.Sqrt)},
+		"fabs/0":           {fn: mathFunc(math.Abs)},
+	}
+	loadPrelude()
+}
+
+func genGetpath(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+	return it.eval(args[0], e, item{v: in.v}, func(x item) error {
+		p, ok := x.v.([]interface{})
+		if !ok {
+			return errorf("Path must be specified as an array")
+		}
+		v, err := getpath(in.v, p)
+		if err != nil {
+			return err
+		}
+		if in.path == nil {
+			return yield(item{v: v})
+		}
+		full := in.path
+		for _, key := range p {
+			full = full.extend(key)
+		}
+		return yield(item{v: v, path: full})
+	})
+}
+
+func genRange(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+	step := &node{kind: nLiteral, value: float64(1)}
+	if len(args) == 3 {
+		step = args[2]
+	}
+	return it.eval(args[0], e, item{v: in.v}, func(from item) error {
+		return it.eval(args[1], e, item{v: in.v}, func(upto item) error {
+			return it.eval(step, e, item{v: in.v}, func(by item) error {
+				f, ok1 := from.v.(float64)
+				u, ok2 := upto.v.(float64)
+				s, ok3 := by.v.(float64)
+				if !ok1 || !ok2 || !ok3 {
+					return errorf("Range bounds must be numeric")
+				}
+				switch {
+				case s > 0:
+					for x := f; x < u; x += s {
+						if err := it.tick(); err != nil {
+							return err
+						}
+						if err := it.value(in, x, yield); err != nil {
+							return err
+						}
+					}
+				case s < 0:
+					for x := f; x > u; x += s {
+						if err := it.tick(); err != nil {
+							return err
+						}
+						if err := it.value(in, x, yield); err != nil {
+							return err
+						}
+					}
+				case f < u:
+					// jq loops forever on a zero step, stop at the time limit
+					for {
+						if err := it.tick(); err != nil {
+							return err
+						}
+						if err := it.value(in, f, yield); err != nil {
+							return err
+						}
+					}
+				}
+				return nil
+			})
+		})
+	})
+}
+
+func genLimit(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+	return it.eval(args[0], e, item{v: in.v}, func(n item) error {
+		limit, ok := n.v.(float64)
+		if !ok {
+			return errorf("Invalid limit %s: must be a number", toJSON(n.v))
+		}
+		if limit <= 0 {
+			return nil
+		}
+		count := 0
+		stop := &breakError{label: new(int)}
+		err := it.eval(args[1], e, in, func(x item) error {
+			if err := it.tick(); err != nil {
+				return err
+			}
+			count++
+			if err := yield(x); err != nil {
+				return err
+			}
+			if float64(count) >= limit {
+				return stop
+			}
+			return nil
+		})
+		if err == stop {
+			return nil
+		}
+		return err
+	})
+}
+
+func fnUTF8ByteLength(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	s, ok := in.(string)
+	if !ok {
+		return nil, errorf("%s only strings have UTF-8 byte length", describeValue(in))
+	}
+	return float64(len(s)), nil
+}
+
+func fnSetpath(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+	p, ok := args[0].([]interface{})
+	if !ok {
+		return nil, errorf("Path must be specified as an array")
+	}
+	return setpath(in, p, args[1])
+}
+
+func fnDelpaths(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+	paths, ok := args[0].([]interface{})
+	if !ok {
+		return nil, errorf("Paths must be specified as an array")
+	}
+	return delpaths(in, paths)
+}
+
+func fnToNumber(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	switch x := in.(type) {
+	case float64:
+		return x, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(x), 64)
+		if err != nil {
+			return nil, errorf("Cannot parse %s as a number", strconv.Quote(x))
+		}
+		return f, nil
+	}
+	return nil, errorf("%s cannot be parsed as a number", describeValue(in))
+}
+
+func fnFromJSON(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	s, ok := in.(string)
+	if !ok {
+		return nil, errorf("%s cannot be parsed as JSON", describeValue(in))
+	}
+	if strings.TrimSpace(s) == "nan" {
+		return math.NaN(), nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return nil, errorf("%s (while parsing '%s')", err, s)
+	}
+	return v, nil
+}
+
+func numberPredicate(pred func(float64) bool) func(*interp, interface{}, []interface{}) (interface{}, error) {
+	return func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+		f, ok := in.(float64)
+		if !ok {
+			return nil, errorf("%s number required", describeValue(in))
+		}
+		return pred(f), nil
+	}
+}
+
+func isNormal(f float64) bool {
+	return !math.IsNaN(f) && !math.IsInf(f, 0) && f != 0 && math.Abs(f) >= 2.2250738585072014e-308
+}
+
+func mathFunc(op func(float64) float64) func(*interp, interface{}, []interface{}) (interface{}, error) {
+	return func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+		f, ok := in.(float64)
+		if !ok {
+			return nil, errorf("%s number required", describeValue(in))
+		}
+		return op(f), nil
+	}
+}
+
+func fnPow(_ *interp, _ interface{}, args []interface{}) (interface{}, error) {
+	x, ok1 := args[0].(float64)
+	y, ok2 := args[1].(float64)
+	if !ok1 || !ok2 {
+		return nil, errorf("pow requires numbers")
+	}
+	return math.Pow(x, y), nil
+}
+
+func arrayInput(name string, in interface{}) ([]interface{}, error) {
+	arr, ok := in.([]interface{})
+	if !ok {
+		return nil, errorf("%s cannot be %s, as it is not an array", describeValue(in), name)
+	}
+	return arr, nil
+}
+
+func fnSort(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	arr, err := arrayInput("sorted", in)
+	if err != nil {
+		return nil, err
+	}
+	return sortValues(arr), nil
+}
+
+// sortedByKeys returns the indexes of arr ordered by the matching keys.
+func sortedByKeys(name string, in, keysValue interface{}) ([]interface{}, []interface{}, []int, error) {
+	arr, err := arrayInput(name, in)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	keys, _ := keysValue.([]interface{})
+	order := make([]int, len(arr))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return compare(keys[order[i]], keys[order[j]]) < 0
+	})
+	return arr, keys, order, nil
+}
+
+func fnSortBy(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+	arr, _, order, err := sortedByKeys("sorted", in, args[0])
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(arr))
+	for i, j := range order {
+		out[i] = arr[j]
+	}
+	return out, nil
+}
+
+func fnGroupBy(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+	arr, keys, order, err := sortedByKeys("grouped", in, args[0])
+	if err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	var group []interface{}
+	for i, j := range order {
+		if i > 0 && compare(keys[order[i-1]], keys[j]) != 0 {
+			out = append(out, group)
+			group = nil
+		}
+		group = append(group, arr[j])
+	}
+	if group != nil {
+		out = append(out, group)
+	}
+	return out, nil
+}
+
+// extremeBy returns the element of in with the smallest (sign -1) or
+// largest (sign 1) key, or null for an empty array.
+func extremeBy(in, keysValue interface{}, sign int) (interface{}, error) {
+	arr, err := arrayInput("compared", in)
+	if err != nil {
+		return nil, err
+	}
+	keys, _ := keysValue.([]interface{})
+	best := -1
+	for i := range arr {
+		if best < 0 {
+			best = i
+			continue
+		}
+		c := compare(keys[i], keys[best])
+		if sign < 0 && c < 0 || sign > 0 && c >= 0 {
+			best = i
+		}
+	}
+	if best < 0 {
+		return nil, nil
+	}
+	return arr[best], nil
+}
+
+func fnReverse(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	arr, err := arrayInput("reversed", in)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]interface{}, len(arr))
+	for i, v := range arr {
+		out[len(arr)-1-i] = v
+	}
+	return out, nil
+}
+
+func fnFlatten(it *interp, in interface{}, args []interface{}) (interface{}, error) {
+	arr, err := arrayInput("flattened", in)
+	if err != nil {
+		return nil, err
+	}
+	depth, ok := args[0].(float64)
+	if !ok || depth < 0 {
+		return nil, errorf("flatten depth must not be negative")
+	}
+	return it.flatten([]interface{}{}, arr, depth)
+}
+
+// flatten appends the elements of arr to out, those of nested arrays up to
+// depth levels down. Arrays sharing elements can flatten to far more than
+// they hold, so the length of out is checked as it grows.
+func (it *interp) flatten(out, arr []interface{}, depth float64) ([]interface{}, error) {
+	for _, v := range arr {
+		if err := it.tick(); err != nil {
+			return nil, err
+		}
+		if inner, ok := v.([]interface{}); ok && depth > 0 {
+			var err error
+			if out, err = it.flatten(out, inner, depth-1); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if len(out) >= maxArrayLen {
+			return nil, ErrTooLarge
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+func fnStrIndices(it *interp, in interface{}, args []interface{}) (interface{}, error) {
+	s, _ := in.(string)
+	sub, _ := args[0].(string)
+	out := []interface{}{}
+	if sub == "" {
+		return nil, nil
+	}
+	// jq reports byte offsets here
+	for i := 0; i+len(sub) <= len(s); i++ {
+		if err := it.tick(); err != nil {
+			return nil, err
+		}
+		if s[i:i+len(sub)] == sub {
+			out = append(out, float64(i))
+		}
+	}
+	return out, nil
+}
+
+func fnExplode(it *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	s, ok := in.(string)
+	if !ok {
+		return nil, errorf("%s cannot be exploded, as it is not a string", describeValue(in))
+	}
+	if err := checkLen(utf8.RuneCountInString(s), maxArrayLen); err != nil {
+		return nil, err
+	}
+	out := []interface{}{}
+	for _, r := range s {
+		if err := it.tick(); err != nil {
+			return nil, err
+		}
+		out = append(out, float64(r))
+	}
+	return out, nil
+}
+
+func fnImplode(it *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	arr, ok := in.([]interface{})
+	if !ok {
+		return nil, errorf("%s cannot be imploded, as it is not an array", describeValue(in))
+	}
+	// arrays are capped so that this can't pass maxStringLen
+	var b strings.Builder
+	for _, v := range arr {
+		if err := it.tick(); err != nil {
+			return nil, err
+		}
+		f, ok := v.(float64)
+		if !ok {
+			return nil, errorf("Unicode codepoint must be numeric")
+		}
+		r := rune(f)
+		if !utf8.ValidRune(r) {
+			r = utf8.RuneError
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), nil
+}
+
+func fnSplit(it *interp, in interface{}, args []interface{}) (interface{}, error) {
+	s, ok1 := in.(string)
+	sep, ok2 := args[0].(string)
+	if !ok1 || !ok2 {
+		return nil, errorf("split input and separator must be strings")
+	}
+	return it.splitString(s, sep)
+}
+
+func fnTrimStr(trim func(string, string) string) func(*interp, interface{}, []interface{}) (interface{}, error) {
+	return func(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+		s, ok1 := in.(string)
+		affix, ok2 := args[0].(string)
+		if !ok1 || !ok2 {
+			return in, nil
+		}
+		return trim(s, affix), nil
+	}
+}
+
+func fnStringTest(name string, test func(string, string) bool) func(*interp, interface{}, []interface{}) (interface{}, error) {
+	return func(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+		s, ok1 := in.(string)
+		affix, ok2 := args[0].(string)
+		if !ok1 || !ok2 {
+			return nil, errorf("%s() requires string inputs", name)
+		}
+		return test(s, affix), nil
+	}
+}
+
+func fnStringMap(name string, op func(string) string) func(*interp, interface{}, []interface{}) (interface{}, error) {
+	return func(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+		s, ok := in.(string)
+		if !ok {
+			return nil, errorf("%s input must be a string", name)
+		}
+		return op(s), nil
+	}
+}
+
+func asciiCase(from, to byte, delta int) func(string) string {
+	return func(s string) string {
+		b := []byte(s)
+		for i, c := range b {
+			if c >= from && c <= to {
+				b[i] = byte(int(c) + delta)
+			}
+		}
+		return string(b)
+	}
+}
+
+func fnFormat(it *interp, in interface{}, args []interface{}) (interface{}, error) {
+	name, ok := args[0].(string)
+	if !ok {
+		return nil, errorf("%s is not a valid format", describeValue(args[0]))
+	}
+	return it.format(name, in)
+}
@@ -0,0 +1,714 @@
+package jq
+
+import (
+	"context"
+	"fmt"
+)
+
+// maxDepth limits the nesting of function calls, to stop runaway
+// recursion before it exhausts the stack.
+const maxDepth = 4096
+
+// maxStringLen and maxArrayLen cap the values a filter can build, so that
+// a filter like "x" * 1e10 fails before allocating rather than exhausting
+// the memory of the process. Objects are capped at maxArrayLen entries.
+const (
+	maxStringLen = 64 << 20
+	maxArrayLen  = 8 << 20
+)
+
+// valueError is a runtime error raised by a filter. Unlike timeouts and
+// other errors, it can be caught with try.
+type valueError struct {
+	value interface{}
+}
+
+func (e *valueError) Error() string {
+	if s, ok := e.value.(string); ok {
+		return s
+	}
+	return toJSON(e.value) + " (not a string)"
+}
+
+func errorf(format string, args ...interface{}) error {
+	return &valueError{value: fmt.Sprintf(format, args...)}
+}
+
+// downstreamError wraps errors returned by the consumer of a try body's
+// outputs, so that try only catches errors raised by the body itself.
+type downstreamError struct {
+	err error
+	id  *int
+}
+
+func (e *downstreamError) Error() string {
+	return e.err.Error()
+}
+
+type breakError struct {
+	label *int
+}
+
+func (e *breakError) Error() string {
+	return "break"
+}
+
+// item is a value flowing through a filter. path is only set when the
+// paths of values are being tracked, as for path(f) and assignments.
+type item struct {
+	v    interface{}
+	path *path
+}
+
+type path struct {
+	parent *path
+	key    interface{}
+}
+
+var rootPath = &path{}
+
+func (p *path) extend(key interface{}) *path {
+	return &path{parent: p, key: key}
+}
+
+func (p *path) slice() []interface{} {
+	n := 0
+	for q := p; q != rootPath; q = q.parent {
+		n++
+	}
+	out := make([]interface{}, n)
+	for q := p; q != rootPath; q = q.parent {
+		n--
+		out[n] = q.key
+	}
+	return out
+}
+
+// env is a linked list of scopes, each holding a single variable,
+// function, filter parameter or label.
+type env struct {
+	parent *env
+
+	variable string
+	value    interface{}
+
+	fn *funcDef
+
+	param   string
+	closure *closure
+
+	label   string
+	labelID *int
+}
+
+type closure struct {
+	n *node
+	e *env
+}
+
+func (e *env) bindVar(name string, value interface{}) *env {
+	return &env{parent: e, variable: name, value: value}
+}
+
+func (e *env) lookupVar(name string) (interface{}, bool) {
+	for s := e; s != nil; s = s.parent {
+		if s.variable == name {
+			return s.value, true
+		}
+	}
+	return nil, false
+}
+
+// lookupFunc finds the innermost function or filter parameter with the
+// name and arity, returning the scope it was found in.
+func (e *env) lookupFunc(name string, arity int) (*env, bool) {
+	for s := e; s != nil; s = s.parent {
+		if s.fn != nil && s.fn.name == name && len(s.fn.params) == arity {
+			return s, true
+		}
+		if s.closure != nil && arity == 0 && s.param == name {
+			return s, true
+		}
+	}
+	return nil, false
+}
+
+type interp struct {
+	ctx   context.Context
+	steps int
+	depth int
+}
+
+var recurseCall = &node{kind: nCall, name: "recurse"}
+
+// value yields a computed value, which has no path.
+func (it *interp) value(in item, v interface{}, yield func(item) error) error {
+	if in.path != nil {
+		return errorf("Invalid path expression with result %s", preview(v))
+	}
+	return yield(item{v: v})
+}
+
+// tick counts a step and checks ctx every so often. Loops in natives
+// call it too, as they can run without going back through eval.
+func (it *interp) tick() error {
+	it.steps++
+	if it.steps&255 == 0 {
+		return it.ctx.Err()
+	}
+	return nil
+}
+
+func (it *interp) eval(n *node, e *env, in item, yield func(item) error) error {
+	if err := it.tick(); err != nil {
+		return err
+	}
+
+	switch n.kind {
+	case nIdentity:
+		return yield(in)
+
+	case nRecurse:
+		return it.eval(recurseCall, e, in, yield)
+
+	case nLiteral:
+		return it.value(in, n.value, yield)
+
+	case nString:
+		return it.evalString(n, e, in, 0, "", yield)
+
+	case nFormat:
+		s, err := it.format(n.name, in.v)
+		if err != nil {
+			return err
+		}
+		return it.value(in, s, yield)
+
+	case nIndex:
+		return it.eval(n.left, e, in, func(l item) error {
+			return it.eval(n.right, e, item{v: in.v}, func(k item) error {
+				v, err := index(l.v, k.v)
+				if err != nil {
+					return err
+				}
+				if l.path != nil {
+					return yield(item{v: v, path: l.path.extend(k.v)})
+				}
+				return yield(item{v: v})
+			})
+		})
+
+	case nSlice:
+		return it.eval(n.left, e, in, func(l item) error {
+			return it.evalOptional(n.args[1], e, item{v: in.v}, func(to interface{}) error {
+				return it.evalOptional(n.args[0], e, item{v: in.v}, func(from interface{}) error {
+					v, err := slice(l.v, from, to)
+					if err != nil {
+						return err
+					}
+					if l.path != nil {
+						key := map[string]interface{}{"start": from, "end": to}
+						return yield(item{v: v, path: l.path.extend(key)})
+					}
+					return yield(item{v: v})
+				})
+			})
+		})
+
+	case nIterate:
+		return it.eval(n.left, e, in, func(l item) error {
+			return iterate(l, yield)
+		})
+
+	case nPipe:
+		return it.eval(n.left, e, in, func(l item) error {
+			return it.eval(n.right, e, l, yield)
+		})
+
+	case nComma:
+		if err := it.eval(n.left, e, in, yield); err != nil {
+			return err
+		}
+		return it.eval(n.right, e, in, yield)
+
+	case nArith, nCompare:
+		return it.eval(n.right, e, item{v: in.v}, func(r item) error {
+			return it.eval(n.left, e, item{v: in.v}, func(l item) error {
+				if n.kind == nCompare {
+					return it.value(in, compareOp(n.op, l.v, r.v), yield)
+				}
+				v, err := it.arith(n.op, l.v, r.v)
+				if err != nil {
+					return err
+				}
+				return it.value(in, v, yield)
+			})
+		})
+
+	case nAnd, nOr:
+		return it.eval(n.left, e, item{v: in.v}, func(l item) error {
+			if n.kind == nAnd && !truthy(l.v) {
+				return it.value(in, false, yield)
+			}
+			if n.kind == nOr && truthy(l.v) {
+				return it.value(in, true, yield)
+			}
+			return it.eval(n.right, e, item{v: in.v}, func(r item) error {
+				return it.value(in, truthy(r.v), yield)
+			})
+		})
+
+	case nAlternative:
+		any := false
+		err := it.try(func(yield func(item) error) error {
+			return it.eval(n.left, e, in, yield)
+		}, func(l item) error {
+			if !truthy(l.v) {
+				return nil
+			}
+			any = true
+			return yield(l)
+		})
+		if _, ok := err.(*valueError); ok {
+			err = nil
+		}
+		if err != nil || any {
+			return err
+		}
+		return it.eval(n.right, e, in, yield)
+
+	case nAssign:
+		return it.evalAssign(n, e, in, yield)
+
+	case nNeg:
+		return it.eval(n.left, e, item{v: in.v}, func(l item) error {
+			f, ok := l.v.(float64)
+			if !ok {
+				return errorf("%s (%s) cannot be negated", typeName(l.v), preview(l.v))
+			}
+			return it.value(in, -f, yield)
+		})
+
+	case nArray:
+		out := []interface{}{}
+		if n.left != nil {
+			err := it.eval(n.left, e, item{v: in.v}, func(x item) error {
+				if len(out) >= maxArrayLen {
+					return ErrTooLarge
+				}
+				out = append(out, x.v)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return it.value(in, out, yield)
+
+	case nObject:
+		return it.evalObject(n.entries, e, in, map[string]interface{}{}, yield)
+
+	case nVariable:
+		v, ok := e.lookupVar(n.name)
+		if !ok {
+			if n.name == "ENV" {
+				// the environment of the gateway is not exposed
+				v, ok = map[string]interface{}{}, true
+			} else {
+				return fmt.Errorf("jq: $%s is not defined", n.name)
+			}
+		}
+		return it.value(in, v, yield)
+
+	case nCall:
+		return it.call(n, e, in, yield)
+
+	case nIf:
+		return it.eval(n.left, e, item{v: in.v}, func(c item) error {
+			if truthy(c.v) {
+				return it.eval(n.right, e, in, yield)
+			}
+			if len(n.args) > 0 {
+				return it.eval(n.args[0], e, in, yield)
+			}
+			return yield(in)
+		})
+
+	case nTry:
+		err := it.try(func(yield func(item) error) error {
+			return it.eval(n.left, e, in, yield)
+		}, yield)
+		verr, ok := err.(*valueError)
+		if !ok {
+			return err
+		}
+		if n.right == nil {
+			return nil
+		}
+		return it.eval(n.right, e, item{v: verr.value}, yield)
+
+	case nReduce:
+		return it.eval(n.args[0], e, in, func(acc item) error {
+			err := it.eval(n.left, e, item{v: in.v}, func(x item) error {
+				return it.bindPattern(n.pattern, e, x.v, func(scope *env) error {
+					next := item{}
+					err := it.eval(n.args[1], scope, acc, func(u item) error {
+						next = u
+						return nil
+					})
+					acc = next
+					return err
+				})
+			})
+			if err != nil {
+				return err
+			}
+			return yield(acc)
+		})
+
+	case nForeach:
+		return it.eval(n.args[0], e, in, func(acc item) error {
+			return it.eval(n.left, e, item{v: in.v}, func(x item) error {
+				return it.bindPattern(n.pattern, e, x.v, func(scope *env) error {
+					return it.eval(n.args[1], scope, acc, func(u item) error {
+						acc = u
+						if len(n.args) == 3 {
+							return it.eval(n.args[2], scope, u, yield)
+						}
+						return yield(u)
+					})
+				})
+			})
+		})
+
+	case nBind:
+		return it.eval(n.left, e, item{v: in.v}, func(x item) error {
+			return it.bindPattern(n.pattern, e, x.v, func(scope *env) error {
+				return it.eval(n.right, scope, in, yield)
+			})
+		})
+
+	case nFuncDef:
+		return it.eval(n.right, &env{parent: e, fn: n.fn}, in, yield)
+
+	case nLabel:
+		id := new(int)
+		err := it.eval(n.right, &env{parent: e, label: n.name, labelID: id}, in, yield)
+		if b, ok := err.(*breakError); ok && b.label == id {
+			return nil
+		}
+		return err
+
+	case nBreak:
+		for s := e; s != nil; s = s.parent {
+			if s.labelID != nil && s.label == n.name {
+				return &breakError{label: s.labelID}
+			}
+		}
+		return fmt.Errorf("jq: $*label-%s is not defined", n.name)
+	}
+	return fmt.Errorf("jq: unknown expression %d", n.kind)
+}
+
+// try runs body, marking errors from yield so they can be told apart
+// from errors raised by body.
+func (it *interp) try(body func(func(item) error) error, yield func(item) error) error {
+	id := new(int)
+	err := body(func(x item) error {
+		if err := yield(x); err != nil {
+			return &downstreamError{err: err, id: id}
+		}
+		return nil
+	})
+	if d, ok := err.(*downstreamError); ok && d.id == id {
+		return d.err
+	}
+	return err
+}
+
+func iterate(l item, yield func(item) error) error {
+	switch x := l.v.(type) {
+	case []interface{}:
+		for i, v := range x {
+			next := item{v: v}
+			if l.path != nil {
+				next.path = l.path.extend(float64(i))
+			}
+			if err := yield(next); err != nil {
+				return err
+			}
+		}
+		return nil
+	case map[string]interface{}:
+		for _, k := range sortedKeys(x) {
+			next := item{v: x[k]}
+			if l.path != nil {
+				next.path = l.path.extend(k)
+			}
+			if err := yield(next); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return errorf("Cannot iterate over %s", describeValue(l.v))
+}
+
+func describeValue(v interface{}) string {
+	if v == nil {
+		return "null"
+	}
+	return fmt.Sprintf("%s (%s)", typeName(v), preview(v))
+}
+
+// evalOptional evaluates n, or yields null if it's nil, as for the
+// missing bounds of a slice.
+func (it *interp) evalOptional(n *node, e *env, in item, yield func(interface{}) error) error {
+	if n == nil {
+		return yield(nil)
+	}
+	return it.eval(n, e, in, func(x item) error {
+		return yield(x.v)
+	})
+}
+
+func (it *interp) evalString(n *node, e *env, in item, i int, prefix string, yield func(item) error) error {
+	if i == len(n.parts) {
+		return it.value(in, prefix, yield)
+	}
+	part := n.parts[i]
+	if part.kind == nLiteral {
+		return it.evalString(n, e, in, i+1, prefix+part.value.(string), yield)
+	}
+	return it.eval(part, e, item{v: in.v}, func(x item) error {
+		var s string
+		var err error
+		if n.name != "" {
+			s, err = it.format(n.name, x.v)
+		} else {
+			s, err = it.tostring(x.v)
+		}
+		if err != nil {
+			return err
+		}
+		if len(prefix)+len(s) > maxStringLen {
+			return ErrTooLarge
+		}
+		return it.evalString(n, e, in, i+1, prefix+s, yield)
+	})
+}
+
+func (it *interp) evalObject(entries []objectEntry, e *env, in item, obj map[string]interface{}, yield func(item) error) error {
+	if len(entries) == 0 {
+		return it.value(in, obj, yield)
+	}
+	entry := entries[0]
+	return it.eval(entry.key, e, item{v: in.v}, func(k item) error {
+		key, ok := k.v.(string)
+		if !ok {
+			return errorf("Object keys must be strings")
+		}
+		return it.eval(entry.value, e, item{v: in.v}, func(v item) error {
+			next := make(map[string]interface{}, len(obj)+1)
+			for ok, ov := range obj {
+				next[ok] = ov
+			}
+			next[key] = v.v
+			return it.evalObject(entries[1:], e, in, next, yield)
+		})
+	})
+}
+
+// bindPattern binds the variables of pat to the parts of v.
+func (it *interp) bindPattern(pat *pattern, e *env, v interface{}, body func(*env) error) error {
+	switch {
+	case pat.name != "":
+		return body(e.bindVar(pat.name, v))
+	case pat.isArray:
+		if v != nil {
+			if _, ok := v.([]interface{}); !ok {
+				return errorf("Cannot index %s with number", typeName(v))
+			}
+		}
+		return it.bindArray(pat.array, 0, e, v, body)
+	}
+	return it.bindObject(pat.object, e, v, body)
+}
+
+func (it *interp) bindArray(pats []*pattern, i int, e *env, v interface{}, body func(*env) error) error {
+	if i == len(pats) {
+		return body(e)
+	}
+	elem, err := index(v, float64(i))
+	if err != nil {
+		return err
+	}
+	return it.bindPattern(pats[i], e, elem, func(scope *env) error {
+		return it.bindArray(pats, i+1, scope, v, body)
+	})
+}
+
+func (it *interp) bindObject(entries []patternEntry, e *env, v interface{}, body func(*env) error) error {
+	if len(entries) == 0 {
+		return body(e)
+	}
+	entry := entries[0]
+	return it.eval(entry.key, e, item{v: v}, func(k item) error {
+		if _, ok := k.v.(string); !ok {
+			return errorf("Cannot index %s with %s", typeName(v), typeName(k.v))
+		}
+		elem, err := index(v, k.v)
+		if err != nil {
+			return err
+		}
+		scope := e
+		if entry.variable != "" {
+			scope = scope.bindVar(entry.variable, elem)
+		}
+		if entry.value == nil {
+			return it.bindObject(entries[1:], scope, v, body)
+		}
+		return it.bindPattern(entry.value, scope, elem, func(scope *env) error {
+			return it.bindObject(entries[1:], scope, v, body)
+		})
+	})
+}
+
+func (it *interp) call(n *node, e *env, in item, yield func(item) error) error {
+	scope, ok := e.lookupFunc(n.name, len(n.args))
+	if !ok {
+		fn := natives[nativeKey(n.name, len(n.args))]
+		if fn == nil {
+			return fmt.Errorf("jq: %s/%d is not defined", n.name, len(n.args))
+		}
+		return it.callNative(fn, n.args, e, in, yield)
+	}
+
+	it.depth++
+	defer func() { it.depth-- }()
+	if it.depth > maxDepth {
+		return fmt.Errorf("jq: maximum call depth of %d exceeded", maxDepth)
+	}
+
+	if scope.closure != nil {
+		return it.eval(scope.closure.n, scope.closure.e, in, yield)
+	}
+	return it.bindParams(scope.fn, 0, n.args, e, scope, in, yield)
+}
+
+// bindParams binds the arguments of a call to the parameters of fn,
+// evaluating $params, then evaluates the body.
+func (it *interp) bindParams(fn *funcDef, i int, args []*node, caller, scope *env, in item, yield func(item) error) error {
+	if i == len(fn.params) {
+		return it.eval(fn.body, scope, in, yield)
+	}
+	name := fn.params[i]
+	if name[0] != '$' {
+		scope = &env{parent: scope, param: name, closure: &closure{n: args[i], e: caller}}
+		return it.bindParams(fn, i+1, args, caller, scope, in, yield)
+	}
+	return it.eval(args[i], caller, item{v: in.v}, func(x item) error {
+		// a $param can also be called as a filter
+		literal := &node{kind: nLiteral, value: x.v}
+		s := scope.bindVar(name[1:], x.v)
+		s = &env{parent: s, param: name[1:], closure: &closure{n: literal, e: s}}
+		return it.bindParams(fn, i+1, args, caller, s, in, yield)
+	})
+}
+
+func (it *interp) callNative(fn *native, args []*node, e *env, in item, yield func(item) error) error {
+	if fn.gen != nil {
+		return fn.gen(it, e, in, args, yield)
+	}
+	values := make([]interface{}, len(args))
+	var each func(i int) error
+	each = func(i int) error {
+		if i == len(args) {
+			v, err := fn.fn(it, in.v, values)
+			if err != nil {
+				return err
+			}
+			return it.value(in, v, yield)
+		}
+		return it.eval(args[i], e, item{v: in.v}, func(x item) error {
+			values[i] = x.v
+			return each(i + 1)
+		})
+	}
+	return each(0)
+}
+
+// evalAssign implements =, |= and the arithmetic update operators by
+// collecting the paths of the left hand side and updating the input at
+// each of them.
+func (it *interp) evalAssign(n *node, e *env, in item, yield func(item) error) error {
+	paths := []interface{}{}
+	err := it.eval(n.left, e, item{v: in.v, path: rootPath}, func(x item) error {
+		paths = append(paths, x.path.slice())
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if n.op == "|=" {
+		v := in.v
+		var deletions []interface{}
+		for _, p := range paths {
+			old, err := getpath(v, p.([]interface{}))
+			if err != nil {
+				return err
+			}
+			found := false
+			err = it.eval(n.right, e, item{v: old}, func(u item) error {
+				found = true
+				v, err = setpath(v, p.([]interface{}), u.v)
+				if err != nil {
+					return err
+				}
+				return errStop
+			})
+			if err != nil && err != errStop {
+				return err
+			}
+			if !found {
+				deletions = append(deletions, p)
+			}
+		}
+		if len(deletions) > 0 {
+			if v, err = delpaths(v, deletions); err != nil {
+				return err
+			}
+		}
+		return it.value(in, v, yield)
+	}
+
+	return it.eval(n.right, e, item{v: in.v}, func(r item) error {
+		v := in.v
+		for _, p := range paths {
+			value := r.v
+			if n.op != "=" {
+				old, err := getpath(v, p.([]interface{}))
+				if err != nil {
+					return err
+				}
+				op := n.op[:len(n.op)-1]
+				if op == "//" {
+					if truthy(old) {
+						value = old
+					}
+				} else if value, err = it.arith(op, old, r.v); err != nil {
+					return err
+				}
+			}
+			var err error
+			if v, err = setpath(v, p.([]interface{}), value); err != nil {
+				return err
+			}
+		}
+		return it.value(in, v, yield)
+	})
+}
+
+// errStop ends a generator early once the outputs needed are collected.
+var errStop = &breakError{}
@@ -0,0 +1,422 @@
+package jq
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/ins-tykgw/tyk/regexp"
+)
+
+// format implements the @name string formats.
+func (it *interp) format(name string, v interface{}) (string, error) {
+	s, err := it.formatValue(name, v)
+	if err != nil {
+		return "", err
+	}
+	if err := checkLen(len(s), maxStringLen); err != nil {
+		return "", err
+	}
+	return s, nil
+}
+
+func (it *interp) formatValue(name string, v interface{}) (string, error) {
+	switch name {
+	case "text":
+		return it.tostring(v)
+	case "json":
+		return it.toJSON(v)
+	case "html":
+		s, err := it.tostring(v)
+		return strings.NewReplacer("<", "&lt;", ">", "&gt;", "&", "&amp;", "'", "&#39;", `"`, "&quot;").Replace(s), err
+	case "uri":
+		s, err := it.tostring(v)
+		return strings.Replace(url.QueryEscape(s), "+", "%20", -1), err
+	case "csv", "tsv":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return "", errorf("%s cannot be %s-formatted, only an array can be", describeValue(v), name)
+		}
+		fields := make([]string, len(arr))
+		size := 0
+		for i, elem := range arr {
+			if err := it.tick(); err != nil {
+				return "", err
+			}
+			switch x := elem.(type) {
+			case nil:
+			case bool, float64:
+				fields[i] = tostring(x)
+			case string:
+				if name == "csv" {
+					fields[i] = `"` + strings.Replace(x, `"`, `""`, -1) + `"`
+				} else {
+					fields[i] = strings.NewReplacer(`\`, `\\`, "\t", `\t`, "\r", `\r`, "\n", `\n`).Replace(x)
+				}
+			default:
+				return "", errorf("%s is not valid in a csv row", describeValue(elem))
+			}
+			// the fields of an array sharing a string can add up to more
+			// than the array holds
+			if size += len(fields[i]) + 1; size > maxStringLen {
+				return "", ErrTooLarge
+			}
+		}
+		if name == "csv" {
+			return strings.Join(fields, ","), nil
+		}
+		return strings.Join(fields, "\t"), nil
+	case "sh":
+		quote := func(elem interface{}) (string, error) {
+			switch x := elem.(type) {
+			case string:
+				return "'" + strings.Replace(x, "'", `'\''`, -1) + "'", nil
+			case []interface{}, map[string]interface{}:
+				return "", errorf("%s can not be escaped for shell", describeValue(elem))
+			}
+			return tostring(elem), nil
+		}
+		arr, ok := v.([]interface{})
+		if !ok {
+			return quote(v)
+		}
+		words := make([]string, len(arr))
+		size := 0
+		for i, elem := range arr {
+			if err := it.tick(); err != nil {
+				return "", err
+			}
+			var err error
+			if words[i], err = quote(elem); err != nil {
+				return "", err
+			}
+			if size += len(words[i]) + 1; size > maxStringLen {
+				return "", ErrTooLarge
+			}
+		}
+		return strings.Join(words, " "), nil
+	}
+
+	s, err := it.tostring(v)
+	if err != nil {
+		return "", err
+	}
+	switch name {
+	case "base64":
+		return base64.StdEncoding.EncodeToString([]byte(s)), nil
+	case "base64d":
+		b, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(s, "="))
+		if err != nil {
+			return "", errorf("%s is not valid base64 data", describeValue(v))
+		}
+		return string(b), nil
+	case "base32":
+		return base32.StdEncoding.EncodeToString([]byte(s)), nil
+	case "base32d":
+		b, err := base32.StdEncoding.DecodeString(s)
+		if err != nil {
+			return "", errorf("%s is not valid base32 data", describeValue(v))
+		}
+		return string(b), nil
+	}
+	return "", errorf("%s is not a valid format", name)
+}
+
+func compileRegexp(re, flags interface{}) (*regexp.Regexp, bool, bool, error) {
+	expr, ok := re.(string)
+	if !ok {
+		return nil, false, false, errorf("%s cannot be matched, as it is not a string", describeValue(re))
+	}
+	var modes string
+	switch f := flags.(type) {
+	case nil:
+	case string:
+		modes = f
+	default:
+		return nil, false, false, errorf("%s is not a string", describeValue(flags))
+	}
+
+	global, skipEmpty := false, false
+	prefix := ""
+	for _, m := range modes {
+		switch m {
+		case 'g':
+			global = true
+		case 'i':
+			prefix += "i"
+		case 's':
+			prefix += "s"
+		case 'n':
+			skipEmpty = true
+		case 'p':
+			prefix += "s"
+			skipEmpty = true
+		case 'x':
+			expr = stripExtended(expr)
+		default:
+			return nil, false, false, errorf("%s is not a valid modifier string", modes)
+		}
+	}
+	if prefix != "" {
+		expr = "(?" + prefix + ")" + expr
+	}
+	rx, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, false, false, errorf("%s (at offset 0) is not a valid regex: %v", expr, err)
+	}
+	return rx, global, skipEmpty, nil
+}
+
+// stripExtended removes whitespace and comments from an extended regex.
+func stripExtended(expr string) string {
+	var b strings.Builder
+	escaped, comment := false, false
+	for _, r := range expr {
+		switch {
+		case comment:
+			comment = r != '\n'
+			continue
+		case escaped:
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '#':
+			comment = true
+			continue
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fnMatch returns the match objects of a regex, or whether it matches
+// when the third argument is true.
+func fnMatch(it *interp, in interface{}, args []interface{}) (interface{}, error) {
+	s, ok := in.(string)
+	if !ok {
+		return nil, errorf("%s cannot be matched, as it is not a string", describeValue(in))
+	}
+	rx, global, skipEmpty, err := compileRegexp(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	if test, _ := args[2].(bool); test {
+		return rx.MatchString(s), nil
+	}
+
+	n := 1
+	if global {
+		n = -1
+	}
+	names := rx.SubexpNames()
+	out := []interface{}{}
+	for _, loc := range rx.FindAllStringSubmatchIndex(s, n) {
+		if err := it.tick(); err != nil {
+			return nil, err
+		}
+		if skipEmpty && loc[0] == loc[1] {
+			continue
+		}
+		captures := []interface{}{}
+		for i := 1; i < len(names); i++ {
+			capture := map[string]interface{}{"offset": float64(-1), "length": float64(0), "string": nil, "name": nil}
+			if names[i] != "" {
+				capture["name"] = names[i]
+			}
+			if start, end := loc[2*i], loc[2*i+1]; start >= 0 {
+				capture["offset"] = float64(utf8.RuneCountInString(s[:start]))
+				capture["length"] = float64(utf8.RuneCountInString(s[start:end]))
+				capture["string"] = s[start:end]
+			}
+			captures = append(captures, capture)
+		}
+		out = append(out, map[string]interface{}{
+			// offsets are in code points, as in jq
+			"offset":   float64(utf8.RuneCountInString(s[:loc[0]])),
+			"length":   float64(utf8.RuneCountInString(s[loc[0]:loc[1]])),
+			"string":   s[loc[0]:loc[1]],
+			"captures": captures,
+		})
+	}
+	return out, nil
+}
+
+// genSub implements sub(re; replacement; flags). The replacement is a
+// filter run on an object of the named captures.
+func genSub(it *interp, e *env, in item, args []*node, yield func(item) error) error {
+	s, ok := in.v.(string)
+	if !ok {
+		return errorf("%s cannot be matched, as it is not a string", describeValue(in.v))
+	}
+	return it.eval(args[0], e, item{v: in.v}, func(re item) error {
+		return it.eval(args[2], e, item{v: in.v}, func(flags item) error {
+			rx, global, _, err := compileRegexp(re.v, flags.v)
+			if err != nil {
+				return err
+			}
+			n := 1
+			if global {
+				n = -1
+			}
+			matches := rx.FindAllStringSubmatchIndex(s, n)
+			names := rx.SubexpNames()
+
+			var build func(i, last int, prefix string) error
+			build = func(i, last int, prefix string) error {
+				if i == len(matches) {
+					return it.value(in, prefix+s[last:], yield)
+				}
+				loc := matches[i]
+				captures := map[string]interface{}{}
+				for j := 1; j < len(names); j++ {
+					if names[j] == "" {
+						continue
+					}
+					if loc[2*j] >= 0 {
+						captures[names[j]] = s[loc[2*j]:loc[2*j+1]]
+					} else {
+						captures[names[j]] = nil
+					}
+				}
+				return it.eval(args[1], e, item{v: captures}, func(r item) error {
+					replacement, ok := r.v.(string)
+					if !ok {
+						return errorf("%s cannot be added to a string", describeValue(r.v))
+					}
+					if len(prefix)+loc[0]-last+len(replacement) > maxStringLen {
+						return ErrTooLarge
+					}
+					return build(i+1, loc[1], prefix+s[last:loc[0]]+replacement)
+				})
+			}
+			return build(0, 0, "")
+		})
+	})
+}
+
+func fnNow(*interp, interface{}, []interface{}) (interface{}, error) {
+	return float64(time.Now().UnixNano()) / 1e9, nil
+}
+
+// brokenDownTime converts a timestamp to jq's broken down time:
+// [year, month (0-11), day, hours, minutes, seconds, weekday, yearday].
+func brokenDownTime(t time.Time) []interface{} {
+	t = t.UTC()
+	seconds := float64(t.Second()) + float64(t.Nanosecond())/1e9
+	return []interface{}{
+		float64(t.Year()), float64(t.Month() - 1), float64(t.Day()),
+		float64(t.Hour()), float64(t.Minute()), seconds,
+		float64(t.Weekday()), float64(t.YearDay() - 1),
+	}
+}
+
+func timeFromBrokenDown(v interface{}) (time.Time, error) {
+	parts, ok := v.([]interface{})
+	if !ok || len(parts) < 6 {
+		return time.Time{}, errorf("mktime requires array of 6 numbers")
+	}
+	nums := make([]float64, 6)
+	for i := range nums {
+		f, ok := parts[i].(float64)
+		if !ok {
+			return time.Time{}, errorf("mktime requires parsed datetime inputs")
+		}
+		nums[i] = f
+	}
+	sec, frac := math.Modf(nums[5])
+	return time.Date(int(nums[0]), time.Month(nums[1]+1), int(nums[2]), int(nums[3]), int(nums[4]), int(sec), int(frac*1e9), time.UTC), nil
+}
+
+func timeInput(v interface{}) (time.Time, error) {
+	if f, ok := v.(float64); ok {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*1e9)).UTC(), nil
+	}
+	return timeFromBrokenDown(v)
+}
+
+func fnMktime(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	t, err := timeFromBrokenDown(in)
+	if err != nil {
+		return nil, err
+	}
+	return math.Floor(float64(t.Unix())), nil
+}
+
+func fnGmtime(_ *interp, in interface{}, _ []interface{}) (interface{}, error) {
+	f, ok := in.(float64)
+	if !ok {
+		return nil, errorf("gmtime() requires a number")
+	}
+	t, _ := timeInput(f)
+	return brokenDownTime(t), nil
+}
+
+// strftimeLayouts maps strftime directives to Go time layouts.
+var strftimeLayouts = map[byte]string{
+	'Y': "2006", 'y': "06", 'm': "01", 'd': "02", 'e': "_2", 'H': "15", 'I': "03",
+	'M': "04", 'S': "05", 'p': "PM", 'Z': "MST", 'z': "-0700", 'a': "Mon", 'A': "Monday",
+	'b': "Jan", 'h': "Jan", 'B': "January", 'j': "002", 'T': "15:04:05", 'D': "01/02/06",
+	'F': "2006-01-02", 'R': "15:04", 'c': "Mon Jan _2 15:04:05 2006",
+}
+
+func strftimeLayout(f string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' || i+1 == len(f) {
+			b.WriteByte(f[i])
+			continue
+		}
+		i++
+		if f[i] == '%' {
+			b.WriteByte('%')
+			continue
+		}
+		layout, ok := strftimeLayouts[f[i]]
+		if !ok {
+			return "", errorf("strftime/1: unsupported directive %%%c", f[i])
+		}
+		b.WriteString(layout)
+	}
+	return b.String(), nil
+}
+
+func fnStrftime(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+	f, ok := args[0].(string)
+	if !ok {
+		return nil, errorf("strftime/1 requires a string format")
+	}
+	t, err := timeInput(in)
+	if err != nil {
+		return nil, errorf("strftime/1 requires parsed datetime inputs")
+	}
+	layout, err := strftimeLayout(f)
+	if err != nil {
+		return nil, err
+	}
+	return t.Format(layout), nil
+}
+
+func fnStrptime(_ *interp, in interface{}, args []interface{}) (interface{}, error) {
+	s, ok1 := in.(string)
+	f, ok2 := args[0].(string)
+	if !ok1 || !ok2 {
+		return nil, errorf("strptime/1 requires string inputs and arguments")
+	}
+	layout, err := strftimeLayout(f)
+	if err != nil {
+		return nil, err
+	}
+	t, err := time.Parse(layout, s)
+	if err != nil {
+		return nil, errorf("date %s does not match format %s", fmt.Sprintf("%q", s), fmt.Sprintf("%q", f))
+	}
+	return brokenDownTime(t), nil
+}
@@ -0,0 +1,267 @@
+/*
+Package jq is a pure Go implementation of the jq JSON processing language.
+
+It covers the language and the builtins that are useful for transforming
+request and response bodies: paths and assignment, reduce and foreach,
+function definitions, try/catch, label/break, string interpolation and
+formats, regular expressions and dates. Module imports, input/inputs,
+streaming and the SQL style builtins other than IN and INDEX are not
+supported. $ENV is always empty, so programs can't read the environment of
+the process running them. Function calls can nest 4096 deep, which also
+bounds the number of steps taken by recursive builtins like until, while
+and repeat. Builtins check the context passed to Run as they loop, and
+values are capped in size, see ErrTooLarge.
+
+Programs are compiled once and are safe for concurrent use.
+*/
+package jq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrNoOutput is returned by First when the program yields nothing.
+	ErrNoOutput = errors.New("jq: program produced no output")
+	// ErrTooLarge is returned when the program builds a string longer than
+	// 64 MiB or an array or object with more than 8M entries. Like
+	// timeouts, it can't be caught with try.
+	ErrTooLarge = errors.New("jq: value is too large")
+)
+
+// Code is a compiled jq program.
+type Code struct {
+	root *node
+}
+
+var preludeEnv *env
+
+// preludeScope records the prelude definitions for Compile's checks.
+var preludeScope *checkScope
+
+// loadPrelude compiles the builtins defined in jq. It runs after the
+// natives are set up, as the prelude's checks need them.
+func loadPrelude() {
+	n, err := parse(prelude)
+	if err != nil {
+		panic(err)
+	}
+	// each definition can see the ones before it
+	for ; n.kind == nFuncDef; n = n.right {
+		if err := preludeScope.checkFunc(n.fn); err != nil {
+			panic(err)
+		}
+		preludeEnv = &env{parent: preludeEnv, fn: n.fn}
+		preludeScope = &checkScope{parent: preludeScope, fn: nativeKey(n.fn.name, len(n.fn.params))}
+	}
+}
+
+// Compile parses a program and checks that the functions and variables
+// it uses are defined.
+func Compile(program string) (*Code, error) {
+	n, err := parse(program)
+	if err != nil {
+		return nil, err
+	}
+	if err := preludeScope.check(n); err != nil {
+		return nil, err
+	}
+	return &Code{root: n}, nil
+}
+
+// Run runs the program on input and returns all of its outputs. Input is
+// converted to JSON values first, so maps, slices and structs can be
+// passed directly. Run stops with ctx's error once ctx is done.
+func (c *Code) Run(ctx context.Context, input interface{}) ([]interface{}, error) {
+	out := []interface{}{}
+	err := c.run(ctx, input, func(v interface{}) error {
+		out = append(out, v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// First runs the program on input and returns its first output.
+func (c *Code) First(ctx context.Context, input interface{}) (interface{}, error) {
+	var first interface{}
+	found := false
+	err := c.run(ctx, input, func(v interface{}) error {
+		first, found = v, true
+		return errStop
+	})
+	if err != nil && err != errStop {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNoOutput
+	}
+	return first, nil
+}
+
+func (c *Code) run(ctx context.Context, input interface{}, yield func(interface{}) error) error {
+	v, err := normalize(input)
+	if err != nil {
+		return fmt.Errorf("jq: invalid input: %v", err)
+	}
+	it := &interp{ctx: ctx}
+	err = it.eval(c.root, preludeEnv, item{v: v}, func(x item) error {
+		return yield(x.v)
+	})
+	if err, ok := err.(*valueError); ok {
+		return fmt.Errorf("jq: error: %v", err)
+	}
+	return err
+}
+
+// checkScope is the static counterpart of env, used to find undefined
+// functions, variables and labels when a program is compiled.
+type checkScope struct {
+	parent   *checkScope
+	fn       string
+	variable string
+	label    string
+}
+
+func (s *checkScope) hasFunc(key string) bool {
+	for ; s != nil; s = s.parent {
+		if s.fn == key {
+			return true
+		}
+	}
+	return natives[key] != nil
+}
+
+func (s *checkScope) hasVar(name string) bool {
+	for ; s != nil; s = s.parent {
+		if s.variable == name {
+			return true
+		}
+	}
+	return name == "ENV"
+}
+
+func (s *checkScope) hasLabel(name string) bool {
+	for ; s != nil; s = s.parent {
+		if s.label == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *checkScope) checkFunc(fn *funcDef) error {
+	// the function can call itself
+	scope := &checkScope{parent: s, fn: nativeKey(fn.name, len(fn.params))}
+	for _, p := range fn.params {
+		if p[0] == '$' {
+			scope = &checkScope{parent: scope, variable: p[1:]}
+			p = p[1:]
+		}
+		scope = &checkScope{parent: scope, fn: nativeKey(p, 0)}
+	}
+	return scope.check(fn.body)
+}
+
+func (s *checkScope) bindPattern(pat *pattern) (*checkScope, error) {
+	if pat.name != "" {
+		return &checkScope{parent: s, variable: pat.name}, nil
+	}
+	scope := s
+	for _, elem := range pat.array {
+		var err error
+		if scope, err = scope.bindPattern(elem); err != nil {
+			return nil, err
+		}
+	}
+	for _, entry := range pat.object {
+		// keys can use the variables bound before them
+		if err := scope.check(entry.key); err != nil {
+			return nil, err
+		}
+		if entry.variable != "" {
+			scope = &checkScope{parent: scope, variable: entry.variable}
+		}
+		if entry.value != nil {
+			var err error
+			if scope, err = scope.bindPattern(entry.value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return scope, nil
+}
+
+func (s *checkScope) check(n *node) error {
+	if n == nil {
+		return nil
+	}
+	switch n.kind {
+	case nCall:
+		if !s.hasFunc(nativeKey(n.name, len(n.args))) {
+			return fmt.Errorf("jq: %s/%d is not defined", n.name, len(n.args))
+		}
+	case nVariable:
+		if !s.hasVar(n.name) {
+			return fmt.Errorf("jq: $%s is not defined", n.name)
+		}
+	case nBreak:
+		if !s.hasLabel(n.name) {
+			return fmt.Errorf("jq: $*label-%s is not defined", n.name)
+		}
+	case nFuncDef:
+		if err := s.checkFunc(n.fn); err != nil {
+			return err
+		}
+		return (&checkScope{parent: s, fn: nativeKey(n.fn.name, len(n.fn.params))}).check(n.right)
+	case nLabel:
+		return (&checkScope{parent: s, label: n.name}).check(n.right)
+	case nBind, nReduce, nForeach:
+		if err := s.check(n.left); err != nil {
+			return err
+		}
+		scope, err := s.bindPattern(n.pattern)
+		if err != nil {
+			return err
+		}
+		if n.kind == nBind {
+			return scope.check(n.right)
+		}
+		// the initial value doesn't see the pattern's variables
+		if err := s.check(n.args[0]); err != nil {
+			return err
+		}
+		for _, arg := range n.args[1:] {
+			if err := scope.check(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, child := range []*node{n.left, n.right} {
+		if err := s.check(child); err != nil {
+			return err
+		}
+	}
+	for _, children := range [][]*node{n.args, n.parts} {
+		for _, child := range children {
+			if err := s.check(child); err != nil {
+				return err
+			}
+		}
+	}
+	for _, entry := range n.entries {
+		if err := s.check(entry.key); err != nil {
+			return err
+		}
+		if err := s.check(entry.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,230 @@
+package jq
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testInput = `{"a":1,"b":2,"c":[1,2,3],"d":"key","e":{"f":"g"}}`
+
+func runProgram(t *testing.T, program string) (string, error) {
+	t.Helper()
+	code, err := Compile(program)
+	if err != nil {
+		return "", err
+	}
+	var input interface{}
+	if err := json.Unmarshal([]byte(testInput), &input); err != nil {
+		t.Fatal(err)
+	}
+	out, err := code.Run(context.Background(), input)
+	if err != nil {
+		return "", err
+	}
+	parts := make([]string, len(out))
+	for i, v := range out {
+		parts[i] = toJSON(v)
+	}
+	return strings.Join(parts, " "), nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		program, want string
+	}{
+		{`.`, testInput},
+		{`.a, .c[1], .e.f, .["d"], .missing`, `1 2 "g" "key" null`},
+		{`.c[1:], .c[-1], .d[1:]`, `[2,3] 3 "ey"`},
+		{`[.c[] | . * 2]`, `[2,4,6]`},
+		{`.c | map(select(. > 1))`, `[2,3]`},
+		{`{a, "k": .b, (.d): 1}`, `{"a":1,"k":2,"key":1}`},
+		{"1 as $x |\n$__loc__", `{"file":"<stdin>","line":2}`},
+		{`"x\(.a + .b)y"`, `"x3y"`},
+		{`@base64 "v=\(.d)", @csv "\(.c)", (.c | @json)`, `"v=a2V5" "1,2,3" "[1,2,3]"`},
+		{`.a as $x | .c | map(. + $x)`, `[2,3,4]`},
+		{`. as {a: $v, c: [$first]} | [$v, $first]`, `[1,1]`},
+		{`reduce .c[] as $x (0; . + $x)`, `6`},
+		{`[foreach .c[] as $x (0; . + $x)]`, `[1,3,6]`},
+		{`[limit(2; .c[])], first(.c[]), [range(0; 10; 3)]`, `[1,2] 1 [0,3,6,9]`},
+		{`def inc(f): f + 1; def add($x): . + $x; .a | inc(.) | add(10)`, `12`},
+		{`def fac: if . <= 1 then 1 else . * (. - 1 | fac) end; 5 | fac`, `120`},
+		{`[label $out | .c[] | if . > 1 then ., break $out else . end]`, `[1,2]`},
+		{`if .a == 1 then "one" elif .a == 2 then "two" else "many" end`, `"one"`},
+		{`.x // "default", (.a // "default")`, `"default" 1`},
+		{`try error("boom") catch ., [.c[] | try (if . == 2 then error("x") else . end)]`, `"boom" [1,3]`},
+		{`.a.b?, [.c[]?], (.a | .[]?)`, `[1,2,3]`},
+		{`.b |= . + 1 | .c += [4] | .z //= 5 | del(.d, .e)`, `{"a":1,"b":3,"c":[1,2,3,4],"z":5}`},
+		{`(.c[] | select(. == 2)) = 9 | .c`, `[1,9,3]`},
+		{`{} | .a.b.c = 1`, `{"a":{"b":{"c":1}}}`},
+		{`[paths], [leaf_paths] | length`, `9 7`},
+		{`path(.c[0]), getpath(["e", "f"]), (setpath(["e", "f"]; 1) | .e)`, `["c",0] "g" {"f":1}`},
+		{`to_entries[0], (with_entries(select(.value | type == "number")))`, `{"key":"a","value":1} {"a":1,"b":2}`},
+		{`walk(if type == "number" then . + 1 else . end) | .c`, `[2,3,4]`},
+		{`keys, (.c | length), (.d | length), has("a"), (.c | contains([2]))`, `["a","b","c","d","e"] 3 3 true true`},
+		{`[3,1,2] | sort, reverse, min, max, unique, add`, `[1,2,3] [2,1,3] 1 3 [1,2,3] 6`},
+		{`[{"k":2},{"k":1},{"k":2}] | (sort_by(.k), unique_by(.k) | map(.k)), (group_by(.k) | map(length))`, `[1,2,2] [1,2] [1,2]`},
+		{`[1,[2,[3]]] | flatten, flatten(1)`, `[1,2,3] [1,2,[3]]`},
+		{`{"a":{"b":1}} * {"a":{"c":2}}, [1,2,2] - [2], "abc" / "b", "ab" * 2`, `{"a":{"b":1,"c":2}} [1] ["a","c"] "abab"`},
+		{`[.c[] | tostring] | join("-")`, `"1-2-3"`},
+		{`"10" | tonumber + 1, (1 | tojson), ("[1]" | fromjson)`, `11 "1" [1]`},
+		{`.d | ascii_upcase, ltrimstr("k"), rtrimstr("y"), startswith("k"), explode`, `"KEY" "ey" "ke" true [107,101,121]`},
+		{`"a, b,c" | split(", *"; null), [splits(",")], split(",")`, `["a","b","c"] ["a"," b","c"] ["a"," b","c"]`},
+		{`"abc" | test("B"; "i"), [match("[bc]"; "g").offset]`, `true [1,2]`},
+		{`"a1b22" | [scan("[0-9]+")], sub("(?<n>[0-9]+)"; "<\(.n)>"), gsub("[0-9]"; "")`, `["1","22"] "a<1>b22" "ab"`},
+		{`"foo bar" | capture("(?<first>\\w+) (?<second>\\w+)")`, `{"first":"foo","second":"bar"}`},
+		{`"é <a>" | @uri, @html, @sh`, `"%C3%A9%20%3Ca%3E" "é &lt;a&gt;" "'é <a>'"`},
+		{`0 | todate, ("2015-03-05T23:51:47Z" | fromdate), (1425599507 | strftime("%Y-%m-%d"))`, `"1970-01-01T00:00:00Z" 1425599507 "2015-03-05"`},
+		{`[.[] | numbers], [.. | scalars] | length`, `2 7`},
+		{`$ENV, env`, `{} {}`},
+		{`1e1000, (infinite | isinfinite), (nan | isnan), (-1 | abs), (2 | pow(.; 3))`, `1.7976931348623157e+308 true true 1 8`},
+	}
+	for _, tc := range tests {
+		got, err := runProgram(t, tc.program)
+		if err != nil {
+			t.Errorf("%s: %v", tc.program, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s:\nwant %s\ngot  %s", tc.program, tc.want, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tests := []struct {
+		program, want string
+	}{
+		{`.[`, "syntax error"},
+		{`{a: }`, "syntax error"},
+		{`foo`, "foo/0 is not defined"},
+		{`def f(x): x; f`, "f/0 is not defined"},
+		{`$x`, "$x is not defined"},
+		{`break $out`, "label-out is not defined"},
+		{`.a.b`, `Cannot index number with "b"`},
+		{`.a[]`, "Cannot iterate over number (1)"},
+		{`.a + "x"`, `number (1) and string ("x") cannot be added`},
+		{`1 / 0`, "cannot be divided because the divisor is zero"},
+		{`error("custom")`, "jq: error: custom"},
+		{`path(1)`, "Invalid path expression"},
+		{`{(1): 2}`, "Object keys must be strings"},
+		{`def f: f; f`, "maximum call depth"},
+	}
+	for _, tc := range tests {
+		_, err := runProgram(t, tc.program)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: want error containing %q, got %v", tc.program, tc.want, err)
+		}
+	}
+}
+
+func TestFirst(t *testing.T) {
+	code, err := Compile(`.[] | select(. > 1)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := code.First(context.Background(), []int{1, 2, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != float64(2) {
+		t.Errorf("want 2, got %v", v)
+	}
+	if _, err := code.First(context.Background(), []int{1}); err != ErrNoOutput {
+		t.Errorf("want ErrNoOutput, got %v", err)
+	}
+	// the rest of the outputs are never computed
+	code, _ = Compile(`1, error("not reached")`)
+	if _, err := code.First(context.Background(), nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestInput(t *testing.T) {
+	code, _ := Compile(`.name + "-" + (.tags | join(","))`)
+	input := struct {
+		Name string   `json:"name"`
+		Tags []string `json:"tags"`
+	}{"api", []string{"a", "b"}}
+	v, err := code.First(context.Background(), input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != "api-a,b" {
+		t.Errorf("want api-a,b, got %v", v)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	for _, program := range []string{
+		`[range(1e12)]`,
+		`last(range(1e12))`,
+		`reduce range(1e12) as $x (0; . + $x)`,
+	} {
+		code, err := Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		start := time.Now()
+		_, err = code.Run(ctx, nil)
+		cancel()
+		if err != context.DeadlineExceeded {
+			t.Errorf("%s: want deadline exceeded, got %v", program, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: stopped after %v", program, elapsed)
+		}
+	}
+}
+
+func TestTooLarge(t *testing.T) {
+	for _, program := range []string{
+		`"x" * 1e10`,
+		`"x" * 3e8 | length`,
+		`reduce range(40) as $i ("x"; . + .)`,
+		`reduce range(30) as $i ([1]; [.,.]) | flatten`,
+		`reduce range(30) as $i ([1]; [.,.]) | tojson`,
+		`"x" * 1e3 | [range(1e5) as $i | .] | @csv`,
+		`"ab" * 4e7 | explode`,
+		`[range(1e12)]`,
+	} {
+		code, err := Compile(program)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		start := time.Now()
+		_, err = code.Run(ctx, nil)
+		cancel()
+		if err != ErrTooLarge && err != context.DeadlineExceeded {
+			t.Errorf("%s: want a size or timeout error, got %v", program, err)
+		}
+		if elapsed := time.Since(start); elapsed > 3*time.Second {
+			t.Errorf("%s: stopped after %v", program, elapsed)
+		}
+	}
+	// not even try can go on past the cap
+	if _, err := runProgram(t, `try ("x" * 1e10) catch "caught"`); err != ErrTooLarge {
+		t.Errorf("want ErrTooLarge, got %v", err)
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	code, err := Compile(`{body: (.body + {path: ._tyk_context.path}), rewrite_headers: {"X-Foo": .body.foo}}`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	input := map[string]interface{}{
+		"body":         map[string]interface{}{"foo": "bar", "items": []interface{}{1.0, 2.0, 3.0}},
+		"_tyk_context": map[string]interface{}{"path": "/jq"},
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := code.First(context.Background(), input); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
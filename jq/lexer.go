@@ -0,0 +1,304 @@
+package jq
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokField    // .foo
+	tokVariable // $foo
+	tokFormat   // @base64
+	tokNumber
+	tokString
+	tokOp
+	tokKeyword
+)
+
+var keywords = map[string]bool{
+	"def": true, "if": true, "then": true, "elif": true, "else": true, "end": true,
+	"as": true, "reduce": true, "foreach": true, "try": true, "catch": true,
+	"and": true, "or": true, "label": true, "import": true, "include": true,
+}
+
+// multi-character operators, longest first
+var operators = []string{
+	"|=", "+=", "-=", "*=", "/=", "%=", "//=", "==", "!=", "<=", ">=", "//",
+	"..", "|", ",", "+", "-", "*", "/", "%", "=", "<", ">", ".", "[", "]", "(", ")",
+	"{", "}", ":", ";", "?",
+}
+
+type token struct {
+	kind tokenKind
+	text string
+	// num is set for tokNumber, and is the line of $__loc__
+	num float64
+	// parts is set for tokString: literal strings alternate with the
+	// source of interpolated expressions
+	parts []string
+	pos   int
+}
+
+type lexer struct {
+	src    string
+	pos    int
+	tokens []token
+}
+
+func lex(src string) ([]token, error) {
+	l := &lexer{src: src}
+	for {
+		tok, err := l.next()
+		if err != nil {
+			return nil, err
+		}
+		l.tokens = append(l.tokens, tok)
+		if tok.kind == tokEOF {
+			return l.tokens, nil
+		}
+	}
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("jq: syntax error at offset %d: %s", l.pos, fmt.Sprintf(format, args...))
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.pos++
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) ident() string {
+	start := l.pos
+	for l.pos < len(l.src) && (isIdentChar(l.src[l.pos]) ||
+		// module separators, as in "foo::bar"
+		l.src[l.pos] == ':' && l.pos+2 < len(l.src) && l.src[l.pos+1] == ':' && isIdentStart(l.src[l.pos+2])) {
+		if l.src[l.pos] == ':' {
+			l.pos++
+		}
+		l.pos++
+	}
+	return l.src[start:l.pos]
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipSpace()
+	start := l.pos
+	if l.pos >= len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case c == '"':
+		parts, err := l.str()
+		return token{kind: tokString, parts: parts, pos: start}, err
+	case isDigit(c) || c == '.' && l.pos+1 < len(l.src) && isDigit(l.src[l.pos+1]):
+		return l.number()
+	case isIdentStart(c):
+		name := l.ident()
+		if keywords[name] {
+			return token{kind: tokKeyword, text: name, pos: start}, nil
+		}
+		return token{kind: tokIdent, text: name, pos: start}, nil
+	case c == '$' || c == '@':
+		l.pos++
+		if l.pos >= len(l.src) || !isIdentStart(l.src[l.pos]) {
+			return token{}, l.errorf("expected a name after %q", c)
+		}
+		name := l.ident()
+		if c == '$' && name == "__loc__" {
+			line := 1 + strings.Count(l.src[:start], "\n")
+			return token{kind: tokKeyword, text: name, num: float64(line), pos: start}, nil
+		}
+		if c == '$' {
+			return token{kind: tokVariable, text: name, pos: start}, nil
+		}
+		return token{kind: tokFormat, text: name, pos: start}, nil
+	case c == '.' && l.pos+1 < len(l.src) && isIdentStart(l.src[l.pos+1]):
+		l.pos++
+		for l.pos < len(l.src) && isIdentChar(l.src[l.pos]) {
+			l.pos++
+		}
+		return token{kind: tokField, text: l.src[start+1 : l.pos], pos: start}, nil
+	}
+
+	for _, op := range operators {
+		if strings.HasPrefix(l.src[l.pos:], op) {
+			l.pos += len(op)
+			return token{kind: tokOp, text: op, pos: start}, nil
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+	return token{}, l.errorf("unexpected character %q", r)
+}
+
+func (l *lexer) number() (token, error) {
+	start := l.pos
+	for l.pos < len(l.src) && (isDigit(l.src[l.pos]) || l.src[l.pos] == '.') {
+		l.pos++
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		l.pos++
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.pos++
+		}
+		for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+			l.pos++
+		}
+	}
+	num, err := strconv.ParseFloat(l.src[start:l.pos], 64)
+	if err != nil && !isRangeError(err) {
+		return token{}, l.errorf("invalid number %q", l.src[start:l.pos])
+	}
+	return token{kind: tokNumber, num: num, text: l.src[start:l.pos], pos: start}, nil
+}
+
+// str lexes a string literal, returning its literal parts and the source
+// of any \(...) interpolations between them.
+func (l *lexer) str() ([]string, error) {
+	l.pos++ // opening quote
+	var parts []string
+	var buf strings.Builder
+	for {
+		if l.pos >= len(l.src) {
+			return nil, l.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.pos++
+			return append(parts, buf.String()), nil
+		case c != '\\':
+			buf.WriteByte(c)
+			l.pos++
+			continue
+		}
+
+		l.pos++
+		if l.pos >= len(l.src) {
+			return nil, l.errorf("unterminated string")
+		}
+		esc := l.src[l.pos]
+		l.pos++
+		switch esc {
+		case '"', '\\', '/':
+			buf.WriteByte(esc)
+		case 'b':
+			buf.WriteByte('\b')
+		case 'f':
+			buf.WriteByte('\f')
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		case 't':
+			buf.WriteByte('\t')
+		case 'u':
+			r, err := l.unicodeEscape()
+			if err != nil {
+				return nil, err
+			}
+			buf.WriteRune(r)
+		case '(':
+			src, err := l.interpolation()
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, buf.String(), src)
+			buf.Reset()
+		default:
+			return nil, l.errorf("invalid escape \\%c", esc)
+		}
+	}
+}
+
+func (l *lexer) unicodeEscape() (rune, error) {
+	read := func() (rune, error) {
+		if l.pos+4 > len(l.src) {
+			return 0, l.errorf("invalid \\u escape")
+		}
+		n, err := strconv.ParseUint(l.src[l.pos:l.pos+4], 16, 16)
+		if err != nil {
+			return 0, l.errorf("invalid \\u escape")
+		}
+		l.pos += 4
+		return rune(n), nil
+	}
+	r, err := read()
+	if err != nil {
+		return 0, err
+	}
+	// surrogate pairs
+	if r >= 0xd800 && r < 0xdc00 && strings.HasPrefix(l.src[l.pos:], `\u`) {
+		l.pos += 2
+		lo, err := read()
+		if err != nil {
+			return 0, err
+		}
+		r = (r-0xd800)<<10 + (lo - 0xdc00) + 0x10000
+	}
+	return r, nil
+}
+
+// interpolation returns the source of a \(...) expression, which is
+// parsed separately.
+func (l *lexer) interpolation() (string, error) {
+	start := l.pos
+	depth := 1
+	for l.pos < len(l.src) {
+		switch l.src[l.pos] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				l.pos++
+				return l.src[start : l.pos-1], nil
+			}
+		case '"':
+			if _, err := l.str(); err != nil {
+				return "", err
+			}
+			continue
+		}
+		l.pos++
+	}
+	return "", l.errorf("unterminated string interpolation")
+}
+
+// isRangeError reports whether a number literal was out of range. jq
+// clamps those rather than failing, and ParseFloat already returns the
+// closest value it can.
+func isRangeError(err error) bool {
+	e, ok := err.(*strconv.NumError)
+	return ok && e.Err == strconv.ErrRange
+}
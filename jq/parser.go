@@ -0,0 +1,874 @@
+package jq
+
+import (
+	"fmt"
+)
+
+type nodeKind int
+
+const (
+	nIdentity nodeKind = iota
+	nRecurse
+	nLiteral
+	nString
+	nFormat
+	nIndex
+	nSlice
+	nIterate
+	nPipe
+	nComma
+	nArith
+	nCompare
+	nAnd
+	nOr
+	nAlternative
+	nAssign
+	nNeg
+	nArray
+	nObject
+	nVariable
+	nCall
+	nIf
+	nTry
+	nReduce
+	nForeach
+	nBind
+	nFuncDef
+	nLabel
+	nBreak
+)
+
+type node struct {
+	kind nodeKind
+	// op is the operator of arithmetic, comparison and assignment nodes
+	op string
+	// name is the name of variables, calls, formats and labels
+	name  string
+	value interface{}
+
+	left, right *node
+	// args are the arguments of calls, the slice bounds and the
+	// optional parts of if, try, reduce and foreach
+	args []*node
+	// parts are the literal and interpolated parts of a string
+	parts   []*node
+	entries []objectEntry
+	pattern *pattern
+	fn      *funcDef
+}
+
+type objectEntry struct {
+	key   *node
+	value *node
+}
+
+// pattern is the target of "as": a variable or a destructuring pattern.
+type pattern struct {
+	name    string
+	array   []*pattern
+	object  []patternEntry
+	isArray bool
+}
+
+type patternEntry struct {
+	key *node
+	// variable is set for "$name" and "$name: pattern" entries
+	variable string
+	value    *pattern
+}
+
+type funcDef struct {
+	name   string
+	params []string
+	body   *node
+}
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func parse(src string) (*node, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	n, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, p.errorf(t, "unexpected %s", t.describe())
+	}
+	return n, nil
+}
+
+func (t token) describe() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of program"
+	case tokString:
+		return "string"
+	case tokNumber:
+		return "number " + t.text
+	case tokVariable:
+		return "$" + t.text
+	case tokFormat:
+		return "@" + t.text
+	case tokField:
+		return "." + t.text
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func (p *parser) errorf(t token, format string, args ...interface{}) error {
+	return fmt.Errorf("jq: syntax error at offset %d: %s", t.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) advance() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) isOp(text string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == text
+}
+
+func (p *parser) isKeyword(text string) bool {
+	t := p.peek()
+	return t.kind == tokKeyword && t.text == text
+}
+
+func (p *parser) expectOp(text string) error {
+	if !p.isOp(text) {
+		t := p.peek()
+		return p.errorf(t, "expected %q but got %s", text, t.describe())
+	}
+	p.advance()
+	return nil
+}
+
+func (p *parser) expectKeyword(text string) error {
+	if !p.isKeyword(text) {
+		t := p.peek()
+		return p.errorf(t, "expected %q but got %s", text, t.describe())
+	}
+	p.advance()
+	return nil
+}
+
+// parsePipe parses the lowest precedence level: definitions, bindings
+// and pipes.
+func (p *parser) parsePipe() (*node, error) {
+	if p.isKeyword("def") {
+		fn, err := p.parseFuncDef()
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nFuncDef, fn: fn, right: body}, nil
+	}
+	if p.isKeyword("label") {
+		p.advance()
+		t := p.advance()
+		if t.kind != tokVariable {
+			return nil, p.errorf(t, "expected a label name")
+		}
+		if err := p.expectOp("|"); err != nil {
+			return nil, err
+		}
+		body, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nLabel, name: t.text, right: body}, nil
+	}
+
+	left, err := p.parseComma()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.isKeyword("as") {
+		p.advance()
+		pat, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp("|"); err != nil {
+			return nil, err
+		}
+		body, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nBind, left: left, pattern: pat, right: body}, nil
+	}
+
+	if p.isOp("|") {
+		p.advance()
+		right, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nPipe, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseFuncDef() (*funcDef, error) {
+	p.advance() // def
+	t := p.advance()
+	if t.kind != tokIdent && t.kind != tokKeyword {
+		return nil, p.errorf(t, "expected a function name")
+	}
+	fn := &funcDef{name: t.text}
+	if p.isOp("(") {
+		p.advance()
+		for {
+			param := p.advance()
+			switch param.kind {
+			case tokIdent:
+				fn.params = append(fn.params, param.text)
+			case tokVariable:
+				fn.params = append(fn.params, "$"+param.text)
+			default:
+				return nil, p.errorf(param, "expected a parameter name")
+			}
+			if p.isOp(";") {
+				p.advance()
+				continue
+			}
+			if err := p.expectOp(")"); err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	if err := p.expectOp(":"); err != nil {
+		return nil, err
+	}
+	body, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectOp(";"); err != nil {
+		return nil, err
+	}
+	fn.body = body
+	return fn, nil
+}
+
+func (p *parser) parsePattern() (*pattern, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokVariable:
+		p.advance()
+		return &pattern{name: t.text}, nil
+	case p.isOp("["):
+		p.advance()
+		pat := &pattern{isArray: true}
+		for {
+			elem, err := p.parsePattern()
+			if err != nil {
+				return nil, err
+			}
+			pat.array = append(pat.array, elem)
+			if p.isOp(",") {
+				p.advance()
+				continue
+			}
+			return pat, p.expectOp("]")
+		}
+	case p.isOp("{"):
+		p.advance()
+		pat := &pattern{}
+		for {
+			entry, err := p.parsePatternEntry()
+			if err != nil {
+				return nil, err
+			}
+			pat.object = append(pat.object, entry)
+			if p.isOp(",") {
+				p.advance()
+				continue
+			}
+			return pat, p.expectOp("}")
+		}
+	}
+	return nil, p.errorf(t, "expected a variable or destructuring pattern")
+}
+
+func (p *parser) parsePatternEntry() (patternEntry, error) {
+	var entry patternEntry
+	t := p.peek()
+	switch {
+	case t.kind == tokVariable:
+		p.advance()
+		entry.variable = t.text
+		entry.key = &node{kind: nLiteral, value: t.text}
+		if !p.isOp(":") {
+			return entry, nil
+		}
+	case t.kind == tokIdent || t.kind == tokKeyword:
+		p.advance()
+		entry.key = &node{kind: nLiteral, value: t.text}
+	case t.kind == tokString:
+		key, err := p.parseString("")
+		if err != nil {
+			return entry, err
+		}
+		entry.key = key
+	case p.isOp("("):
+		p.advance()
+		key, err := p.parsePipe()
+		if err != nil {
+			return entry, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return entry, err
+		}
+		entry.key = key
+	default:
+		return entry, p.errorf(t, "invalid object pattern")
+	}
+	if err := p.expectOp(":"); err != nil {
+		return entry, err
+	}
+	value, err := p.parsePattern()
+	entry.value = value
+	return entry, err
+}
+
+func (p *parser) parseComma() (*node, error) {
+	left, err := p.parseAlternative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp(",") {
+		p.advance()
+		right, err := p.parseAlternative()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{kind: nComma, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseAlternative parses "//", which is right associative and binds
+// looser than assignment.
+func (p *parser) parseAlternative() (*node, error) {
+	left, err := p.parseAssign()
+	if err != nil {
+		return nil, err
+	}
+	if p.isOp("//") {
+		p.advance()
+		right, err := p.parseAlternative()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nAlternative, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+var assignOps = map[string]bool{"=": true, "|=": true, "+=": true, "-=": true, "*=": true, "/=": true, "%=": true, "//=": true}
+
+func (p *parser) parseAssign() (*node, error) {
+	left, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && assignOps[t.text] {
+		p.advance()
+		right, err := p.parseAlternative()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nAssign, op: t.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseOr() (*node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("or") {
+		p.advance()
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{kind: nOr, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (*node, error) {
+	left, err := p.parseCompare()
+	if err != nil {
+		return nil, err
+	}
+	for p.isKeyword("and") {
+		p.advance()
+		right, err := p.parseCompare()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{kind: nAnd, left: left, right: right}
+	}
+	return left, nil
+}
+
+var compareOps = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true}
+
+func (p *parser) parseCompare() (*node, error) {
+	left, err := p.parseAdditive()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind == tokOp && compareOps[t.text] {
+		p.advance()
+		right, err := p.parseAdditive()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nCompare, op: t.text, left: left, right: right}, nil
+	}
+	return left, nil
+}
+
+func (p *parser) parseAdditive() (*node, error) {
+	left, err := p.parseMultiplicative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("+") || p.isOp("-") {
+		op := p.advance().text
+		right, err := p.parseMultiplicative()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{kind: nArith, op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseMultiplicative() (*node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("*") || p.isOp("/") || p.isOp("%") {
+		op := p.advance().text
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{kind: nArith, op: op, left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (*node, error) {
+	if p.isOp("-") {
+		p.advance()
+		operand, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nNeg, left: operand}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *parser) parsePostfix() (*node, error) {
+	term, err := p.parseTerm()
+	if err != nil {
+		return nil, err
+	}
+	return p.parseSuffixes(term)
+}
+
+func (p *parser) parseSuffixes(term *node) (*node, error) {
+	for {
+		t := p.peek()
+		switch {
+		case t.kind == tokField:
+			p.advance()
+			term = &node{kind: nIndex, left: term, right: &node{kind: nLiteral, value: t.text}}
+		case p.isOp(".") && p.tokens[p.pos+1].kind == tokString:
+			p.advance()
+			key, err := p.parseString("")
+			if err != nil {
+				return nil, err
+			}
+			term = &node{kind: nIndex, left: term, right: key}
+		case p.isOp(".") && p.tokens[p.pos+1].kind == tokOp && p.tokens[p.pos+1].text == "[":
+			p.advance()
+			continue
+		case p.isOp("["):
+			p.advance()
+			var err error
+			if term, err = p.parseBracket(term); err != nil {
+				return nil, err
+			}
+		case p.isOp("?"):
+			p.advance()
+			term = &node{kind: nTry, left: term}
+		default:
+			return term, nil
+		}
+	}
+}
+
+// parseBracket parses what follows "[" in a suffix: an iteration, an
+// index or a slice.
+func (p *parser) parseBracket(term *node) (*node, error) {
+	if p.isOp("]") {
+		p.advance()
+		return &node{kind: nIterate, left: term}, nil
+	}
+	var from, to *node
+	var err error
+	if !p.isOp(":") {
+		if from, err = p.parsePipe(); err != nil {
+			return nil, err
+		}
+	}
+	if p.isOp(":") {
+		p.advance()
+		if !p.isOp("]") {
+			if to, err = p.parsePipe(); err != nil {
+				return nil, err
+			}
+		}
+		if err := p.expectOp("]"); err != nil {
+			return nil, err
+		}
+		return &node{kind: nSlice, left: term, args: []*node{from, to}}, nil
+	}
+	if err := p.expectOp("]"); err != nil {
+		return nil, err
+	}
+	return &node{kind: nIndex, left: term, right: from}, nil
+}
+
+func (p *parser) parseTerm() (*node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokNumber:
+		p.advance()
+		return &node{kind: nLiteral, value: t.num}, nil
+	case tokString:
+		return p.parseString("")
+	case tokFormat:
+		p.advance()
+		if p.peek().kind == tokString {
+			return p.parseString(t.text)
+		}
+		return &node{kind: nFormat, name: t.text}, nil
+	case tokField:
+		p.advance()
+		return &node{kind: nIndex, left: &node{kind: nIdentity}, right: &node{kind: nLiteral, value: t.text}}, nil
+	case tokVariable:
+		p.advance()
+		return &node{kind: nVariable, name: t.text}, nil
+	case tokKeyword:
+		return p.parseKeywordTerm()
+	case tokIdent:
+		switch t.text {
+		case "true", "false", "null":
+			p.advance()
+			return &node{kind: nLiteral, value: map[string]interface{}{"true": true, "false": false, "null": nil}[t.text]}, nil
+		}
+		return p.parseCall()
+	case tokOp:
+		switch t.text {
+		case ".":
+			p.advance()
+			if p.peek().kind == tokString {
+				key, err := p.parseString("")
+				if err != nil {
+					return nil, err
+				}
+				return &node{kind: nIndex, left: &node{kind: nIdentity}, right: key}, nil
+			}
+			return &node{kind: nIdentity}, nil
+		case "..":
+			p.advance()
+			return &node{kind: nRecurse}, nil
+		case "(":
+			p.advance()
+			n, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			return n, p.expectOp(")")
+		case "[":
+			p.advance()
+			if p.isOp("]") {
+				p.advance()
+				return &node{kind: nArray}, nil
+			}
+			body, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			return &node{kind: nArray, left: body}, p.expectOp("]")
+		case "{":
+			return p.parseObject()
+		}
+	}
+	return nil, p.errorf(t, "unexpected %s", t.describe())
+}
+
+func (p *parser) parseCall() (*node, error) {
+	t := p.advance()
+	if t.text == "break" && p.peek().kind == tokVariable {
+		return &node{kind: nBreak, name: p.advance().text}, nil
+	}
+	call := &node{kind: nCall, name: t.text}
+	if !p.isOp("(") {
+		return call, nil
+	}
+	p.advance()
+	for {
+		arg, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		call.args = append(call.args, arg)
+		if p.isOp(";") {
+			p.advance()
+			continue
+		}
+		return call, p.expectOp(")")
+	}
+}
+
+func (p *parser) parseKeywordTerm() (*node, error) {
+	t := p.peek()
+	switch t.text {
+	case "if":
+		return p.parseIf()
+	case "try":
+		p.advance()
+		body, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		n := &node{kind: nTry, left: body}
+		if p.isKeyword("catch") {
+			p.advance()
+			if n.right, err = p.parsePostfix(); err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	case "reduce", "foreach":
+		p.advance()
+		source, err := p.parsePostfix()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("as"); err != nil {
+			return nil, err
+		}
+		pat, err := p.parsePattern()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectOp("("); err != nil {
+			return nil, err
+		}
+		var args []*node
+		for {
+			arg, err := p.parsePipe()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			if p.isOp(";") {
+				p.advance()
+				continue
+			}
+			break
+		}
+		if err := p.expectOp(")"); err != nil {
+			return nil, err
+		}
+		kind := nReduce
+		if t.text == "foreach" {
+			kind = nForeach
+			if len(args) < 2 || len(args) > 3 {
+				return nil, p.errorf(t, "foreach takes 2 or 3 arguments")
+			}
+		} else if len(args) != 2 {
+			return nil, p.errorf(t, "reduce takes 2 arguments")
+		}
+		return &node{kind: kind, left: source, pattern: pat, args: args}, nil
+	case "def", "label":
+		return p.parsePipe()
+	case "__loc__":
+		p.advance()
+		return &node{kind: nLiteral, value: map[string]interface{}{"file": "<stdin>", "line": t.num}}, nil
+	}
+	return nil, p.errorf(t, "unexpected keyword %q", t.text)
+}
+
+func (p *parser) parseIf() (*node, error) {
+	p.advance() // if or elif
+	cond, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("then"); err != nil {
+		return nil, err
+	}
+	then, err := p.parsePipe()
+	if err != nil {
+		return nil, err
+	}
+	n := &node{kind: nIf, left: cond, right: then}
+	switch {
+	case p.isKeyword("elif"):
+		elif, err := p.parseIf()
+		if err != nil {
+			return nil, err
+		}
+		n.args = []*node{elif}
+		return n, nil
+	case p.isKeyword("else"):
+		p.advance()
+		otherwise, err := p.parsePipe()
+		if err != nil {
+			return nil, err
+		}
+		n.args = []*node{otherwise}
+	}
+	return n, p.expectKeyword("end")
+}
+
+func (p *parser) parseObject() (*node, error) {
+	p.advance() // {
+	n := &node{kind: nObject}
+	if p.isOp("}") {
+		p.advance()
+		return n, nil
+	}
+	for {
+		entry, err := p.parseObjectEntry()
+		if err != nil {
+			return nil, err
+		}
+		n.entries = append(n.entries, entry)
+		if p.isOp(",") {
+			p.advance()
+			continue
+		}
+		return n, p.expectOp("}")
+	}
+}
+
+func (p *parser) parseObjectEntry() (objectEntry, error) {
+	var entry objectEntry
+	t := p.peek()
+	switch {
+	case t.kind == tokVariable:
+		// {$x} is {x: $x}
+		p.advance()
+		entry.key = &node{kind: nLiteral, value: t.text}
+		entry.value = &node{kind: nVariable, name: t.text}
+		return entry, nil
+	case t.kind == tokIdent || t.kind == tokKeyword:
+		p.advance()
+		entry.key = &node{kind: nLiteral, value: t.text}
+	case t.kind == tokNumber:
+		p.advance()
+		entry.key = &node{kind: nLiteral, value: t.text}
+	case t.kind == tokString || t.kind == tokFormat:
+		key, err := p.parseTerm()
+		if err != nil {
+			return entry, err
+		}
+		entry.key = key
+	case p.isOp("("):
+		p.advance()
+		key, err := p.parsePipe()
+		if err != nil {
+			return entry, err
+		}
+		if err := p.expectOp(")"); err != nil {
+			return entry, err
+		}
+		entry.key = key
+	default:
+		return entry, p.errorf(t, "invalid object key %s", t.describe())
+	}
+
+	if !p.isOp(":") {
+		// {a} is {a: .a}
+		entry.value = &node{kind: nIndex, left: &node{kind: nIdentity}, right: entry.key}
+		return entry, nil
+	}
+	p.advance()
+	// object values can't contain unparenthesised commas or pipes
+	value, err := p.parseObjectValue()
+	entry.value = value
+	return entry, err
+}
+
+func (p *parser) parseObjectValue() (*node, error) {
+	left, err := p.parseAlternative()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("|") {
+		p.advance()
+		right, err := p.parseAlternative()
+		if err != nil {
+			return nil, err
+		}
+		left = &node{kind: nPipe, left: left, right: right}
+	}
+	return left, nil
+}
+
+// parseString parses a string token. Interpolated expressions are
+// formatted with format if it's set.
+func (p *parser) parseString(format string) (*node, error) {
+	t := p.advance()
+	if len(t.parts) == 1 {
+		return &node{kind: nLiteral, value: t.parts[0]}, nil
+	}
+	n := &node{kind: nString, name: format}
+	for i, part := range t.parts {
+		if i%2 == 0 {
+			n.parts = append(n.parts, &node{kind: nLiteral, value: part})
+			continue
+		}
+		expr, err := parse(part)
+		if err != nil {
+			return nil, err
+		}
+		n.parts = append(n.parts, expr)
+	}
+	return n, nil
+}
@@ -0,0 +1,966 @@
+package jq
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Values are represented as nil, bool, float64, string, []interface{} and
+// map[string]interface{}. They are never modified in place, so they can
+// be shared freely between variables and outputs.
+
+// normalize converts a Go value into the representation used by the
+// interpreter.
+func normalize(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil, bool, float64, string:
+		return x, nil
+	case int:
+		return float64(x), nil
+	case int64:
+		return float64(x), nil
+	case int32:
+		return float64(x), nil
+	case float32:
+		return float64(x), nil
+	case json.Number:
+		return x.Float64()
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, elem := range x {
+			var err error
+			if out[i], err = normalize(elem); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, elem := range x {
+			var err error
+			if out[k], err = normalize(elem); err != nil {
+				return nil, err
+			}
+		}
+		return out, nil
+	case []string:
+		out := make([]interface{}, len(x))
+		for i, s := range x {
+			out[i] = s
+		}
+		return out, nil
+	case map[string]string:
+		out := make(map[string]interface{}, len(x))
+		for k, s := range x {
+			out[k] = s
+		}
+		return out, nil
+	}
+
+	// anything else goes through its JSON encoding
+	rv := reflect.ValueOf(v)
+	if (rv.Kind() == reflect.Ptr || rv.Kind() == reflect.Map || rv.Kind() == reflect.Slice) && rv.IsNil() {
+		return nil, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("invalid (%T)", v)
+}
+
+func truthy(v interface{}) bool {
+	switch x := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return x
+	}
+	return true
+}
+
+// typeOrder is the order of types when sorting.
+func typeOrder(v interface{}) int {
+	switch x := v.(type) {
+	case nil:
+		return 0
+	case bool:
+		if x {
+			return 2
+		}
+		return 1
+	case float64:
+		return 3
+	case string:
+		return 4
+	case []interface{}:
+		return 5
+	}
+	return 6
+}
+
+// compare orders values as jq does: null < false < true < numbers <
+// strings < arrays < objects.
+func compare(a, b interface{}) int {
+	ta, tb := typeOrder(a), typeOrder(b)
+	if ta != tb {
+		if ta < tb {
+			return -1
+		}
+		return 1
+	}
+	switch x := a.(type) {
+	case float64:
+		y := b.(float64)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case string:
+		y := b.(string)
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	case []interface{}:
+		y := b.([]interface{})
+		for i := 0; i < len(x) && i < len(y); i++ {
+			if c := compare(x[i], y[i]); c != 0 {
+				return c
+			}
+		}
+		return compareInts(len(x), len(y))
+	case map[string]interface{}:
+		y := b.(map[string]interface{})
+		kx, ky := sortedKeys(x), sortedKeys(y)
+		for i := 0; i < len(kx) && i < len(ky); i++ {
+			if kx[i] != ky[i] {
+				if kx[i] < ky[i] {
+					return -1
+				}
+				return 1
+			}
+		}
+		if c := compareInts(len(kx), len(ky)); c != 0 {
+			return c
+		}
+		for _, k := range kx {
+			if c := compare(x[k], y[k]); c != 0 {
+				return c
+			}
+		}
+	}
+	return 0
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func sortValues(values []interface{}) []interface{} {
+	out := append([]interface{}(nil), values...)
+	sort.SliceStable(out, func(i, j int) bool { return compare(out[i], out[j]) < 0 })
+	return out
+}
+
+// toInt truncates a number to an index, clamping huge values.
+func toInt(f float64) int {
+	switch {
+	case math.IsNaN(f):
+		return 0
+	case f > math.MaxInt32:
+		return math.MaxInt32
+	case f < math.MinInt32:
+		return math.MinInt32
+	}
+	return int(f)
+}
+
+func index(v, key interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		switch key.(type) {
+		case string, float64, nil, map[string]interface{}:
+			return nil, nil
+		}
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			return x[k], nil
+		}
+	case []interface{}:
+		switch k := key.(type) {
+		case float64:
+			i := toInt(math.Floor(k))
+			if i < 0 {
+				i += len(x)
+			}
+			if i < 0 || i >= len(x) {
+				return nil, nil
+			}
+			return x[i], nil
+		case []interface{}:
+			return indices(x, k), nil
+		case map[string]interface{}:
+			return sliceByKey(v, k)
+		}
+	case string:
+		if k, ok := key.(map[string]interface{}); ok {
+			return sliceByKey(v, k)
+		}
+	}
+	return nil, errorf("Cannot index %s with %s", typeName(v), describeKey(key))
+}
+
+func describeKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return strconv.Quote(s)
+	}
+	return typeName(key)
+}
+
+func sliceByKey(v interface{}, key map[string]interface{}) (interface{}, error) {
+	return slice(v, key["start"], key["end"])
+}
+
+// sliceBounds resolves the bounds of .[from:to] on a value of length n.
+func sliceBounds(n int, from, to interface{}) (int, int, error) {
+	start, end := 0, n
+	if from != nil {
+		f, ok := from.(float64)
+		if !ok {
+			return 0, 0, errorf("Start and end indices of an array slice must be numbers")
+		}
+		start = toInt(math.Floor(f))
+	}
+	if to != nil {
+		f, ok := to.(float64)
+		if !ok {
+			return 0, 0, errorf("Start and end indices of an array slice must be numbers")
+		}
+		end = toInt(math.Ceil(f))
+	}
+	if start < 0 {
+		start += n
+	}
+	if end < 0 {
+		end += n
+	}
+	if start < 0 {
+		start = 0
+	}
+	if end > n {
+		end = n
+	}
+	if start > end {
+		start = end
+	}
+	return start, end, nil
+}
+
+func slice(v, from, to interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		start, end, err := sliceBounds(len(x), from, to)
+		if err != nil {
+			return nil, err
+		}
+		return x[start:end:end], nil
+	case string:
+		// strings are sliced by code point
+		runes := []rune(x)
+		start, end, err := sliceBounds(len(runes), from, to)
+		if err != nil {
+			return nil, err
+		}
+		return string(runes[start:end]), nil
+	}
+	return nil, errorf("Cannot index %s with object", typeName(v))
+}
+
+func indices(v, sub []interface{}) []interface{} {
+	out := []interface{}{}
+	if len(sub) == 0 {
+		return nil
+	}
+	for i := 0; i+len(sub) <= len(v); i++ {
+		match := true
+		for j := range sub {
+			if compare(v[i+j], sub[j]) != 0 {
+				match = false
+				break
+			}
+		}
+		if match {
+			out = append(out, float64(i))
+		}
+	}
+	return out
+}
+
+func length(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case nil:
+		return float64(0), nil
+	case bool:
+		return nil, errorf("boolean (%s) has no length", toJSON(v))
+	case float64:
+		return math.Abs(x), nil
+	case string:
+		return float64(utf8.RuneCountInString(x)), nil
+	case []interface{}:
+		return float64(len(x)), nil
+	case map[string]interface{}:
+		return float64(len(x)), nil
+	}
+	return nil, errorf("%s has no length", typeName(v))
+}
+
+func keys(v interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make([]interface{}, 0, len(x))
+		for _, k := range sortedKeys(x) {
+			out = append(out, k)
+		}
+		return out, nil
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i := range x {
+			out[i] = float64(i)
+		}
+		return out, nil
+	}
+	return nil, errorf("%s (%s) has no keys", typeName(v), toJSON(v))
+}
+
+func has(v, key interface{}) (interface{}, error) {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		if k, ok := key.(string); ok {
+			_, found := x[k]
+			return found, nil
+		}
+	case []interface{}:
+		if k, ok := key.(float64); ok {
+			return k >= 0 && int(k) < len(x), nil
+		}
+	}
+	return nil, errorf("Cannot check whether %s has a %s key", typeName(v), typeName(key))
+}
+
+func contains(a, b interface{}) (bool, error) {
+	if typeOrder(a) != typeOrder(b) && !(isBool(a) && isBool(b)) {
+		return false, errorf("%s (%s) and %s (%s) cannot have their containment checked", typeName(a), toJSON(a), typeName(b), toJSON(b))
+	}
+	switch x := a.(type) {
+	case map[string]interface{}:
+		for k, bv := range b.(map[string]interface{}) {
+			av, ok := x[k]
+			if !ok {
+				return false, nil
+			}
+			if c, err := contains(av, bv); err != nil || !c {
+				return false, err
+			}
+		}
+		return true, nil
+	case []interface{}:
+		for _, bv := range b.([]interface{}) {
+			found := false
+			for _, av := range x {
+				if typeOrder(av) != typeOrder(bv) {
+					continue
+				}
+				if c, err := contains(av, bv); err == nil && c {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false, nil
+			}
+		}
+		return true, nil
+	case string:
+		return bytes.Contains([]byte(x), []byte(b.(string))), nil
+	}
+	return compare(a, b) == 0, nil
+}
+
+func isBool(v interface{}) bool {
+	_, ok := v.(bool)
+	return ok
+}
+
+// checkLen fails with ErrTooLarge when a value of n bytes or entries would
+// pass max.
+func checkLen(n, max int) error {
+	if n > max || n < 0 {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+func add(a, b interface{}) (interface{}, error) {
+	if a == nil {
+		return b, nil
+	}
+	if b == nil {
+		return a, nil
+	}
+	switch x := a.(type) {
+	case float64:
+		if y, ok := b.(float64); ok {
+			return x + y, nil
+		}
+	case string:
+		if y, ok := b.(string); ok {
+			if err := checkLen(len(x)+len(y), maxStringLen); err != nil {
+				return nil, err
+			}
+			return x + y, nil
+		}
+	case []interface{}:
+		if y, ok := b.([]interface{}); ok {
+			if err := checkLen(len(x)+len(y), maxArrayLen); err != nil {
+				return nil, err
+			}
+			out := make([]interface{}, 0, len(x)+len(y))
+			return append(append(out, x...), y...), nil
+		}
+	case map[string]interface{}:
+		if y, ok := b.(map[string]interface{}); ok {
+			if err := checkLen(len(x)+len(y), maxArrayLen); err != nil {
+				return nil, err
+			}
+			out := make(map[string]interface{}, len(x)+len(y))
+			for k, v := range x {
+				out[k] = v
+			}
+			for k, v := range y {
+				out[k] = v
+			}
+			return out, nil
+		}
+	}
+	return nil, binaryError(a, b, "added")
+}
+
+func binaryError(a, b interface{}, verb string) error {
+	return errorf("%s (%s) and %s (%s) cannot be %s", typeName(a), preview(a), typeName(b), preview(b), verb)
+}
+
+// preview is the start of the JSON of v, for error messages. It only
+// encodes as much of v as it shows.
+func preview(v interface{}) string {
+	w := &jsonWriter{limit: 11}
+	w.encode(v)
+	s := w.buf.String()
+	if len(s) > 11 {
+		return s[:10] + "..."
+	}
+	return s
+}
+
+func (it *interp) arith(op string, a, b interface{}) (interface{}, error) {
+	if op == "+" {
+		return add(a, b)
+	}
+	x, xNum := a.(float64)
+	y, yNum := b.(float64)
+	switch op {
+	case "-":
+		if xNum && yNum {
+			return x - y, nil
+		}
+		xs, ok1 := a.([]interface{})
+		ys, ok2 := b.([]interface{})
+		if ok1 && ok2 {
+			out := []interface{}{}
+			for _, v := range xs {
+				keep := true
+				for _, r := range ys {
+					if compare(v, r) == 0 {
+						keep = false
+						break
+					}
+				}
+				if keep {
+					out = append(out, v)
+				}
+			}
+			return out, nil
+		}
+		return nil, binaryError(a, b, "subtracted")
+	case "*":
+		if xNum && yNum {
+			return x * y, nil
+		}
+		if s, ok := a.(string); ok && yNum {
+			return repeatString(s, y)
+		}
+		if s, ok := b.(string); ok && xNum {
+			return repeatString(s, x)
+		}
+		xo, ok1 := a.(map[string]interface{})
+		yo, ok2 := b.(map[string]interface{})
+		if ok1 && ok2 {
+			return deepMerge(xo, yo), nil
+		}
+		return nil, binaryError(a, b, "multiplied")
+	case "/":
+		if xNum && yNum {
+			if y == 0 {
+				return nil, binaryError(a, b, "divided because the divisor is zero")
+			}
+			return x / y, nil
+		}
+		xs, ok1 := a.(string)
+		ys, ok2 := b.(string)
+		if ok1 && ok2 {
+			return it.splitString(xs, ys)
+		}
+		return nil, binaryError(a, b, "divided")
+	case "%":
+		if xNum && yNum {
+			xi, yi := int64(x), int64(y)
+			if yi < 0 {
+				yi = -yi
+			}
+			if yi == 0 {
+				return nil, binaryError(a, b, "divided because the divisor is zero")
+			}
+			return float64(xi % yi), nil
+		}
+		return nil, binaryError(a, b, "divided")
+	}
+	return nil, errorf("unknown operator %s", op)
+}
+
+// repeatString repeats s n times, rounding n up, checking the size of the
+// result before building it.
+func repeatString(s string, n float64) (interface{}, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	if s == "" {
+		return s, nil
+	}
+	n = math.Ceil(n)
+	if n > float64(maxStringLen/len(s)) {
+		return nil, ErrTooLarge
+	}
+	return strings.Repeat(s, int(n)), nil
+}
+
+func (it *interp) splitString(s, sep string) ([]interface{}, error) {
+	out := []interface{}{}
+	if s == "" {
+		return out, nil
+	}
+	if sep == "" {
+		if err := checkLen(utf8.RuneCountInString(s), maxArrayLen); err != nil {
+			return nil, err
+		}
+		for _, r := range s {
+			if err := it.tick(); err != nil {
+				return nil, err
+			}
+			out = append(out, string(r))
+		}
+		return out, nil
+	}
+	if err := checkLen(strings.Count(s, sep)+1, maxArrayLen); err != nil {
+		return nil, err
+	}
+	for _, part := range strings.Split(s, sep) {
+		if err := it.tick(); err != nil {
+			return nil, err
+		}
+		out = append(out, part)
+	}
+	return out, nil
+}
+
+func deepMerge(a, b map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(a)+len(b))
+	for k, v := range a {
+		out[k] = v
+	}
+	for k, v := range b {
+		ao, ok1 := out[k].(map[string]interface{})
+		bo, ok2 := v.(map[string]interface{})
+		if ok1 && ok2 {
+			out[k] = deepMerge(ao, bo)
+		} else {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+func compareOp(op string, a, b interface{}) bool {
+	c := compare(a, b)
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	}
+	return c >= 0
+}
+
+// getpath returns the value at path, or null where it doesn't exist.
+func getpath(v interface{}, path []interface{}) (interface{}, error) {
+	for _, key := range path {
+		if v == nil {
+			return nil, nil
+		}
+		var err error
+		if v, err = index(v, key); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// setpath returns a copy of v with the value at path replaced.
+func setpath(v interface{}, path []interface{}, value interface{}) (interface{}, error) {
+	if len(path) == 0 {
+		return value, nil
+	}
+	key, rest := path[0], path[1:]
+	switch k := key.(type) {
+	case string:
+		obj, ok := v.(map[string]interface{})
+		if !ok && v != nil {
+			return nil, errorf("Cannot index %s with %q", typeName(v), k)
+		}
+		child, err := setpath(obj[k], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		out := make(map[string]interface{}, len(obj)+1)
+		for ok, ov := range obj {
+			out[ok] = ov
+		}
+		out[k] = child
+		return out, nil
+	case float64:
+		arr, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, errorf("Cannot index %s with number", typeName(v))
+		}
+		i := toInt(k)
+		if i < 0 {
+			i += len(arr)
+			if i < 0 {
+				return nil, errorf("Out of bounds negative array index")
+			}
+		}
+		if err := checkLen(i+1, maxArrayLen); err != nil {
+			return nil, err
+		}
+		var current interface{}
+		if i < len(arr) {
+			current = arr[i]
+		}
+		child, err := setpath(current, rest, value)
+		if err != nil {
+			return nil, err
+		}
+		size := len(arr)
+		if i >= size {
+			size = i + 1
+		}
+		out := make([]interface{}, size)
+		copy(out, arr)
+		out[i] = child
+		return out, nil
+	case map[string]interface{}:
+		arr, ok := v.([]interface{})
+		if !ok && v != nil {
+			return nil, errorf("Cannot update field at object index of %s", typeName(v))
+		}
+		start, end, err := sliceBounds(len(arr), k["start"], k["end"])
+		if err != nil {
+			return nil, err
+		}
+		child, err := setpath(arr[start:end:end], rest, value)
+		if err != nil {
+			return nil, err
+		}
+		replacement, ok := child.([]interface{})
+		if !ok {
+			return nil, errorf("A slice of an array can only be assigned another array")
+		}
+		out := make([]interface{}, 0, len(arr)-(end-start)+len(replacement))
+		out = append(out, arr[:start]...)
+		out = append(out, replacement...)
+		return append(out, arr[end:]...), nil
+	}
+	return nil, errorf("Invalid path component %s", typeName(key))
+}
+
+// delpaths returns a copy of v without the values at paths. Longer paths
+// are deleted first so earlier deletions don't shift later ones.
+func delpaths(v interface{}, paths []interface{}) (interface{}, error) {
+	sorted := sortValues(paths)
+	for i := len(sorted) - 1; i >= 0; i-- {
+		p, ok := sorted[i].([]interface{})
+		if !ok {
+			return nil, errorf("Path must be specified as an array")
+		}
+		var err error
+		if v, err = delpath(v, p); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func delpath(v interface{}, path []interface{}) (interface{}, error) {
+	if v == nil {
+		return nil, nil
+	}
+	if len(path) == 0 {
+		return nil, nil
+	}
+	key, rest := path[0], path[1:]
+	if len(rest) > 0 {
+		child, err := index(v, key)
+		if err != nil {
+			return nil, err
+		}
+		if child == nil {
+			return v, nil
+		}
+		if child, err = delpath(child, rest); err != nil {
+			return nil, err
+		}
+		return setpath(v, []interface{}{key}, child)
+	}
+
+	switch k := key.(type) {
+	case string:
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errorf("Cannot delete field at object index of %s", typeName(v))
+		}
+		out := make(map[string]interface{}, len(obj))
+		for ok, ov := range obj {
+			if ok != k {
+				out[ok] = ov
+			}
+		}
+		return out, nil
+	case float64:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, errorf("Cannot delete field at index of %s", typeName(v))
+		}
+		i := toInt(k)
+		if i < 0 {
+			i += len(arr)
+		}
+		if i < 0 || i >= len(arr) {
+			return v, nil
+		}
+		out := make([]interface{}, 0, len(arr)-1)
+		return append(append(out, arr[:i]...), arr[i+1:]...), nil
+	case map[string]interface{}:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, errorf("Cannot delete slice of %s", typeName(v))
+		}
+		start, end, err := sliceBounds(len(arr), k["start"], k["end"])
+		if err != nil {
+			return nil, err
+		}
+		out := make([]interface{}, 0, len(arr)-(end-start))
+		return append(append(out, arr[:start]...), arr[end:]...), nil
+	}
+	return nil, errorf("Invalid path component %s", typeName(key))
+}
+
+// toJSON encodes a value compactly, without escaping HTML characters.
+// Values sharing parts can encode far larger than they are held, so the
+// output is cut off at maxStringLen.
+func toJSON(v interface{}) string {
+	w := &jsonWriter{limit: maxStringLen}
+	w.encode(v)
+	return w.buf.String()
+}
+
+// toJSON encodes a value for a filter, failing with ErrTooLarge rather
+// than cutting it off, and checking ctx as it goes.
+func (it *interp) toJSON(v interface{}) (string, error) {
+	w := &jsonWriter{it: it, limit: maxStringLen}
+	if err := w.encode(v); err != nil {
+		return "", err
+	}
+	return w.buf.String(), nil
+}
+
+// jsonWriter encodes values as JSON, stopping with ErrTooLarge once the
+// output passes limit bytes. With it set, the filter's context is checked
+// too.
+type jsonWriter struct {
+	buf   bytes.Buffer
+	it    *interp
+	limit int
+}
+
+func (w *jsonWriter) encode(v interface{}) error {
+	if w.buf.Len() > w.limit {
+		return ErrTooLarge
+	}
+	if w.it != nil {
+		if err := w.it.tick(); err != nil {
+			return err
+		}
+	}
+	switch x := v.(type) {
+	case nil:
+		w.buf.WriteString("null")
+	case bool:
+		w.buf.WriteString(strconv.FormatBool(x))
+	case float64:
+		w.buf.WriteString(formatNumber(x))
+	case string:
+		if rest := w.limit - w.buf.Len(); len(x) > rest {
+			// quoted, it can only be longer
+			encodeJSONString(&w.buf, x[:rest])
+			return ErrTooLarge
+		}
+		encodeJSONString(&w.buf, x)
+	case []interface{}:
+		w.buf.WriteByte('[')
+		for i, elem := range x {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			if err := w.encode(elem); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteByte(']')
+	case map[string]interface{}:
+		w.buf.WriteByte('{')
+		for i, k := range sortedKeys(x) {
+			if i > 0 {
+				w.buf.WriteByte(',')
+			}
+			if err := w.encode(k); err != nil {
+				return err
+			}
+			w.buf.WriteByte(':')
+			if err := w.encode(x[k]); err != nil {
+				return err
+			}
+		}
+		w.buf.WriteByte('}')
+	default:
+		w.buf.WriteString("null")
+	}
+	if w.buf.Len() > w.limit {
+		return ErrTooLarge
+	}
+	return nil
+}
+
+func encodeJSONString(buf *bytes.Buffer, s string) {
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(s)
+	// Encode adds a newline
+	buf.Truncate(buf.Len() - 1)
+}
+
+func formatNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "null"
+	case math.IsInf(f, 1):
+		return "1.7976931348623157e+308"
+	case math.IsInf(f, -1):
+		return "-1.7976931348623157e+308"
+	case f == math.Trunc(f) && math.Abs(f) < 1e17:
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// tostring is the string form of a value: strings as they are, anything
+// else as JSON.
+func tostring(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return toJSON(v)
+}
+
+func (it *interp) tostring(v interface{}) (string, error) {
+	if s, ok := v.(string); ok {
+		return s, nil
+	}
+	return it.toJSON(v)
+}
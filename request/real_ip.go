@@ -1,13 +1,82 @@
 package request
 
 import (
+	"errors"
 	"net"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/ins-tykgw/tyk/headers"
 )
 
+var (
+	trustedMu      sync.RWMutex
+	trustedProxies []*net.IPNet
+	trustedHops    int
+	trustedHeader  = headers.XForwardFor
+)
+
+// SetTrustedProxies configures which peers RealIP trusts to report the
+// client address. cidrs lists the trusted proxy networks, plain IPs are
+// accepted too. hops is the number of proxies in front of the gateway; if
+// it is set, RealIP walks back at most that many entries through the
+// forwarding header. header is the forwarding header the trusted proxies
+// set, one of X-Forwarded-For, Forwarded or X-Real-IP; it defaults to
+// X-Forwarded-For and the others are ignored. With neither cidrs nor hops
+// set, forwarding headers are trusted from any peer.
+func SetTrustedProxies(cidrs []string, hops int, header string) error {
+	header = http.CanonicalHeaderKey(header)
+	switch header {
+	case "":
+		header = headers.XForwardFor
+	case http.CanonicalHeaderKey(headers.XForwardFor), headers.Forwarded, http.CanonicalHeaderKey(headers.XRealIP):
+	default:
+		return errors.New("unsupported forwarding header: " + header)
+	}
+
+	nets := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		if !strings.Contains(cidr, "/") {
+			ip := net.ParseIP(cidr)
+			if ip == nil {
+				return &net.ParseError{Type: "IP address", Text: cidr}
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		nets = append(nets, ipNet)
+	}
+
+	trustedMu.Lock()
+	trustedProxies = nets
+	trustedHops = hops
+	trustedHeader = header
+	trustedMu.Unlock()
+	return nil
+}
+
+func isTrustedProxy(nets []*net.IPNet, addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // RealIP takes a request object, and returns the real Client IP address.
 func RealIP(r *http.Request) string {
 
@@ -15,6 +84,14 @@ func RealIP(r *http.Request) string {
 		return contextIp.(string)
 	}
 
+	trustedMu.RLock()
+	nets, hops, header := trustedProxies, trustedHops, trustedHeader
+	trustedMu.RUnlock()
+
+	if len(nets) > 0 || hops > 0 {
+		return trustedRealIP(r, nets, hops, header)
+	}
+
 	if realIP := r.Header.Get(headers.XRealIP); realIP != "" {
 		return realIP
 	}
@@ -29,6 +106,86 @@ func RealIP(r *http.Request) string {
 		return fw
 	}
 
+	return remoteHost(r)
+}
+
+// trustedRealIP walks back through the forwarding header of the trusted
+// proxies from the nearest proxy, stopping at the first address that isn't
+// a trusted proxy or after hops proxies.
+func trustedRealIP(r *http.Request, nets []*net.IPNet, hops int, header string) string {
+	peer := remoteHost(r)
+	if len(nets) > 0 && !isTrustedProxy(nets, peer) {
+		return peer
+	}
+
+	chain := forwardedFor(r.Header, header)
+	// the peer is the first proxy
+	walked := 1
+	for i := len(chain) - 1; i >= 0; i-- {
+		if hops > 0 && walked >= hops {
+			return chain[i]
+		}
+		if len(nets) > 0 && !isTrustedProxy(nets, chain[i]) {
+			return chain[i]
+		}
+		walked++
+	}
+
+	// The chain ran out. With trusted networks, every address in it is a
+	// trusted proxy, reported by another one. Otherwise it is shorter than
+	// hops, and its leftmost address may come from the client.
+	if len(chain) > 0 && len(nets) > 0 {
+		return chain[0]
+	}
+	return peer
+}
+
+// forwardedFor returns the client addresses from the forwarding header,
+// ordered from the client to the nearest proxy.
+func forwardedFor(h http.Header, header string) []string {
+	var chain []string
+	switch header {
+	case headers.Forwarded:
+		for _, value := range h[headers.Forwarded] {
+			for _, elem := range strings.Split(value, ",") {
+				for _, pair := range strings.Split(elem, ";") {
+					pair = strings.TrimSpace(pair)
+					if len(pair) < 4 || !strings.EqualFold(pair[:4], "for=") {
+						continue
+					}
+					chain = append(chain, forwardedNode(pair[4:]))
+				}
+			}
+		}
+	default:
+		for _, value := range h[header] {
+			for _, addr := range strings.Split(value, ",") {
+				if addr = strings.TrimSpace(addr); addr != "" {
+					chain = append(chain, addr)
+				}
+			}
+		}
+	}
+	return chain
+}
+
+// forwardedNode strips the quotes, brackets and port from a Forwarded node
+// as defined in RFC 7239, section 6.
+func forwardedNode(node string) string {
+	node = strings.Trim(node, `"`)
+	if strings.HasPrefix(node, "[") {
+		if i := strings.IndexByte(node, ']'); i > 0 {
+			return node[1:i]
+		}
+		return node
+	}
+	if i := strings.IndexByte(node, ':'); i >= 0 {
+		return node[:i]
+	}
+	return node
+}
+
+func remoteHost(r *http.Request) string {
 	// From net/http.Request.RemoteAddr:
 	//   The HTTP server in this package sets RemoteAddr to an
 	//   "IP:port" address before invoking a handler.
@@ -47,6 +47,102 @@ func TestRealIP(t *testing.T) {
 	}
 }
 
+var trustedProxyTests = []struct {
+	proxies    []string
+	hops       int
+	header     string
+	remoteAddr string
+	headers    map[string]string
+	expected   string
+	comment    string
+}{
+	{
+		proxies: []string{"10.0.0.0/8"}, remoteAddr: "1.2.3.4:8080",
+		headers:  map[string]string{"X-Forwarded-For": "5.5.5.5", "X-Real-IP": "5.5.5.5"},
+		expected: "1.2.3.4", comment: "Untrusted peer",
+	},
+	{
+		proxies: []string{"10.0.0.0/8"}, header: "X-Real-IP", remoteAddr: "10.0.1.4:8080",
+		headers:  map[string]string{"X-Real-IP": "5.5.5.5", "X-Forwarded-For": "6.6.6.6"},
+		expected: "5.5.5.5", comment: "Trusted peer X-Real-IP",
+	},
+	{
+		proxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.1.4:8080",
+		headers:  map[string]string{"X-Real-IP": "5.5.5.5"},
+		expected: "10.0.1.4", comment: "Header not set by the trusted proxy",
+	},
+	{
+		proxies: []string{"10.0.0.0/8", "192.168.1.1"}, remoteAddr: "10.0.1.4:8080",
+		headers:  map[string]string{"X-Forwarded-For": "6.6.6.6, 5.5.5.5, 192.168.1.1, 10.0.0.2"},
+		expected: "5.5.5.5", comment: "Walk back to first untrusted address",
+	},
+	{
+		proxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.1.4:8080",
+		headers:  map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"},
+		expected: "10.0.0.3", comment: "All addresses trusted",
+	},
+	{
+		hops: 2, remoteAddr: "1.2.3.4:8080",
+		headers:  map[string]string{"X-Forwarded-For": "6.6.6.6, 5.5.5.5, 7.7.7.7"},
+		expected: "5.5.5.5", comment: "Hop count",
+	},
+	{
+		hops: 3, remoteAddr: "1.2.3.4:8080",
+		headers:  map[string]string{"X-Forwarded-For": "6.6.6.6, 5.5.5.5"},
+		expected: "1.2.3.4", comment: "Chain shorter than the hop count",
+	},
+	{
+		proxies: []string{"10.0.0.0/8"}, hops: 1, remoteAddr: "10.0.1.4:8080",
+		headers:  map[string]string{"X-Forwarded-For": "5.5.5.5, 10.0.0.2"},
+		expected: "10.0.0.2", comment: "Hop count with trusted proxies",
+	},
+	{
+		proxies: []string{"10.0.0.0/8", "2001:db8::/32"}, header: "Forwarded", remoteAddr: "10.0.1.4:8080",
+		headers: map[string]string{
+			"Forwarded":       `for=192.0.2.60;proto=http, For="[2001:db8:cafe::17]:4711"`,
+			"X-Forwarded-For": "6.6.6.6",
+		},
+		expected: "192.0.2.60", comment: "Forwarded",
+	},
+	{
+		proxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.1.4:8080",
+		headers: map[string]string{
+			"Forwarded":       "for=6.6.6.6",
+			"X-Forwarded-For": "5.5.5.5",
+		},
+		expected: "5.5.5.5", comment: "Forwarded from the client",
+	},
+}
+
+func TestRealIPTrustedProxies(t *testing.T) {
+	defer SetTrustedProxies(nil, 0, "")
+
+	for _, test := range trustedProxyTests {
+		t.Run(test.comment, func(t *testing.T) {
+			if err := SetTrustedProxies(test.proxies, test.hops, test.header); err != nil {
+				t.Fatal(err)
+			}
+
+			r, _ := http.NewRequest(http.MethodGet, "http://abc.com:8080", nil)
+			for k, v := range test.headers {
+				r.Header.Set(k, v)
+			}
+			r.RemoteAddr = test.remoteAddr
+
+			if ip := RealIP(r); ip != test.expected {
+				t.Errorf("expected %s got %s", test.expected, ip)
+			}
+		})
+	}
+
+	if err := SetTrustedProxies([]string{"10.0.0.0/33"}, 0, ""); err == nil {
+		t.Error("expected invalid CIDR to be rejected")
+	}
+	if err := SetTrustedProxies([]string{"10.0.0.0/8"}, 0, "X-Client-IP"); err == nil {
+		t.Error("expected unsupported header to be rejected")
+	}
+}
+
 func BenchmarkRealIP_RemoteAddr(b *testing.B) {
 	b.ReportAllocs()
 
@@ -35,8 +35,16 @@ type AnalyticsRecord struct {
 	Tags          []string
 	Alias         string
 	TrackPath     bool
+	RequestID     string
+	WebSocket     *WebSocketRecord
 	ExpireAt      time.Time `bson:"expireAt" json:"expireAt"`
 }
+type WebSocketRecord struct {
+	MessagesIn  int64
+	MessagesOut int64
+	Duration    int64
+	CloseReason string
+}
 type GeoData struct {
 	Country struct {
 		ISOCode string `maxminddb:"iso_code"`
@@ -0,0 +1,1040 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+// ------------------- EMBEDDED STORAGE MANAGER -------------------------------
+
+const (
+	// EmbeddedType is the storage type that selects the embedded store
+	// instead of Redis.
+	EmbeddedType = "embedded"
+
+	embeddedPersistInterval = 1 * time.Second
+	embeddedSweepInterval   = 1 * time.Minute
+	embeddedPubSubBuffer    = 1024
+	embeddedOpenTimeout     = 5 * time.Second
+)
+
+const (
+	kindString = "string"
+	kindList   = "list"
+	kindSet    = "set"
+	kindZSet   = "zset"
+)
+
+var (
+	embeddedSingleton      *memoryDB
+	embeddedCacheSingleton *memoryDB
+
+	errWrongType = errors.New("operation against a key holding the wrong kind of value")
+
+	embeddedBucket = []byte("keys")
+)
+
+// EmbeddedStorage is a storage manager that keeps its data in the gateway's
+// memory, so that a single gateway can run without Redis. It is selected
+// with the "embedded" storage type, and persists its data to a bbolt file
+// at embedded_path when one is set.
+type EmbeddedStorage struct {
+	KeyPrefix string
+	HashKeys  bool
+	IsCache   bool
+}
+
+// memoryItem is a single key, holding a value of one of the kinds.
+type memoryItem struct {
+	Kind      string             `json:"kind"`
+	String    string             `json:"string,omitempty"`
+	List      []string           `json:"list,omitempty"`
+	Set       map[string]bool    `json:"set,omitempty"`
+	ZSet      map[string]float64 `json:"zset,omitempty"`
+	ExpiresAt int64              `json:"expires_at,omitempty"` // unix nanoseconds
+}
+
+type memoryDB struct {
+	mu    sync.Mutex
+	items map[string]*memoryItem
+	path  string
+	bolt  *bolt.DB
+	dirty map[string]bool // keys changed since the last persist
+
+	subsMu sync.Mutex
+	subs   map[string]map[chan redis.Message]bool
+}
+
+func storageConfig(isCache bool) config.StorageOptionsConf {
+	if isCache && config.Global().EnableSeperateCacheStore {
+		return config.Global().CacheStorage
+	}
+	return config.Global().Storage
+}
+
+func newMemoryDB(path string) *memoryDB {
+	db := &memoryDB{
+		items: make(map[string]*memoryItem),
+		path:  path,
+		dirty: make(map[string]bool),
+		subs:  make(map[string]map[chan redis.Message]bool),
+	}
+	if path != "" {
+		if err := db.load(); err != nil {
+			log.WithError(err).Error("Could not load embedded storage from ", path)
+		}
+	}
+	go db.maintain()
+	return db
+}
+
+// load opens the bbolt file at db.path and reads the keys that haven't
+// expired yet. The data stays in memory only if the file can't be opened.
+func (db *memoryDB) load() error {
+	b, err := bolt.Open(db.path, 0600, &bolt.Options{Timeout: embeddedOpenTimeout})
+	if err != nil {
+		return err
+	}
+	now := time.Now().UnixNano()
+	err = b.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(embeddedBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			item := &memoryItem{}
+			if err := json.Unmarshal(v, item); err != nil {
+				log.WithError(err).Warning("Skipping unreadable embedded storage key ", string(k))
+				return nil
+			}
+			if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+				db.dirty[string(k)] = true
+				return nil
+			}
+			db.items[string(k)] = item
+			return nil
+		})
+	})
+	if err != nil {
+		b.Close()
+		db.items = make(map[string]*memoryItem)
+		db.dirty = make(map[string]bool)
+		return err
+	}
+	db.bolt = b
+	return nil
+}
+
+// maintain writes the data to disk when it changes and drops expired keys
+// that nobody reads any more.
+func (db *memoryDB) maintain() {
+	lastSweep := time.Now()
+	for range time.Tick(embeddedPersistInterval) {
+		if time.Since(lastSweep) > embeddedSweepInterval {
+			db.sweep()
+			lastSweep = time.Now()
+		}
+		if db.bolt != nil {
+			if err := db.persist(); err != nil {
+				log.WithError(err).Error("Could not persist embedded storage to ", db.path)
+			}
+		}
+	}
+}
+
+func (db *memoryDB) sweep() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	now := time.Now().UnixNano()
+	for key, item := range db.items {
+		if item.ExpiresAt > 0 && item.ExpiresAt <= now {
+			delete(db.items, key)
+			db.dirty[key] = true
+		}
+	}
+}
+
+// persist writes the keys that changed since the last run to disk, and
+// removes the ones that were deleted. Only the changed keys are encoded
+// under db.mu; the write itself happens outside it.
+func (db *memoryDB) persist() error {
+	db.mu.Lock()
+	if len(db.dirty) == 0 {
+		db.mu.Unlock()
+		return nil
+	}
+	changes := make(map[string][]byte, len(db.dirty))
+	for key := range db.dirty {
+		var v []byte
+		if item := db.items[key]; item != nil {
+			b, err := json.Marshal(item)
+			if err != nil {
+				db.mu.Unlock()
+				return err
+			}
+			v = b
+		}
+		changes[key] = v
+	}
+	db.dirty = make(map[string]bool)
+	db.mu.Unlock()
+
+	err := db.bolt.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(embeddedBucket)
+		for key, v := range changes {
+			var err error
+			if v == nil {
+				err = bucket.Delete([]byte(key))
+			} else {
+				err = bucket.Put([]byte(key), v)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		// try again with the next run
+		db.mu.Lock()
+		for key := range changes {
+			db.dirty[key] = true
+		}
+		db.mu.Unlock()
+	}
+	return err
+}
+
+// item returns the live item at key, or nil. The caller must hold db.mu.
+func (db *memoryDB) item(key string) *memoryItem {
+	item := db.items[key]
+	if item != nil && item.ExpiresAt > 0 && item.ExpiresAt <= time.Now().UnixNano() {
+		delete(db.items, key)
+		db.dirty[key] = true
+		return nil
+	}
+	return item
+}
+
+// lookup returns the item at key if it is of the given kind.
+func (db *memoryDB) lookup(key, kind string) (*memoryItem, error) {
+	item := db.item(key)
+	if item != nil && item.Kind != kind {
+		return nil, errWrongType
+	}
+	return item, nil
+}
+
+// create returns the item at key, creating it if it doesn't exist.
+func (db *memoryDB) create(key, kind string) (*memoryItem, error) {
+	item, err := db.lookup(key, kind)
+	if err != nil || item != nil {
+		return item, err
+	}
+	item = &memoryItem{Kind: kind}
+	switch kind {
+	case kindSet:
+		item.Set = make(map[string]bool)
+	case kindZSet:
+		item.ZSet = make(map[string]float64)
+	}
+	db.items[key] = item
+	db.dirty[key] = true
+	return item, nil
+}
+
+func (db *memoryDB) set(key, value string, timeout int64) {
+	db.items[key] = &memoryItem{Kind: kindString, String: value}
+	db.dirty[key] = true
+	if timeout > 0 {
+		db.expire(key, timeout)
+	}
+}
+
+func (db *memoryDB) get(key string) (string, error) {
+	item, err := db.lookup(key, kindString)
+	if err != nil {
+		return "", err
+	}
+	if item == nil {
+		return "", ErrKeyNotFound
+	}
+	return item.String, nil
+}
+
+// expire works like Redis EXPIRE, so a timeout that isn't positive
+// deletes the key.
+func (db *memoryDB) expire(key string, timeout int64) {
+	item := db.item(key)
+	if item == nil {
+		return
+	}
+	db.dirty[key] = true
+	if timeout <= 0 {
+		delete(db.items, key)
+		return
+	}
+	item.ExpiresAt = time.Now().Add(time.Duration(timeout) * time.Second).UnixNano()
+}
+
+// ttl works like Redis TTL: -2 for missing keys and -1 for keys without
+// an expiry.
+func (db *memoryDB) ttl(key string) int64 {
+	item := db.item(key)
+	switch {
+	case item == nil:
+		return -2
+	case item.ExpiresAt == 0:
+		return -1
+	}
+	left := time.Duration(item.ExpiresAt - time.Now().UnixNano())
+	return int64((left + time.Second/2) / time.Second)
+}
+
+func (db *memoryDB) del(key string) {
+	if _, ok := db.items[key]; ok {
+		delete(db.items, key)
+		db.dirty[key] = true
+	}
+}
+
+func (db *memoryDB) incrBy(key string, n int64) (int64, error) {
+	item, err := db.create(key, kindString)
+	if err != nil {
+		return 0, err
+	}
+	val := int64(0)
+	if item.String != "" {
+		if val, err = strconv.ParseInt(item.String, 10, 64); err != nil {
+			return 0, errors.New("value is not an integer")
+		}
+	}
+	val += n
+	item.String = strconv.FormatInt(val, 10)
+	db.dirty[key] = true
+	return val, nil
+}
+
+// keys returns the sorted live keys that match a Redis glob pattern.
+func (db *memoryDB) keys(pattern string) []string {
+	keys := make([]string, 0)
+	for key := range db.items {
+		if globMatch(pattern, key) && db.item(key) != nil {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (db *memoryDB) zRangeByScore(key string, min, max scoreBound) ([]string, []float64, error) {
+	item, err := db.lookup(key, kindZSet)
+	if err != nil || item == nil {
+		return nil, nil, err
+	}
+	members := make([]string, 0, len(item.ZSet))
+	for member, score := range item.ZSet {
+		if min.below(score) && max.above(score) {
+			members = append(members, member)
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := item.ZSet[members[i]], item.ZSet[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+	scores := make([]float64, len(members))
+	for i, member := range members {
+		scores[i] = item.ZSet[member]
+	}
+	return members, scores, nil
+}
+
+func (db *memoryDB) zRemRangeByScore(key string, min, max scoreBound) error {
+	item, err := db.lookup(key, kindZSet)
+	if err != nil || item == nil {
+		return err
+	}
+	for member, score := range item.ZSet {
+		if min.below(score) && max.above(score) {
+			delete(item.ZSet, member)
+			db.dirty[key] = true
+		}
+	}
+	if len(item.ZSet) == 0 {
+		db.del(key)
+	}
+	return nil
+}
+
+func (db *memoryDB) zAdd(key, member string, score float64) error {
+	item, err := db.create(key, kindZSet)
+	if err != nil {
+		return err
+	}
+	item.ZSet[member] = score
+	db.dirty[key] = true
+	return nil
+}
+
+// rollingWindow drops the values older than per seconds from a sorted set
+// and returns the ones left, in the form Redis returns them. With add set,
+// a value is added and the expiry of the set is refreshed too.
+func (db *memoryDB) rollingWindow(key string, per int64, value string, add bool) (int, []interface{}) {
+	now := time.Now()
+	onePeriodAgo := now.Add(time.Duration(-1*per) * time.Second)
+	min := scoreBound{value: math.Inf(-1)}
+	max := scoreBound{value: float64(onePeriodAgo.UnixNano())}
+	if err := db.zRemRangeByScore(key, min, max); err != nil {
+		log.Error("Multi command failed: ", err)
+		return 0, nil
+	}
+	members, _, _ := db.zRangeByScore(key, min, scoreBound{value: math.Inf(1)})
+
+	if add {
+		if value == "-1" {
+			value = strconv.Itoa(int(now.UnixNano()))
+		}
+		if err := db.zAdd(key, value, float64(now.UnixNano())); err != nil {
+			log.Error("Multi command failed: ", err)
+			return 0, nil
+		}
+		db.expire(key, per)
+	}
+
+	vals := make([]interface{}, len(members))
+	for i, member := range members {
+		vals[i] = []byte(member)
+	}
+	return len(vals), vals
+}
+
+func (db *memoryDB) subscribe(channel string) chan redis.Message {
+	ch := make(chan redis.Message, embeddedPubSubBuffer)
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	if db.subs[channel] == nil {
+		db.subs[channel] = make(map[chan redis.Message]bool)
+	}
+	db.subs[channel][ch] = true
+	return ch
+}
+
+func (db *memoryDB) publish(channel, message string) {
+	db.subsMu.Lock()
+	defer db.subsMu.Unlock()
+	for ch := range db.subs[channel] {
+		select {
+		case ch <- redis.Message{Channel: channel, Data: []byte(message)}:
+		default:
+			log.Warning("Embedded pub/sub subscriber is too slow, dropping message on ", channel)
+		}
+	}
+}
+
+// scoreBound is a sorted set range bound in the Redis syntax, such as
+// "-inf", "+inf", "10" or "(10" for an exclusive bound.
+type scoreBound struct {
+	value     float64
+	exclusive bool
+}
+
+func parseScoreBound(s string) (scoreBound, error) {
+	var b scoreBound
+	if strings.HasPrefix(s, "(") {
+		b.exclusive = true
+		s = s[1:]
+	}
+	switch strings.ToLower(s) {
+	case "-inf":
+		b.value = math.Inf(-1)
+	case "+inf", "inf":
+		b.value = math.Inf(1)
+	default:
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return b, errors.New("min or max is not a float")
+		}
+		b.value = v
+	}
+	return b, nil
+}
+
+// below reports whether score is within a lower bound.
+func (b scoreBound) below(score float64) bool {
+	if b.exclusive {
+		return b.value < score
+	}
+	return b.value <= score
+}
+
+// above reports whether score is within an upper bound.
+func (b scoreBound) above(score float64) bool {
+	if b.exclusive {
+		return score < b.value
+	}
+	return score <= b.value
+}
+
+// globMatch matches s against a Redis glob pattern, supporting *, ?,
+// character classes and backslash escapes.
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if pattern == "" {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s == "" {
+				return false
+			}
+		case '[':
+			if s == "" {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 {
+				// no closing bracket, match it literally
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			pattern = pattern[end+1:]
+			if !classMatch(class, s[0]) {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if s == "" || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return s == ""
+}
+
+func classMatch(class string, c byte) bool {
+	negate := strings.HasPrefix(class, "^")
+	if negate {
+		class = class[1:]
+	}
+	matched := false
+	for i := 0; i < len(class); i++ {
+		switch {
+		case class[i] == '\\' && i+1 < len(class):
+			i++
+			matched = matched || class[i] == c
+		case i+2 < len(class) && class[i+1] == '-':
+			lo, hi := class[i], class[i+2]
+			if lo > hi {
+				lo, hi = hi, lo
+			}
+			matched = matched || (lo <= c && c <= hi)
+			i += 2
+		default:
+			matched = matched || class[i] == c
+		}
+	}
+	return matched != negate
+}
+
+// embeddedDB returns the embedded data for the main or the cache store, or
+// nil if it isn't connected.
+func embeddedDB(isCache bool) *memoryDB {
+	redisSingletonMu.RLock()
+	defer redisSingletonMu.RUnlock()
+	if isCache {
+		return embeddedCacheSingleton
+	}
+	return embeddedSingleton
+}
+
+// Connect sets up the embedded data, loading it from disk if a path is
+// configured. The cache shares the main data unless a separate cache
+// store is enabled.
+func (e *EmbeddedStorage) Connect() bool {
+	redisSingletonMu.Lock()
+	defer redisSingletonMu.Unlock()
+	if e.IsCache && config.Global().EnableSeperateCacheStore {
+		if embeddedCacheSingleton == nil {
+			embeddedCacheSingleton = newMemoryDB(config.Global().CacheStorage.EmbeddedPath)
+		}
+		return true
+	}
+	if embeddedSingleton == nil {
+		embeddedSingleton = newMemoryDB(config.Global().Storage.EmbeddedPath)
+	}
+	if e.IsCache && embeddedCacheSingleton == nil {
+		embeddedCacheSingleton = embeddedSingleton
+	}
+	return true
+}
+
+// db returns the embedded data, connecting first if needed.
+func (e *EmbeddedStorage) db() *memoryDB {
+	if db := embeddedDB(e.IsCache); db != nil {
+		return db
+	}
+	e.Connect()
+	return embeddedDB(e.IsCache)
+}
+
+func (e *EmbeddedStorage) hashKey(in string) string {
+	if !e.HashKeys {
+		// Not hashing? Return the raw key
+		return in
+	}
+	return HashStr(in)
+}
+
+func (e *EmbeddedStorage) fixKey(keyName string) string {
+	return e.KeyPrefix + e.hashKey(keyName)
+}
+
+func (e *EmbeddedStorage) cleanKey(keyName string) string {
+	return strings.Replace(keyName, e.KeyPrefix, "", 1)
+}
+
+// GetKey will retrieve a key from the store
+func (e *EmbeddedStorage) GetKey(keyName string) (string, error) {
+	return e.GetRawKey(e.fixKey(keyName))
+}
+
+func (e *EmbeddedStorage) GetRawKey(keyName string) (string, error) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	value, err := db.get(keyName)
+	if err != nil {
+		log.Debug("Error trying to get value:", err)
+		return "", ErrKeyNotFound
+	}
+	return value, nil
+}
+
+func (e *EmbeddedStorage) GetKeyTTL(keyName string) (int64, error) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.ttl(e.fixKey(keyName)), nil
+}
+
+func (e *EmbeddedStorage) GetExp(keyName string) (int64, error) {
+	return e.GetKeyTTL(keyName)
+}
+
+func (e *EmbeddedStorage) SetExp(keyName string, timeout int64) error {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.expire(e.fixKey(keyName), timeout)
+	return nil
+}
+
+// SetKey will create (or update) a key value in the store
+func (e *EmbeddedStorage) SetKey(keyName, session string, timeout int64) error {
+	return e.SetRawKey(e.fixKey(keyName), session, timeout)
+}
+
+func (e *EmbeddedStorage) SetRawKey(keyName, session string, timeout int64) error {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.set(keyName, session, timeout)
+	return nil
+}
+
+// Decrement will decrement a key in the store
+func (e *EmbeddedStorage) Decrement(keyName string) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, err := db.incrBy(e.fixKey(keyName), -1); err != nil {
+		log.Error("Error trying to decrement value:", err)
+	}
+}
+
+// IncrememntWithExpire will increment a key in the store
+func (e *EmbeddedStorage) IncrememntWithExpire(keyName string, expire int64) int64 {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	// This function uses a raw key, so we shouldn't call fixKey
+	val, err := db.incrBy(keyName, 1)
+	if err != nil {
+		log.Error("Error trying to increment value:", err)
+	}
+	if val == 1 {
+		db.expire(keyName, expire)
+	}
+	return val
+}
+
+// GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
+func (e *EmbeddedStorage) GetKeys(filter string) []string {
+	filterHash := ""
+	if filter != "" {
+		filterHash = e.hashKey(filter)
+	}
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := db.keys(e.KeyPrefix + filterHash + "*")
+	for i, v := range keys {
+		keys[i] = e.cleanKey(v)
+	}
+	return keys
+}
+
+// ScanKeys pages through the keys matching pattern in key order. The
+// cursor is the encoded last key of the previous page.
+func (e *EmbeddedStorage) ScanKeys(cursor, pattern string, count int64) ([]string, string, error) {
+	after := ""
+	if cursor != "" && cursor != "0" {
+		last, err := base64.RawURLEncoding.DecodeString(cursor)
+		if err != nil {
+			return nil, "", errors.New("invalid cursor")
+		}
+		after = string(last)
+	}
+
+	db := e.db()
+	db.mu.Lock()
+	keys := db.keys(pattern)
+	db.mu.Unlock()
+
+	start := sort.SearchStrings(keys, after)
+	if start < len(keys) && keys[start] == after {
+		start++
+	}
+	end := start + int(count)
+	if count <= 0 || end >= len(keys) {
+		return keys[start:], "0", nil
+	}
+	return keys[start:end], base64.RawURLEncoding.EncodeToString([]byte(keys[end-1])), nil
+}
+
+func (e *EmbeddedStorage) GetRawKeys(keys []string) ([]string, error) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	values := make([]string, len(keys))
+	for i, key := range keys {
+		values[i], _ = db.get(key)
+	}
+	return values, nil
+}
+
+func (e *EmbeddedStorage) SetRawKeysPipelined(values []KeyValue) error {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, kv := range values {
+		db.set(kv.Key, kv.Value, kv.TTL)
+	}
+	return nil
+}
+
+func (e *EmbeddedStorage) DeleteRawKeys(keys []string) bool {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, key := range keys {
+		db.del(key)
+	}
+	return true
+}
+
+// GetKeysAndValuesWithFilter will return all keys and their values with a filter
+func (e *EmbeddedStorage) GetKeysAndValuesWithFilter(filter string) map[string]string {
+	filterHash := ""
+	if filter != "" {
+		filterHash = e.hashKey(filter)
+	}
+	return e.keysAndValues(e.KeyPrefix + filterHash + "*")
+}
+
+// GetKeysAndValues will return all keys and their values - not to be used lightly
+func (e *EmbeddedStorage) GetKeysAndValues() map[string]string {
+	return e.keysAndValues(e.KeyPrefix + "*")
+}
+
+func (e *EmbeddedStorage) keysAndValues(pattern string) map[string]string {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := db.keys(pattern)
+	if len(keys) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(keys))
+	for _, key := range keys {
+		// values of other kinds read as empty, like with MGET
+		value, _ := db.get(key)
+		m[e.cleanKey(key)] = value
+	}
+	return m
+}
+
+// DeleteKey will remove a key from the store
+func (e *EmbeddedStorage) DeleteKey(keyName string) bool {
+	return e.DeleteRawKey(e.fixKey(keyName))
+}
+
+// DeleteRawKey will remove a key from the store without prefixing, assumes user knows what they are doing
+func (e *EmbeddedStorage) DeleteRawKey(keyName string) bool {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.del(keyName)
+	return true
+}
+
+// DeleteScanMatch will remove all keys matching a pattern
+func (e *EmbeddedStorage) DeleteScanMatch(pattern string) bool {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	keys := db.keys(pattern)
+	for _, key := range keys {
+		db.del(key)
+	}
+	log.Debug("Deleted: ", len(keys), " records")
+	return true
+}
+
+// DeleteKeys will remove a group of keys in bulk
+func (e *EmbeddedStorage) DeleteKeys(keys []string) bool {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, key := range keys {
+		db.del(e.fixKey(key))
+	}
+	return true
+}
+
+// StartPubSubHandler will listen for a signal and run the callback for
+// every subscription and message event. Messages are only exchanged within
+// this gateway.
+func (e *EmbeddedStorage) StartPubSubHandler(channel string, callback func(interface{})) error {
+	ch := e.db().subscribe(channel)
+	callback(redis.Subscription{Kind: "subscribe", Channel: channel, Count: 1})
+	for msg := range ch {
+		callback(msg)
+	}
+	return nil
+}
+
+func (e *EmbeddedStorage) Publish(channel, message string) error {
+	e.db().publish(channel, message)
+	return nil
+}
+
+func (e *EmbeddedStorage) GetAndDeleteSet(keyName string) []interface{} {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	fixedKey := e.fixKey(keyName)
+	item, err := db.lookup(fixedKey, kindList)
+	if err != nil {
+		log.Error("Multi command failed: ", err)
+		return nil
+	}
+	vals := make([]interface{}, 0)
+	if item != nil {
+		for _, v := range item.List {
+			vals = append(vals, []byte(v))
+		}
+		db.del(fixedKey)
+	}
+	return vals
+}
+
+func (e *EmbeddedStorage) AppendToSet(keyName, value string) {
+	e.AppendToSetPipelined(keyName, []string{value})
+}
+
+func (e *EmbeddedStorage) AppendToSetPipelined(keyName string, values []string) {
+	if len(values) == 0 {
+		return
+	}
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	fixedKey := e.fixKey(keyName)
+	item, err := db.create(fixedKey, kindList)
+	if err != nil {
+		log.WithError(err).Error("Error trying to append to set keys")
+		return
+	}
+	item.List = append(item.List, values...)
+	db.dirty[fixedKey] = true
+}
+
+func (e *EmbeddedStorage) GetSet(keyName string) (map[string]string, error) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	item, err := db.lookup(e.fixKey(keyName), kindSet)
+	if err != nil {
+		log.Error("Error trying to get key set:", err)
+		return nil, err
+	}
+	members := make([]string, 0)
+	if item != nil {
+		for member := range item.Set {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	vals := make(map[string]string, len(members))
+	for i, value := range members {
+		vals[strconv.Itoa(i)] = value
+	}
+	return vals, nil
+}
+
+func (e *EmbeddedStorage) AddToSet(keyName, value string) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	fixedKey := e.fixKey(keyName)
+	item, err := db.create(fixedKey, kindSet)
+	if err != nil {
+		log.Error("Error trying to append keys: ", err)
+		return
+	}
+	item.Set[value] = true
+	db.dirty[fixedKey] = true
+}
+
+func (e *EmbeddedStorage) RemoveFromSet(keyName, value string) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	fixedKey := e.fixKey(keyName)
+	item, err := db.lookup(fixedKey, kindSet)
+	if err != nil {
+		log.Error("Error trying to remove keys: ", err)
+		return
+	}
+	if item == nil {
+		return
+	}
+	delete(item.Set, value)
+	db.dirty[fixedKey] = true
+	if len(item.Set) == 0 {
+		db.del(fixedKey)
+	}
+}
+
+func (e *EmbeddedStorage) IsMemberOfSet(keyName, value string) bool {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	item, err := db.lookup(e.fixKey(keyName), kindSet)
+	if err != nil {
+		log.Error("Error trying to check set memeber: ", err)
+		return false
+	}
+	return item != nil && item.Set[value]
+}
+
+// SetRollingWindow will append to a sorted set and extract a timed window of values
+func (e *EmbeddedStorage) SetRollingWindow(keyName string, per int64, valueOverride string, pipeline bool) (int, []interface{}) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.rollingWindow(keyName, per, valueOverride, true)
+}
+
+func (e *EmbeddedStorage) GetRollingWindow(keyName string, per int64, pipeline bool) (int, []interface{}) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.rollingWindow(keyName, per, "", false)
+}
+
+// GetKeyPrefix returns storage key prefix
+func (e *EmbeddedStorage) GetKeyPrefix() string {
+	return e.KeyPrefix
+}
+
+// AddToSortedSet adds value with given score to sorted set identified by keyName
+func (e *EmbeddedStorage) AddToSortedSet(keyName, value string, score float64) {
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := db.zAdd(e.fixKey(keyName), value, score); err != nil {
+		log.WithField("keyName", keyName).WithError(err).Error("ZADD command failed")
+	}
+}
+
+// GetSortedSetRange gets range of elements of sorted set identified by keyName
+func (e *EmbeddedStorage) GetSortedSetRange(keyName, scoreFrom, scoreTo string) ([]string, []float64, error) {
+	min, max, err := parseScoreRange(scoreFrom, scoreTo)
+	if err != nil {
+		return nil, nil, err
+	}
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	elements, scores, err := db.zRangeByScore(e.fixKey(keyName), min, max)
+	if err != nil || len(elements) == 0 {
+		return nil, nil, err
+	}
+	return elements, scores, nil
+}
+
+// RemoveSortedSetRange removes range of elements from sorted set identified by keyName
+func (e *EmbeddedStorage) RemoveSortedSetRange(keyName, scoreFrom, scoreTo string) error {
+	min, max, err := parseScoreRange(scoreFrom, scoreTo)
+	if err != nil {
+		return err
+	}
+	db := e.db()
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.zRemRangeByScore(e.fixKey(keyName), min, max)
+}
+
+func parseScoreRange(scoreFrom, scoreTo string) (min, max scoreBound, err error) {
+	if min, err = parseScoreBound(scoreFrom); err != nil {
+		return
+	}
+	max, err = parseScoreBound(scoreTo)
+	return
+}
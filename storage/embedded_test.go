@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+// testHandler runs the same checks against any storage handler.
+func testHandler(t *testing.T, h Handler) {
+	h.Connect()
+	h.DeleteScanMatch(h.GetKeyPrefix() + "*")
+	h.DeleteScanMatch("storage-test-raw.*")
+	defer h.DeleteScanMatch(h.GetKeyPrefix() + "*")
+	defer h.DeleteScanMatch("storage-test-raw.*")
+
+	t.Run("Keys", func(t *testing.T) {
+		if _, err := h.GetKey("missing"); err != ErrKeyNotFound {
+			t.Fatalf("want ErrKeyNotFound, got %v", err)
+		}
+		if err := h.SetKey("a1", "one", 0); err != nil {
+			t.Fatal(err)
+		}
+		h.SetKey("a2", "two", 100)
+		h.SetKey("b1", "three", 0)
+		if v, err := h.GetKey("a1"); err != nil || v != "one" {
+			t.Fatalf("want one, got %q %v", v, err)
+		}
+		if v, err := h.GetRawKey(h.GetKeyPrefix() + "a2"); err != nil || v != "two" {
+			t.Fatalf("want two, got %q %v", v, err)
+		}
+
+		keys := h.GetKeys("a")
+		sort.Strings(keys)
+		if want := []string{"a1", "a2"}; !reflect.DeepEqual(keys, want) {
+			t.Errorf("want keys %v, got %v", want, keys)
+		}
+		if want := map[string]string{"a1": "one", "a2": "two"}; !reflect.DeepEqual(h.GetKeysAndValuesWithFilter("a"), want) {
+			t.Errorf("want %v, got %v", want, h.GetKeysAndValuesWithFilter("a"))
+		}
+		if got := h.GetKeysAndValues(); len(got) != 3 {
+			t.Errorf("want 3 keys, got %v", got)
+		}
+
+		h.DeleteKey("a1")
+		h.DeleteKeys([]string{"a2"})
+		h.DeleteRawKey(h.GetKeyPrefix() + "b1")
+		if got := h.GetKeysAndValues(); got != nil && len(got) != 0 {
+			t.Errorf("want no keys, got %v", got)
+		}
+	})
+
+	t.Run("Expiry", func(t *testing.T) {
+		h.SetKey("exp", "value", 100)
+		if ttl, _ := h.GetExp("exp"); ttl < 99 || ttl > 100 {
+			t.Errorf("want TTL of 100, got %d", ttl)
+		}
+		h.SetKey("exp", "value", 0)
+		if ttl, _ := h.GetExp("exp"); ttl != -1 {
+			t.Errorf("want no TTL after setting again, got %d", ttl)
+		}
+		h.SetExp("exp", 1)
+		time.Sleep(1100 * time.Millisecond)
+		if _, err := h.GetKey("exp"); err != ErrKeyNotFound {
+			t.Errorf("want expired key, got %v", err)
+		}
+		if ttl, _ := h.GetExp("exp"); ttl != -2 {
+			t.Errorf("want TTL of a missing key, got %d", ttl)
+		}
+	})
+
+	t.Run("Counters", func(t *testing.T) {
+		key := "storage-test-raw.counter"
+		for want := int64(1); want <= 3; want++ {
+			if got := h.IncrememntWithExpire(key, 100); got != want {
+				t.Fatalf("want %d, got %d", want, got)
+			}
+		}
+	})
+
+	t.Run("Rolling window", func(t *testing.T) {
+		key := "storage-test-raw.window"
+		for want := 0; want < 3; want++ {
+			if got, _ := h.SetRollingWindow(key, 1, "-1", false); got != want {
+				t.Fatalf("want %d, got %d", want, got)
+			}
+		}
+		if got, vals := h.GetRollingWindow(key, 1, false); got != 3 || len(vals) != 3 {
+			t.Fatalf("want 3, got %d %v", got, vals)
+		}
+		time.Sleep(1100 * time.Millisecond)
+		if got, _ := h.GetRollingWindow(key, 1, false); got != 0 {
+			t.Errorf("want an empty window, got %d", got)
+		}
+	})
+
+	t.Run("Sets", func(t *testing.T) {
+		h.AddToSet("set", "x")
+		h.AddToSet("set", "y")
+		h.AddToSet("set", "x")
+		h.RemoveFromSet("set", "y")
+		if got, err := h.GetSet("set"); err != nil || !reflect.DeepEqual(got, map[string]string{"0": "x"}) {
+			t.Errorf("want just x, got %v %v", got, err)
+		}
+
+		h.AppendToSet("list", "1")
+		h.AppendToSetPipelined("list", []string{"2", "3"})
+		got := h.GetAndDeleteSet("list")
+		want := []interface{}{[]byte("1"), []byte("2"), []byte("3")}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("want %v, got %v", want, got)
+		}
+		if got := h.GetAndDeleteSet("list"); len(got) != 0 {
+			t.Errorf("want an empty list, got %v", got)
+		}
+	})
+
+	t.Run("Sorted sets", func(t *testing.T) {
+		h.AddToSortedSet("zset", "c", 3)
+		h.AddToSortedSet("zset", "a", 1)
+		h.AddToSortedSet("zset", "b", 2)
+
+		elements, scores, err := h.GetSortedSetRange("zset", "(1", "+inf")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(elements, []string{"b", "c"}) || !reflect.DeepEqual(scores, []float64{2, 3}) {
+			t.Errorf("want b and c, got %v %v", elements, scores)
+		}
+
+		if err := h.RemoveSortedSetRange("zset", "-inf", "2"); err != nil {
+			t.Fatal(err)
+		}
+		elements, _, _ = h.GetSortedSetRange("zset", "-inf", "+inf")
+		if !reflect.DeepEqual(elements, []string{"c"}) {
+			t.Errorf("want just c, got %v", elements)
+		}
+		if _, _, err := h.GetSortedSetRange("zset", "x", "+inf"); err == nil {
+			t.Error("want an error for an invalid score")
+		}
+	})
+
+	t.Run("Batch", func(t *testing.T) {
+		b, ok := h.(BatchHandler)
+		if !ok {
+			t.Skip("not a batch handler")
+		}
+		prefix := h.GetKeyPrefix() + "batch-"
+		values := make([]KeyValue, 25)
+		for i := range values {
+			values[i] = KeyValue{Key: prefix + string(rune('a'+i)), Value: string(rune('A' + i))}
+		}
+		values[0].TTL = 100
+		if err := b.SetRawKeysPipelined(values); err != nil {
+			t.Fatal(err)
+		}
+		if ttl, _ := h.GetExp(prefix[len(h.GetKeyPrefix()):] + "a"); ttl <= 0 {
+			t.Errorf("want a TTL, got %d", ttl)
+		}
+
+		var keys []string
+		cursor := "0"
+		for pages := 0; ; pages++ {
+			if pages > 25 {
+				t.Fatal("scan doesn't end")
+			}
+			page, next, err := b.ScanKeys(cursor, prefix+"*", 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, page...)
+			if cursor = next; cursor == "0" {
+				break
+			}
+		}
+		sort.Strings(keys)
+		if len(keys) != 25 || keys[0] != prefix+"a" {
+			t.Fatalf("want 25 keys, got %v", keys)
+		}
+
+		got, err := b.GetRawKeys([]string{prefix + "b", prefix + "missing", prefix + "c"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, []string{"B", "", "C"}) {
+			t.Errorf("want B, empty and C, got %q", got)
+		}
+
+		if !b.DeleteRawKeys(keys) {
+			t.Error("want keys deleted")
+		}
+		if got, _ := b.GetRawKeys([]string{prefix + "b"}); got[0] != "" {
+			t.Errorf("want b deleted, got %q", got)
+		}
+	})
+}
+
+func TestRedisCluster(t *testing.T) {
+	testHandler(t, &RedisCluster{KeyPrefix: "storage-test."})
+}
+
+// resetEmbedded drops the embedded data, so that RedisCluster uses Redis
+// again.
+func resetEmbedded() {
+	redisSingletonMu.Lock()
+	embeddedSingleton = nil
+	embeddedCacheSingleton = nil
+	redisSingletonMu.Unlock()
+}
+
+func TestEmbeddedStorage(t *testing.T) {
+	defer resetEmbedded()
+	testHandler(t, &EmbeddedStorage{KeyPrefix: "storage-test."})
+}
+
+func TestEmbeddedStorageDecrement(t *testing.T) {
+	defer resetEmbedded()
+	e := &EmbeddedStorage{KeyPrefix: "storage-test."}
+	e.SetKey("dec", "5", 0)
+	e.Decrement("dec")
+	e.Decrement("missing")
+	if v, _ := e.GetKey("dec"); v != "4" {
+		t.Errorf("want 4, got %q", v)
+	}
+	if v, _ := e.GetKey("missing"); v != "-1" {
+		t.Errorf("want -1, got %q", v)
+	}
+}
+
+func TestEmbeddedStoragePersistence(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tyk-embedded-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.db")
+
+	db := newMemoryDB(path)
+	db.set("persisted", "value", 0)
+	db.set("expiring", "value", 100)
+	db.set("deleted", "value", 0)
+	if err := db.persist(); err != nil {
+		t.Fatal(err)
+	}
+	db.del("deleted")
+	if err := db.persist(); err != nil {
+		t.Fatal(err)
+	}
+	if len(db.dirty) != 0 {
+		t.Errorf("want no keys left to persist, got %v", db.dirty)
+	}
+	db.bolt.Close()
+
+	db = newMemoryDB(path)
+	defer db.bolt.Close()
+	if v, err := db.get("persisted"); err != nil || v != "value" {
+		t.Errorf("want value, got %q %v", v, err)
+	}
+	if ttl := db.ttl("expiring"); ttl < 99 || ttl > 100 {
+		t.Errorf("want the TTL to be kept, got %d", ttl)
+	}
+	if _, err := db.get("deleted"); err != ErrKeyNotFound {
+		t.Errorf("want the deleted key gone, got %v", err)
+	}
+}
+
+func TestEmbeddedStoragePubSub(t *testing.T) {
+	defer resetEmbedded()
+	e := &EmbeddedStorage{}
+	got := make(chan interface{}, 2)
+	go e.StartPubSubHandler("test-channel", func(v interface{}) {
+		got <- v
+	})
+
+	if _, ok := (<-got).(redis.Subscription); !ok {
+		t.Fatal("want a subscription event first")
+	}
+	e.Publish("other-channel", "ignored")
+	e.Publish("test-channel", "hello")
+	msg, ok := (<-got).(redis.Message)
+	if !ok || string(msg.Data) != "hello" || msg.Channel != "test-channel" {
+		t.Errorf("want the published message, got %#v", msg)
+	}
+}
+
+func TestRedisClusterUsesEmbeddedStorage(t *testing.T) {
+	globalConf := config.Global()
+	defer config.SetGlobal(globalConf)
+	embeddedConf := globalConf
+	embeddedConf.Storage.Type = EmbeddedType
+	config.SetGlobal(embeddedConf)
+	defer resetEmbedded()
+
+	redisSingletonMu.Lock()
+	redisSingleton := redisClusterSingleton
+	redisClusterSingleton = nil
+	redisSingletonMu.Unlock()
+	defer func() {
+		redisSingletonMu.Lock()
+		redisClusterSingleton = redisSingleton
+		redisSingletonMu.Unlock()
+	}()
+
+	r := &RedisCluster{KeyPrefix: "storage-test-switch."}
+	r.SetKey("key", "value", 0)
+	defer r.DeleteKey("key")
+	e := &EmbeddedStorage{KeyPrefix: "storage-test-switch."}
+	if v, err := e.GetKey("key"); err != nil || v != "value" {
+		t.Errorf("want the value in the embedded store, got %q %v", v, err)
+	}
+	if r.singleton() != nil {
+		t.Error("Redis should not be connected")
+	}
+}
+
+func TestGlobMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"apikey-*", "apikey-abc", true},
+		{"apikey-*", "orgkey.abc", false},
+		{"*", "", true},
+		{"a?c", "abc", true},
+		{"a?c", "ac", false},
+		{"a[bc]d", "acd", true},
+		{"a[^bc]d", "acd", false},
+		{"a[a-c]d", "abd", true},
+		{`a\*`, "a*", true},
+		{`a\*`, "ab", false},
+		{"Test/sub-*", "Test/sub-key", true},
+	}
+	for _, tc := range tests {
+		if got := globMatch(tc.pattern, tc.s); got != tc.want {
+			t.Errorf("globMatch(%q, %q) = %v, want %v", tc.pattern, tc.s, got, tc.want)
+		}
+	}
+}
@@ -1,7 +1,11 @@
 package storage
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
+	"fmt"
+	"io/ioutil"
 	"strconv"
 	"strings"
 	"sync"
@@ -9,6 +13,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/garyburd/redigo/redis"
+	redigo "github.com/gomodule/redigo/redis"
 	uuid "github.com/satori/go.uuid"
 
 	"github.com/TykTechnologies/redigocluster/rediscluster"
@@ -31,7 +36,8 @@ var (
 	redisCacheClusterSingleton *rediscluster.RedisCluster
 )
 
-// RedisCluster is a storage manager that uses the redis database.
+// RedisCluster is a storage manager that uses the redis database. When the
+// storage type is "embedded" it hands every call to EmbeddedStorage instead.
 type RedisCluster struct {
 	KeyPrefix string
 	HashKeys  bool
@@ -75,7 +81,7 @@ func IsConnected() bool {
 		// check that redis is available
 		var redisIsReady bool
 		for _, cluster := range testClusters {
-			redisIsReady = cluster.singleton() != nil && clusterConnectionIsOpen(cluster)
+			redisIsReady = (cluster.singleton() != nil || embeddedDB(cluster.IsCache) != nil) && clusterConnectionIsOpen(cluster)
 			if !redisIsReady {
 				break
 			}
@@ -94,12 +100,9 @@ func IsConnected() bool {
 	return true
 }
 
-func NewRedisClusterPool(isCache bool) *rediscluster.RedisCluster {
+func NewRedisClusterPool(isCache bool) (*rediscluster.RedisCluster, error) {
 	// redisSingletonMu is locked and we know the singleton is nil
-	cfg := config.Global().Storage
-	if isCache && config.Global().EnableSeperateCacheStore {
-		cfg = config.Global().CacheStorage
-	}
+	cfg := storageConfig(isCache)
 
 	log.Debug("Creating new Redis connection pool")
 
@@ -123,6 +126,11 @@ func NewRedisClusterPool(isCache bool) *rediscluster.RedisCluster {
 		timeout = time.Duration(cfg.Timeout) * time.Second
 	}
 
+	tlsConfig, err := redisTLSConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("could not load the Redis TLS certificates: %v", err)
+	}
+
 	poolConf := rediscluster.PoolConfig{
 		MaxIdle:        maxIdle,
 		MaxActive:      maxActive,
@@ -144,19 +152,133 @@ func NewRedisClusterPool(isCache bool) *rediscluster.RedisCluster {
 
 	seed_redii := []map[string]string{}
 
-	for h, p := range cfg.Hosts {
-		seed_redii = append(seed_redii, map[string]string{h: p})
+	master := ""
+	if cfg.SentinelMasterName != "" {
+		log.Info("--> Using Sentinel mode, master: ", cfg.SentinelMasterName)
+		host, port, err := sentinelMaster(cfg, timeout, tlsConfig)
+		if err != nil {
+			return nil, fmt.Errorf("could not get the Redis master from Sentinel: %v", err)
+		}
+		master = host + ":" + port
+		seed_redii = append(seed_redii, map[string]string{host: port})
+	} else {
+		for h, p := range cfg.Hosts {
+			seed_redii = append(seed_redii, map[string]string{h: p})
+		}
 	}
 	if len(seed_redii) == 0 {
 		seed_redii = append(seed_redii, map[string]string{cfg.Host: strconv.Itoa(cfg.Port)})
 	}
 
-	cluster := rediscluster.NewRedisCluster(seed_redii, poolConf, false)
-	return &cluster
+	var cluster rediscluster.RedisCluster
+	if cfg.Username == "" && tlsConfig == nil {
+		cluster = rediscluster.NewRedisCluster(seed_redii, poolConf, false)
+	} else {
+		// rediscluster can't dial with a username or TLS certificates,
+		// so the handles are set up here. It adds the nodes of a cluster
+		// itself, which is why cluster mode isn't supported.
+		if cfg.EnableCluster {
+			return nil, errors.New("Redis usernames and TLS certificate files aren't supported in cluster mode")
+		}
+		cluster = rediscluster.NewRedisCluster(nil, poolConf, false)
+		for _, seed := range seed_redii {
+			for host, port := range seed {
+				cluster.SeedHosts.Set(host+":"+port, true)
+				cluster.Handles.Set(host+":"+port, newRedisHandle(host, port, cfg, poolConf, tlsConfig))
+			}
+		}
+	}
+	if cfg.SentinelMasterName != "" {
+		go watchSentinel(isCache, &cluster, master, cfg, timeout, tlsConfig, sentinelCheckInterval)
+	}
+	return &cluster, nil
+}
+
+// redisTLSConfig returns the TLS config for the CA and client certificate
+// files, or nil when none are set.
+func redisTLSConfig(cfg config.StorageOptionsConf) (*tls.Config, error) {
+	if cfg.CAFile == "" && cfg.CertFile == "" && cfg.KeyFile == "" {
+		return nil, nil
+	}
+	tlsConfig := &tls.Config{InsecureSkipVerify: cfg.SSLInsecureSkipVerify}
+	if cfg.CAFile != "" {
+		caPEM, err := ioutil.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(caPEM) {
+			return nil, errors.New("no certificates found in " + cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newRedisHandle is rediscluster.NewRedisHandle, but authenticating with
+// the ACL username and using the TLS config of the CA and client
+// certificate files.
+func newRedisHandle(host, port string, cfg config.StorageOptionsConf, poolConf rediscluster.PoolConfig, tlsConfig *tls.Config) *rediscluster.RedisHandle {
+	return &rediscluster.RedisHandle{
+		Host: host,
+		Port: port,
+		Pool: &redigo.Pool{
+			MaxIdle:     poolConf.MaxIdle,
+			MaxActive:   poolConf.MaxActive,
+			IdleTimeout: poolConf.IdleTimeout,
+			Dial: func() (redigo.Conn, error) {
+				return dialRedis(host+":"+port, cfg, poolConf, tlsConfig)
+			},
+		},
+	}
+}
+
+func dialRedis(addr string, cfg config.StorageOptionsConf, poolConf rediscluster.PoolConfig, tlsConfig *tls.Config) (redigo.Conn, error) {
+	opts := []redigo.DialOption{
+		redigo.DialUseTLS(poolConf.UseTLS),
+		redigo.DialTLSSkipVerify(poolConf.TLSSkipVerify),
+		redigo.DialTLSConfig(tlsConfig),
+		redigo.DialConnectTimeout(poolConf.ConnectTimeout),
+		redigo.DialReadTimeout(poolConf.ReadTimeout),
+		redigo.DialWriteTimeout(poolConf.WriteTimeout),
+	}
+	if cfg.Username == "" {
+		opts = append(opts, redigo.DialPassword(cfg.Password), redigo.DialDatabase(cfg.Database))
+	}
+	c, err := redigo.Dial("tcp", addr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Username == "" {
+		return c, nil
+	}
+
+	// ACL auth needs the username, so AUTH and SELECT are sent here
+	if _, err := c.Do("AUTH", cfg.Username, cfg.Password); err != nil {
+		c.Close()
+		return nil, err
+	}
+	if cfg.Database != 0 {
+		if _, err := c.Do("SELECT", cfg.Database); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
 }
 
-// Connect will establish a connection to the r.singleton()
+// Connect will establish a connection to the r.singleton(), or set up the
+// embedded store when the storage type is "embedded"
 func (r *RedisCluster) Connect() bool {
+	if storageConfig(r.IsCache).Type == EmbeddedType {
+		return r.embeddedStore().Connect()
+	}
 	redisSingletonMu.Lock()
 	defer redisSingletonMu.Unlock()
 	disconnected := redisClusterSingleton == nil
@@ -165,11 +287,16 @@ func (r *RedisCluster) Connect() bool {
 	}
 	if disconnected {
 		log.Debug("Connecting to redis cluster")
+		cluster, err := NewRedisClusterPool(r.IsCache)
+		if err != nil {
+			log.WithError(err).Error("Could not connect to Redis")
+			return false
+		}
 		if r.IsCache {
-			redisCacheClusterSingleton = NewRedisClusterPool(true)
+			redisCacheClusterSingleton = cluster
 			return true
 		}
-		redisClusterSingleton = NewRedisClusterPool(false)
+		redisClusterSingleton = cluster
 		return true
 	}
 
@@ -186,6 +313,20 @@ func (r *RedisCluster) singleton() *rediscluster.RedisCluster {
 	return redisClusterSingleton
 }
 
+func (r *RedisCluster) embeddedStore() *EmbeddedStorage {
+	return &EmbeddedStorage{KeyPrefix: r.KeyPrefix, HashKeys: r.HashKeys, IsCache: r.IsCache}
+}
+
+// embedded returns the embedded store to use in place of Redis, or nil
+// when Redis is used.
+func (r *RedisCluster) embedded() *EmbeddedStorage {
+	r.ensureConnection()
+	if embeddedDB(r.IsCache) == nil {
+		return nil
+	}
+	return r.embeddedStore()
+}
+
 func (r *RedisCluster) hashKey(in string) string {
 	if !r.HashKeys {
 		// Not hashing? Return the raw key
@@ -203,23 +344,27 @@ func (r *RedisCluster) cleanKey(keyName string) string {
 }
 
 func (r *RedisCluster) ensureConnection() {
-	if r.singleton() != nil {
+	if r.singleton() != nil || embeddedDB(r.IsCache) != nil {
 		// already connected
 		return
 	}
 	log.Info("Connection dropped, reconnecting...")
 	for {
 		r.Connect()
-		if r.singleton() != nil {
+		if r.singleton() != nil || embeddedDB(r.IsCache) != nil {
 			// reconnection worked
 			return
 		}
 		log.Info("Reconnecting again...")
+		time.Sleep(waitStorageRetriesInterval)
 	}
 }
 
 // GetKey will retrieve a key from the database
 func (r *RedisCluster) GetKey(keyName string) (string, error) {
+	if e := r.embedded(); e != nil {
+		return e.GetKey(keyName)
+	}
 	r.ensureConnection()
 	log.Debug("[STORE] Getting WAS: ", keyName)
 	log.Debug("[STORE] Getting: ", r.fixKey(keyName))
@@ -236,11 +381,17 @@ func (r *RedisCluster) GetKey(keyName string) (string, error) {
 }
 
 func (r *RedisCluster) GetKeyTTL(keyName string) (ttl int64, err error) {
+	if e := r.embedded(); e != nil {
+		return e.GetKeyTTL(keyName)
+	}
 	r.ensureConnection()
 	return redis.Int64(r.singleton().Do("TTL", r.fixKey(keyName)))
 }
 
 func (r *RedisCluster) GetRawKey(keyName string) (string, error) {
+	if e := r.embedded(); e != nil {
+		return e.GetRawKey(keyName)
+	}
 	r.ensureConnection()
 	value, err := redis.String(r.singleton().Do("GET", keyName))
 	if err != nil {
@@ -252,6 +403,9 @@ func (r *RedisCluster) GetRawKey(keyName string) (string, error) {
 }
 
 func (r *RedisCluster) GetExp(keyName string) (int64, error) {
+	if e := r.embedded(); e != nil {
+		return e.GetExp(keyName)
+	}
 	log.Debug("Getting exp for key: ", r.fixKey(keyName))
 	r.ensureConnection()
 
@@ -264,6 +418,9 @@ func (r *RedisCluster) GetExp(keyName string) (int64, error) {
 }
 
 func (r *RedisCluster) SetExp(keyName string, timeout int64) error {
+	if e := r.embedded(); e != nil {
+		return e.SetExp(keyName, timeout)
+	}
 	_, err := r.singleton().Do("EXPIRE", r.fixKey(keyName), timeout)
 	if err != nil {
 		log.Error("Could not EXPIRE key: ", err)
@@ -273,6 +430,9 @@ func (r *RedisCluster) SetExp(keyName string, timeout int64) error {
 
 // SetKey will create (or update) a key value in the store
 func (r *RedisCluster) SetKey(keyName, session string, timeout int64) error {
+	if e := r.embedded(); e != nil {
+		return e.SetKey(keyName, session, timeout)
+	}
 	log.Debug("[STORE] SET Raw key is: ", keyName)
 	log.Debug("[STORE] Setting key: ", r.fixKey(keyName))
 
@@ -291,6 +451,9 @@ func (r *RedisCluster) SetKey(keyName, session string, timeout int64) error {
 }
 
 func (r *RedisCluster) SetRawKey(keyName, session string, timeout int64) error {
+	if e := r.embedded(); e != nil {
+		return e.SetRawKey(keyName, session, timeout)
+	}
 	r.ensureConnection()
 	_, err := r.singleton().Do("SET", keyName, session)
 	if timeout > 0 {
@@ -309,6 +472,10 @@ func (r *RedisCluster) SetRawKey(keyName, session string, timeout int64) error {
 
 // Decrement will decrement a key in redis
 func (r *RedisCluster) Decrement(keyName string) {
+	if e := r.embedded(); e != nil {
+		e.Decrement(keyName)
+		return
+	}
 	keyName = r.fixKey(keyName)
 	log.Debug("Decrementing key: ", keyName)
 	r.ensureConnection()
@@ -320,6 +487,9 @@ func (r *RedisCluster) Decrement(keyName string) {
 
 // IncrementWithExpire will increment a key in redis
 func (r *RedisCluster) IncrememntWithExpire(keyName string, expire int64) int64 {
+	if e := r.embedded(); e != nil {
+		return e.IncrememntWithExpire(keyName, expire)
+	}
 	log.Debug("Incrementing raw key: ", keyName)
 	r.ensureConnection()
 	// This function uses a raw key, so we shouldn't call fixKey
@@ -338,6 +508,9 @@ func (r *RedisCluster) IncrememntWithExpire(keyName string, expire int64) int64
 
 // GetKeys will return all keys according to the filter (filter is a prefix - e.g. tyk.keys.*)
 func (r *RedisCluster) GetKeys(filter string) []string {
+	if e := r.embedded(); e != nil {
+		return e.GetKeys(filter)
+	}
 	r.ensureConnection()
 	filterHash := ""
 	if filter != "" {
@@ -357,8 +530,100 @@ func (r *RedisCluster) GetKeys(filter string) []string {
 	return sessions
 }
 
+// ScanKeys pages through the keys matching pattern with SCAN
+func (r *RedisCluster) ScanKeys(cursor, pattern string, count int64) ([]string, string, error) {
+	if e := r.embedded(); e != nil {
+		return e.ScanKeys(cursor, pattern, count)
+	}
+	r.ensureConnection()
+	if cursor == "" {
+		cursor = "0"
+	}
+	arr, err := redis.Values(r.singleton().Do("SCAN", cursor, "MATCH", pattern, "COUNT", count))
+	if err != nil {
+		log.Error("Error trying to scan keys: ", err)
+		return nil, "", err
+	}
+	if len(arr) != 2 {
+		return nil, "", errors.New("unexpected SCAN reply")
+	}
+	next, _ := redis.String(arr[0], nil)
+	keys, _ := redis.Strings(arr[1], nil)
+	return keys, next, nil
+}
+
+// GetRawKeys gets the values of keys with MGET
+func (r *RedisCluster) GetRawKeys(keys []string) ([]string, error) {
+	if e := r.embedded(); e != nil {
+		return e.GetRawKeys(keys)
+	}
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	r.ensureConnection()
+	args := make([]interface{}, len(keys))
+	for i, v := range keys {
+		args[i] = v
+	}
+	values, err := redis.Strings(r.singleton().Do("MGET", args...))
+	if err != nil {
+		log.Error("Error trying to get keys: ", err)
+		return nil, err
+	}
+	return values, nil
+}
+
+// SetRawKeysPipelined writes all values in a single pipeline
+func (r *RedisCluster) SetRawKeysPipelined(values []KeyValue) error {
+	if e := r.embedded(); e != nil {
+		return e.SetRawKeysPipelined(values)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+
+	pipeLine := make([]rediscluster.ClusterTransaction, len(values))
+	for i, kv := range values {
+		args := []interface{}{kv.Key, kv.Value}
+		if kv.TTL > 0 {
+			args = append(args, "EX", kv.TTL)
+		}
+		pipeLine[i] = rediscluster.ClusterTransaction{Cmd: "SET", Args: args}
+	}
+
+	r.ensureConnection()
+	if _, err := r.singleton().DoPipeline(pipeLine); err != nil {
+		log.WithError(err).Error("Error trying to set keys")
+		return err
+	}
+	return nil
+}
+
+// DeleteRawKeys removes keys without prefixing or hashing them
+func (r *RedisCluster) DeleteRawKeys(keys []string) bool {
+	if e := r.embedded(); e != nil {
+		return e.DeleteRawKeys(keys)
+	}
+	if len(keys) == 0 {
+		return true
+	}
+	r.ensureConnection()
+	args := make([]interface{}, len(keys))
+	for i, v := range keys {
+		args[i] = v
+	}
+	if _, err := r.singleton().Do("DEL", args...); err != nil {
+		log.Error("Error trying to delete keys: ", err)
+		return false
+	}
+	return true
+}
+
 // GetKeysAndValuesWithFilter will return all keys and their values with a filter
 func (r *RedisCluster) GetKeysAndValuesWithFilter(filter string) map[string]string {
+	if e := r.embedded(); e != nil {
+		return e.GetKeysAndValuesWithFilter(filter)
+	}
 	r.ensureConnection()
 	filterHash := ""
 	if filter != "" {
@@ -392,6 +657,9 @@ func (r *RedisCluster) GetKeysAndValuesWithFilter(filter string) map[string]stri
 
 // GetKeysAndValues will return all keys and their values - not to be used lightly
 func (r *RedisCluster) GetKeysAndValues() map[string]string {
+	if e := r.embedded(); e != nil {
+		return e.GetKeysAndValues()
+	}
 	r.ensureConnection()
 	searchStr := r.KeyPrefix + "*"
 	sessionsInterface, err := r.singleton().Do("KEYS", searchStr)
@@ -416,6 +684,9 @@ func (r *RedisCluster) GetKeysAndValues() map[string]string {
 
 // DeleteKey will remove a key from the database
 func (r *RedisCluster) DeleteKey(keyName string) bool {
+	if e := r.embedded(); e != nil {
+		return e.DeleteKey(keyName)
+	}
 	r.ensureConnection()
 	log.Debug("DEL Key was: ", keyName)
 	log.Debug("DEL Key became: ", r.fixKey(keyName))
@@ -429,6 +700,9 @@ func (r *RedisCluster) DeleteKey(keyName string) bool {
 
 // DeleteKey will remove a key from the database without prefixing, assumes user knows what they are doing
 func (r *RedisCluster) DeleteRawKey(keyName string) bool {
+	if e := r.embedded(); e != nil {
+		return e.DeleteRawKey(keyName)
+	}
 	r.ensureConnection()
 	_, err := r.singleton().Do("DEL", keyName)
 	if err != nil {
@@ -440,6 +714,9 @@ func (r *RedisCluster) DeleteRawKey(keyName string) bool {
 
 // DeleteKeys will remove a group of keys in bulk
 func (r *RedisCluster) DeleteScanMatch(pattern string) bool {
+	if e := r.embedded(); e != nil {
+		return e.DeleteScanMatch(pattern)
+	}
 	r.ensureConnection()
 	log.Debug("Deleting: ", pattern)
 
@@ -485,6 +762,9 @@ func (r *RedisCluster) DeleteScanMatch(pattern string) bool {
 
 // DeleteKeys will remove a group of keys in bulk
 func (r *RedisCluster) DeleteKeys(keys []string) bool {
+	if e := r.embedded(); e != nil {
+		return e.DeleteKeys(keys)
+	}
 	r.ensureConnection()
 	if len(keys) > 0 {
 		asInterface := make([]interface{}, len(keys))
@@ -507,6 +787,9 @@ func (r *RedisCluster) DeleteKeys(keys []string) bool {
 // StartPubSubHandler will listen for a signal and run the callback for
 // every subscription and message event.
 func (r *RedisCluster) StartPubSubHandler(channel string, callback func(interface{})) error {
+	if e := r.embedded(); e != nil {
+		return e.StartPubSubHandler(channel, callback)
+	}
 	cluster := r.singleton()
 	if cluster == nil {
 		return errors.New("Redis connection failed")
@@ -539,6 +822,9 @@ func (r *RedisCluster) StartPubSubHandler(channel string, callback func(interfac
 }
 
 func (r *RedisCluster) Publish(channel, message string) error {
+	if e := r.embedded(); e != nil {
+		return e.Publish(channel, message)
+	}
 	r.ensureConnection()
 	_, err := r.singleton().Do("PUBLISH", channel, message)
 	if err != nil {
@@ -549,6 +835,9 @@ func (r *RedisCluster) Publish(channel, message string) error {
 }
 
 func (r *RedisCluster) GetAndDeleteSet(keyName string) []interface{} {
+	if e := r.embedded(); e != nil {
+		return e.GetAndDeleteSet(keyName)
+	}
 	log.Debug("Getting raw key set: ", keyName)
 	r.ensureConnection()
 	log.Debug("keyName is: ", keyName)
@@ -582,6 +871,10 @@ func (r *RedisCluster) GetAndDeleteSet(keyName string) []interface{} {
 }
 
 func (r *RedisCluster) AppendToSet(keyName, value string) {
+	if e := r.embedded(); e != nil {
+		e.AppendToSet(keyName, value)
+		return
+	}
 	fixedKey := r.fixKey(keyName)
 	log.WithField("keyName", keyName).Debug("Pushing to raw key list")
 	log.WithField("fixedKey", fixedKey).Debug("Appending to fixed key list")
@@ -592,6 +885,10 @@ func (r *RedisCluster) AppendToSet(keyName, value string) {
 }
 
 func (r *RedisCluster) AppendToSetPipelined(key string, values []string) {
+	if e := r.embedded(); e != nil {
+		e.AppendToSetPipelined(key, values)
+		return
+	}
 	if len(values) == 0 {
 		return
 	}
@@ -618,6 +915,9 @@ func (r *RedisCluster) AppendToSetPipelined(key string, values []string) {
 }
 
 func (r *RedisCluster) GetSet(keyName string) (map[string]string, error) {
+	if e := r.embedded(); e != nil {
+		return e.GetSet(keyName)
+	}
 	log.Debug("Getting from key set: ", keyName)
 	log.Debug("Getting from fixed key set: ", r.fixKey(keyName))
 	r.ensureConnection()
@@ -637,6 +937,10 @@ func (r *RedisCluster) GetSet(keyName string) (map[string]string, error) {
 }
 
 func (r *RedisCluster) AddToSet(keyName, value string) {
+	if e := r.embedded(); e != nil {
+		e.AddToSet(keyName, value)
+		return
+	}
 	log.Debug("Pushing to raw key set: ", keyName)
 	log.Debug("Pushing to fixed key set: ", r.fixKey(keyName))
 	r.ensureConnection()
@@ -648,6 +952,10 @@ func (r *RedisCluster) AddToSet(keyName, value string) {
 }
 
 func (r *RedisCluster) RemoveFromSet(keyName, value string) {
+	if e := r.embedded(); e != nil {
+		e.RemoveFromSet(keyName, value)
+		return
+	}
 	log.Debug("Removing from raw key set: ", keyName)
 	log.Debug("Removing from fixed key set: ", r.fixKey(keyName))
 	r.ensureConnection()
@@ -659,6 +967,9 @@ func (r *RedisCluster) RemoveFromSet(keyName, value string) {
 }
 
 func (r *RedisCluster) IsMemberOfSet(keyName, value string) bool {
+	if e := r.embedded(); e != nil {
+		return e.IsMemberOfSet(keyName, value)
+	}
 	r.ensureConnection()
 	val, err := redis.Int64(r.singleton().Do("SISMEMBER", r.fixKey(keyName), value))
 
@@ -674,6 +985,9 @@ func (r *RedisCluster) IsMemberOfSet(keyName, value string) bool {
 
 // SetRollingWindow will append to a sorted set in redis and extract a timed window of values
 func (r *RedisCluster) SetRollingWindow(keyName string, per int64, value_override string, pipeline bool) (int, []interface{}) {
+	if e := r.embedded(); e != nil {
+		return e.SetRollingWindow(keyName, per, value_override, pipeline)
+	}
 	log.Debug("Incrementing raw key: ", keyName)
 	r.ensureConnection()
 	log.Debug("keyName is: ", keyName)
@@ -733,6 +1047,9 @@ func (r *RedisCluster) SetRollingWindow(keyName string, per int64, value_overrid
 }
 
 func (r RedisCluster) GetRollingWindow(keyName string, per int64, pipeline bool) (int, []interface{}) {
+	if e := r.embedded(); e != nil {
+		return e.GetRollingWindow(keyName, per, pipeline)
+	}
 	r.ensureConnection()
 	now := time.Now()
 	onePeriodAgo := now.Add(time.Duration(-1*per) * time.Second)
@@ -781,6 +1098,10 @@ func (r *RedisCluster) GetKeyPrefix() string {
 
 // AddToSortedSet adds value with given score to sorted set identified by keyName
 func (r *RedisCluster) AddToSortedSet(keyName, value string, score float64) {
+	if e := r.embedded(); e != nil {
+		e.AddToSortedSet(keyName, value, score)
+		return
+	}
 	fixedKey := r.fixKey(keyName)
 	logEntry := logrus.Fields{
 		"keyName":  keyName,
@@ -796,6 +1117,9 @@ func (r *RedisCluster) AddToSortedSet(keyName, value string, score float64) {
 
 // GetSortedSetRange gets range of elements of sorted set identified by keyName
 func (r *RedisCluster) GetSortedSetRange(keyName, scoreFrom, scoreTo string) ([]string, []float64, error) {
+	if e := r.embedded(); e != nil {
+		return e.GetSortedSetRange(keyName, scoreFrom, scoreTo)
+	}
 	fixedKey := r.fixKey(keyName)
 	logEntry := logrus.Fields{
 		"keyName":   keyName,
@@ -828,6 +1152,9 @@ func (r *RedisCluster) GetSortedSetRange(keyName, scoreFrom, scoreTo string) ([]
 
 // RemoveSortedSetRange removes range of elements from sorted set identified by keyName
 func (r *RedisCluster) RemoveSortedSetRange(keyName, scoreFrom, scoreTo string) error {
+	if e := r.embedded(); e != nil {
+		return e.RemoveSortedSetRange(keyName, scoreFrom, scoreTo)
+	}
 	fixedKey := r.fixKey(keyName)
 	logEntry := logrus.Fields{
 		"keyName":   keyName,
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"crypto/tls"
+	"errors"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+
+	"github.com/TykTechnologies/redigocluster/rediscluster"
+	"github.com/ins-tykgw/tyk/config"
+)
+
+// sentinelCheckInterval is how often Sentinel is asked for the master, so
+// that the gateway follows a failover.
+var sentinelCheckInterval = 1 * time.Second
+
+// sentinelMaster asks the sentinels in turn for the address of the master.
+func sentinelMaster(cfg config.StorageOptionsConf, timeout time.Duration, tlsConfig *tls.Config) (host, port string, err error) {
+	if len(cfg.SentinelAddrs) == 0 {
+		return "", "", errors.New("no sentinel addresses are set")
+	}
+	for _, addr := range cfg.SentinelAddrs {
+		if host, port, err = askSentinel(addr, cfg, timeout, tlsConfig); err == nil {
+			return host, port, nil
+		}
+		log.WithError(err).Warning("Could not get the Redis master from sentinel ", addr)
+	}
+	return "", "", err
+}
+
+func askSentinel(addr string, cfg config.StorageOptionsConf, timeout time.Duration, tlsConfig *tls.Config) (string, string, error) {
+	c, err := redis.Dial("tcp", addr,
+		redis.DialUseTLS(cfg.UseSSL),
+		redis.DialTLSSkipVerify(cfg.SSLInsecureSkipVerify),
+		redis.DialTLSConfig(tlsConfig),
+		redis.DialPassword(cfg.SentinelPassword),
+		redis.DialConnectTimeout(timeout),
+		redis.DialReadTimeout(timeout),
+		redis.DialWriteTimeout(timeout),
+	)
+	if err != nil {
+		return "", "", err
+	}
+	defer c.Close()
+
+	reply, err := redis.Strings(c.Do("SENTINEL", "get-master-addr-by-name", cfg.SentinelMasterName))
+	switch {
+	case err == redis.ErrNil:
+		return "", "", errors.New("sentinel doesn't know master " + cfg.SentinelMasterName)
+	case err != nil:
+		return "", "", err
+	case len(reply) != 2:
+		return "", "", errors.New("unexpected reply from sentinel")
+	}
+	return reply[0], reply[1], nil
+}
+
+// watchSentinel checks the master of a pool created in Sentinel mode. When
+// it changes, a pool for the new master replaces the one in use, which is
+// then closed.
+func watchSentinel(isCache bool, cluster *rediscluster.RedisCluster, master string, cfg config.StorageOptionsConf, timeout time.Duration, tlsConfig *tls.Config, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if (&RedisCluster{IsCache: isCache}).singleton() != cluster {
+			// replaced or not used
+			return
+		}
+		host, port, err := sentinelMaster(cfg, timeout, tlsConfig)
+		if err != nil || host+":"+port == master {
+			continue
+		}
+		log.Info("Redis master changed from ", master, " to ", host+":"+port)
+
+		newCluster, err := NewRedisClusterPool(isCache)
+		if err != nil {
+			log.WithError(err).Error("Could not connect to the new Redis master")
+			continue
+		}
+		redisSingletonMu.Lock()
+		replaced := false
+		if isCache && redisCacheClusterSingleton == cluster {
+			redisCacheClusterSingleton = newCluster
+			replaced = true
+		} else if !isCache && redisClusterSingleton == cluster {
+			redisClusterSingleton = newCluster
+			replaced = true
+		}
+		redisSingletonMu.Unlock()
+
+		if replaced {
+			cluster.CloseConnection()
+		} else {
+			newCluster.CloseConnection()
+		}
+		return
+	}
+}
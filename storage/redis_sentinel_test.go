@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ins-tykgw/tyk/config"
+)
+
+// fakeSentinel answers SENTINEL get-master-addr-by-name for one master.
+type fakeSentinel struct {
+	ln   net.Listener
+	name string
+
+	mu     sync.Mutex
+	master string
+}
+
+func startFakeSentinel(t *testing.T, name, master string) *fakeSentinel {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &fakeSentinel{ln: ln, name: name, master: master}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSentinel) setMaster(master string) {
+	s.mu.Lock()
+	s.master = master
+	s.mu.Unlock()
+}
+
+func (s *fakeSentinel) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		switch {
+		case len(args) == 3 && strings.ToUpper(args[0]) == "SENTINEL" && args[2] == s.name:
+			s.mu.Lock()
+			host, port, _ := net.SplitHostPort(s.master)
+			s.mu.Unlock()
+			fmt.Fprintf(conn, "*2\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(host), host, len(port), port)
+		case strings.ToUpper(args[0]) == "SENTINEL":
+			conn.Write([]byte("*-1\r\n"))
+		default:
+			conn.Write([]byte("-ERR unknown command\r\n"))
+		}
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "*")))
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		if _, err := r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		arg, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		args[i] = strings.TrimSuffix(arg, "\r\n")
+	}
+	return args, nil
+}
+
+func TestSentinelMaster(t *testing.T) {
+	s := startFakeSentinel(t, "mymaster", "10.0.0.1:6380")
+	defer s.ln.Close()
+
+	// nothing listens on the first sentinel address
+	cfg := config.StorageOptionsConf{
+		SentinelMasterName: "mymaster",
+		SentinelAddrs:      []string{"127.0.0.1:1", s.ln.Addr().String()},
+	}
+	host, port, err := sentinelMaster(cfg, time.Second, nil)
+	if err != nil || host != "10.0.0.1" || port != "6380" {
+		t.Errorf("want 10.0.0.1:6380, got %s:%s %v", host, port, err)
+	}
+
+	cfg.SentinelMasterName = "other"
+	if _, _, err := sentinelMaster(cfg, time.Second, nil); err == nil {
+		t.Error("want an error for an unknown master")
+	}
+}
+
+func TestNewRedisClusterPoolErrors(t *testing.T) {
+	globalConf := config.Global()
+	defer config.SetGlobal(globalConf)
+
+	// nothing listens on the sentinel
+	conf := globalConf
+	conf.Storage.SentinelMasterName = "mymaster"
+	conf.Storage.SentinelAddrs = []string{"127.0.0.1:1"}
+	config.SetGlobal(conf)
+	if cluster, err := NewRedisClusterPool(false); err == nil {
+		cluster.CloseConnection()
+		t.Error("want an error when no sentinel answers")
+	}
+
+	conf = globalConf
+	conf.Storage.CAFile = "/does/not/exist"
+	config.SetGlobal(conf)
+	if cluster, err := NewRedisClusterPool(false); err == nil {
+		cluster.CloseConnection()
+		t.Error("want an error for a missing CA file")
+	}
+
+	conf = globalConf
+	conf.Storage.Username = "tyk"
+	conf.Storage.EnableCluster = true
+	config.SetGlobal(conf)
+	if cluster, err := NewRedisClusterPool(false); err == nil {
+		cluster.CloseConnection()
+		t.Error("want an error for a username in cluster mode")
+	}
+}
+
+func TestRedisUsername(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	commands := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			args, err := readCommand(r)
+			if err != nil {
+				return
+			}
+			commands <- strings.Join(args, " ")
+			conn.Write([]byte("+OK\r\n"))
+		}
+	}()
+
+	globalConf := config.Global()
+	defer config.SetGlobal(globalConf)
+	conf := globalConf
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	conf.Storage.Host = "127.0.0.1"
+	conf.Storage.Port, _ = strconv.Atoi(port)
+	conf.Storage.Hosts = nil
+	conf.Storage.Username = "tyk"
+	conf.Storage.Password = "secret"
+	conf.Storage.Database = 2
+	config.SetGlobal(conf)
+
+	cluster, err := NewRedisClusterPool(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cluster.CloseConnection()
+	if _, err := cluster.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"AUTH tyk secret", "SELECT 2", "PING"} {
+		if got := <-commands; got != want {
+			t.Errorf("want %q, got %q", want, got)
+		}
+	}
+}
+
+func TestSentinelFailover(t *testing.T) {
+	// nothing listens on the first master
+	s := startFakeSentinel(t, "mymaster", "127.0.0.1:1")
+	defer s.ln.Close()
+
+	globalConf := config.Global()
+	defer config.SetGlobal(globalConf)
+	sentinelConf := globalConf
+	sentinelConf.Storage.SentinelMasterName = "mymaster"
+	sentinelConf.Storage.SentinelAddrs = []string{s.ln.Addr().String()}
+	config.SetGlobal(sentinelConf)
+
+	defer func(interval time.Duration) { sentinelCheckInterval = interval }(sentinelCheckInterval)
+	sentinelCheckInterval = 10 * time.Millisecond
+
+	redisSingletonMu.Lock()
+	redisSingleton := redisClusterSingleton
+	redisClusterSingleton = nil
+	redisSingletonMu.Unlock()
+	defer func() {
+		redisSingletonMu.Lock()
+		if redisClusterSingleton != nil {
+			redisClusterSingleton.CloseConnection()
+		}
+		redisClusterSingleton = redisSingleton
+		redisSingletonMu.Unlock()
+	}()
+
+	r := &RedisCluster{KeyPrefix: "sentinel-test."}
+	r.Connect()
+	first := r.singleton()
+	if err := r.SetKey("key", "value", 0); err == nil {
+		t.Fatal("want an error from the unreachable master")
+	}
+
+	port := globalConf.Storage.Port
+	if port == 0 {
+		port = defaultRedisPort
+	}
+	s.setMaster(fmt.Sprintf("127.0.0.1:%d", port))
+	for i := 0; i < 100 && r.singleton() == first; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if r.singleton() == first {
+		t.Fatal("the pool was not replaced after the failover")
+	}
+	if err := r.SetKey("key", "value", 0); err != nil {
+		t.Fatal(err)
+	}
+	defer r.DeleteKey("key")
+	if v, err := r.GetKey("key"); err != nil || v != "value" {
+		t.Errorf("want value, got %q %v", v, err)
+	}
+}
+
+func TestRedisTLSConfig(t *testing.T) {
+	if conf, err := redisTLSConfig(config.StorageOptionsConf{}); conf != nil || err != nil {
+		t.Errorf("want no TLS config, got %v %v", conf, err)
+	}
+
+	dir, err := ioutil.TempDir("", "tyk-redis-tls-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	priv, _ := rsa.GenerateKey(rand.Reader, 2048)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "redis"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(priv)}), 0600)
+
+	conf, err := redisTLSConfig(config.StorageOptionsConf{
+		CAFile:   certFile,
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.RootCAs == nil || len(conf.Certificates) != 1 {
+		t.Errorf("want the CA and the client certificate, got %#v", conf)
+	}
+
+	if _, err := redisTLSConfig(config.StorageOptionsConf{CertFile: certFile}); err == nil {
+		t.Error("want an error for a missing key file")
+	}
+	if _, err := redisTLSConfig(config.StorageOptionsConf{CAFile: keyFile}); err == nil {
+		t.Error("want an error for a CA file without certificates")
+	}
+}
@@ -55,6 +55,29 @@ type Handler interface {
 	RemoveSortedSetRange(string, string, string) error
 }
 
+// BatchHandler is implemented by storage backends that can page through
+// their keys and read and write many keys at once. Keys are given in full,
+// with their prefix, as returned by ScanKeys.
+type BatchHandler interface {
+	// ScanKeys returns some of the keys matching pattern, starting at
+	// cursor, and the cursor to carry on from. The scan starts and ends at
+	// cursor "0".
+	ScanKeys(cursor, pattern string, count int64) ([]string, string, error)
+	// GetRawKeys returns the values of keys, with an empty value for the
+	// missing ones.
+	GetRawKeys(keys []string) ([]string, error)
+	SetRawKeysPipelined(values []KeyValue) error
+	DeleteRawKeys(keys []string) bool
+}
+
+// KeyValue is a key to write with SetRawKeysPipelined. A positive TTL sets
+// its expiry in seconds.
+type KeyValue struct {
+	Key   string
+	Value string
+	TTL   int64
+}
+
 const defaultHashAlgorithm = "murmur64"
 
 // If hashing algorithm is empty, use legacy key generation
@@ -75,6 +75,13 @@ type SessionState struct {
 	JWTData struct {
 		Secret string `json:"secret" msg:"secret"`
 	} `json:"jwt_data" msg:"jwt_data"`
+	// Secrets replaced by a rotation, still accepted until Expires
+	RotatedSecrets struct {
+		HmacSecret        string   `json:"hmac_string" msg:"hmac_string"`
+		BasicAuthPassword string   `json:"basic_auth_password" msg:"basic_auth_password"`
+		BasicAuthHash     HashType `json:"basic_auth_hash_type" msg:"basic_auth_hash_type"`
+		Expires           int64    `json:"expires" msg:"expires"`
+	} `json:"rotated_secrets" msg:"rotated_secrets"`
 	HMACEnabled   bool     `json:"hmac_enabled" msg:"hmac_enabled"`
 	HmacSecret    string   `json:"hmac_string" msg:"hmac_string"`
 	IsInactive    bool     `json:"is_inactive" msg:"is_inactive"`
@@ -116,6 +123,46 @@ func (s *SessionState) KeyHashEmpty() bool {
 	return s.keyHash == ""
 }
 
+// RotatedSecretsValid reports whether the secrets replaced by the last
+// rotation are still accepted.
+func (s *SessionState) RotatedSecretsValid() bool {
+	return s.RotatedSecrets.Expires > time.Now().Unix()
+}
+
+// Clone returns a copy of the session that shares no maps, slices or
+// limits with it.
+func (s *SessionState) Clone() SessionState {
+	n := *s
+	if s.AccessRights != nil {
+		n.AccessRights = make(map[string]AccessDefinition, len(s.AccessRights))
+		for id, access := range s.AccessRights {
+			access.Versions = append([]string(nil), access.Versions...)
+			access.AllowedURLs = append([]AccessSpec(nil), access.AllowedURLs...)
+			if access.Limit != nil {
+				limit := *access.Limit
+				access.Limit = &limit
+			}
+			n.AccessRights[id] = access
+		}
+	}
+	if s.OauthKeys != nil {
+		n.OauthKeys = make(map[string]string, len(s.OauthKeys))
+		for k, v := range s.OauthKeys {
+			n.OauthKeys[k] = v
+		}
+	}
+	if s.MetaData != nil {
+		n.MetaData = make(map[string]interface{}, len(s.MetaData))
+		for k, v := range s.MetaData {
+			n.MetaData[k] = v
+		}
+	}
+	n.ApplyPolicies = append([]string(nil), s.ApplyPolicies...)
+	n.Tags = append([]string(nil), s.Tags...)
+	n.Monitor.TriggerLimits = append([]float64(nil), s.Monitor.TriggerLimits...)
+	return n
+}
+
 func (s *SessionState) Lifetime(fallback int64) int64 {
 	if config.Global().ForceGlobalSessionLifetime {
 		return config.Global().GlobalSessionLifetime